@@ -11,8 +11,10 @@ import (
 	"billing-system/config"
 	"billing-system/internal/handlers"
 	"billing-system/internal/middleware"
+	"billing-system/internal/migrations"
 	"billing-system/internal/repositories"
 	"billing-system/internal/repository"
+	"billing-system/internal/scheduler"
 	"billing-system/internal/services"
 
 	"github.com/gofiber/fiber/v2"
@@ -30,6 +32,10 @@ func main() {
 
 	// Загружаем конфигурацию
 	cfg := config.Load()
+	location, err := time.LoadLocation(cfg.App.Timezone)
+	if err != nil {
+		log.Fatalf("Некорректный часовой пояс APP_TIMEZONE %q: %v", cfg.App.Timezone, err)
+	}
 
 	// Подключаемся к базе данных
 	db, err := repository.NewDatabase(cfg.Database.MongoURI, cfg.Database.DatabaseName)
@@ -42,21 +48,60 @@ func main() {
 		}
 	}()
 
+	// Применяем миграции данных
+	migrationCtx, cancelMigrations := context.WithTimeout(context.Background(), 5*time.Minute)
+	err = migrations.Run(migrationCtx, db.DB)
+	cancelMigrations()
+	if err != nil {
+		log.Fatalf("Ошибка применения миграций: %v", err)
+	}
+
 	// Инициализируем репозитории
-	repos := repository.NewRepositories(db)
+	repos := repository.NewRepositories(db, cfg.Numbering)
 	userRepo := repositories.NewUserRepository(db.DB)
 
 	// Инициализируем сервисы
 	emailService := services.NewEmailService(cfg.Email)
 	authService := services.NewAuthService(userRepo)
-	brokerService := services.NewBrokerService(repos.Broker)
-	invoiceService := services.NewInvoiceService(repos.Invoice, repos.Payment, repos.Broker, emailService)
-	paymentService := services.NewPaymentService(repos.Payment, repos.Invoice, repos.Broker, emailService)
-	loadService := services.NewLoadService(repos.Load, repos.Broker, repos.Invoice)
-	dashboardService := services.NewDashboardService(repos)
+	brokerService := services.NewBrokerService(repos.Broker, cfg.Currency)
+	pdfService := services.NewPDFService(repos.Load, repos.Broker, cfg.Company)
+	invoiceBalancer := services.NewInvoiceBalancer(repos.Invoice, repos.Payment, repos.CreditNote)
+	exchangeRateService := services.NewExchangeRateService(repos.ExchangeRate, cfg.Currency)
+	creditLimitService := services.NewCreditLimitService(repos.Invoice, repos.Load, repos.Broker, exchangeRateService, emailService, cfg.Credit)
+	invoiceService := services.NewInvoiceService(repos.Invoice, repos.Payment, repos.CreditNote, repos.Broker, repos.Load, repos.UnitOfWork, invoiceBalancer, exchangeRateService, creditLimitService, emailService, pdfService)
+	paymentService := services.NewPaymentService(repos.Payment, repos.Invoice, repos.Broker, repos.UnitOfWork, invoiceBalancer, invoiceService, exchangeRateService, emailService)
+	creditNoteService := services.NewCreditNoteService(repos.CreditNote, repos.Invoice, repos.Payment, repos.UnitOfWork, invoiceBalancer, pdfService)
+	bankReconciliationService := services.NewBankReconciliationService(repos.BankTransaction, repos.Invoice, repos.Broker, repos.UnitOfWork, paymentService, exchangeRateService, cfg.Numbering.Invoice)
+	loadService := services.NewLoadService(repos.Load, repos.Broker, repos.Invoice, repos.UnitOfWork, creditLimitService, cfg.Currency)
+	dashboardService := services.NewDashboardService(repos, cfg.Currency, location)
+	reportService := services.NewReportService(repos.Invoice, cfg.Currency)
+	exportService := services.NewExportService(repos.Invoice, repos.Payment, repos.Broker)
+	dunningService := services.NewDunningService(repos.Invoice, repos.Broker, repos.Dunning, emailService, cfg.Dunning)
+	lateFeeService := services.NewLateFeeService(repos.Invoice, repos.Broker, invoiceService, cfg.LateFees, cfg.Currency)
+	billingScheduleService := services.NewBillingScheduleService(repos.BillingSchedule, repos.Broker, repos.Invoice, invoiceService, exchangeRateService)
 
-	// Устанавливаем взаимные зависимости
-	// TODO: Реализовать правильную настройку зависимостей между сервисами
+	// Запускаем фоновые задачи
+	var jobScheduler *scheduler.Scheduler
+	if cfg.Scheduler.Enabled {
+		jobScheduler, err = scheduler.New(repos.Job, cfg.Scheduler)
+		if err != nil {
+			log.Fatalf("Ошибка настройки планировщика: %v", err)
+		}
+
+		jobs := []scheduler.Job{
+			scheduler.OverdueSweepJob(cfg.Scheduler.OverdueSweepSchedule, invoiceService),
+			scheduler.OverdueRemindersJob(cfg.Scheduler.RemindersSchedule, dunningService),
+			scheduler.LateFeesJob(cfg.Scheduler.LateFeesSchedule, lateFeeService),
+			scheduler.RecurringInvoicesJob(cfg.Scheduler.RecurringSchedule, billingScheduleService),
+		}
+		for _, job := range jobs {
+			if err := jobScheduler.Register(job); err != nil {
+				log.Fatalf("Ошибка настройки планировщика: %v", err)
+			}
+		}
+
+		jobScheduler.Start()
+	}
 
 	// Создаем Fiber приложение
 	app := fiber.New(fiber.Config{
@@ -87,6 +132,16 @@ func main() {
 		paymentService,
 		loadService,
 		dashboardService,
+		reportService,
+		exportService,
+		pdfService,
+		dunningService,
+		exchangeRateService,
+		creditNoteService,
+		bankReconciliationService,
+		lateFeeService,
+		billingScheduleService,
+		creditLimitService,
 	)
 	authHandlers := handlers.NewAuthHandlers(authService)
 
@@ -116,6 +171,10 @@ func main() {
 		log.Fatalf("Ошибка при завершении работы сервера: %v", err)
 	}
 
+	if jobScheduler != nil {
+		jobScheduler.Stop(ctx)
+	}
+
 	log.Println("Сервер остановлен")
 }
 
@@ -146,6 +205,17 @@ func setupRoutes(app *fiber.App, h *handlers.Handlers, authHandlers *handlers.Au
 	dashboard := protected.Group("dashboard")
 	dashboard.Get("/metrics", h.GetDashboardMetrics)
 
+	// Reports routes
+	reports := protected.Group("reports")
+	reports.Get("/aging", h.GetAgingReport)
+
+	// Currencies and exchange rates routes
+	protected.Get("currencies", h.GetCurrencies)
+	exchangeRates := protected.Group("exchange-rates")
+	exchangeRates.Get("/", h.GetExchangeRates)
+	exchangeRates.Post("/", authMiddleware.RequireRole("admin"), h.SaveExchangeRates)
+	exchangeRates.Post("/import", authMiddleware.RequireRole("admin"), h.ImportExchangeRates)
+
 	// Brokers routes
 	brokers := protected.Group("brokers")
 	brokers.Get("/", h.GetBrokers)
@@ -154,21 +224,36 @@ func setupRoutes(app *fiber.App, h *handlers.Handlers, authHandlers *handlers.Au
 	brokers.Get("/:id", h.GetBroker)
 	brokers.Put("/:id", h.UpdateBroker)
 	brokers.Delete("/:id", h.DeleteBroker)
+	brokers.Put("/:id/status", h.UpdateBrokerStatus)
+	brokers.Post("/:id/release-hold", authMiddleware.RequireRole("admin"), h.ReleaseBrokerCreditHold)
 	brokers.Get("/:id/stats", h.GetBrokerStats)
 	brokers.Get("/:id/invoices", h.GetBrokerInvoices)
 	brokers.Get("/:id/payments", h.GetBrokerPayments)
+	brokers.Get("/:id/credit", h.GetBrokerCredit)
+	brokers.Get("/:id/exposure", h.GetBrokerExposure)
+	brokers.Post("/:id/apply-credit", h.ApplyBrokerCredit)
 	brokers.Get("/:id/loads/unbilled", h.GetBrokerUnbilledLoads)
 
 	// Invoices routes
 	invoices := protected.Group("invoices")
 	invoices.Get("/", h.GetInvoices)
 	invoices.Post("/", h.CreateInvoice)
+	invoices.Post("/from-loads", h.CreateInvoiceFromLoads)
 	invoices.Get("/overdue", h.GetOverdueInvoices)
 	invoices.Get("/status/:status", h.GetInvoicesByStatus)
 	invoices.Get("/:id", h.GetInvoice)
 	invoices.Put("/:id", h.UpdateInvoice)
 	invoices.Delete("/:id", h.DeleteInvoice)
+	invoices.Post("/:id/issue", h.IssueInvoice)
+	invoices.Post("/:id/void", h.VoidInvoice)
 	invoices.Get("/:id/payments", h.GetInvoicePayments)
+	invoices.Get("/:id/pdf", h.GetInvoicePDF)
+	invoices.Get("/:id/dunning", h.GetInvoiceDunningHistory)
+	invoices.Get("/:id/late-fees", h.GetInvoiceLateFees)
+	invoices.Post("/:id/waive-late-fee", h.WaiveLateFee)
+	invoices.Get("/:id/credit-notes", h.GetInvoiceCreditNotes)
+	invoices.Post("/:id/credit-notes", h.CreateCreditNote)
+	invoices.Get("/:id/credit-notes/:creditNoteId/pdf", h.GetCreditNotePDF)
 
 	// Payments routes
 	payments := protected.Group("payments")
@@ -176,7 +261,30 @@ func setupRoutes(app *fiber.App, h *handlers.Handlers, authHandlers *handlers.Au
 	payments.Post("/", h.CreatePayment)
 	payments.Get("/:id", h.GetPayment)
 	payments.Put("/:id", h.UpdatePayment)
-	payments.Delete("/:id", h.DeletePayment)
+	payments.Delete("/:id", authMiddleware.RequireRole("admin"), h.DeletePayment)
+	payments.Get("/:id/adjustments", h.GetPaymentAdjustments)
+	payments.Post("/:id/reverse", h.ReversePayment)
+	payments.Post("/:id/refund", h.RefundPayment)
+
+	// Bank reconciliation routes
+	bankTransactions := protected.Group("bank-transactions")
+	bankTransactions.Get("/", h.GetBankTransactions)
+	bankTransactions.Post("/import", h.ImportBankStatement)
+	bankTransactions.Get("/:id", h.GetBankTransaction)
+	bankTransactions.Post("/:id/confirm", h.ConfirmBankTransaction)
+	bankTransactions.Post("/:id/split", h.SplitBankTransaction)
+	bankTransactions.Post("/:id/reject", h.RejectBankTransaction)
+
+	// Billing schedule routes
+	billingSchedules := protected.Group("billing-schedules")
+	billingSchedules.Get("/", h.GetBillingSchedules)
+	billingSchedules.Post("/", h.CreateBillingSchedule)
+	billingSchedules.Get("/:id", h.GetBillingSchedule)
+	billingSchedules.Put("/:id", h.UpdateBillingSchedule)
+	billingSchedules.Delete("/:id", h.DeleteBillingSchedule)
+	billingSchedules.Post("/:id/pause", h.PauseBillingSchedule)
+	billingSchedules.Post("/:id/resume", h.ResumeBillingSchedule)
+	billingSchedules.Get("/:id/preview", h.PreviewBillingSchedule)
 
 	// Loads routes
 	loads := protected.Group("loads")
@@ -196,4 +304,6 @@ func setupRoutes(app *fiber.App, h *handlers.Handlers, authHandlers *handlers.Au
 	// Administrative routes (только для admin)
 	admin := protected.Group("admin", authMiddleware.RequireRole("admin"))
 	admin.Post("/send-overdue-notifications", h.SendOverdueNotifications)
+	admin.Post("/accrue-late-fees", h.AccrueLateFees)
+	admin.Post("/generate-scheduled-invoices", h.GenerateScheduledInvoices)
 }
@@ -1,16 +1,29 @@
 package config
 
 import (
+	"fmt"
+	"log"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config конфигурация приложения
 type Config struct {
-	Server   ServerConfig   `json:"server"`
-	Database DatabaseConfig `json:"database"`
-	Email    EmailConfig    `json:"email"`
-	App      AppConfig      `json:"app"`
+	Server    ServerConfig    `json:"server"`
+	Database  DatabaseConfig  `json:"database"`
+	Email     EmailConfig     `json:"email"`
+	App       AppConfig       `json:"app"`
+	Company   CompanyConfig   `json:"company"`
+	Scheduler SchedulerConfig `json:"scheduler"`
+	Dunning   DunningConfig   `json:"dunning"`
+	Currency  CurrencyConfig  `json:"currency"`
+	Numbering NumberingConfig `json:"numbering"`
+	LateFees  LateFeeConfig   `json:"late_fees"`
+	Credit    CreditConfig    `json:"credit"`
 }
 
 // ServerConfig настройки сервера
@@ -42,8 +55,164 @@ type AppConfig struct {
 	Version     string `json:"version"`
 	Environment string `json:"environment"`
 	JWTSecret   string `json:"jwt_secret"`
+	Timezone    string `json:"timezone"` // часовой пояс дней и месяцев в сводках дашборда
 }
 
+// CompanyConfig реквизиты компании для счетов
+type CompanyConfig struct {
+	Name          string `json:"name"`
+	Street        string `json:"street"`
+	City          string `json:"city"`
+	State         string `json:"state"`
+	ZipCode       string `json:"zip_code"`
+	Country       string `json:"country"`
+	Phone         string `json:"phone"`
+	Email         string `json:"email"`
+	BankName      string `json:"bank_name"`
+	AccountName   string `json:"account_name"`
+	AccountNumber string `json:"account_number"`
+	RoutingNumber string `json:"routing_number"`
+	SWIFT         string `json:"swift"`
+	PaymentTerms  string `json:"payment_terms"`
+}
+
+// SchedulerConfig настройки фоновых задач.
+// Расписания задаются в формате cron (минута час день месяц день_недели), пустое расписание отключает задачу.
+type SchedulerConfig struct {
+	Enabled              bool   `json:"enabled"`
+	Timezone             string `json:"timezone"`
+	OverdueSweepSchedule string `json:"overdue_sweep_schedule"`
+	RemindersSchedule    string `json:"reminders_schedule"`
+	LateFeesSchedule     string `json:"late_fees_schedule"`
+	RecurringSchedule    string `json:"recurring_schedule"` // выставление счетов по расписаниям регулярных счетов
+	LockTTLMinutes       int    `json:"lock_ttl_minutes"`
+}
+
+// DunningConfig настройки напоминаний о задолженности
+type DunningConfig struct {
+	Stages []DunningStage `json:"stages"` // отсортированы по возрастанию DaysPastDue
+}
+
+// DunningStage этап напоминаний: письмо отправляется, когда просрочка счета достигает DaysPastDue дней.
+// Имя этапа выбирает шаблон письма: reminder, firm или final.
+type DunningStage struct {
+	Name        string `json:"name"`
+	DaysPastDue int    `json:"days_past_due"`
+	CreditHold  bool   `json:"credit_hold"` // перевести брокера в статус credit_hold
+}
+
+// LateFeeConfig правила начисления пеней за просрочку по умолчанию; брокеру можно задать собственные
+type LateFeeConfig struct {
+	Type        string  `json:"type"`         // none, flat, interest
+	FlatFee     float64 `json:"flat_fee"`     // фиксированная пеня в базовой валюте, пересчитывается по курсу счета
+	MonthlyRate float64 `json:"monthly_rate"` // процент от остатка за каждый полный месяц просрочки
+	GraceDays   int     `json:"grace_days"`   // дней после срока оплаты без начисления
+}
+
+// CreditConfig настройки контроля кредитных лимитов брокеров
+type CreditConfig struct {
+	AlertPercent float64 `json:"alert_percent"` // процент использования лимита, при достижении которого отправляется предупреждение
+	AlertEmail   string  `json:"alert_email"`   // получатель предупреждений о лимитах
+}
+
+// CurrencyConfig настройки валют
+type CurrencyConfig struct {
+	Base      string   `json:"base"`      // валюта отчетности, в нее пересчитываются сводные суммы
+	Supported []string `json:"supported"` // валюты счетов, платежей и грузов; всегда включает базовую
+}
+
+// IsSupported проверяет, что валюта разрешена
+func (c CurrencyConfig) IsSupported(currency string) bool {
+	for _, supported := range c.Supported {
+		if supported == currency {
+			return true
+		}
+	}
+	return false
+}
+
+// NumberingConfig форматы номеров документов
+type NumberingConfig struct {
+	Invoice    NumberFormat `json:"invoice"`
+	Load       NumberFormat `json:"load"`
+	CreditNote NumberFormat `json:"credit_note"`
+}
+
+// NumberFormat формат номера документа: префикс и шаблон с токенами {YYYY}, {YY}, {MM}, {DD} и {seq} или {seq:N},
+// где N - минимальное число цифр. Последовательность начинается заново, когда меняется номер без {seq},
+// например, каждый месяц для шаблона {YYYY}{MM}-{seq:4}.
+type NumberFormat struct {
+	Prefix  string `json:"prefix"`
+	Pattern string `json:"pattern"`
+}
+
+// numberTokenPattern токены шаблона номера
+var numberTokenPattern = regexp.MustCompile(`\{(YYYY|YY|MM|DD|seq(?::(\d+))?)\}`)
+
+// Render формирует номер на дату date с порядковым номером seq
+func (f NumberFormat) Render(date time.Time, seq int64) string {
+	return f.Prefix + numberTokenPattern.ReplaceAllStringFunc(f.Pattern, func(token string) string {
+		match := numberTokenPattern.FindStringSubmatch(token)
+		switch match[1] {
+		case "YYYY":
+			return fmt.Sprintf("%04d", date.Year())
+		case "YY":
+			return fmt.Sprintf("%02d", date.Year()%100)
+		case "MM":
+			return fmt.Sprintf("%02d", int(date.Month()))
+		case "DD":
+			return fmt.Sprintf("%02d", date.Day())
+		}
+		width, _ := strconv.Atoi(match[2])
+		return fmt.Sprintf("%0*d", width, seq)
+	})
+}
+
+// Period ключ периода последовательности на дату date: номер без порядкового номера
+func (f NumberFormat) Period(date time.Time) string {
+	period := NumberFormat{Prefix: f.Prefix, Pattern: numberTokenPattern.ReplaceAllStringFunc(f.Pattern, func(token string) string {
+		if strings.HasPrefix(token, "{seq") {
+			return ""
+		}
+		return token
+	})}
+	return period.Render(date, 0)
+}
+
+// Tokens разбирает шаблон на литералы и токены по порядку; токены возвращаются без фигурных скобок
+func (f NumberFormat) Tokens() (parts []string, isToken []bool) {
+	pattern := f.Prefix + f.Pattern
+	last := 0
+	for _, loc := range numberTokenPattern.FindAllStringIndex(pattern, -1) {
+		if loc[0] > last {
+			parts = append(parts, pattern[last:loc[0]])
+			isToken = append(isToken, false)
+		}
+		parts = append(parts, pattern[loc[0]+1:loc[1]-1])
+		isToken = append(isToken, true)
+		last = loc[1]
+	}
+	if last < len(pattern) {
+		parts = append(parts, pattern[last:])
+		isToken = append(isToken, false)
+	}
+	return parts, isToken
+}
+
+// validNumberPattern проверяет, что шаблон содержит ровно один порядковый номер
+func validNumberPattern(pattern string) bool {
+	count := 0
+	for _, match := range numberTokenPattern.FindAllStringSubmatch(pattern, -1) {
+		if strings.HasPrefix(match[1], "seq") {
+			count++
+		}
+	}
+	return count == 1
+}
+
+// defaultDunningStages этапы напоминаний по умолчанию
+const defaultDunningStages = "reminder:3,firm:15,final:45:hold"
+
 // Load загружает конфигурацию из переменных окружения
 func Load() *Config {
 	return &Config{
@@ -69,8 +238,71 @@ func Load() *Config {
 			Version:     getEnv("APP_VERSION", "1.0.0"),
 			Environment: getEnv("APP_ENV", "development"),
 			JWTSecret:   getEnv("JWT_SECRET", "your-secret-key-here"),
+			Timezone:    getEnv("APP_TIMEZONE", "UTC"),
+		},
+		Company: CompanyConfig{
+			Name:          getEnv("COMPANY_NAME", "Billing System"),
+			Street:        getEnv("COMPANY_STREET", ""),
+			City:          getEnv("COMPANY_CITY", ""),
+			State:         getEnv("COMPANY_STATE", ""),
+			ZipCode:       getEnv("COMPANY_ZIP", ""),
+			Country:       getEnv("COMPANY_COUNTRY", ""),
+			Phone:         getEnv("COMPANY_PHONE", ""),
+			Email:         getEnv("COMPANY_EMAIL", ""),
+			BankName:      getEnv("REMIT_BANK_NAME", ""),
+			AccountName:   getEnv("REMIT_ACCOUNT_NAME", ""),
+			AccountNumber: getEnv("REMIT_ACCOUNT_NUMBER", ""),
+			RoutingNumber: getEnv("REMIT_ROUTING_NUMBER", ""),
+			SWIFT:         getEnv("REMIT_SWIFT", ""),
+			PaymentTerms:  getEnv("PAYMENT_TERMS", "Net 30"),
+		},
+		Scheduler: SchedulerConfig{
+			Enabled:              getEnvAsBool("SCHEDULER_ENABLED", true),
+			Timezone:             getEnv("SCHEDULER_TIMEZONE", "UTC"),
+			OverdueSweepSchedule: getEnv("OVERDUE_SWEEP_SCHEDULE", "0 1 * * *"),
+			RemindersSchedule:    getEnv("OVERDUE_REMINDERS_SCHEDULE", "0 9 * * 1-5"),
+			LateFeesSchedule:     getEnv("LATE_FEES_SCHEDULE", "0 2 * * *"),
+			RecurringSchedule:    getEnv("RECURRING_INVOICES_SCHEDULE", "0 6 * * *"),
+			LockTTLMinutes:       getEnvAsInt("SCHEDULER_LOCK_TTL_MINUTES", 30),
+		},
+		Dunning: DunningConfig{
+			Stages: getEnvAsDunningStages("DUNNING_STAGES", defaultDunningStages),
 		},
+		Currency: newCurrencyConfig(
+			strings.ToUpper(getEnv("BASE_CURRENCY", "USD")),
+			getEnvAsList("SUPPORTED_CURRENCIES", "USD,EUR,RUB"),
+		),
+		Numbering: NumberingConfig{
+			Invoice:    getEnvAsNumberFormat("INVOICE_NUMBER", "INV-", "{YYYY}{MM}-{seq:4}"),
+			Load:       getEnvAsNumberFormat("LOAD_NUMBER", "LD-", "{YYYY}{MM}{DD}-{seq:3}"),
+			CreditNote: getEnvAsNumberFormat("CREDIT_NOTE_NUMBER", "CN-", "{YYYY}{MM}-{seq:4}"),
+		},
+		LateFees: LateFeeConfig{
+			Type:        getEnv("LATE_FEE_TYPE", "none"),
+			FlatFee:     getEnvAsFloat("LATE_FEE_FLAT_AMOUNT", 0),
+			MonthlyRate: getEnvAsFloat("LATE_FEE_MONTHLY_RATE", 0),
+			GraceDays:   getEnvAsInt("LATE_FEE_GRACE_DAYS", 0),
+		},
+		Credit: CreditConfig{
+			AlertPercent: getEnvAsFloat("CREDIT_ALERT_PERCENT", 80),
+			AlertEmail:   getEnv("CREDIT_ALERT_EMAIL", getEnv("COMPANY_EMAIL", "")),
+		},
+	}
+}
+
+// newCurrencyConfig собирает настройки валют, добавляя базовую валюту в список разрешенных
+func newCurrencyConfig(base string, supported []string) CurrencyConfig {
+	currencies := CurrencyConfig{Base: base}
+	for _, currency := range supported {
+		currency = strings.ToUpper(currency)
+		if !currencies.IsSupported(currency) {
+			currencies.Supported = append(currencies.Supported, currency)
+		}
+	}
+	if !currencies.IsSupported(base) {
+		currencies.Supported = append([]string{base}, currencies.Supported...)
 	}
+	return currencies
 }
 
 // getEnv получает переменную окружения или возвращает значение по умолчанию
@@ -89,3 +321,93 @@ func getEnvAsInt(name string, fallback int) int {
 	}
 	return fallback
 }
+
+// getEnvAsFloat получает переменную окружения как float64 или возвращает значение по умолчанию
+func getEnvAsFloat(name string, fallback float64) float64 {
+	valueStr := getEnv(name, "")
+	if value, err := strconv.ParseFloat(valueStr, 64); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// getEnvAsBool получает переменную окружения как bool или возвращает значение по умолчанию
+func getEnvAsBool(name string, fallback bool) bool {
+	valueStr := getEnv(name, "")
+	if value, err := strconv.ParseBool(valueStr); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// getEnvAsList получает переменную окружения как список значений через запятую
+func getEnvAsList(name, fallback string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(name, fallback), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvAsNumberFormat получает формат номера из переменных <name>_PREFIX и <name>_FORMAT.
+// Шаблон без единственного токена {seq} заменяется шаблоном по умолчанию.
+func getEnvAsNumberFormat(name, prefix, pattern string) NumberFormat {
+	format := NumberFormat{
+		Prefix:  getEnv(name+"_PREFIX", prefix),
+		Pattern: getEnv(name+"_FORMAT", pattern),
+	}
+	if !validNumberPattern(format.Pattern) {
+		format.Pattern = pattern
+	}
+	return format
+}
+
+// getEnvAsDunningStages получает этапы напоминаний в формате "name:days[:hold],..." или возвращает значение по умолчанию.
+// Ошибка в значении записывается в лог: этапы определяют, когда брокер попадает на кредитный стоп.
+func getEnvAsDunningStages(name, fallback string) []DunningStage {
+	stages, err := parseDunningStages(getEnv(name, fallback))
+	if err == nil {
+		return stages
+	}
+	log.Printf("Некорректное значение %s: %v; используются этапы по умолчанию %q", name, err, fallback)
+	stages, _ = parseDunningStages(fallback)
+	return stages
+}
+
+// parseDunningStages разбирает этапы напоминаний и сортирует их по сроку просрочки
+func parseDunningStages(value string) ([]DunningStage, error) {
+	var stages []DunningStage
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid dunning stage %q", item)
+		}
+
+		days, err := strconv.Atoi(parts[1])
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("invalid dunning stage %q", item)
+		}
+
+		stage := DunningStage{Name: parts[0], DaysPastDue: days}
+		if len(parts) == 3 {
+			if parts[2] != "hold" {
+				return nil, fmt.Errorf("invalid dunning stage %q", item)
+			}
+			stage.CreditHold = true
+		}
+		stages = append(stages, stage)
+	}
+
+	sort.Slice(stages, func(i, j int) bool {
+		return stages[i].DaysPastDue < stages[j].DaysPastDue
+	})
+
+	return stages, nil
+}
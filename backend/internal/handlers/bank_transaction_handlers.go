@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"billing-system/internal/models"
+	"billing-system/internal/services"
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Bank reconciliation handlers
+
+// ImportBankStatement загружает банковскую выписку (поле формы file; необязательные format и currency)
+func (h *Handlers) ImportBankStatement(c *fiber.Ctx) error {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Statement file is required",
+		})
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to read statement file",
+		})
+	}
+	defer file.Close()
+
+	username, _ := c.Locals("username").(string)
+	result, err := h.bankReconciliationService.ImportStatement(c.Context(), c.FormValue("format"), c.FormValue("currency"), file, username)
+	if err != nil {
+		return bankTransactionError(c, err, "Failed to import bank statement")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d bank transactions imported, %d matched", result.Imported, result.Matched),
+		Data:    result,
+	})
+}
+
+// GetBankTransactions получает банковские операции (фильтры status, import_id, broker_id, date_from, date_to)
+func (h *Handlers) GetBankTransactions(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	filter := &models.BankTransactionFilter{
+		Status: c.Query("status"),
+	}
+
+	var err error
+	if importID := c.Query("import_id"); importID != "" {
+		if filter.ImportID, err = primitive.ObjectIDFromHex(importID); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid import ID",
+			})
+		}
+	}
+	if brokerID := c.Query("broker_id"); brokerID != "" {
+		if filter.BrokerID, err = primitive.ObjectIDFromHex(brokerID); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid broker ID",
+			})
+		}
+	}
+	if filter.DateFrom, err = queryDate(c, "date_from"); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid date_from, expected YYYY-MM-DD",
+		})
+	}
+	if filter.DateTo, err = queryDate(c, "date_to"); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid date_to, expected YYYY-MM-DD",
+		})
+	}
+
+	transactions, pagination, err := h.bankReconciliationService.GetTransactions(c.Context(), filter, page, limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch bank transactions",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success":    true,
+		"data":       transactions,
+		"pagination": pagination,
+	})
+}
+
+// GetBankTransaction получает банковскую операцию с предложенным сопоставлением
+func (h *Handlers) GetBankTransaction(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid bank transaction ID",
+		})
+	}
+
+	transaction, err := h.bankReconciliationService.GetTransaction(c.Context(), id)
+	if err != nil {
+		return bankTransactionError(c, err, "Failed to fetch bank transaction")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    transaction,
+	})
+}
+
+// ConfirmBankTransaction проводит банковскую операцию как платеж
+func (h *Handlers) ConfirmBankTransaction(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid bank transaction ID",
+		})
+	}
+
+	// Тело необязательно: без него проводится предложенное сопоставление
+	var req models.ConfirmBankTransactionRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid request body",
+			})
+		}
+	}
+
+	username, _ := c.Locals("username").(string)
+	payment, err := h.bankReconciliationService.ConfirmTransaction(c.Context(), id, &req, username)
+	if err != nil {
+		return bankTransactionError(c, err, "Failed to confirm bank transaction")
+	}
+
+	return c.Status(201).JSON(models.APIResponse{
+		Success: true,
+		Message: "Bank transaction confirmed, payment created",
+		Data:    payment,
+	})
+}
+
+// SplitBankTransaction делит банковскую операцию на части для отдельного разбора
+func (h *Handlers) SplitBankTransaction(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid bank transaction ID",
+		})
+	}
+
+	var req models.SplitBankTransactionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	username, _ := c.Locals("username").(string)
+	parts, err := h.bankReconciliationService.SplitTransaction(c.Context(), id, &req, username)
+	if err != nil {
+		return bankTransactionError(c, err, "Failed to split bank transaction")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Bank transaction split into %d parts", len(parts)),
+		Data:    parts,
+	})
+}
+
+// RejectBankTransaction отклоняет банковскую операцию
+func (h *Handlers) RejectBankTransaction(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid bank transaction ID",
+		})
+	}
+
+	var req models.RejectBankTransactionRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid request body",
+			})
+		}
+	}
+
+	username, _ := c.Locals("username").(string)
+	transaction, err := h.bankReconciliationService.RejectTransaction(c.Context(), id, &req, username)
+	if err != nil {
+		return bankTransactionError(c, err, "Failed to reject bank transaction")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Bank transaction rejected",
+		Data:    transaction,
+	})
+}
+
+// bankTransactionError формирует ответ на ошибку сверки
+func bankTransactionError(c *fiber.Ctx, err error, message string) error {
+	if err == mongo.ErrNoDocuments {
+		return c.Status(404).JSON(fiber.Map{
+			"success": false,
+			"error":   "Bank transaction not found",
+		})
+	}
+	if validationErr, ok := err.(*services.ValidationError); ok {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   validationErr.Message,
+		})
+	}
+	return c.Status(500).JSON(fiber.Map{
+		"success": false,
+		"error":   message,
+	})
+}
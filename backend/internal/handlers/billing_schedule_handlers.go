@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"billing-system/internal/models"
+	"billing-system/internal/services"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Billing schedule handlers
+
+// GetBillingSchedules получает расписания регулярных счетов (фильтры broker_id, status)
+func (h *Handlers) GetBillingSchedules(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	filter := &models.BillingScheduleFilter{
+		Status: c.Query("status"),
+	}
+	if brokerID := c.Query("broker_id"); brokerID != "" {
+		var err error
+		if filter.BrokerID, err = primitive.ObjectIDFromHex(brokerID); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid broker ID",
+			})
+		}
+	}
+
+	schedules, pagination, err := h.billingScheduleService.GetSchedules(c.Context(), filter, page, limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch billing schedules",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success":    true,
+		"data":       schedules,
+		"pagination": pagination,
+	})
+}
+
+// CreateBillingSchedule создает расписание регулярных счетов
+func (h *Handlers) CreateBillingSchedule(c *fiber.Ctx) error {
+	var schedule models.BillingSchedule
+	if err := c.BodyParser(&schedule); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	username, _ := c.Locals("username").(string)
+	if err := h.billingScheduleService.CreateSchedule(c.Context(), &schedule, username); err != nil {
+		return billingScheduleError(c, err, "Failed to create billing schedule")
+	}
+
+	return c.Status(201).JSON(models.APIResponse{
+		Success: true,
+		Message: "Billing schedule created successfully",
+		Data:    schedule,
+	})
+}
+
+// GetBillingSchedule получает расписание по ID
+func (h *Handlers) GetBillingSchedule(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid billing schedule ID",
+		})
+	}
+
+	schedule, err := h.billingScheduleService.GetSchedule(c.Context(), id)
+	if err != nil {
+		return billingScheduleError(c, err, "Failed to get billing schedule")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    schedule,
+	})
+}
+
+// UpdateBillingSchedule обновляет расписание; изменения действуют для следующих периодов
+func (h *Handlers) UpdateBillingSchedule(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid billing schedule ID",
+		})
+	}
+
+	var schedule models.BillingSchedule
+	if err := c.BodyParser(&schedule); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := h.billingScheduleService.UpdateSchedule(c.Context(), id, &schedule); err != nil {
+		return billingScheduleError(c, err, "Failed to update billing schedule")
+	}
+
+	updated, err := h.billingScheduleService.GetSchedule(c.Context(), id)
+	if err != nil {
+		return billingScheduleError(c, err, "Failed to get billing schedule")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Billing schedule updated successfully",
+		Data:    updated,
+	})
+}
+
+// DeleteBillingSchedule удаляет расписание без выставленных счетов
+func (h *Handlers) DeleteBillingSchedule(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid billing schedule ID",
+		})
+	}
+
+	if err := h.billingScheduleService.DeleteSchedule(c.Context(), id); err != nil {
+		return billingScheduleError(c, err, "Failed to delete billing schedule")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Billing schedule deleted successfully",
+	})
+}
+
+// PauseBillingSchedule приостанавливает расписание
+func (h *Handlers) PauseBillingSchedule(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid billing schedule ID",
+		})
+	}
+
+	schedule, err := h.billingScheduleService.PauseSchedule(c.Context(), id)
+	if err != nil {
+		return billingScheduleError(c, err, "Failed to pause billing schedule")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Billing schedule paused",
+		Data:    schedule,
+	})
+}
+
+// ResumeBillingSchedule возобновляет расписание со следующего периода
+func (h *Handlers) ResumeBillingSchedule(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid billing schedule ID",
+		})
+	}
+
+	schedule, err := h.billingScheduleService.ResumeSchedule(c.Context(), id)
+	if err != nil {
+		return billingScheduleError(c, err, "Failed to resume billing schedule")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Billing schedule resumed",
+		Data:    schedule,
+	})
+}
+
+// PreviewBillingSchedule показывает следующие счета расписания (count, по умолчанию 3)
+func (h *Handlers) PreviewBillingSchedule(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid billing schedule ID",
+		})
+	}
+
+	invoices, err := h.billingScheduleService.PreviewSchedule(c.Context(), id, c.QueryInt("count", 3))
+	if err != nil {
+		return billingScheduleError(c, err, "Failed to preview billing schedule")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    invoices,
+	})
+}
+
+// GenerateScheduledInvoices выставляет счета по наступившим периодам расписаний (то же делает задача recurring_invoices)
+func (h *Handlers) GenerateScheduledInvoices(c *fiber.Ctx) error {
+	summary, err := h.billingScheduleService.Run(c.Context())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to generate scheduled invoices",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Scheduled invoices generated",
+		"data":    summary,
+	})
+}
+
+// billingScheduleError формирует ответ на ошибку операции с расписанием
+func billingScheduleError(c *fiber.Ctx, err error, message string) error {
+	if err == mongo.ErrNoDocuments {
+		return c.Status(404).JSON(fiber.Map{
+			"success": false,
+			"error":   "Billing schedule not found",
+		})
+	}
+	if validationErr, ok := err.(*services.ValidationError); ok {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   validationErr.Message,
+		})
+	}
+	return c.Status(500).JSON(fiber.Map{
+		"success": false,
+		"error":   message,
+	})
+}
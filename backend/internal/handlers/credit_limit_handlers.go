@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"billing-system/internal/models"
+	"billing-system/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Credit limit handlers
+
+// GetBrokerExposure получает кредитную нагрузку брокера относительно его кредитного лимита
+func (h *Handlers) GetBrokerExposure(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid broker ID",
+		})
+	}
+
+	exposure, err := h.creditLimitService.GetExposure(c.Context(), id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"success": false,
+				"error":   "Broker not found",
+			})
+		}
+		if validationErr, ok := err.(*services.ValidationError); ok {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   validationErr.Message,
+			})
+		}
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to get broker exposure",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    exposure,
+	})
+}
+
+// approveCreditOverride подписывает разрешение превысить кредитный лимит именем текущего пользователя.
+// Разрешение может дать только администратор; false означает, что у пользователя нет прав.
+func approveCreditOverride(c *fiber.Ctx, override *models.CreditOverride) bool {
+	if override == nil {
+		return true
+	}
+	if role, _ := c.Locals("role").(string); role != "admin" {
+		return false
+	}
+	override.ApprovedBy, _ = c.Locals("username").(string)
+	return true
+}
+
+// creditOverrideForbidden ответ на попытку превысить кредитный лимит без прав администратора
+func creditOverrideForbidden(c *fiber.Ctx) error {
+	return c.Status(403).JSON(fiber.Map{
+		"success": false,
+		"error":   "Only administrators can override the credit limit",
+	})
+}
+
+// creditLimitExceeded ответ на документ сверх кредитного лимита: 409 с текущей нагрузкой брокера
+// и суммой документа в валюте лимита
+func creditLimitExceeded(c *fiber.Ctx, limitErr *services.CreditLimitError) error {
+	return c.Status(409).JSON(fiber.Map{
+		"success":  false,
+		"error":    limitErr.Message,
+		"exposure": limitErr.Exposure,
+		"amount":   limitErr.Amount,
+	})
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"billing-system/internal/models"
+	"billing-system/internal/services"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Credit note handlers
+
+// GetInvoiceCreditNotes получает кредит-ноты счета
+func (h *Handlers) GetInvoiceCreditNotes(c *fiber.Ctx) error {
+	invoiceID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid invoice ID",
+		})
+	}
+
+	notes, err := h.creditNoteService.GetInvoiceCreditNotes(c.Context(), invoiceID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch credit notes",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    notes,
+	})
+}
+
+// CreateCreditNote выписывает кредит-ноту к счету
+func (h *Handlers) CreateCreditNote(c *fiber.Ctx) error {
+	invoiceID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid invoice ID",
+		})
+	}
+
+	var note models.CreditNote
+	if err := c.BodyParser(&note); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+	note.CreatedBy, _ = c.Locals("username").(string)
+
+	if err := h.creditNoteService.CreateCreditNote(c.Context(), invoiceID, &note); err != nil {
+		if validationErr, ok := err.(*services.ValidationError); ok {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   validationErr.Message,
+			})
+		}
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to create credit note",
+		})
+	}
+
+	return c.Status(201).JSON(models.APIResponse{
+		Success: true,
+		Message: "Credit note created successfully",
+		Data:    note,
+	})
+}
+
+// GetCreditNotePDF отдает кредит-ноту в формате PDF
+func (h *Handlers) GetCreditNotePDF(c *fiber.Ctx) error {
+	invoiceID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid invoice ID",
+		})
+	}
+
+	creditNoteID, err := primitive.ObjectIDFromHex(c.Params("creditNoteId"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid credit note ID",
+		})
+	}
+
+	if _, err := h.creditNoteService.GetCreditNote(c.Context(), invoiceID, creditNoteID); err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"success": false,
+			"error":   "Credit note not found",
+		})
+	}
+
+	note, pdf, err := h.creditNoteService.RenderCreditNote(c.Context(), invoiceID, creditNoteID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to render credit note PDF",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`inline; filename="%s.pdf"`, note.CreditNoteNumber))
+	return c.Send(pdf)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"billing-system/internal/models"
+	"billing-system/internal/services"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Exchange rate handlers
+
+// GetCurrencies получает базовую валюту и список разрешенных валют
+func (h *Handlers) GetCurrencies(c *fiber.Ctx) error {
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    h.exchangeRateService.Currencies(),
+	})
+}
+
+// GetExchangeRates получает загруженные курсы валют
+func (h *Handlers) GetExchangeRates(c *fiber.Ctx) error {
+	filter := &models.ExchangeRateFilter{
+		Currency: strings.ToUpper(c.Query("currency")),
+	}
+
+	var err error
+	if filter.DateFrom, err = queryDate(c, "date_from"); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid date_from, expected YYYY-MM-DD",
+		})
+	}
+	if filter.DateTo, err = queryDate(c, "date_to"); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid date_to, expected YYYY-MM-DD",
+		})
+	}
+
+	rates, err := h.exchangeRateService.GetRates(c.Context(), filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to get exchange rates",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    rates,
+	})
+}
+
+// SaveExchangeRates загружает курсы валют через API
+func (h *Handlers) SaveExchangeRates(c *fiber.Ctx) error {
+	var req models.ExchangeRatesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	rates := make([]*models.ExchangeRate, 0, len(req.Rates))
+	for i, input := range req.Rates {
+		date, err := time.Parse("2006-01-02", input.Date)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   fmt.Sprintf("Rate %d: date must be in YYYY-MM-DD format", i+1),
+			})
+		}
+		rates = append(rates, &models.ExchangeRate{
+			Currency: input.Currency,
+			Date:     date,
+			Rate:     input.Rate,
+			Source:   models.ExchangeRateSourceAPI,
+		})
+	}
+
+	saved, err := h.exchangeRateService.SaveRates(c.Context(), rates)
+	if err != nil {
+		return exchangeRateError(c, err)
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d exchange rates saved", saved),
+		Data:    fiber.Map{"saved": saved},
+	})
+}
+
+// ImportExchangeRates загружает курсы валют из CSV файла (поле формы file)
+func (h *Handlers) ImportExchangeRates(c *fiber.Ctx) error {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "CSV file is required",
+		})
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to read CSV file",
+		})
+	}
+	defer file.Close()
+
+	saved, err := h.exchangeRateService.ImportCSV(c.Context(), file)
+	if err != nil {
+		return exchangeRateError(c, err)
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d exchange rates imported", saved),
+		Data:    fiber.Map{"saved": saved},
+	})
+}
+
+// queryDate разбирает необязательный параметр запроса с датой YYYY-MM-DD
+func queryDate(c *fiber.Ctx, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
+
+// exchangeRateError формирует ответ на ошибку загрузки курсов
+func exchangeRateError(c *fiber.Ctx, err error) error {
+	if validationErr, ok := err.(*services.ValidationError); ok {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   validationErr.Message,
+		})
+	}
+	return c.Status(500).JSON(fiber.Map{
+		"success": false,
+		"error":   "Failed to save exchange rates",
+	})
+}
@@ -3,20 +3,39 @@ package handlers
 import (
 	"billing-system/internal/models"
 	"billing-system/internal/services"
+	"bufio"
+	"context"
+	"fmt"
+	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// exportTimeout максимальное время формирования файла экспорта
+const exportTimeout = 10 * time.Minute
+
 // Handlers основная структура handlers
 type Handlers struct {
-	brokerService    services.BrokerService
-	invoiceService   services.InvoiceService
-	paymentService   services.PaymentService
-	loadService      services.LoadService
-	dashboardService services.DashboardService
+	brokerService             services.BrokerService
+	invoiceService            services.InvoiceService
+	paymentService            services.PaymentService
+	loadService               services.LoadService
+	dashboardService          services.DashboardService
+	reportService             services.ReportService
+	exportService             services.ExportService
+	pdfService                services.PDFService
+	dunningService            services.DunningService
+	exchangeRateService       services.ExchangeRateService
+	creditNoteService         services.CreditNoteService
+	bankReconciliationService services.BankReconciliationService
+	lateFeeService            services.LateFeeService
+	billingScheduleService    services.BillingScheduleService
+	creditLimitService        services.CreditLimitService
 }
 
 // NewHandlers создает новый экземпляр handlers
@@ -26,13 +45,33 @@ func NewHandlers(
 	paymentService services.PaymentService,
 	loadService services.LoadService,
 	dashboardService services.DashboardService,
+	reportService services.ReportService,
+	exportService services.ExportService,
+	pdfService services.PDFService,
+	dunningService services.DunningService,
+	exchangeRateService services.ExchangeRateService,
+	creditNoteService services.CreditNoteService,
+	bankReconciliationService services.BankReconciliationService,
+	lateFeeService services.LateFeeService,
+	billingScheduleService services.BillingScheduleService,
+	creditLimitService services.CreditLimitService,
 ) *Handlers {
 	return &Handlers{
-		brokerService:    brokerService,
-		invoiceService:   invoiceService,
-		paymentService:   paymentService,
-		loadService:      loadService,
-		dashboardService: dashboardService,
+		brokerService:             brokerService,
+		invoiceService:            invoiceService,
+		paymentService:            paymentService,
+		loadService:               loadService,
+		dashboardService:          dashboardService,
+		reportService:             reportService,
+		exportService:             exportService,
+		pdfService:                pdfService,
+		dunningService:            dunningService,
+		exchangeRateService:       exchangeRateService,
+		creditNoteService:         creditNoteService,
+		bankReconciliationService: bankReconciliationService,
+		lateFeeService:            lateFeeService,
+		billingScheduleService:    billingScheduleService,
+		creditLimitService:        creditLimitService,
 	}
 }
 
@@ -40,7 +79,13 @@ func NewHandlers(
 
 // GetDashboardMetrics получает метрики дашборда
 func (h *Handlers) GetDashboardMetrics(c *fiber.Ctx) error {
-	metrics, err := h.dashboardService.GetDashboardMetrics(c.Context())
+	period := &models.DashboardPeriod{
+		Days:       c.QueryInt("days", 30),
+		Months:     c.QueryInt("months", 12),
+		TopDebtors: c.QueryInt("top", 10),
+	}
+
+	metrics, err := h.dashboardService.GetDashboardMetrics(c.Context(), period)
 	if err != nil {
 		return err
 	}
@@ -187,6 +232,87 @@ func (h *Handlers) DeleteBroker(c *fiber.Ctx) error {
 	})
 }
 
+// UpdateBrokerStatus обновляет статус брокера
+func (h *Handlers) UpdateBrokerStatus(c *fiber.Ctx) error {
+	objectID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid broker ID",
+		})
+	}
+
+	var statusRequest struct {
+		Status string `json:"status"`
+	}
+	if err := c.BodyParser(&statusRequest); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := h.brokerService.UpdateBrokerStatus(c.Context(), objectID, statusRequest.Status); err != nil {
+		return brokerError(c, err, "Failed to update broker status")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Broker status updated successfully",
+	})
+}
+
+// ReleaseBrokerCreditHold снимает кредитный стоп брокера
+func (h *Handlers) ReleaseBrokerCreditHold(c *fiber.Ctx) error {
+	objectID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid broker ID",
+		})
+	}
+
+	var req models.ReleaseCreditHoldRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	username, _ := c.Locals("username").(string)
+	broker, err := h.brokerService.ReleaseCreditHold(c.Context(), objectID, &req, username)
+	if err != nil {
+		return brokerError(c, err, "Failed to release credit hold")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Credit hold released",
+		Data:    broker,
+	})
+}
+
+// brokerError формирует ответ на ошибку операции с брокером
+func brokerError(c *fiber.Ctx, err error, message string) error {
+	if err == mongo.ErrNoDocuments {
+		return c.Status(404).JSON(fiber.Map{
+			"success": false,
+			"error":   "Broker not found",
+		})
+	}
+	if validationErr, ok := err.(*services.ValidationError); ok {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   validationErr.Message,
+		})
+	}
+	return c.Status(500).JSON(fiber.Map{
+		"success": false,
+		"error":   message,
+	})
+}
+
 // SearchBrokers поиск брокеров
 func (h *Handlers) GetAllBrokers(c *fiber.Ctx) error {
 	page, _ := strconv.Atoi(c.Query("page", "1"))
@@ -313,6 +439,71 @@ func (h *Handlers) GetBrokerPayments(c *fiber.Ctx) error {
 	})
 }
 
+// GetBrokerCredit получает неразнесенный кредит брокера по валютам
+func (h *Handlers) GetBrokerCredit(c *fiber.Ctx) error {
+	objectID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid broker ID",
+		})
+	}
+
+	credit, err := h.paymentService.GetBrokerCredit(c.Context(), objectID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch broker credit",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    credit,
+	})
+}
+
+// ApplyBrokerCredit зачитывает неразнесенный кредит брокера в оплату открытых счетов
+func (h *Handlers) ApplyBrokerCredit(c *fiber.Ctx) error {
+	objectID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid broker ID",
+		})
+	}
+
+	var req models.ApplyCreditRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid request body",
+			})
+		}
+	}
+
+	result, err := h.paymentService.ApplyBrokerCredit(c.Context(), objectID, &req)
+	if err != nil {
+		if validationErr, ok := err.(*services.ValidationError); ok {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   validationErr.Message,
+			})
+		}
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to apply broker credit",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Broker credit applied successfully",
+		Data:    result,
+	})
+}
+
 // GetBrokerUnbilledLoads получает неоплаченные грузы брокера
 func (h *Handlers) GetBrokerUnbilledLoads(c *fiber.Ctx) error {
 	id := c.Params("id")
@@ -388,7 +579,33 @@ func (h *Handlers) CreateInvoice(c *fiber.Ctx) error {
 		})
 	}
 
+	if !approveCreditOverride(c, invoice.CreditOverride) {
+		return creditOverrideForbidden(c)
+	}
+
 	err := h.invoiceService.CreateInvoice(c.Context(), &invoice)
+	if err != nil {
+		return invoiceError(c, err, "Failed to create invoice")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Invoice created successfully",
+		"data":    invoice,
+	})
+}
+
+// CreateInvoiceFromLoads выставляет счет по выбранным грузам брокера
+func (h *Handlers) CreateInvoiceFromLoads(c *fiber.Ctx) error {
+	var req models.InvoiceFromLoadsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	invoices, err := h.invoiceService.CreateInvoiceFromLoads(c.Context(), &req)
 	if err != nil {
 		if validationErr, ok := err.(*services.ValidationError); ok {
 			return c.Status(400).JSON(fiber.Map{
@@ -405,7 +622,7 @@ func (h *Handlers) CreateInvoice(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
 		"success": true,
 		"message": "Invoice created successfully",
-		"data":    invoice,
+		"data":    invoices,
 	})
 }
 
@@ -433,6 +650,38 @@ func (h *Handlers) GetInvoice(c *fiber.Ctx) error {
 	})
 }
 
+// GetInvoicePDF отдает счет в формате PDF
+func (h *Handlers) GetInvoicePDF(c *fiber.Ctx) error {
+	id := c.Params("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid invoice ID",
+		})
+	}
+
+	invoice, err := h.invoiceService.GetInvoice(c.Context(), objectID)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invoice not found",
+		})
+	}
+
+	pdf, err := h.pdfService.RenderInvoice(c.Context(), invoice)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to render invoice PDF",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`inline; filename="%s.pdf"`, invoice.InvoiceNumber))
+	return c.Send(pdf)
+}
+
 // UpdateInvoice обновляет счет
 func (h *Handlers) UpdateInvoice(c *fiber.Ctx) error {
 	id := c.Params("id")
@@ -451,17 +700,12 @@ func (h *Handlers) UpdateInvoice(c *fiber.Ctx) error {
 		})
 	}
 
+	if !approveCreditOverride(c, invoice.CreditOverride) {
+		return creditOverrideForbidden(c)
+	}
+
 	if err := h.invoiceService.UpdateInvoice(c.Context(), objectID, &invoice); err != nil {
-		if validationErr, ok := err.(*services.ValidationError); ok {
-			return c.Status(400).JSON(fiber.Map{
-				"success": false,
-				"error":   validationErr.Message,
-			})
-		}
-		return c.Status(500).JSON(fiber.Map{
-			"success": false,
-			"error":   "Failed to update invoice",
-		})
+		return invoiceError(c, err, "Failed to update invoice")
 	}
 
 	return c.JSON(fiber.Map{
@@ -482,10 +726,7 @@ func (h *Handlers) DeleteInvoice(c *fiber.Ctx) error {
 	}
 
 	if err := h.invoiceService.DeleteInvoice(c.Context(), objectID); err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"success": false,
-			"error":   "Failed to delete invoice",
-		})
+		return invoiceError(c, err, "Failed to delete invoice")
 	}
 
 	return c.JSON(fiber.Map{
@@ -521,6 +762,31 @@ func (h *Handlers) GetInvoicePayments(c *fiber.Ctx) error {
 	})
 }
 
+// GetInvoiceDunningHistory получает историю напоминаний по счету
+func (h *Handlers) GetInvoiceDunningHistory(c *fiber.Ctx) error {
+	id := c.Params("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid invoice ID",
+		})
+	}
+
+	records, err := h.dunningService.GetInvoiceHistory(c.Context(), objectID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to fetch dunning history",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    records,
+	})
+}
+
 // GetPayments получает список платежей
 func (h *Handlers) GetPayments(c *fiber.Ctx) error {
 	page, _ := strconv.Atoi(c.Query("page", "1"))
@@ -538,6 +804,9 @@ func (h *Handlers) GetPayments(c *fiber.Ctx) error {
 	if method := c.Query("method"); method != "" {
 		filter.PaymentMethod = method
 	}
+	if paymentType := c.Query("type"); paymentType != "" {
+		filter.Type = paymentType
+	}
 
 	payments, pagination, err := h.paymentService.GetAllPayments(c.Context(), filter, page, limit)
 	if err != nil {
@@ -564,6 +833,9 @@ func (h *Handlers) CreatePayment(c *fiber.Ctx) error {
 		})
 	}
 
+	// Платеж по банковской операции проводится только подтверждением сверки
+	payment.BankTransactionID = primitive.NilObjectID
+
 	err := h.paymentService.CreatePayment(c.Context(), &payment)
 	if err != nil {
 		if validationErr, ok := err.(*services.ValidationError); ok {
@@ -660,10 +932,7 @@ func (h *Handlers) DeletePayment(c *fiber.Ctx) error {
 	}
 
 	if err := h.paymentService.DeletePayment(c.Context(), objectID); err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"success": false,
-			"error":   "Failed to delete payment",
-		})
+		return paymentAdjustmentError(c, err, "Failed to delete payment")
 	}
 
 	return c.JSON(fiber.Map{
@@ -720,8 +989,15 @@ func (h *Handlers) CreateLoad(c *fiber.Ctx) error {
 		})
 	}
 
+	if !approveCreditOverride(c, load.CreditOverride) {
+		return creditOverrideForbidden(c)
+	}
+
 	err := h.loadService.CreateLoad(c.Context(), &load)
 	if err != nil {
+		if limitErr, ok := err.(*services.CreditLimitError); ok {
+			return creditLimitExceeded(c, limitErr)
+		}
 		if validationErr, ok := err.(*services.ValidationError); ok {
 			return c.Status(400).JSON(fiber.Map{
 				"success": false,
@@ -785,7 +1061,14 @@ func (h *Handlers) UpdateLoad(c *fiber.Ctx) error {
 		})
 	}
 
+	if !approveCreditOverride(c, load.CreditOverride) {
+		return creditOverrideForbidden(c)
+	}
+
 	if err := h.loadService.UpdateLoad(c.Context(), objectID, &load); err != nil {
+		if limitErr, ok := err.(*services.CreditLimitError); ok {
+			return creditLimitExceeded(c, limitErr)
+		}
 		if validationErr, ok := err.(*services.ValidationError); ok {
 			return c.Status(400).JSON(fiber.Map{
 				"success": false,
@@ -870,34 +1153,107 @@ func (h *Handlers) UpdateLoadStatus(c *fiber.Ctx) error {
 
 // ExportInvoices экспорт счетов
 func (h *Handlers) ExportInvoices(c *fiber.Ctx) error {
-	// TODO: Реализовать
-	return c.JSON(models.APIResponse{
-		Success: true,
-		Message: "Функция в разработке",
+	var req models.ExportRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	return streamExport(c, "invoices", req.Format, func(ctx context.Context) (services.ExportFunc, error) {
+		return h.exportService.ExportInvoices(ctx, &req)
 	})
 }
 
 // ExportPayments экспорт платежей
 func (h *Handlers) ExportPayments(c *fiber.Ctx) error {
-	// TODO: Реализовать
-	return c.JSON(models.APIResponse{
-		Success: true,
-		Message: "Функция в разработке",
+	var req models.ExportRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	return streamExport(c, "payments", req.Format, func(ctx context.Context) (services.ExportFunc, error) {
+		return h.exportService.ExportPayments(ctx, &req)
 	})
 }
 
 // ExportBrokers экспорт брокеров
 func (h *Handlers) ExportBrokers(c *fiber.Ctx) error {
-	// TODO: Реализовать
-	return c.JSON(models.APIResponse{
-		Success: true,
-		Message: "Функция в разработке",
+	var req models.ExportRequest
+	if err := c.BodyParser(&req); err != nil && len(c.Body()) > 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	return streamExport(c, "brokers", req.Format, func(ctx context.Context) (services.ExportFunc, error) {
+		return h.exportService.ExportBrokers(ctx, &req)
+	})
+}
+
+// exportError формирует ответ об ошибке подготовки экспорта
+func exportError(c *fiber.Ctx, err error) error {
+	if validationErr, ok := err.(*services.ValidationError); ok {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   validationErr.Message,
+		})
+	}
+	return c.Status(500).JSON(fiber.Map{
+		"success": false,
+		"error":   "Failed to prepare export",
 	})
 }
 
-// SendOverdueNotifications отправляет уведомления о просроченных счетах
+// streamExport отдает файл экспорта потоком, не собирая его целиком в памяти.
+// Выборка выполняется в prepare до начала ответа, поэтому ее ошибка возвращается обычным ответом об ошибке.
+// Ошибка во время передачи обрывает соединение, чтобы клиент не принял неполный файл за целый.
+func streamExport(c *fiber.Ctx, name, format string, prepare func(ctx context.Context) (services.ExportFunc, error)) error {
+	// Контекст запроса завершается вместе с handler, поэтому используем свой
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	export, err := prepare(ctx)
+	if err != nil {
+		cancel()
+		return exportError(c, err)
+	}
+
+	format, _ = services.NormalizeExportFormat(format)
+
+	contentType := "text/csv; charset=utf-8"
+	if format == models.ExportFormatXLSX {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102-150405"), format)
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	conn := c.Context().Conn()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		if err := export(ctx, w); err != nil {
+			log.Printf("Ошибка экспорта %s: %v", filename, err)
+			conn.Close()
+			return
+		}
+		if err := w.Flush(); err != nil {
+			log.Printf("Ошибка отправки экспорта %s: %v", filename, err)
+		}
+	})
+
+	return nil
+}
+
+// SendOverdueNotifications отправляет поэтапные напоминания о просроченных счетах
 func (h *Handlers) SendOverdueNotifications(c *fiber.Ctx) error {
-	if err := h.invoiceService.SendOverdueNotifications(c.Context()); err != nil {
+	summary, err := h.dunningService.Run(c.Context())
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"success": false,
 			"error":   "Failed to send notifications",
@@ -907,5 +1263,6 @@ func (h *Handlers) SendOverdueNotifications(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
 		"success": true,
 		"message": "Notifications sent successfully",
+		"data":    summary,
 	})
 }
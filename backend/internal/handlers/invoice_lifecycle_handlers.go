@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"billing-system/internal/models"
+	"billing-system/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Invoice lifecycle handlers
+
+// IssueInvoice выставляет черновик счета брокеру (необязательное поле credit_override)
+func (h *Handlers) IssueInvoice(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid invoice ID",
+		})
+	}
+
+	// Тело запроса необязательно: оно нужно только для выставления сверх кредитного лимита
+	var req models.IssueInvoiceRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid request body",
+			})
+		}
+	}
+	if !approveCreditOverride(c, req.CreditOverride) {
+		return creditOverrideForbidden(c)
+	}
+
+	invoice, err := h.invoiceService.IssueInvoice(c.Context(), id, &req)
+	if err != nil {
+		return invoiceError(c, err, "Failed to issue invoice")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Invoice issued",
+		Data:    invoice,
+	})
+}
+
+// VoidInvoice аннулирует счет (обязательное поле reason)
+func (h *Handlers) VoidInvoice(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid invoice ID",
+		})
+	}
+
+	var req models.VoidInvoiceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	username, _ := c.Locals("username").(string)
+	invoice, err := h.invoiceService.VoidInvoice(c.Context(), id, &req, username)
+	if err != nil {
+		return invoiceError(c, err, "Failed to void invoice")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Invoice voided",
+		Data:    invoice,
+	})
+}
+
+// invoiceError формирует ответ на ошибку операции со счетом.
+// Недопустимый в текущем статусе переход возвращается как 409 с текущим статусом счета,
+// превышение кредитного лимита - как 409 с нагрузкой брокера.
+func invoiceError(c *fiber.Ctx, err error, message string) error {
+	if err == mongo.ErrNoDocuments {
+		return c.Status(404).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invoice not found",
+		})
+	}
+	if stateErr, ok := err.(*services.InvoiceStateError); ok {
+		return c.Status(409).JSON(fiber.Map{
+			"success": false,
+			"error":   stateErr.Message,
+			"status":  stateErr.Status,
+			"action":  stateErr.Action,
+		})
+	}
+	if limitErr, ok := err.(*services.CreditLimitError); ok {
+		return creditLimitExceeded(c, limitErr)
+	}
+	if validationErr, ok := err.(*services.ValidationError); ok {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   validationErr.Message,
+		})
+	}
+	return c.Status(500).JSON(fiber.Map{
+		"success": false,
+		"error":   message,
+	})
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"billing-system/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Late fee handlers
+
+// GetInvoiceLateFees получает пени, начисленные за просрочку счета
+func (h *Handlers) GetInvoiceLateFees(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid invoice ID",
+		})
+	}
+
+	fees, err := h.lateFeeService.GetInvoiceLateFees(c.Context(), id)
+	if err != nil {
+		return invoiceError(c, err, "Failed to get late fees")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    fees,
+	})
+}
+
+// WaiveLateFee списывает пеню (обязательное поле reason)
+func (h *Handlers) WaiveLateFee(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid invoice ID",
+		})
+	}
+
+	var req models.WaiveLateFeeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	username, _ := c.Locals("username").(string)
+	invoice, err := h.lateFeeService.WaiveLateFee(c.Context(), id, &req, username)
+	if err != nil {
+		return invoiceError(c, err, "Failed to waive late fee")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Late fee waived",
+		Data:    invoice,
+	})
+}
+
+// AccrueLateFees начисляет пени по просроченным счетам (то же делает задача late_fees)
+func (h *Handlers) AccrueLateFees(c *fiber.Ctx) error {
+	summary, err := h.lateFeeService.Run(c.Context())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to accrue late fees",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Late fees accrued",
+		"data":    summary,
+	})
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"billing-system/internal/models"
+	"billing-system/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Payment reversal and refund handlers
+
+// ReversePayment отменяет вернувшийся платеж (чек без покрытия, chargeback)
+func (h *Handlers) ReversePayment(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid payment ID",
+		})
+	}
+
+	var req models.ReversePaymentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	username, _ := c.Locals("username").(string)
+	result, err := h.paymentService.ReversePayment(c.Context(), id, &req, username)
+	if err != nil {
+		return paymentAdjustmentError(c, err, "Failed to reverse payment")
+	}
+
+	return c.Status(201).JSON(models.APIResponse{
+		Success: true,
+		Message: "Payment reversed",
+		Data:    result,
+	})
+}
+
+// RefundPayment возвращает брокеру часть неразнесенного остатка платежа
+func (h *Handlers) RefundPayment(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid payment ID",
+		})
+	}
+
+	var req models.RefundPaymentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	username, _ := c.Locals("username").(string)
+	refund, err := h.paymentService.RefundPayment(c.Context(), id, &req, username)
+	if err != nil {
+		return paymentAdjustmentError(c, err, "Failed to refund payment")
+	}
+
+	return c.Status(201).JSON(models.APIResponse{
+		Success: true,
+		Message: "Payment refunded",
+		Data:    refund,
+	})
+}
+
+// GetPaymentAdjustments получает сторно и возвраты платежа
+func (h *Handlers) GetPaymentAdjustments(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid payment ID",
+		})
+	}
+
+	adjustments, err := h.paymentService.GetPaymentAdjustments(c.Context(), id)
+	if err != nil {
+		return paymentAdjustmentError(c, err, "Failed to fetch payment adjustments")
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    adjustments,
+	})
+}
+
+// paymentAdjustmentError формирует ответ на ошибку операции с платежом
+func paymentAdjustmentError(c *fiber.Ctx, err error, message string) error {
+	if err == mongo.ErrNoDocuments {
+		return c.Status(404).JSON(fiber.Map{
+			"success": false,
+			"error":   "Payment not found",
+		})
+	}
+	if validationErr, ok := err.(*services.ValidationError); ok {
+		return c.Status(400).JSON(fiber.Map{
+			"success": false,
+			"error":   validationErr.Message,
+		})
+	}
+	return c.Status(500).JSON(fiber.Map{
+		"success": false,
+		"error":   message,
+	})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"billing-system/internal/models"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Report handlers
+
+// GetAgingReport получает отчет о возрасте дебиторской задолженности
+func (h *Handlers) GetAgingReport(c *fiber.Ctx) error {
+	asOf := time.Now()
+	if asOfParam := c.Query("as_of"); asOfParam != "" {
+		date, err := time.Parse("2006-01-02", asOfParam)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid as_of date, expected YYYY-MM-DD",
+			})
+		}
+		// Отчет строится на конец указанного дня
+		asOf = date.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+
+	var brokerID primitive.ObjectID
+	if brokerParam := c.Query("broker_id"); brokerParam != "" {
+		objectID, err := primitive.ObjectIDFromHex(brokerParam)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"success": false,
+				"error":   "Invalid broker ID",
+			})
+		}
+		brokerID = objectID
+	}
+
+	report, err := h.reportService.GetAgingReport(c.Context(), asOf, brokerID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"success": false,
+			"error":   "Failed to build aging report",
+		})
+	}
+
+	if c.Query("format") == "csv" {
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="aging-%s.csv"`, asOf.Format("2006-01-02")))
+		return h.reportService.WriteAgingReportCSV(c.Response().BodyWriter(), report)
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    report,
+	})
+}
@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// invoiceStatusRenames прежние статусы счетов и их значения в жизненном цикле draft -> issued -> void
+var invoiceStatusRenames = map[string]string{
+	"pending":  "issued",
+	"canceled": "void",
+}
+
+// invoiceStatuses переименовывает статусы счетов: pending стал issued, canceled - void
+var invoiceStatuses = Migration{
+	ID:          "0004_invoice_statuses",
+	Description: "rename invoice statuses pending to issued and canceled to void",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		invoices := db.Collection("invoices")
+		for from, to := range invoiceStatusRenames {
+			_, err := invoices.UpdateMany(ctx, bson.M{"status": from}, bson.M{"$set": bson.M{"status": to}})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,69 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// collectionName коллекция с примененными миграциями
+const collectionName = "migrations"
+
+// Migration версионированное изменение данных
+type Migration struct {
+	ID          string
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+}
+
+// appliedMigration запись о примененной миграции
+type appliedMigration struct {
+	ID          string    `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// all список миграций в порядке применения. Идентификаторы не меняются после релиза.
+var all = []Migration{
+	moneyMinorUnits,
+	paymentAllocations,
+	paymentTypes,
+	invoiceStatuses,
+	numberSequences,
+	uniqueIndexes,
+}
+
+// Run применяет миграции, которые еще не были применены к базе
+func Run(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection(collectionName)
+
+	for _, migration := range all {
+		err := collection.FindOne(ctx, bson.M{"_id": migration.ID}).Err()
+		if err == nil {
+			continue
+		}
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		log.Printf("Применяется миграция %s: %s", migration.ID, migration.Description)
+		if err := migration.Up(ctx, db); err != nil {
+			return fmt.Errorf("migration %s: %w", migration.ID, err)
+		}
+
+		record := appliedMigration{
+			ID:          migration.ID,
+			Description: migration.Description,
+			AppliedAt:   time.Now(),
+		}
+		if _, err := collection.InsertOne(ctx, record); err != nil {
+			return fmt.Errorf("migration %s: %w", migration.ID, err)
+		}
+	}
+
+	return nil
+}
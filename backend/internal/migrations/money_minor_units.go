@@ -0,0 +1,92 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// moneyMinorUnits переводит денежные поля из дробных чисел в целые центы.
+// Уже переведенные значения (int64) не затрагиваются, поэтому миграцию безопасно повторить.
+var moneyMinorUnits = Migration{
+	ID:          "0001_money_minor_units",
+	Description: "store money amounts as integer minor units",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		targets := []struct {
+			collection string
+			fields     []string
+			arrays     map[string][]string
+		}{
+			{
+				collection: "invoices",
+				fields:     []string{"amount", "paid_amount", "subtotal", "discount_total", "tax_total"},
+				arrays: map[string][]string{
+					"line_items": {"unit_price", "amount", "tax_amount"},
+					"discounts":  {"amount"},
+				},
+			},
+			{collection: "payments", fields: []string{"amount"}},
+			{collection: "loads", fields: []string{"cost"}},
+			{collection: "brokers", fields: []string{"credit_limit"}},
+			{collection: "dunning_history", fields: []string{"amount"}},
+		}
+
+		for _, target := range targets {
+			set := bson.M{}
+			var conditions bson.A
+			for _, field := range target.fields {
+				set[field] = toMinorUnits("$" + field)
+				conditions = append(conditions, bson.M{field: bson.M{"$type": fractionalTypes}})
+			}
+			for array, fields := range target.arrays {
+				set[array] = convertArray(array, fields)
+				conditions = append(conditions, bson.M{array + "." + fields[0]: bson.M{"$type": fractionalTypes}})
+			}
+
+			filter := bson.M{"$or": conditions}
+			pipeline := mongo.Pipeline{{{Key: "$set", Value: set}}}
+			if _, err := db.Collection(target.collection).UpdateMany(ctx, filter, pipeline); err != nil {
+				return err
+			}
+		}
+
+		// Лимит, заданный до появления валюты лимита, считается в долларах
+		_, err := db.Collection("brokers").UpdateMany(ctx,
+			bson.M{"credit_limit_currency": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"credit_limit_currency": "USD"}},
+		)
+		return err
+	},
+}
+
+// fractionalTypes типы BSON, в которых суммы хранились до перехода на центы
+var fractionalTypes = bson.A{"double", "int", "decimal"}
+
+// toMinorUnits выражение, умножающее сумму на 100 с округлением до целого int64.
+// Значения других типов (уже int64 или отсутствующие) остаются как есть.
+func toMinorUnits(path string) bson.M {
+	return bson.M{"$cond": bson.A{
+		bson.M{"$in": bson.A{bson.M{"$type": path}, fractionalTypes}},
+		bson.M{"$toLong": bson.M{"$round": bson.A{bson.M{"$multiply": bson.A{path, 100}}, 0}}},
+		path,
+	}}
+}
+
+// convertArray выражение, переводящее поля каждого элемента массива в центы
+func convertArray(array string, fields []string) bson.M {
+	converted := bson.M{}
+	for _, field := range fields {
+		converted[field] = toMinorUnits("$$item." + field)
+	}
+
+	return bson.M{"$cond": bson.A{
+		bson.M{"$isArray": "$" + array},
+		bson.M{"$map": bson.M{
+			"input": "$" + array,
+			"as":    "item",
+			"in":    bson.M{"$mergeObjects": bson.A{"$$item", converted}},
+		}},
+		"$" + array,
+	}}
+}
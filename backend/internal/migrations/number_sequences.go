@@ -0,0 +1,87 @@
+package migrations
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// numberedCollection коллекция с номерами документов и имя ее последовательности в counters
+type numberedCollection struct {
+	collection string
+	field      string
+	sequence   string
+}
+
+// numberedCollections документы, номера которых выдаются последовательностями репозиториев
+var numberedCollections = []numberedCollection{
+	{collection: "invoices", field: "invoice_number", sequence: "invoice"},
+	{collection: "loads", field: "load_number", sequence: "load"},
+	{collection: "credit_notes", field: "credit_note_number", sequence: "credit_note"},
+}
+
+// numberSequences заводит счетчики номеров по уже выданным номерам, чтобы новые номера продолжали их.
+// Ключ счетчика - номер без завершающих цифр, как у форматов с порядковым номером в конце.
+var numberSequences = Migration{
+	ID:          "0005_number_sequences",
+	Description: "seed document number counters from existing numbers",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		counters := db.Collection("counters")
+
+		for _, numbered := range numberedCollections {
+			last, err := lastNumbers(ctx, db.Collection(numbered.collection), numbered.field)
+			if err != nil {
+				return err
+			}
+
+			for period, seq := range last {
+				_, err := counters.UpdateOne(ctx,
+					bson.M{"_id": numbered.sequence + ":" + period},
+					bson.M{"$max": bson.M{"seq": seq}},
+					options.Update().SetUpsert(true),
+				)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	},
+}
+
+// lastNumbers находит наибольший порядковый номер для каждого периода среди номеров в поле field
+func lastNumbers(ctx context.Context, collection *mongo.Collection, field string) (map[string]int64, error) {
+	cursor, err := collection.Find(ctx,
+		bson.M{field: bson.M{"$type": "string"}},
+		options.Find().SetProjection(bson.M{field: 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	last := make(map[string]int64)
+	for cursor.Next(ctx) {
+		var document bson.M
+		if err := cursor.Decode(&document); err != nil {
+			return nil, err
+		}
+
+		number, _ := document[field].(string)
+		period := strings.TrimRight(number, "0123456789")
+		seq, err := strconv.ParseInt(number[len(period):], 10, 64)
+		if err != nil {
+			continue
+		}
+		if seq > last[period] {
+			last[period] = seq
+		}
+	}
+
+	return last, cursor.Err()
+}
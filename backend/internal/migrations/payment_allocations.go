@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// paymentAllocations переносит счет платежа (invoice_id) в список разнесений.
+// Разнесенная сумма равна сумме платежа за вычетом неразнесенного остатка.
+var paymentAllocations = Migration{
+	ID:          "0002_payment_allocations",
+	Description: "move payment invoice_id into allocations",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		hasInvoice := bson.M{"$eq": bson.A{bson.M{"$type": "$invoice_id"}, "objectId"}}
+		unapplied := bson.M{"$ifNull": bson.A{"$unapplied_amount", 0}}
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"allocations": bson.M{"$cond": bson.A{
+					hasInvoice,
+					bson.A{bson.M{
+						"invoice_id":   "$invoice_id",
+						"amount":       bson.M{"$subtract": bson.A{"$amount", unapplied}},
+						"fx_gain_loss": bson.M{"$ifNull": bson.A{"$fx_gain_loss", 0}},
+					}},
+					bson.A{},
+				}},
+				"unapplied_amount": bson.M{"$cond": bson.A{hasInvoice, unapplied, "$amount"}},
+			}}},
+			{{Key: "$unset", Value: "invoice_id"}},
+		}
+
+		_, err := db.Collection("payments").UpdateMany(ctx,
+			bson.M{"allocations": bson.M{"$exists": false}},
+			pipeline,
+		)
+		return err
+	},
+}
@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// paymentTypes помечает существующие записи как обычные платежи: появились сторно и возвраты
+var paymentTypes = Migration{
+	ID:          "0003_payment_types",
+	Description: "set type of existing payments",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("payments").UpdateMany(ctx,
+			bson.M{"type": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"type": "payment", "refunded_amount": 0}},
+		)
+		return err
+	},
+}
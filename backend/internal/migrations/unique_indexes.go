@@ -0,0 +1,221 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// uniqueIndex уникальный индекс коллекции; partial ограничивает его документами с заданным полем
+type uniqueIndex struct {
+	collection string
+	keys       bson.D
+	partial    string
+}
+
+// idempotencyIndexes уникальные индексы, на которых держится однократность операций: пеня за период
+// просрочки, счет за период расписания, платеж по операции выписки, однократная загрузка операции выписки,
+// один курс валюты на день и одно напоминание каждого этапа по счету
+var idempotencyIndexes = []uniqueIndex{
+	{
+		collection: "invoices",
+		keys:       bson.D{{Key: "late_fee_for", Value: 1}, {Key: "late_fee_period", Value: 1}},
+		partial:    "late_fee_for",
+	},
+	{
+		collection: "invoices",
+		keys:       bson.D{{Key: "billing_schedule_id", Value: 1}, {Key: "billing_period", Value: 1}},
+		partial:    "billing_schedule_id",
+	},
+	{
+		collection: "payments",
+		keys:       bson.D{{Key: "bank_transaction_id", Value: 1}},
+		partial:    "bank_transaction_id",
+	},
+	{
+		collection: "bank_transactions",
+		keys:       bson.D{{Key: "fingerprint", Value: 1}},
+	},
+	{
+		collection: "exchange_rates",
+		keys:       bson.D{{Key: "currency", Value: 1}, {Key: "base_currency", Value: 1}, {Key: "date", Value: -1}},
+	},
+	{
+		collection: "dunning_history",
+		keys:       bson.D{{Key: "invoice_id", Value: 1}, {Key: "stage", Value: 1}},
+	},
+}
+
+// uniqueIndexes устраняет повторяющиеся номера документов, оставшиеся от выдачи номеров без счетчиков,
+// и создает уникальные индексы номеров и однократного выставления. Каждый индекс создается отдельно,
+// ошибка останавливает запуск: без индексов уникальность номеров и однократность операций не гарантированы.
+var uniqueIndexes = Migration{
+	ID:          "0006_unique_indexes",
+	Description: "resolve duplicate document numbers and create unique indexes",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		for _, numbered := range numberedCollections {
+			collection := db.Collection(numbered.collection)
+			if err := renumberDuplicates(ctx, collection, numbered.field); err != nil {
+				return err
+			}
+			if err := createUniqueIndex(ctx, collection, uniqueIndex{keys: bson.D{{Key: numbered.field, Value: 1}}}); err != nil {
+				return err
+			}
+		}
+
+		for _, index := range idempotencyIndexes {
+			collection := db.Collection(index.collection)
+			if err := checkNoDuplicates(ctx, collection, index); err != nil {
+				return err
+			}
+			if err := createUniqueIndex(ctx, collection, index); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
+
+// duplicateGroup документы с одинаковым значением ключа
+type duplicateGroup struct {
+	Key interface{}          `bson:"_id"`
+	IDs []primitive.ObjectID `bson:"ids"`
+}
+
+// findDuplicates группирует документы с одинаковыми значениями keys; id в группе упорядочены по созданию
+func findDuplicates(ctx context.Context, collection *mongo.Collection, keys bson.D, match bson.M) ([]duplicateGroup, error) {
+	groupKey := bson.M{}
+	for _, key := range keys {
+		groupKey[key.Key] = "$" + key.Key
+	}
+
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$sort": bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{"$group": bson.M{"_id": groupKey, "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}},
+		{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []duplicateGroup
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// renumberDuplicates оставляет номер самому раннему документу, остальным добавляет суффикс -2, -3, ...
+// Прежний номер сохраняется в поле renumbered_from.
+func renumberDuplicates(ctx context.Context, collection *mongo.Collection, field string) error {
+	groups, err := findDuplicates(ctx, collection, bson.D{{Key: field, Value: 1}},
+		bson.M{field: bson.M{"$type": "string", "$ne": ""}})
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		var number string
+		if key, ok := group.Key.(bson.D); ok && len(key) > 0 {
+			number, _ = key[0].Value.(string)
+		}
+		suffix := 2
+		for _, id := range group.IDs[1:] {
+			renumbered, err := freeNumber(ctx, collection, field, number, &suffix)
+			if err != nil {
+				return err
+			}
+
+			_, err = collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+				field:             renumbered,
+				"renumbered_from": number,
+			}})
+			if err != nil {
+				return err
+			}
+			log.Printf("Повторяющийся номер %s в %s: документу %s присвоен номер %s", number, collection.Name(), id.Hex(), renumbered)
+		}
+	}
+
+	return nil
+}
+
+// freeNumber подбирает незанятый номер вида number-N, начиная с суффикса *suffix
+func freeNumber(ctx context.Context, collection *mongo.Collection, field, number string, suffix *int) (string, error) {
+	for {
+		candidate := fmt.Sprintf("%s-%d", number, *suffix)
+		*suffix++
+
+		count, err := collection.CountDocuments(ctx, bson.M{field: candidate})
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+}
+
+// checkNoDuplicates возвращает ошибку, если уникальный индекс нельзя создать из-за повторов.
+// Повторы денежных документов, платежей и курсов не исправляются автоматически: выбрать верный документ
+// может только оператор.
+func checkNoDuplicates(ctx context.Context, collection *mongo.Collection, index uniqueIndex) error {
+	match := bson.M{}
+	if index.partial != "" {
+		match[index.partial] = bson.M{"$exists": true}
+	}
+	groups, err := findDuplicates(ctx, collection, index.keys, match)
+	if err != nil {
+		return err
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+
+	var ids []string
+	for _, group := range groups {
+		for _, id := range group.IDs[1:] {
+			ids = append(ids, id.Hex())
+		}
+	}
+	fix := "delete the later documents"
+	if index.partial != "" {
+		fix += " or unset their " + index.partial
+	}
+	return fmt.Errorf("%s has %d duplicate groups by %s; %s (%s) and restart",
+		collection.Name(), len(groups), indexName(index.keys), fix, strings.Join(ids, ", "))
+}
+
+// createUniqueIndex создает уникальный индекс с именем по умолчанию, чтобы уже созданный индекс не дублировался
+func createUniqueIndex(ctx context.Context, collection *mongo.Collection, index uniqueIndex) error {
+	opts := options.Index().SetUnique(true)
+	if index.partial != "" {
+		opts.SetPartialFilterExpression(bson.M{index.partial: bson.M{"$exists": true}})
+	}
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: index.keys, Options: opts})
+	if err != nil {
+		return fmt.Errorf("create unique index %s on %s: %w", indexName(index.keys), collection.Name(), err)
+	}
+	return nil
+}
+
+// indexName имя индекса по умолчанию: поля и направления через подчеркивание
+func indexName(keys bson.D) string {
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s_%v", key.Key, key.Value))
+	}
+	return strings.Join(parts, "_")
+}
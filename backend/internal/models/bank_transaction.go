@@ -0,0 +1,124 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Форматы банковских выписок
+const (
+	BankStatementFormatCSV     = "csv"
+	BankStatementFormatOFX     = "ofx"
+	BankStatementFormatCamt053 = "camt053"
+)
+
+// BankTransactionStatus статусы банковских операций
+const (
+	BankTransactionStatusUnmatched = "unmatched" // брокер не определен, нужен ручной разбор
+	BankTransactionStatusMatched   = "matched"   // предложено сопоставление, ждет подтверждения
+	BankTransactionStatusConfirmed = "confirmed" // проведена как платеж
+	BankTransactionStatusRejected  = "rejected"  // не является оплатой брокера
+	BankTransactionStatusSplit     = "split"     // разделена на части, каждая разбирается отдельно
+)
+
+// Признаки, по которым операция сопоставлена
+const (
+	MatchReasonInvoiceNumber = "invoice_number" // номер счета в назначении платежа или референсе
+	MatchReasonBrokerName    = "broker_name"    // имя плательщика совпало с брокером
+	MatchReasonAmount        = "amount"         // сумма совпала с остатком счета
+	MatchReasonOldestDue     = "oldest_due"     // разнесение по самым ранним срокам оплаты
+	MatchReasonManual        = "manual"         // брокер указан при разделении операции
+)
+
+// BankTransaction поступление из банковской выписки, ожидающее сверки с платежами
+type BankTransaction struct {
+	ID              primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	ImportID        primitive.ObjectID  `json:"import_id" bson:"import_id"` // загрузка выписки
+	ParentID        primitive.ObjectID  `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	Format          string              `json:"format" bson:"format"`
+	AccountID       string              `json:"account_id" bson:"account_id"`   // счет получателя в выписке (IBAN, ACCTID)
+	ExternalID      string              `json:"external_id" bson:"external_id"` // идентификатор операции в банке (FITID, AcctSvcrRef)
+	Fingerprint     string              `json:"-" bson:"fingerprint"`           // ключ для отсева повторной загрузки
+	BookingDate     time.Time           `json:"booking_date" bson:"booking_date"`
+	Amount          Amount              `json:"amount" bson:"amount"`
+	Currency        string              `json:"currency" bson:"currency"`
+	PayerName       string              `json:"payer_name" bson:"payer_name"`
+	Memo            string              `json:"memo" bson:"memo"`                         // назначение платежа
+	ReferenceNumber string              `json:"reference_number" bson:"reference_number"` // референс плательщика
+	Status          string              `json:"status" bson:"status"`
+	BrokerID        primitive.ObjectID  `json:"broker_id,omitempty" bson:"broker_id,omitempty"`
+	Allocations     []PaymentAllocation `json:"allocations" bson:"allocations"`     // предложенное разнесение по счетам
+	MatchReasons    []string            `json:"match_reasons" bson:"match_reasons"` // признаки сопоставления
+	PaymentID       primitive.ObjectID  `json:"payment_id,omitempty" bson:"payment_id,omitempty"`
+	RejectReason    string              `json:"reject_reason,omitempty" bson:"reject_reason,omitempty"`
+	ImportedBy      string              `json:"imported_by" bson:"imported_by"`
+	ReviewedBy      string              `json:"reviewed_by,omitempty" bson:"reviewed_by,omitempty"`
+	ReviewedAt      *time.Time          `json:"reviewed_at,omitempty" bson:"reviewed_at,omitempty"`
+	CreatedAt       time.Time           `json:"created_at" bson:"created_at"`
+
+	// Computed fields from JOINs (не сохраняются в БД)
+	BrokerName string `json:"broker_name,omitempty" bson:"broker_name,omitempty"`
+}
+
+// IsOpen проверяет, что операция еще ждет разбора
+func (t *BankTransaction) IsOpen() bool {
+	return t.Status == BankTransactionStatusUnmatched || t.Status == BankTransactionStatusMatched
+}
+
+// AllocatedAmount часть поступления, разнесенная на счета
+func (t *BankTransaction) AllocatedAmount() Amount {
+	var total Amount
+	for _, allocation := range t.Allocations {
+		total += allocation.Amount
+	}
+	return total
+}
+
+// BankTransactionFilter фильтры для поиска банковских операций
+type BankTransactionFilter struct {
+	Status   string             `json:"status"`
+	ImportID primitive.ObjectID `json:"import_id"`
+	BrokerID primitive.ObjectID `json:"broker_id"`
+	DateFrom *time.Time         `json:"date_from"`
+	DateTo   *time.Time         `json:"date_to"`
+}
+
+// BankImportResult итог загрузки выписки
+type BankImportResult struct {
+	ImportID   primitive.ObjectID `json:"import_id"`
+	Format     string             `json:"format"`
+	Total      int                `json:"total"`      // операций в выписке
+	Imported   int                `json:"imported"`   // новых поступлений
+	Duplicates int                `json:"duplicates"` // загружены ранее
+	Skipped    int                `json:"skipped"`    // списания, в сверке не участвуют
+	Matched    int                `json:"matched"`
+	Unmatched  int                `json:"unmatched"`
+}
+
+// ConfirmBankTransactionRequest подтверждение операции. Незаданные поля берутся из предложенного сопоставления;
+// broker_id, allocations и auto_allocate заменяют его целиком.
+type ConfirmBankTransactionRequest struct {
+	BrokerID      primitive.ObjectID  `json:"broker_id"`
+	Allocations   []PaymentAllocation `json:"allocations"`
+	AutoAllocate  bool                `json:"auto_allocate"`
+	PaymentMethod string              `json:"payment_method"`
+	Notes         string              `json:"notes"`
+}
+
+// BankTransactionPart часть разделяемой операции; брокер необязателен
+type BankTransactionPart struct {
+	Amount   Amount             `json:"amount"`
+	BrokerID primitive.ObjectID `json:"broker_id"`
+	Memo     string             `json:"memo"`
+}
+
+// SplitBankTransactionRequest разделение операции на части, сумма частей равна сумме операции
+type SplitBankTransactionRequest struct {
+	Parts []BankTransactionPart `json:"parts"`
+}
+
+// RejectBankTransactionRequest отклонение операции
+type RejectBankTransactionRequest struct {
+	Reason string `json:"reason"`
+}
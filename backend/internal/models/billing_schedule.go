@@ -0,0 +1,94 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BillingInterval периодичность счетов по расписанию
+const (
+	BillingIntervalWeekly    = "weekly"
+	BillingIntervalMonthly   = "monthly"
+	BillingIntervalQuarterly = "quarterly"
+	BillingIntervalYearly    = "yearly"
+)
+
+// BillingScheduleStatus статусы расписания
+const (
+	BillingScheduleStatusActive = "active"
+	BillingScheduleStatusPaused = "paused"
+	BillingScheduleStatusEnded  = "ended" // выставлены все периоды до даты окончания
+)
+
+// BillingSchedule расписание регулярных счетов брокеру: абонентская плата за диспетчеризацию, аренда оборудования и т.п.
+// Периоды нумеруются с 1 от даты начала, счет за период выставляется в день его начала.
+type BillingSchedule struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	BrokerID     primitive.ObjectID `json:"broker_id" bson:"broker_id"`
+	Name         string             `json:"name" bson:"name"`
+	Amount       Amount             `json:"amount" bson:"amount"` // итог счета за период; без позиций - сумма единственной позиции
+	Currency     string             `json:"currency" bson:"currency"`
+	Interval     string             `json:"interval" bson:"interval"` // weekly, monthly, quarterly, yearly
+	StartDate    time.Time          `json:"start_date" bson:"start_date"`
+	EndDate      *time.Time         `json:"end_date,omitempty" bson:"end_date,omitempty"` // периоды, начинающиеся позже, не выставляются
+	LineItems    []LineItem         `json:"line_items" bson:"line_items"`                 // шаблон позиций счета
+	PaymentTerms *PaymentTerms      `json:"payment_terms,omitempty" bson:"payment_terms,omitempty"`
+	Description  string             `json:"description" bson:"description"`
+	Notes        string             `json:"notes" bson:"notes"`
+	Status       string             `json:"status" bson:"status"`
+	LastPeriod   int                `json:"last_period" bson:"last_period"`     // последний выставленный или пропущенный на паузе период
+	NextRunDate  *time.Time         `json:"next_run_date" bson:"next_run_date"` // начало следующего периода, пусто после окончания
+	CreatedBy    string             `json:"created_by" bson:"created_by"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// PeriodStart дата начала периода period. Месячные периоды начинаются в день месяца даты начала,
+// в коротких месяцах - в последний день месяца.
+func (s *BillingSchedule) PeriodStart(period int) time.Time {
+	n := period - 1
+	switch s.Interval {
+	case BillingIntervalWeekly:
+		return s.StartDate.AddDate(0, 0, 7*n)
+	case BillingIntervalQuarterly:
+		return addMonthsClamped(s.StartDate, 3*n)
+	case BillingIntervalYearly:
+		return addMonthsClamped(s.StartDate, 12*n)
+	default:
+		return addMonthsClamped(s.StartDate, n)
+	}
+}
+
+// PeriodEnd последний день периода period
+func (s *BillingSchedule) PeriodEnd(period int) time.Time {
+	return s.PeriodStart(period+1).AddDate(0, 0, -1)
+}
+
+// HasPeriod проверяет, что период period начинается не позже даты окончания расписания
+func (s *BillingSchedule) HasPeriod(period int) bool {
+	return s.EndDate == nil || !s.PeriodStart(period).After(*s.EndDate)
+}
+
+// addMonthsClamped прибавляет months месяцев, не переходя через конец месяца: 31 января + 1 месяц = 28 или 29 февраля
+func addMonthsClamped(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if lastDay := first.AddDate(0, 1, -1).Day(); day > lastDay {
+		day = lastDay
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// BillingScheduleFilter фильтры для поиска расписаний
+type BillingScheduleFilter struct {
+	BrokerID primitive.ObjectID `json:"broker_id"`
+	Status   string             `json:"status"`
+}
+
+// BillingScheduleSummary итог прогона выставления счетов по расписаниям
+type BillingScheduleSummary struct {
+	SchedulesChecked int `json:"schedules_checked"`
+	InvoicesCreated  int `json:"invoices_created"`
+	Failed           int `json:"failed"`
+}
@@ -6,20 +6,45 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// BrokerStatus статусы брокера
+const (
+	BrokerStatusActive     = "active"
+	BrokerStatusInactive   = "inactive"
+	BrokerStatusSuspended  = "suspended"
+	BrokerStatusCreditHold = "credit_hold" // новые грузы не принимаются до погашения просрочки
+)
+
 // Broker представляет брокера/компанию-клиента
 type Broker struct {
-	ID               primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	CompanyName      string             `json:"company_name" bson:"company_name" validate:"required"`
-	ContactPerson    string             `json:"contact_person" bson:"contact_person"`
-	Email            string             `json:"email" bson:"email" validate:"required,email"`
-	Phone            string             `json:"phone" bson:"phone"`
-	Address          Address            `json:"address" bson:"address"`
-	CreditLimit      float64            `json:"credit_limit" bson:"credit_limit"`
-	ReliabilityScore int                `json:"reliability_score" bson:"reliability_score"` // 1-10
-	Status           string             `json:"status" bson:"status"`                       // active, inactive, suspended
-	CreatedAt        time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt        time.Time          `json:"updated_at" bson:"updated_at"`
-	Notes            string             `json:"notes" bson:"notes"`
+	ID                  primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	CompanyName         string             `json:"company_name" bson:"company_name" validate:"required"`
+	ContactPerson       string             `json:"contact_person" bson:"contact_person"`
+	Email               string             `json:"email" bson:"email" validate:"required,email"`
+	Phone               string             `json:"phone" bson:"phone"`
+	Address             Address            `json:"address" bson:"address"`
+	CreditLimit         Amount             `json:"credit_limit" bson:"credit_limit"`
+	CreditLimitCurrency string             `json:"credit_limit_currency" bson:"credit_limit_currency"`
+	CreditAlertAt       *time.Time         `json:"credit_alert_at,omitempty" bson:"credit_alert_at,omitempty"`         // когда отправлено предупреждение о приближении к лимиту
+	PaymentTerms        *PaymentTerms      `json:"payment_terms,omitempty" bson:"payment_terms,omitempty"`             // условия оплаты счетов по умолчанию
+	LateFeePolicy       *LateFeePolicy     `json:"late_fee_policy,omitempty" bson:"late_fee_policy,omitempty"`         // правила начисления пеней вместо общих
+	ReliabilityScore    int                `json:"reliability_score" bson:"reliability_score"`                         // 1-10
+	Status              string             `json:"status" bson:"status"`                                               // active, inactive, suspended, credit_hold
+	CreditHoldRelease   *CreditHoldRelease `json:"credit_hold_release,omitempty" bson:"credit_hold_release,omitempty"` // последнее снятие кредитного стопа
+	CreatedAt           time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt           time.Time          `json:"updated_at" bson:"updated_at"`
+	Notes               string             `json:"notes" bson:"notes"`
+}
+
+// CreditHoldRelease снятие кредитного стопа администратором
+type CreditHoldRelease struct {
+	Reason     string    `json:"reason" bson:"reason"`
+	ReleasedBy string    `json:"released_by" bson:"released_by"`
+	ReleasedAt time.Time `json:"released_at" bson:"released_at"`
+}
+
+// ReleaseCreditHoldRequest запрос снятия кредитного стопа
+type ReleaseCreditHoldRequest struct {
+	Reason string `json:"reason"`
 }
 
 // Address структура для адреса
@@ -34,10 +59,31 @@ type Address struct {
 // BrokerStats статистика по брокеру
 type BrokerStats struct {
 	BrokerID        primitive.ObjectID `json:"broker_id" bson:"broker_id"`
-	TotalDebt       float64            `json:"total_debt" bson:"total_debt"`
-	OverdueAmount   float64            `json:"overdue_amount" bson:"overdue_amount"`
-	PaidThisMonth   float64            `json:"paid_this_month" bson:"paid_this_month"`
+	TotalDebt       Amount             `json:"total_debt" bson:"total_debt"`
+	OverdueAmount   Amount             `json:"overdue_amount" bson:"overdue_amount"`
+	PaidThisMonth   Amount             `json:"paid_this_month" bson:"paid_this_month"`
 	InvoicesCount   int                `json:"invoices_count" bson:"invoices_count"`
 	OverdueInvoices int                `json:"overdue_invoices" bson:"overdue_invoices"`
 	LastPayment     *time.Time         `json:"last_payment" bson:"last_payment"`
+	UnappliedCredit Amount             `json:"unapplied_credit" bson:"unapplied_credit"` // неразнесенные платежи брокера
+
+	// Платежная дисциплина по оплаченным счетам
+	AvgDaysToPay        float64 `json:"avg_days_to_pay" bson:"avg_days_to_pay"`
+	OnTimePaymentRate   float64 `json:"on_time_payment_rate" bson:"on_time_payment_rate"` // процент счетов, оплаченных до срока
+	PaidInvoicesCount   int     `json:"paid_invoices_count" bson:"paid_invoices_count"`
+	OnTimeInvoicesCount int     `json:"on_time_invoices_count" bson:"on_time_invoices_count"`
+
+	// Разбивка денежных показателей по валютам
+	Currencies []BrokerCurrencyStats `json:"currencies" bson:"currencies"`
+}
+
+// BrokerCurrencyStats статистика по брокеру в одной валюте
+type BrokerCurrencyStats struct {
+	Currency        string `json:"currency" bson:"currency"`
+	TotalDebt       Amount `json:"total_debt" bson:"total_debt"`
+	OverdueAmount   Amount `json:"overdue_amount" bson:"overdue_amount"`
+	PaidThisMonth   Amount `json:"paid_this_month" bson:"paid_this_month"`
+	UnappliedCredit Amount `json:"unapplied_credit" bson:"unapplied_credit"`
+	InvoicesCount   int    `json:"invoices_count" bson:"invoices_count"`
+	OverdueInvoices int    `json:"overdue_invoices" bson:"overdue_invoices"`
 }
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreditStatus состояние кредитного лимита брокера
+const (
+	CreditStatusNoLimit   = "no_limit"   // лимит не задан, нагрузка не ограничена
+	CreditStatusOK        = "ok"         // нагрузка ниже порога предупреждения
+	CreditStatusNearLimit = "near_limit" // нагрузка достигла порога предупреждения
+	CreditStatusOverLimit = "over_limit" // нагрузка превышает лимит
+)
+
+// CreditExposure кредитная нагрузка брокера: остатки к оплате по открытым счетам и стоимость
+// невыставленных грузов, пересчитанные в валюту кредитного лимита по текущему курсу
+type CreditExposure struct {
+	BrokerID           primitive.ObjectID `json:"broker_id"`
+	Currency           string             `json:"currency"` // валюта кредитного лимита
+	CreditLimit        Amount             `json:"credit_limit"`
+	OpenInvoices       Amount             `json:"open_invoices"`
+	UnbilledLoads      Amount             `json:"unbilled_loads"`
+	Exposure           Amount             `json:"exposure"`            // открытые счета плюс невыставленные грузы
+	Available          Amount             `json:"available"`           // остаток лимита, отрицательный при превышении
+	UtilizationPercent float64            `json:"utilization_percent"` // доля использованного лимита
+	AlertPercent       float64            `json:"alert_percent"`
+	Status             string             `json:"status"` // no_limit, ok, near_limit, over_limit
+	AlertSentAt        *time.Time         `json:"alert_sent_at,omitempty"`
+
+	// Исходные суммы по валютам документов
+	Currencies []CreditExposureCurrency `json:"currencies"`
+	// Валюты без загруженного курса: их суммы не входят в нагрузку
+	MissingRates []string `json:"missing_rates"`
+}
+
+// CreditExposureCurrency нагрузка брокера в одной валюте до пересчета
+type CreditExposureCurrency struct {
+	Currency      string  `json:"currency"`
+	OpenInvoices  Amount  `json:"open_invoices"`
+	UnbilledLoads Amount  `json:"unbilled_loads"`
+	ExchangeRate  float64 `json:"exchange_rate"` // курс к валюте лимита
+}
+
+// HasLimit проверяет, что брокеру задан кредитный лимит
+func (e *CreditExposure) HasLimit() bool {
+	return e.CreditLimit > 0
+}
+
+// CreditOverrideSystem автор разрешения для счетов, которые выставляет сама система
+const CreditOverrideSystem = "system"
+
+// CreditOverride разрешение администратора превысить кредитный лимит брокера.
+// Клиент передает только причину; остальное заполняет сервер и сохраняет в документе для аудита.
+type CreditOverride struct {
+	Reason      string    `json:"reason" bson:"reason"`
+	ApprovedBy  string    `json:"approved_by" bson:"approved_by"`
+	ApprovedAt  time.Time `json:"approved_at" bson:"approved_at"`
+	Exposure    Amount    `json:"exposure" bson:"exposure"` // нагрузка с учетом документа
+	CreditLimit Amount    `json:"credit_limit" bson:"credit_limit"`
+	Currency    string    `json:"currency" bson:"currency"` // валюта кредитного лимита
+}
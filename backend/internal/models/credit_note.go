@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreditNote кредит-нота: документ, уменьшающий задолженность по счету без изменения самого счета
+type CreditNote struct {
+	ID               primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	CreditNoteNumber string             `json:"credit_note_number" bson:"credit_note_number"`
+	InvoiceID        primitive.ObjectID `json:"invoice_id" bson:"invoice_id"`
+	BrokerID         primitive.ObjectID `json:"broker_id" bson:"broker_id"`
+	Currency         string             `json:"currency" bson:"currency"` // валюта счета
+	Reason           string             `json:"reason" bson:"reason"`
+	LineItems        []LineItem         `json:"line_items" bson:"line_items"`
+	Subtotal         Amount             `json:"subtotal" bson:"subtotal"`
+	TaxTotal         Amount             `json:"tax_total" bson:"tax_total"`
+	Amount           Amount             `json:"amount" bson:"amount"` // итог, на который уменьшается остаток счета
+	Notes            string             `json:"notes" bson:"notes"`
+	CreatedAt        time.Time          `json:"created_at" bson:"created_at"`
+	CreatedBy        string             `json:"created_by" bson:"created_by"`
+
+	// Computed fields from JOINs (не сохраняются в БД)
+	InvoiceNumber string `json:"invoice_number" bson:"invoice_number,omitempty"`
+	BrokerName    string `json:"broker_name" bson:"broker_name,omitempty"`
+}
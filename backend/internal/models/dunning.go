@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DunningRecord этап напоминаний, отправленный по счету
+type DunningRecord struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	InvoiceID     primitive.ObjectID `json:"invoice_id" bson:"invoice_id"`
+	BrokerID      primitive.ObjectID `json:"broker_id" bson:"broker_id"`
+	InvoiceNumber string             `json:"invoice_number" bson:"invoice_number"`
+	Stage         string             `json:"stage" bson:"stage"`
+	StageDays     int                `json:"stage_days" bson:"stage_days"`       // порог просрочки этапа
+	DaysPastDue   int                `json:"days_past_due" bson:"days_past_due"` // фактическая просрочка на момент отправки
+	Amount        Amount             `json:"amount" bson:"amount"`               // остаток к оплате
+	Currency      string             `json:"currency" bson:"currency"`
+	CreditHold    bool               `json:"credit_hold" bson:"credit_hold"`
+	SentAt        time.Time          `json:"sent_at" bson:"sent_at"`
+}
+
+// DunningSummary итог прогона напоминаний
+type DunningSummary struct {
+	InvoicesChecked int `json:"invoices_checked"`
+	NoticesSent     int `json:"notices_sent"`    // писем брокерам
+	StagesRecorded  int `json:"stages_recorded"` // счетов, перешедших на новый этап
+	CreditHolds     int `json:"credit_holds"`
+	Failed          int `json:"failed"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Источники курсов валют
+const (
+	ExchangeRateSourceAPI = "api"
+	ExchangeRateSourceCSV = "csv"
+)
+
+// ExchangeRate дневной курс валюты к базовой валюте:
+// 1 единица Currency стоит Rate единиц BaseCurrency
+type ExchangeRate struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Currency     string             `json:"currency" bson:"currency"`
+	BaseCurrency string             `json:"base_currency" bson:"base_currency"`
+	Date         time.Time          `json:"date" bson:"date"` // начало дня в UTC
+	Rate         float64            `json:"rate" bson:"rate"`
+	Source       string             `json:"source" bson:"source"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// ExchangeRateFilter фильтры для поиска курсов
+type ExchangeRateFilter struct {
+	Currency string     `json:"currency"`
+	DateFrom *time.Time `json:"date_from"`
+	DateTo   *time.Time `json:"date_to"`
+}
+
+// CurrencySettings валюты системы
+type CurrencySettings struct {
+	BaseCurrency string   `json:"base_currency"`
+	Supported    []string `json:"supported"`
+}
+
+// ExchangeRateInput курс в запросе загрузки; дата в формате YYYY-MM-DD
+type ExchangeRateInput struct {
+	Date     string  `json:"date"`
+	Currency string  `json:"currency"`
+	Rate     float64 `json:"rate"`
+}
+
+// ExchangeRatesRequest запрос загрузки курсов через API
+type ExchangeRatesRequest struct {
+	Rates []ExchangeRateInput `json:"rates"`
+}
@@ -6,13 +6,16 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// InvoiceStatus статусы счетов
+// InvoiceStatus статусы счетов.
+// Жизненный цикл: draft -> issued -> partial/paid/overdue -> void. Статусы issued, partial, paid и overdue
+// определяются балансом счета, переход в void выполняется только аннулированием.
 const (
-	InvoiceStatusPending  = "pending"
-	InvoiceStatusPartial  = "partial"
-	InvoiceStatusPaid     = "paid"
-	InvoiceStatusOverdue  = "overdue"
-	InvoiceStatusCanceled = "canceled"
+	InvoiceStatusDraft   = "draft"  // черновик: не отправлен брокеру, не принимает оплату
+	InvoiceStatusIssued  = "issued" // выставлен и ожидает оплаты
+	InvoiceStatusPartial = "partial"
+	InvoiceStatusPaid    = "paid"
+	InvoiceStatusOverdue = "overdue"
+	InvoiceStatusVoid    = "void" // аннулирован, конечный статус
 )
 
 // Currency валюты
@@ -22,36 +25,158 @@ const (
 	CurrencyRUB = "RUB"
 )
 
+// LineItemType типы позиций счета
+const (
+	LineItemTypeLinehaul      = "linehaul"
+	LineItemTypeFuelSurcharge = "fuel_surcharge"
+	LineItemTypeDetention     = "detention"
+	LineItemTypeLumper        = "lumper"
+	LineItemTypeNSFFee        = "nsf_fee"  // комиссия за вернувшийся платеж
+	LineItemTypeLateFee       = "late_fee" // пеня за просрочку оплаты
+	LineItemTypeOther         = "other"
+)
+
+// DiscountType типы скидок
+const (
+	DiscountTypePercent = "percent"
+	DiscountTypeFixed   = "fixed"
+)
+
 // Invoice представляет счет
 type Invoice struct {
-	ID            primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
-	InvoiceNumber string               `json:"invoice_number" bson:"invoice_number" validate:"required"`
-	BrokerID      primitive.ObjectID   `json:"broker_id" bson:"broker_id" validate:"required"`
-	Amount        float64              `json:"amount" bson:"amount" validate:"required,gt=0"`
-	PaidAmount    float64              `json:"paid_amount" bson:"paid_amount"`
-	Currency      string               `json:"currency" bson:"currency" validate:"required"`
-	Status        string               `json:"status" bson:"status"`
-	CreatedAt     time.Time            `json:"created_at" bson:"created_at"`
-	DueDate       time.Time            `json:"due_date" bson:"due_date" validate:"required"`
-	PaidAt        *time.Time           `json:"paid_at" bson:"paid_at"`
-	Description   string               `json:"description" bson:"description"`
-	LoadIDs       []primitive.ObjectID `json:"load_ids" bson:"load_ids"`
-	Notes         string               `json:"notes" bson:"notes"`
+	ID                primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	InvoiceNumber     string               `json:"invoice_number" bson:"invoice_number" validate:"required"`
+	BrokerID          primitive.ObjectID   `json:"broker_id" bson:"broker_id" validate:"required"`
+	Amount            Amount               `json:"amount" bson:"amount" validate:"required,gt=0"` // итог к оплате
+	LineItems         []LineItem           `json:"line_items" bson:"line_items"`
+	Discounts         []Discount           `json:"discounts" bson:"discounts"`
+	Subtotal          Amount               `json:"subtotal" bson:"subtotal"`             // сумма позиций до скидок и налогов
+	DiscountTotal     Amount               `json:"discount_total" bson:"discount_total"` // сумма скидок
+	TaxTotal          Amount               `json:"tax_total" bson:"tax_total"`           // налог после скидок
+	PaidAmount        Amount               `json:"paid_amount" bson:"paid_amount"`
+	CreditedAmount    Amount               `json:"credited_amount" bson:"credited_amount"` // сумма выпущенных кредит-нот
+	EarlyDiscount     Amount               `json:"early_discount" bson:"early_discount"`   // скидка за раннюю оплату, предоставленная по условиям оплаты
+	Currency          string               `json:"currency" bson:"currency" validate:"required"`
+	ExchangeRate      float64              `json:"exchange_rate,omitempty" bson:"exchange_rate,omitempty"`           // курс к базовой валюте на дату счета
+	ExchangeRateBase  string               `json:"exchange_rate_base,omitempty" bson:"exchange_rate_base,omitempty"` // базовая валюта, к которой зафиксирован курс
+	Status            string               `json:"status" bson:"status"`
+	IssueDate         time.Time            `json:"issue_date" bson:"issue_date"` // дата выставления, от нее отсчитываются условия оплаты
+	CreatedAt         time.Time            `json:"created_at" bson:"created_at"`
+	DueDate           time.Time            `json:"due_date" bson:"due_date"` // по умолчанию рассчитывается по условиям оплаты
+	PaymentTerms      *PaymentTerms        `json:"payment_terms,omitempty" bson:"payment_terms,omitempty"`
+	PaidAt            *time.Time           `json:"paid_at" bson:"paid_at"`
+	Description       string               `json:"description" bson:"description"`
+	LoadIDs           []primitive.ObjectID `json:"load_ids" bson:"load_ids"`
+	Notes             string               `json:"notes" bson:"notes"`
+	LateFeeFor        primitive.ObjectID   `json:"late_fee_for,omitempty" bson:"late_fee_for,omitempty"`               // просроченный счет, за который начислена пеня
+	LateFeePeriod     int                  `json:"late_fee_period,omitempty" bson:"late_fee_period,omitempty"`         // номер периода начисления
+	BillingScheduleID primitive.ObjectID   `json:"billing_schedule_id,omitempty" bson:"billing_schedule_id,omitempty"` // расписание, по которому выставлен счет
+	BillingPeriod     int                  `json:"billing_period,omitempty" bson:"billing_period,omitempty"`           // номер периода расписания
+	CreditOverride    *CreditOverride      `json:"credit_override,omitempty" bson:"credit_override,omitempty"`         // разрешение превысить кредитный лимит брокера
+	VoidReason        string               `json:"void_reason,omitempty" bson:"void_reason,omitempty"`
+	VoidedBy          string               `json:"voided_by,omitempty" bson:"voided_by,omitempty"`
+	VoidedAt          *time.Time           `json:"voided_at,omitempty" bson:"voided_at,omitempty"`
+	VoidedFrom        string               `json:"voided_from,omitempty" bson:"voided_from,omitempty"` // статус счета до аннулирования
 
 	// Calculated fields
-	IsOverdue       bool    `json:"is_overdue" bson:"-"`
-	RemainingAmount float64 `json:"remaining_amount" bson:"-"`
+	IsOverdue          bool       `json:"is_overdue" bson:"-"`
+	RemainingAmount    Amount     `json:"remaining_amount" bson:"-"`
+	DiscountDeadline   *time.Time `json:"discount_deadline,omitempty" bson:"-"`    // последний день оплаты со скидкой
+	EarlyPaymentAmount Amount     `json:"early_payment_amount,omitempty" bson:"-"` // остаток к оплате со скидкой до discount_deadline
 
 	// Computed fields from JOINs (не сохраняются в БД)
 	BrokerName string `json:"broker_name" bson:"broker_name,omitempty"`
 }
 
+// IsOpen проверяет, что счет выставлен и ожидает оплаты
+func (i *Invoice) IsOpen() bool {
+	return i.Status == InvoiceStatusIssued || i.Status == InvoiceStatusPartial || i.Status == InvoiceStatusOverdue
+}
+
+// Total сумма счета в его валюте
+func (i *Invoice) Total() Money {
+	return NewMoney(i.Amount, i.Currency)
+}
+
+// Remaining остаток к оплате в валюте счета
+func (i *Invoice) Remaining() Money {
+	return NewMoney(i.RemainingAmount, i.Currency)
+}
+
+// BaseRate курс счета к базовой валюте base. Возвращает 0, если курс не зафиксирован
+// или зафиксирован к прежней базовой валюте.
+func (i *Invoice) BaseRate(base string) float64 {
+	if i.ExchangeRateBase != base {
+		return 0
+	}
+	return i.ExchangeRate
+}
+
+// IsLateFee проверяет, что счет выставлен на пеню за просрочку
+func (i *Invoice) IsLateFee() bool {
+	return !i.LateFeeFor.IsZero()
+}
+
+// IssuedOn дата, от которой отсчитываются условия оплаты. У счетов, созданных до появления
+// даты выставления, ею считается дата создания.
+func (i *Invoice) IssuedOn() time.Time {
+	if i.IssueDate.IsZero() {
+		return i.CreatedAt
+	}
+	return i.IssueDate
+}
+
+// LineItem позиция счета: фрахт, топливная надбавка, простой, услуги грузчиков и т.п.
+type LineItem struct {
+	Type        string             `json:"type" bson:"type"`
+	Description string             `json:"description" bson:"description"`
+	Quantity    float64            `json:"quantity" bson:"quantity"`
+	UnitPrice   Amount             `json:"unit_price" bson:"unit_price"`
+	TaxRate     float64            `json:"tax_rate" bson:"tax_rate"` // ставка налога в процентах
+	LoadID      primitive.ObjectID `json:"load_id,omitempty" bson:"load_id,omitempty"`
+
+	// Вычисляются сервером
+	Amount    Amount `json:"amount" bson:"amount"`         // quantity * unit_price
+	TaxAmount Amount `json:"tax_amount" bson:"tax_amount"` // налог с учетом доли скидок
+}
+
+// Discount скидка на весь счет
+type Discount struct {
+	Description string  `json:"description" bson:"description"`
+	Type        string  `json:"type" bson:"type"`   // percent, fixed
+	Value       float64 `json:"value" bson:"value"` // процент или сумма в основных единицах
+
+	// Вычисляется сервером
+	Amount Amount `json:"amount" bson:"amount"`
+}
+
 // InvoiceWithBroker счет с информацией о брокере
 type InvoiceWithBroker struct {
 	Invoice
 	Broker Broker `json:"broker" bson:"broker"`
 }
 
+// InvoiceFromLoadsRequest запрос на выставление счета по выбранным грузам.
+// Сумма и валюта счета считаются по грузам; для грузов в разных валютах создается по счету на валюту.
+type InvoiceFromLoadsRequest struct {
+	BrokerID     primitive.ObjectID   `json:"broker_id"`
+	LoadIDs      []primitive.ObjectID `json:"load_ids"`
+	DueDate      time.Time            `json:"due_date"`      // по умолчанию по условиям оплаты
+	PaymentTerms *PaymentTerms        `json:"payment_terms"` // по умолчанию условия брокера
+	Description  string               `json:"description"`
+	Notes        string               `json:"notes"`
+}
+
+// IssueInvoiceRequest запрос выставления черновика счета
+type IssueInvoiceRequest struct {
+	CreditOverride *CreditOverride `json:"credit_override,omitempty"` // разрешение превысить кредитный лимит
+}
+
+// VoidInvoiceRequest запрос аннулирования счета
+type VoidInvoiceRequest struct {
+	Reason string `json:"reason"`
+}
+
 // InvoiceFilter фильтры для поиска счетов
 type InvoiceFilter struct {
 	Status     []string           `json:"status"`
@@ -59,7 +184,7 @@ type InvoiceFilter struct {
 	Currency   string             `json:"currency"`
 	DateFrom   *time.Time         `json:"date_from"`
 	DateTo     *time.Time         `json:"date_to"`
-	AmountFrom *float64           `json:"amount_from"`
-	AmountTo   *float64           `json:"amount_to"`
+	AmountFrom *Amount            `json:"amount_from"`
+	AmountTo   *Amount            `json:"amount_to"`
 	IsOverdue  *bool              `json:"is_overdue"`
 }
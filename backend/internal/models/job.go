@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// JobRunStatus статусы запуска фоновой задачи
+const (
+	JobRunStatusRunning = "running"
+	JobRunStatusSuccess = "success"
+	JobRunStatusFailed  = "failed"
+)
+
+// JobRun запись о запуске фоновой задачи
+type JobRun struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Job        string             `json:"job" bson:"job"`
+	Status     string             `json:"status" bson:"status"`
+	Owner      string             `json:"owner" bson:"owner"` // экземпляр приложения, выполнивший задачу
+	StartedAt  time.Time          `json:"started_at" bson:"started_at"`
+	FinishedAt *time.Time         `json:"finished_at" bson:"finished_at"`
+	DurationMs int64              `json:"duration_ms" bson:"duration_ms"`
+	Result     string             `json:"result" bson:"result"`
+	Error      string             `json:"error" bson:"error"`
+}
+
+// JobLock блокировка задачи между экземплярами приложения
+type JobLock struct {
+	Job         string    `json:"job" bson:"_id"`
+	Owner       string    `json:"owner" bson:"owner"`
+	Slot        time.Time `json:"slot" bson:"slot"` // запуск по расписанию, за который взята блокировка
+	LockedUntil time.Time `json:"locked_until" bson:"locked_until"`
+	AcquiredAt  time.Time `json:"acquired_at" bson:"acquired_at"`
+}
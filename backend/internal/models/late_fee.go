@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// LateFeeType способы начисления пеней за просрочку
+const (
+	LateFeeTypeNone     = "none"
+	LateFeeTypeFlat     = "flat"     // фиксированная сумма один раз на просроченный счет
+	LateFeeTypeInterest = "interest" // процент от остатка за каждый полный месяц просрочки
+)
+
+// LateFeePolicy правила начисления пеней. Пени выставляются отдельными счетами, связанными с просроченным.
+type LateFeePolicy struct {
+	Type        string  `json:"type" bson:"type"`
+	FlatFee     Amount  `json:"flat_fee,omitempty" bson:"flat_fee,omitempty"`         // в валюте счета; в общих правилах - в базовой валюте
+	MonthlyRate float64 `json:"monthly_rate,omitempty" bson:"monthly_rate,omitempty"` // процент в месяц
+	GraceDays   int     `json:"grace_days" bson:"grace_days"`                         // дней после срока оплаты без начисления
+}
+
+// PeriodsDue число периодов начисления, наступивших к моменту now по счету со сроком оплаты dueDate:
+// для фиксированной пени - один период по окончании льготного срока, для процентов - каждый полный месяц после него
+func (p LateFeePolicy) PeriodsDue(dueDate, now time.Time) int {
+	start := dueDate.AddDate(0, 0, p.GraceDays)
+	if !now.After(start) {
+		return 0
+	}
+
+	switch p.Type {
+	case LateFeeTypeFlat:
+		return 1
+	case LateFeeTypeInterest:
+		periods := 0
+		for !now.Before(start.AddDate(0, periods+1, 0)) {
+			periods++
+		}
+		return periods
+	default:
+		return 0
+	}
+}
+
+// Fee сумма пени за период по остатку счета remaining
+func (p LateFeePolicy) Fee(remaining Amount) Amount {
+	switch p.Type {
+	case LateFeeTypeFlat:
+		return p.FlatFee
+	case LateFeeTypeInterest:
+		return remaining.Percent(p.MonthlyRate)
+	default:
+		return 0
+	}
+}
+
+// LateFeeSummary итог прогона начисления пеней
+type LateFeeSummary struct {
+	InvoicesChecked int `json:"invoices_checked"`
+	FeesCreated     int `json:"fees_created"` // выставлено счетов на пени
+	Failed          int `json:"failed"`
+}
+
+// WaiveLateFeeRequest запрос списания пени с указанием причины
+type WaiveLateFeeRequest struct {
+	Reason string `json:"reason"`
+}
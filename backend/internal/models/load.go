@@ -16,23 +16,24 @@ const (
 
 // Load представляет груз/рейс
 type Load struct {
-	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	LoadNumber   string             `json:"load_number" bson:"load_number" validate:"required"`
-	BrokerID     primitive.ObjectID `json:"broker_id" bson:"broker_id" validate:"required"`
-	InvoiceID    primitive.ObjectID `json:"invoice_id" bson:"invoice_id"`
-	Route        Route              `json:"route" bson:"route" validate:"required"`
-	PickupDate   time.Time          `json:"pickup_date" bson:"pickup_date"`
-	DeliveryDate time.Time          `json:"delivery_date" bson:"delivery_date"`
-	Cost         float64            `json:"cost" bson:"cost" validate:"required,gt=0"`
-	Currency     string             `json:"currency" bson:"currency" validate:"required"`
-	Status       string             `json:"status" bson:"status"`
-	Weight       float64            `json:"weight" bson:"weight"`
-	Distance     float64            `json:"distance" bson:"distance"`   // в милях
-	Equipment    string             `json:"equipment" bson:"equipment"` // тип трейлера
-	DriverInfo   DriverInfo         `json:"driver_info" bson:"driver_info"`
-	Notes        string             `json:"notes" bson:"notes"`
-	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at"`
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	LoadNumber     string             `json:"load_number" bson:"load_number" validate:"required"`
+	BrokerID       primitive.ObjectID `json:"broker_id" bson:"broker_id" validate:"required"`
+	InvoiceID      primitive.ObjectID `json:"invoice_id" bson:"invoice_id"`
+	Route          Route              `json:"route" bson:"route" validate:"required"`
+	PickupDate     time.Time          `json:"pickup_date" bson:"pickup_date"`
+	DeliveryDate   time.Time          `json:"delivery_date" bson:"delivery_date"`
+	Cost           Amount             `json:"cost" bson:"cost" validate:"required,gt=0"`
+	Currency       string             `json:"currency" bson:"currency" validate:"required"`
+	Status         string             `json:"status" bson:"status"`
+	Weight         float64            `json:"weight" bson:"weight"`
+	Distance       float64            `json:"distance" bson:"distance"`   // в милях
+	Equipment      string             `json:"equipment" bson:"equipment"` // тип трейлера
+	DriverInfo     DriverInfo         `json:"driver_info" bson:"driver_info"`
+	Notes          string             `json:"notes" bson:"notes"`
+	CreditOverride *CreditOverride    `json:"credit_override,omitempty" bson:"credit_override,omitempty"` // разрешение превысить кредитный лимит брокера
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at" bson:"updated_at"`
 
 	// Computed fields from JOINs (не сохраняются в БД)
 	BrokerName    string `json:"broker_name" bson:"broker_name,omitempty"`
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// minorUnits количество минорных единиц в основной (центов в долларе, копеек в рубле)
+const minorUnits = 100
+
+// ErrCurrencyMismatch ошибка операции над суммами в разных валютах
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// Amount денежная сумма в минорных единицах (центах).
+// В MongoDB хранится как int64, в JSON передается десятичным числом: 1234.56
+type Amount int64
+
+// AmountFromFloat переводит сумму в основных единицах в Amount с округлением до цента
+func AmountFromFloat(value float64) Amount {
+	return Amount(math.Round(value * minorUnits))
+}
+
+// ParseAmount разбирает десятичную запись суммы без потери точности: "1234.56", "1e3"
+func ParseAmount(value string) (Amount, error) {
+	rat, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+
+	rat.Mul(rat, big.NewRat(minorUnits, 1))
+
+	// Округление половины от нуля
+	num, denom := rat.Num(), rat.Denom()
+	quotient, remainder := new(big.Int).QuoRem(num, denom, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(denom) >= 0 {
+		if num.Sign() < 0 {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+
+	if !quotient.IsInt64() {
+		return 0, fmt.Errorf("amount %q is out of range", value)
+	}
+	return Amount(quotient.Int64()), nil
+}
+
+// Float64 возвращает сумму в основных единицах
+func (a Amount) Float64() float64 {
+	return float64(a) / minorUnits
+}
+
+// Mul умножает сумму на коэффициент (количество, долю) с округлением до цента
+func (a Amount) Mul(factor float64) Amount {
+	return Amount(math.Round(float64(a) * factor))
+}
+
+// Percent возвращает rate процентов от суммы с округлением до цента
+func (a Amount) Percent(rate float64) Amount {
+	return a.Mul(rate / 100)
+}
+
+// String форматирует сумму с двумя знаками после точки: 1234.56
+func (a Amount) String() string {
+	sign := ""
+	value := int64(a)
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, value/minorUnits, value%minorUnits)
+}
+
+// MarshalJSON кодирует сумму десятичным числом
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON принимает сумму числом или строкой
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	value := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return err
+		}
+		if unquoted == "" {
+			*a = 0
+			return nil
+		}
+		value = unquoted
+	}
+
+	amount, err := ParseAmount(value)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// Money сумма в конкретной валюте. Арифметика не смешивает валюты.
+type Money struct {
+	Amount   Amount `json:"amount" bson:"amount"`
+	Currency string `json:"currency" bson:"currency"`
+}
+
+// NewMoney создает сумму в валюте
+func NewMoney(amount Amount, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Add складывает суммы одной валюты
+func (m Money) Add(other Money) (Money, error) {
+	if err := m.checkCurrency(other); err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Sub вычитает сумму той же валюты
+func (m Money) Sub(other Money) (Money, error) {
+	if err := m.checkCurrency(other); err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount - other.Amount, Currency: m.Currency}, nil
+}
+
+// Cmp сравнивает суммы одной валюты: -1, 0 или 1
+func (m Money) Cmp(other Money) (int, error) {
+	if err := m.checkCurrency(other); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.Amount < other.Amount:
+		return -1, nil
+	case m.Amount > other.Amount:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// Min возвращает меньшую из сумм одной валюты
+func (m Money) Min(other Money) (Money, error) {
+	cmp, err := m.Cmp(other)
+	if err != nil {
+		return Money{}, err
+	}
+	if cmp > 0 {
+		return other, nil
+	}
+	return m, nil
+}
+
+// IsZero проверяет, что сумма равна нулю
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// IsPositive проверяет, что сумма больше нуля
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// String форматирует сумму с валютой: USD 1234.56
+func (m Money) String() string {
+	return m.Currency + " " + m.Amount.String()
+}
+
+// checkCurrency проверяет совпадение валют
+func (m Money) checkCurrency(other Money) error {
+	if m.Currency != other.Currency {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Amount
+		wantErr bool
+	}{
+		{name: "integer", value: "1234", want: 123400},
+		{name: "cents", value: "1234.56", want: 123456},
+		{name: "negative", value: "-12.34", want: -1234},
+		{name: "exponent", value: "1e3", want: 100000},
+		{name: "binary fraction is exact", value: "0.29", want: 29},
+		{name: "half cent rounds up", value: "0.005", want: 1},
+		{name: "negative half cent rounds away from zero", value: "-0.005", want: -1},
+		{name: "below half cent rounds down", value: "10.0049", want: 1000},
+		{name: "above half cent rounds up", value: "1.2351", want: 124},
+		{name: "negative rounds away from zero", value: "-1.235", want: -124},
+		{name: "fraction", value: "1/3", want: 33},
+		{name: "empty", value: "", wantErr: true},
+		{name: "not a number", value: "12,34", wantErr: true},
+		{name: "out of range", value: "1e30", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAmount(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseAmount(%q) = %d, want error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseAmount(%q) error: %v", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAmount(%q) = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmountRounding(t *testing.T) {
+	tests := []struct {
+		name string
+		got  Amount
+		want Amount
+	}{
+		{name: "from float sum", got: AmountFromFloat(0.1 + 0.2), want: 30},
+		{name: "from float negative", got: AmountFromFloat(-19.999), want: -2000},
+		{name: "mul half cent rounds up", got: Amount(10001).Mul(0.5), want: 5001},
+		{name: "mul negative half cent rounds away from zero", got: Amount(-10001).Mul(0.5), want: -5001},
+		{name: "mul exchange rate", got: Amount(100000).Mul(1.08345), want: 108345},
+		{name: "percent", got: Amount(123456).Percent(1.5), want: 1852},
+		{name: "percent of zero", got: Amount(0).Percent(10), want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %d, want %d", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		want   string
+	}{
+		{amount: 0, want: "0.00"},
+		{amount: 5, want: "0.05"},
+		{amount: 123456, want: "1234.56"},
+		{amount: -5, want: "-0.05"},
+		{amount: -123400, want: "-1234.00"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.amount.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", int64(tt.amount), got, tt.want)
+		}
+	}
+}
+
+func TestAmountMarshalJSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		Amount   Amount  `json:"amount"`
+		Optional *Amount `json:"optional"`
+	}{Amount: -123456})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"amount":-1234.56,"optional":null}`
+	if string(data) != want {
+		t.Errorf("json.Marshal = %s, want %s", data, want)
+	}
+}
+
+func TestAmountUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		initial Amount
+		want    Amount
+		wantErr bool
+	}{
+		{name: "number", data: `1234.56`, want: 123456},
+		{name: "integer", data: `42`, want: 4200},
+		{name: "string", data: `"1234.56"`, want: 123456},
+		{name: "empty string", data: `""`, initial: 100, want: 0},
+		{name: "null keeps value", data: `null`, initial: 100, want: 100},
+		{name: "rounds half cent", data: `0.125`, want: 13},
+		{name: "invalid string", data: `"abc"`, wantErr: true},
+		{name: "boolean", data: `true`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc struct {
+				Amount Amount `json:"amount"`
+			}
+			doc.Amount = tt.initial
+
+			err := json.Unmarshal([]byte(`{"amount":`+tt.data+`}`), &doc)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("json.Unmarshal(%s) = %d, want error", tt.data, doc.Amount)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("json.Unmarshal(%s) error: %v", tt.data, err)
+			}
+			if doc.Amount != tt.want {
+				t.Errorf("json.Unmarshal(%s) = %d, want %d", tt.data, doc.Amount, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmountJSONRoundTrip(t *testing.T) {
+	for _, amount := range []Amount{0, 1, -1, 99, 100, 123456, -987654321} {
+		data, err := json.Marshal(amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded Amount
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("json.Unmarshal(%s) error: %v", data, err)
+		}
+		if decoded != amount {
+			t.Errorf("round trip of %d = %d", int64(amount), int64(decoded))
+		}
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	usd := func(amount Amount) Money { return NewMoney(amount, "USD") }
+
+	tests := []struct {
+		name string
+		op   func() (Money, error)
+		want Money
+	}{
+		{name: "add", op: func() (Money, error) { return usd(1050).Add(usd(250)) }, want: usd(1300)},
+		{name: "sub", op: func() (Money, error) { return usd(1050).Sub(usd(2000)) }, want: usd(-950)},
+		{name: "min of smaller", op: func() (Money, error) { return usd(100).Min(usd(200)) }, want: usd(100)},
+		{name: "min of larger", op: func() (Money, error) { return usd(300).Min(usd(200)) }, want: usd(200)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyCmp(t *testing.T) {
+	tests := []struct {
+		a, b Money
+		want int
+	}{
+		{a: NewMoney(100, "USD"), b: NewMoney(200, "USD"), want: -1},
+		{a: NewMoney(200, "USD"), b: NewMoney(200, "USD"), want: 0},
+		{a: NewMoney(300, "USD"), b: NewMoney(200, "USD"), want: 1},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.a.Cmp(tt.b)
+		if err != nil {
+			t.Fatalf("%s.Cmp(%s) error: %v", tt.a, tt.b, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s.Cmp(%s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyCurrencyMismatch(t *testing.T) {
+	usd := NewMoney(1000, "USD")
+	eur := NewMoney(1000, "EUR")
+
+	tests := []struct {
+		name string
+		op   func() error
+	}{
+		{name: "add", op: func() error { _, err := usd.Add(eur); return err }},
+		{name: "sub", op: func() error { _, err := usd.Sub(eur); return err }},
+		{name: "cmp", op: func() error { _, err := usd.Cmp(eur); return err }},
+		{name: "min", op: func() error { _, err := usd.Min(eur); return err }},
+		{name: "missing currency", op: func() error { _, err := usd.Add(NewMoney(1000, "")); return err }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); !errors.Is(err, ErrCurrencyMismatch) {
+				t.Errorf("error = %v, want ErrCurrencyMismatch", err)
+			}
+		})
+	}
+}
@@ -15,26 +15,130 @@ const (
 	PaymentMethodCrypto       = "crypto"
 )
 
-// Payment представляет платеж
+// PaymentType виды записей о движении денег брокера
+const (
+	PaymentTypePayment  = "payment"
+	PaymentTypeReversal = "reversal" // сторно платежа: чек вернулся неоплаченным, chargeback по карте
+	PaymentTypeRefund   = "refund"   // возврат брокеру части неразнесенного остатка платежа
+)
+
+// Коды причин сторно
+const (
+	ReversalReasonNSF           = "nsf" // недостаточно средств, чек вернулся
+	ReversalReasonChargeback    = "chargeback"
+	ReversalReasonStopPayment   = "stop_payment"
+	ReversalReasonAccountClosed = "account_closed"
+	ReversalReasonPostingError  = "posting_error" // платеж проведен по ошибке
+)
+
+// Коды причин возврата
+const (
+	RefundReasonOverpayment     = "overpayment"
+	RefundReasonDuplicate       = "duplicate_payment"
+	RefundReasonCustomerRequest = "customer_request"
+	RefundReasonOther           = "other"
+)
+
+// Payment представляет платеж.
+// Сторно и возврат хранятся отдельными записями с отрицательной суммой и ссылкой на исходный платеж.
 type Payment struct {
-	ID              primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	InvoiceID       primitive.ObjectID `json:"invoice_id" bson:"invoice_id" validate:"required"`
-	BrokerID        primitive.ObjectID `json:"broker_id" bson:"broker_id" validate:"required"`
-	Amount          float64            `json:"amount" bson:"amount" validate:"required,gt=0"`
-	Currency        string             `json:"currency" bson:"currency" validate:"required"`
-	PaymentDate     time.Time          `json:"payment_date" bson:"payment_date" validate:"required"`
-	PaymentMethod   string             `json:"payment_method" bson:"payment_method" validate:"required"`
-	TransactionID   string             `json:"transaction_id" bson:"transaction_id"`
-	ReferenceNumber string             `json:"reference_number" bson:"reference_number"`
-	Notes           string             `json:"notes" bson:"notes"`
-	CreatedAt       time.Time          `json:"created_at" bson:"created_at"`
-	CreatedBy       string             `json:"created_by" bson:"created_by"`
+	ID                primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	Type              string              `json:"type" bson:"type"`
+	BrokerID          primitive.ObjectID  `json:"broker_id" bson:"broker_id" validate:"required"`
+	Amount            Amount              `json:"amount" bson:"amount" validate:"required,gt=0"`
+	Allocations       []PaymentAllocation `json:"allocations" bson:"allocations"`           // разнесение платежа по счетам
+	UnappliedAmount   Amount              `json:"unapplied_amount" bson:"unapplied_amount"` // часть платежа, не разнесенная на счета (кредит брокера)
+	Currency          string              `json:"currency" bson:"currency" validate:"required"`
+	ExchangeRate      float64             `json:"exchange_rate,omitempty" bson:"exchange_rate,omitempty"`           // курс к базовой валюте на дату платежа
+	ExchangeRateBase  string              `json:"exchange_rate_base,omitempty" bson:"exchange_rate_base,omitempty"` // базовая валюта, к которой зафиксирован курс
+	BaseAmount        Amount              `json:"base_amount" bson:"base_amount"`                                   // сумма в базовой валюте по курсу платежа
+	FXGainLoss        Amount              `json:"fx_gain_loss" bson:"fx_gain_loss"`                                 // реализованная курсовая разница по всем разнесениям
+	PaymentDate       time.Time           `json:"payment_date" bson:"payment_date" validate:"required"`
+	PaymentMethod     string              `json:"payment_method" bson:"payment_method" validate:"required"`
+	TransactionID     string              `json:"transaction_id" bson:"transaction_id"`
+	ReferenceNumber   string              `json:"reference_number" bson:"reference_number"`
+	Notes             string              `json:"notes" bson:"notes"`
+	BankTransactionID primitive.ObjectID  `json:"bank_transaction_id,omitempty" bson:"bank_transaction_id,omitempty"` // операция выписки, из которой проведен платеж
+	OriginalPaymentID primitive.ObjectID  `json:"original_payment_id,omitempty" bson:"original_payment_id,omitempty"` // у сторно и возврата - исходный платеж
+	ReasonCode        string              `json:"reason_code,omitempty" bson:"reason_code,omitempty"`                 // причина сторно или возврата
+	ReversalID        primitive.ObjectID  `json:"reversal_id,omitempty" bson:"reversal_id,omitempty"`                 // сторно, отменившее платеж
+	RefundedAmount    Amount              `json:"refunded_amount" bson:"refunded_amount"`                             // возвращено брокеру из неразнесенного остатка
+	FeeInvoiceID      primitive.ObjectID  `json:"fee_invoice_id,omitempty" bson:"fee_invoice_id,omitempty"`           // у сторно - счет на комиссию за возврат (NSF)
+	CreatedAt         time.Time           `json:"created_at" bson:"created_at"`
+	CreatedBy         string              `json:"created_by" bson:"created_by"`
+
+	// Поля запроса (не сохраняются в БД)
+	InvoiceID    primitive.ObjectID `json:"invoice_id" bson:"-"`              // краткая запись разнесения на один счет; в ответе - счет единственного разнесения
+	AutoAllocate bool               `json:"auto_allocate,omitempty" bson:"-"` // разнести на открытые счета брокера начиная с самого раннего срока
+
+	// Computed fields from JOINs (не сохраняются в БД)
+	BrokerName      string `json:"broker_name" bson:"broker_name,omitempty"`
+	InvoiceNumber   string `json:"invoice_number" bson:"invoice_number,omitempty"`
+	AllocatedAmount Amount `json:"allocated_amount,omitempty" bson:"-"` // в истории счета: сумма, разнесенная на этот счет
+}
+
+// PaymentAllocation часть платежа, разнесенная на счет
+type PaymentAllocation struct {
+	InvoiceID  primitive.ObjectID `json:"invoice_id" bson:"invoice_id"`
+	Amount     Amount             `json:"amount" bson:"amount"`
+	FXGainLoss Amount             `json:"fx_gain_loss" bson:"fx_gain_loss"` // курсовая разница относительно курса этого счета
 
 	// Computed fields from JOINs (не сохраняются в БД)
-	BrokerName    string `json:"broker_name" bson:"broker_name,omitempty"`
 	InvoiceNumber string `json:"invoice_number" bson:"invoice_number,omitempty"`
 }
 
+// BaseRate курс платежа к базовой валюте base. Возвращает 0, если курс не зафиксирован
+// или зафиксирован к прежней базовой валюте.
+func (p *Payment) BaseRate(base string) float64 {
+	if p.ExchangeRateBase != base {
+		return 0
+	}
+	return p.ExchangeRate
+}
+
+// Total сумма платежа в его валюте
+func (p *Payment) Total() Money {
+	return NewMoney(p.Amount, p.Currency)
+}
+
+// Unapplied неразнесенный остаток платежа в его валюте
+func (p *Payment) Unapplied() Money {
+	return NewMoney(p.UnappliedAmount, p.Currency)
+}
+
+// AppliedAmount часть платежа, разнесенная на счета
+func (p *Payment) AppliedAmount() Amount {
+	var total Amount
+	for _, allocation := range p.Allocations {
+		total += allocation.Amount
+	}
+	return total
+}
+
+// AllocationFor возвращает разнесение платежа на счет или nil
+func (p *Payment) AllocationFor(invoiceID primitive.ObjectID) *PaymentAllocation {
+	for i := range p.Allocations {
+		if p.Allocations[i].InvoiceID == invoiceID {
+			return &p.Allocations[i]
+		}
+	}
+	return nil
+}
+
+// InvoiceIDs возвращает счета, на которые разнесен платеж
+func (p *Payment) InvoiceIDs() []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, len(p.Allocations))
+	for i, allocation := range p.Allocations {
+		ids[i] = allocation.InvoiceID
+	}
+	return ids
+}
+
+// IsReversed проверяет, что платеж отменен сторно
+func (p *Payment) IsReversed() bool {
+	return !p.ReversalID.IsZero()
+}
+
 // PaymentWithInvoice платеж с информацией о счете
 type PaymentWithInvoice struct {
 	Payment
@@ -47,9 +151,62 @@ type PaymentFilter struct {
 	InvoiceID     primitive.ObjectID `json:"invoice_id"`
 	BrokerID      primitive.ObjectID `json:"broker_id"`
 	PaymentMethod string             `json:"payment_method"`
+	Type          string             `json:"type"`
 	Currency      string             `json:"currency"`
 	DateFrom      *time.Time         `json:"date_from"`
 	DateTo        *time.Time         `json:"date_to"`
-	AmountFrom    *float64           `json:"amount_from"`
-	AmountTo      *float64           `json:"amount_to"`
+	AmountFrom    *Amount            `json:"amount_from"`
+	AmountTo      *Amount            `json:"amount_to"`
+}
+
+// ReversePaymentRequest запрос сторно платежа
+type ReversePaymentRequest struct {
+	ReasonCode string    `json:"reason_code"`
+	Date       time.Time `json:"date"` // дата возврата банком, по умолчанию текущая
+	Notes      string    `json:"notes"`
+	NSFFee     Amount    `json:"nsf_fee"` // комиссия за возврат, выставляется брокеру отдельным счетом
+}
+
+// RefundPaymentRequest запрос возврата брокеру части неразнесенного остатка платежа
+type RefundPaymentRequest struct {
+	Amount          Amount    `json:"amount"`
+	ReasonCode      string    `json:"reason_code"`
+	Date            time.Time `json:"date"` // по умолчанию текущая
+	PaymentMethod   string    `json:"payment_method"`
+	ReferenceNumber string    `json:"reference_number"`
+	Notes           string    `json:"notes"`
+}
+
+// PaymentReversalResult результат сторно платежа
+type PaymentReversalResult struct {
+	Reversal   *Payment `json:"reversal"`
+	FeeInvoice *Invoice `json:"fee_invoice,omitempty"`
+}
+
+// BrokerCredit неразнесенный остаток платежей брокера в одной валюте
+type BrokerCredit struct {
+	Currency string `json:"currency" bson:"_id"`
+	Amount   Amount `json:"amount" bson:"amount"`
+}
+
+// ApplyCreditRequest запрос зачета кредита брокера в оплату открытых счетов.
+// Без InvoiceIDs кредит зачитывается в счета брокера начиная с самого раннего срока оплаты.
+type ApplyCreditRequest struct {
+	Currency   string               `json:"currency"`
+	InvoiceIDs []primitive.ObjectID `json:"invoice_ids"`
+}
+
+// CreditApplication зачет части кредита в оплату счета
+type CreditApplication struct {
+	PaymentID     primitive.ObjectID `json:"payment_id"` // платеж, неразнесенный остаток которого зачтен
+	InvoiceID     primitive.ObjectID `json:"invoice_id"`
+	InvoiceNumber string             `json:"invoice_number"`
+	Currency      string             `json:"currency"`
+	Amount        Amount             `json:"amount"`
+}
+
+// ApplyCreditResult результат зачета кредита брокера
+type ApplyCreditResult struct {
+	Applications    []CreditApplication `json:"applications"`
+	RemainingCredit []BrokerCredit      `json:"remaining_credit"`
 }
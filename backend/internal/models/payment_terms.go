@@ -0,0 +1,88 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// PaymentTermsType типы условий оплаты
+const (
+	PaymentTermsNet          = "net"            // через N дней от даты выставления
+	PaymentTermsDueOnReceipt = "due_on_receipt" // в день выставления
+	PaymentTermsEndOfMonth   = "eom"            // через N дней после конца месяца выставления
+)
+
+// PaymentTerms условия оплаты счета: срок и необязательная скидка за раннюю оплату.
+// Например, 2/10 Net 30 - скидка 2% при оплате в течение 10 дней, иначе полная сумма через 30 дней.
+type PaymentTerms struct {
+	Type            string  `json:"type" bson:"type"` // net, due_on_receipt, eom
+	Days            int     `json:"days" bson:"days"`
+	DiscountPercent float64 `json:"discount_percent,omitempty" bson:"discount_percent,omitempty"`
+	DiscountDays    int     `json:"discount_days,omitempty" bson:"discount_days,omitempty"`
+}
+
+// DueDate срок оплаты счета, выставленного в день issued
+func (t PaymentTerms) DueDate(issued time.Time) time.Time {
+	day := startOfDay(issued)
+
+	switch t.Type {
+	case PaymentTermsDueOnReceipt:
+		return day
+	case PaymentTermsEndOfMonth:
+		// Нулевой день следующего месяца - последний день текущего
+		endOfMonth := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, day.Location())
+		return endOfMonth.AddDate(0, 0, t.Days)
+	default:
+		return day.AddDate(0, 0, t.Days)
+	}
+}
+
+// HasDiscount проверяет, предусмотрена ли скидка за раннюю оплату
+func (t PaymentTerms) HasDiscount() bool {
+	return t.DiscountPercent > 0 && t.DiscountDays > 0
+}
+
+// DiscountDeadline последний день, оплата в который дает скидку
+func (t PaymentTerms) DiscountDeadline(issued time.Time) time.Time {
+	return startOfDay(issued).AddDate(0, 0, t.DiscountDays)
+}
+
+// DiscountAppliesTo проверяет, что оплата в момент paid укладывается в срок скидки
+func (t PaymentTerms) DiscountAppliesTo(issued, paid time.Time) bool {
+	return t.HasDiscount() && paid.Before(t.DiscountDeadline(issued).AddDate(0, 0, 1))
+}
+
+// Discount сумма скидки за раннюю оплату счета на сумму amount
+func (t PaymentTerms) Discount(amount Amount) Amount {
+	if !t.HasDiscount() {
+		return 0
+	}
+	return amount.Percent(t.DiscountPercent)
+}
+
+// String возвращает условия в общепринятой записи: Net 30, 2/10 Net 30, Net 15 EOM, Due on receipt
+func (t PaymentTerms) String() string {
+	var terms string
+	switch t.Type {
+	case PaymentTermsDueOnReceipt:
+		terms = "Due on receipt"
+	case PaymentTermsEndOfMonth:
+		terms = "EOM"
+		if t.Days > 0 {
+			terms = fmt.Sprintf("Net %d EOM", t.Days)
+		}
+	default:
+		terms = fmt.Sprintf("Net %d", t.Days)
+	}
+
+	if t.HasDiscount() {
+		return fmt.Sprintf("%s/%d %s", strconv.FormatFloat(t.DiscountPercent, 'f', -1, 64), t.DiscountDays, terms)
+	}
+	return terms
+}
+
+// startOfDay возвращает начало дня t
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
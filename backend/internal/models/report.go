@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AgingBuckets остатки задолженности по корзинам просрочки (по DueDate)
+type AgingBuckets struct {
+	Current    Amount `json:"current"`
+	Days1To30  Amount `json:"days_1_30"`
+	Days31To60 Amount `json:"days_31_60"`
+	Days61To90 Amount `json:"days_61_90"`
+	Days90Plus Amount `json:"days_90_plus"`
+	Total      Amount `json:"total"`
+}
+
+// Add добавляет остаток счета в корзину по количеству дней просрочки
+func (b *AgingBuckets) Add(daysPastDue int, amount Amount) {
+	switch {
+	case daysPastDue <= 0:
+		b.Current += amount
+	case daysPastDue <= 30:
+		b.Days1To30 += amount
+	case daysPastDue <= 60:
+		b.Days31To60 += amount
+	case daysPastDue <= 90:
+		b.Days61To90 += amount
+	default:
+		b.Days90Plus += amount
+	}
+	b.Total += amount
+}
+
+// AgingReportRow строка отчета по брокеру в одной валюте
+type AgingReportRow struct {
+	BrokerID      primitive.ObjectID `json:"broker_id"`
+	CompanyName   string             `json:"company_name"`
+	Currency      string             `json:"currency"`
+	InvoicesCount int                `json:"invoices_count"`
+	AgingBuckets
+}
+
+// AgingReportTotal итог отчета по одной валюте
+type AgingReportTotal struct {
+	Currency      string `json:"currency"`
+	InvoicesCount int    `json:"invoices_count"`
+	AgingBuckets
+}
+
+// AgingReport отчет о возрасте дебиторской задолженности
+type AgingReport struct {
+	AsOf         time.Time          `json:"as_of"`
+	Rows         []AgingReportRow   `json:"rows"`
+	Totals       []AgingReportTotal `json:"totals"`
+	BaseCurrency string             `json:"base_currency"`
+	BaseTotal    AgingBuckets       `json:"base_total"`    // итог в базовой валюте по курсу на дату счета
+	MissingRates []string           `json:"missing_rates"` // валюты счетов без курса, не вошедших в BaseTotal
+}
@@ -33,50 +33,87 @@ type Pagination struct {
 
 // DashboardMetrics метрики для дашборда
 type DashboardMetrics struct {
-	TotalDebt        float64           `json:"total_debt"`
-	OverdueAmount    float64           `json:"overdue_amount"`
-	PaidThisMonth    float64           `json:"paid_this_month"`
-	PaidLastMonth    float64           `json:"paid_last_month"`
-	TotalInvoices    int               `json:"total_invoices"`
-	OverdueInvoices  int               `json:"overdue_invoices"`
-	PendingInvoices  int               `json:"pending_invoices"`
-	ActiveBrokers    int               `json:"active_brokers"`
-	TotalLoads       int               `json:"total_loads"`
-	CompletedLoads   int               `json:"completed_loads"`
-	TopDebtors       []TopDebtor       `json:"top_debtors"`
-	PaymentsByDay    []PaymentByDay    `json:"payments_by_day"`
-	InvoicesByStatus []InvoiceByStatus `json:"invoices_by_status"`
-	RevenueByMonth   []RevenueByMonth  `json:"revenue_by_month"`
+	// Сводные суммы в базовой валюте по курсу на дату счета или платежа, разбивка по валютам - в Currencies
+	BaseCurrency        string            `json:"base_currency"`
+	TotalDebt           Amount            `json:"total_debt"`
+	OverdueAmount       Amount            `json:"overdue_amount"`
+	PaidThisMonth       Amount            `json:"paid_this_month"`
+	PaidLastMonth       Amount            `json:"paid_last_month"`
+	RealizedFXThisMonth Amount            `json:"realized_fx_this_month"` // курсовая разница по платежам месяца
+	MissingRates        []string          `json:"missing_rates"`          // валюты, суммы в которых не пересчитаны из-за отсутствия курса
+	TotalInvoices       int               `json:"total_invoices"`
+	OverdueInvoices     int               `json:"overdue_invoices"`
+	PendingInvoices     int               `json:"pending_invoices"`
+	ActiveBrokers       int               `json:"active_brokers"`
+	TotalLoads          int               `json:"total_loads"`
+	CompletedLoads      int               `json:"completed_loads"`
+	Currencies          []CurrencyMetrics `json:"currencies"`
+	TopDebtors          []TopDebtor       `json:"top_debtors"`
+	PaymentsByDay       []PaymentByDay    `json:"payments_by_day"`
+	InvoicesByStatus    []InvoiceByStatus `json:"invoices_by_status"`
+	RevenueByMonth      []RevenueByMonth  `json:"revenue_by_month"`
+}
+
+// CurrencyMetrics денежные метрики дашборда в одной валюте
+type CurrencyMetrics struct {
+	Currency      string `json:"currency"`
+	TotalDebt     Amount `json:"total_debt"`
+	OverdueAmount Amount `json:"overdue_amount"`
+	PaidThisMonth Amount `json:"paid_this_month"`
+	PaidLastMonth Amount `json:"paid_last_month"`
+}
+
+// CurrencyAmount сумма и количество документов в одной валюте
+type CurrencyAmount struct {
+	Currency     string `json:"currency" bson:"currency"`
+	Amount       Amount `json:"amount" bson:"amount"`
+	BaseAmount   Amount `json:"base_amount" bson:"base_amount"` // в базовой валюте
+	Count        int    `json:"count" bson:"count"`
+	MissingRates int    `json:"missing_rates" bson:"missing_rates"` // документы без курса, не вошедшие в BaseAmount
 }
 
 // TopDebtor топ должники
 type TopDebtor struct {
-	BrokerID      primitive.ObjectID `json:"broker_id"`
-	CompanyName   string             `json:"company_name"`
-	TotalDebt     float64            `json:"total_debt"`
-	OverdueAmount float64            `json:"overdue_amount"`
-	Currency      string             `json:"currency"`
+	BrokerID      primitive.ObjectID `json:"broker_id" bson:"broker_id"`
+	CompanyName   string             `json:"company_name" bson:"company_name"`
+	TotalDebt     Amount             `json:"total_debt" bson:"total_debt"`
+	OverdueAmount Amount             `json:"overdue_amount" bson:"overdue_amount"`
+	BaseTotalDebt Amount             `json:"base_total_debt" bson:"base_total_debt"`
+	Currency      string             `json:"currency" bson:"currency"`
 }
 
 // PaymentByDay платежи по дням
 type PaymentByDay struct {
-	Date   time.Time `json:"date"`
-	Amount float64   `json:"amount"`
-	Count  int       `json:"count"`
+	Date       time.Time `json:"date" bson:"date"`
+	Currency   string    `json:"currency" bson:"currency"`
+	Amount     Amount    `json:"amount" bson:"amount"`
+	BaseAmount Amount    `json:"base_amount" bson:"base_amount"`
+	Count      int       `json:"count" bson:"count"`
 }
 
 // InvoiceByStatus счета по статусам
 type InvoiceByStatus struct {
-	Status string  `json:"status"`
-	Count  int     `json:"count"`
-	Amount float64 `json:"amount"`
+	Status     string `json:"status" bson:"status"`
+	Currency   string `json:"currency" bson:"currency"`
+	Count      int    `json:"count" bson:"count"`
+	Amount     Amount `json:"amount" bson:"amount"`
+	BaseAmount Amount `json:"base_amount" bson:"base_amount"`
 }
 
 // RevenueByMonth доходы по месяцам
 type RevenueByMonth struct {
-	Month   string  `json:"month"`
-	Revenue float64 `json:"revenue"`
-	Count   int     `json:"count"`
+	Month       string `json:"month" bson:"month"` // YYYY-MM
+	Currency    string `json:"currency" bson:"currency"`
+	Revenue     Amount `json:"revenue" bson:"revenue"`
+	BaseRevenue Amount `json:"base_revenue" bson:"base_revenue"`
+	Count       int    `json:"count" bson:"count"`
+}
+
+// DashboardPeriod параметры периода для графиков дашборда
+type DashboardPeriod struct {
+	Days       int `json:"days"`        // платежи по дням за последние N дней
+	Months     int `json:"months"`      // доходы по месяцам за последние N месяцев
+	TopDebtors int `json:"top_debtors"` // количество брокеров в топе должников
 }
 
 // SearchRequest запрос поиска
@@ -88,9 +125,15 @@ type SearchRequest struct {
 	Sort    map[string]int         `json:"sort"` // field: 1 for asc, -1 for desc
 }
 
+// Форматы экспорта
+const (
+	ExportFormatCSV  = "csv"
+	ExportFormatXLSX = "xlsx"
+)
+
 // ExportRequest запрос экспорта
 type ExportRequest struct {
-	Format   string                 `json:"format"` // excel, pdf
+	Format   string                 `json:"format"` // csv, xlsx
 	Type     string                 `json:"type"`   // invoices, payments, brokers
 	Filters  map[string]interface{} `json:"filters"`
 	DateFrom *time.Time             `json:"date_from"`
@@ -0,0 +1,230 @@
+package repository
+
+import (
+	"billing-system/internal/models"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// bankTransactionRepository реализация BankTransactionRepository
+type bankTransactionRepository struct {
+	collection *mongo.Collection
+}
+
+// NewBankTransactionRepository создает новый BankTransactionRepository
+func NewBankTransactionRepository(db *Database) BankTransactionRepository {
+	collection := db.GetCollection("bank_transactions")
+
+	// Очередь разбора выбирается по статусу; уникальный индекс отпечатка, отсеивающий повторную загрузку выписки,
+	// создается миграцией 0006_unique_indexes
+	indexModels := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "booking_date", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "import_id", Value: 1}},
+		},
+	}
+
+	collection.Indexes().CreateMany(context.Background(), indexModels)
+
+	return &bankTransactionRepository{collection: collection}
+}
+
+// Insert сохраняет операцию; возвращает false, если операция с таким отпечатком уже загружена
+func (r *bankTransactionRepository) Insert(ctx context.Context, transaction *models.BankTransaction) (bool, error) {
+	transaction.ID = primitive.NewObjectID()
+	transaction.CreatedAt = time.Now()
+
+	_, err := r.collection.InsertOne(ctx, transaction)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetByID получает операцию по ID
+func (r *bankTransactionRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.BankTransaction, error) {
+	cursor, err := r.collection.Aggregate(ctx, r.detailsPipeline(bson.M{"_id": id}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			return nil, err
+		}
+		return nil, mongo.ErrNoDocuments
+	}
+
+	var transaction models.BankTransaction
+	if err := cursor.Decode(&transaction); err != nil {
+		return nil, err
+	}
+
+	return &transaction, nil
+}
+
+// GetAll получает операции с фильтрацией и пагинацией, новые по дате проводки первыми
+func (r *bankTransactionRepository) GetAll(ctx context.Context, filter *models.BankTransactionFilter, limit, offset int) ([]*models.BankTransaction, int64, error) {
+	mongoFilter := r.buildFilter(filter)
+
+	total, err := r.collection.CountDocuments(ctx, mongoFilter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pipeline := append(r.detailsPipeline(mongoFilter),
+		bson.M{"$sort": bson.D{{Key: "booking_date", Value: -1}, {Key: "_id", Value: 1}}},
+		bson.M{"$skip": offset},
+		bson.M{"$limit": limit},
+	)
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	transactions := []*models.BankTransaction{}
+	if err = cursor.All(ctx, &transactions); err != nil {
+		return nil, 0, err
+	}
+
+	return transactions, total, nil
+}
+
+// Resolve фиксирует итог разбора операции: статус, брокера, разнесение, платеж или причину отказа.
+// Возвращает false, если операция уже разобрана.
+func (r *bankTransactionRepository) Resolve(ctx context.Context, transaction *models.BankTransaction) (bool, error) {
+	set := bson.M{
+		"status":      transaction.Status,
+		"allocations": transaction.Allocations,
+		"reviewed_by": transaction.ReviewedBy,
+		"reviewed_at": transaction.ReviewedAt,
+	}
+	if !transaction.BrokerID.IsZero() {
+		set["broker_id"] = transaction.BrokerID
+	}
+	if !transaction.PaymentID.IsZero() {
+		set["payment_id"] = transaction.PaymentID
+	}
+	if transaction.RejectReason != "" {
+		set["reject_reason"] = transaction.RejectReason
+	}
+
+	filter := bson.M{
+		"_id":    transaction.ID,
+		"status": bson.M{"$in": []string{models.BankTransactionStatusUnmatched, models.BankTransactionStatusMatched}},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// detailsPipeline выбирает операции по фильтру с именем брокера и номерами счетов предложенного разнесения
+func (r *bankTransactionRepository) detailsPipeline(filter bson.M) []bson.M {
+	return []bson.M{
+		{
+			"$match": filter,
+		},
+		// JOIN с brokers для получения имени брокера
+		{
+			"$lookup": bson.M{
+				"from":         "brokers",
+				"localField":   "broker_id",
+				"foreignField": "_id",
+				"as":           "broker",
+			},
+		},
+		// JOIN с invoices для получения номеров счетов разнесений
+		{
+			"$lookup": bson.M{
+				"from": "invoices",
+				"let":  bson.M{"invoice_ids": bson.M{"$ifNull": []interface{}{"$allocations.invoice_id", []interface{}{}}}},
+				"pipeline": []bson.M{
+					{
+						"$match": bson.M{
+							"$expr": bson.M{
+								"$in": []interface{}{"$_id", "$$invoice_ids"},
+							},
+						},
+					},
+					{
+						"$project": bson.M{"invoice_number": 1},
+					},
+				},
+				"as": "invoices",
+			},
+		},
+		{
+			"$addFields": bson.M{
+				"broker_name": bson.M{"$arrayElemAt": []interface{}{"$broker.company_name", 0}},
+				"allocations": bson.M{
+					"$map": bson.M{
+						"input": bson.M{"$ifNull": []interface{}{"$allocations", []interface{}{}}},
+						"as":    "allocation",
+						"in": bson.M{
+							"$mergeObjects": []interface{}{
+								"$$allocation",
+								bson.M{"invoice_number": allocationInvoiceNumberExpr()},
+							},
+						},
+					},
+				},
+			},
+		},
+		// Удаляем временные поля
+		{
+			"$project": bson.M{
+				"broker":   0,
+				"invoices": 0,
+			},
+		},
+	}
+}
+
+// buildFilter строит MongoDB фильтр из структуры фильтра
+func (r *bankTransactionRepository) buildFilter(filter *models.BankTransactionFilter) bson.M {
+	mongoFilter := bson.M{}
+
+	if filter == nil {
+		return mongoFilter
+	}
+
+	if filter.Status != "" {
+		mongoFilter["status"] = filter.Status
+	}
+
+	if !filter.ImportID.IsZero() {
+		mongoFilter["import_id"] = filter.ImportID
+	}
+
+	if !filter.BrokerID.IsZero() {
+		mongoFilter["broker_id"] = filter.BrokerID
+	}
+
+	if filter.DateFrom != nil || filter.DateTo != nil {
+		dateFilter := bson.M{}
+		if filter.DateFrom != nil {
+			dateFilter["$gte"] = *filter.DateFrom
+		}
+		if filter.DateTo != nil {
+			dateFilter["$lte"] = *filter.DateTo
+		}
+		mongoFilter["booking_date"] = dateFilter
+	}
+
+	return mongoFilter
+}
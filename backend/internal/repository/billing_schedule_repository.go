@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"billing-system/internal/models"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// billingScheduleRepository реализация BillingScheduleRepository
+type billingScheduleRepository struct {
+	collection *mongo.Collection
+}
+
+// NewBillingScheduleRepository создает новый BillingScheduleRepository
+func NewBillingScheduleRepository(db *Database) BillingScheduleRepository {
+	collection := db.GetCollection("billing_schedules")
+
+	// Фоновая задача выбирает активные расписания с наступившим периодом
+	indexModels := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_run_date", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "broker_id", Value: 1}},
+		},
+	}
+
+	collection.Indexes().CreateMany(context.Background(), indexModels)
+
+	return &billingScheduleRepository{collection: collection}
+}
+
+// Create создает новое расписание
+func (r *billingScheduleRepository) Create(ctx context.Context, schedule *models.BillingSchedule) error {
+	schedule.ID = primitive.NewObjectID()
+	schedule.CreatedAt = time.Now()
+	schedule.UpdatedAt = schedule.CreatedAt
+
+	_, err := r.collection.InsertOne(ctx, schedule)
+	return err
+}
+
+// GetByID получает расписание по ID
+func (r *billingScheduleRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.BillingSchedule, error) {
+	var schedule models.BillingSchedule
+	if err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&schedule); err != nil {
+		return nil, err
+	}
+
+	return &schedule, nil
+}
+
+// GetAll получает расписания с фильтрацией и пагинацией
+func (r *billingScheduleRepository) GetAll(ctx context.Context, filter *models.BillingScheduleFilter, limit, offset int) ([]*models.BillingSchedule, int64, error) {
+	mongoFilter := bson.M{}
+	if filter != nil {
+		if !filter.BrokerID.IsZero() {
+			mongoFilter["broker_id"] = filter.BrokerID
+		}
+		if filter.Status != "" {
+			mongoFilter["status"] = filter.Status
+		}
+	}
+
+	total, err := r.collection.CountDocuments(ctx, mongoFilter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetLimit(int64(limit)).
+		SetSkip(int64(offset)).
+		SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := r.collection.Find(ctx, mongoFilter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	schedules := []*models.BillingSchedule{}
+	if err = cursor.All(ctx, &schedules); err != nil {
+		return nil, 0, err
+	}
+
+	return schedules, total, nil
+}
+
+// Update обновляет условия расписания, его статус и дату следующего счета
+func (r *billingScheduleRepository) Update(ctx context.Context, id primitive.ObjectID, schedule *models.BillingSchedule) error {
+	schedule.UpdatedAt = time.Now()
+
+	update := bson.M{
+		"$set": bson.M{
+			"broker_id":     schedule.BrokerID,
+			"name":          schedule.Name,
+			"amount":        schedule.Amount,
+			"currency":      schedule.Currency,
+			"interval":      schedule.Interval,
+			"start_date":    schedule.StartDate,
+			"end_date":      schedule.EndDate,
+			"line_items":    schedule.LineItems,
+			"payment_terms": schedule.PaymentTerms,
+			"description":   schedule.Description,
+			"notes":         schedule.Notes,
+			"status":        schedule.Status,
+			"next_run_date": schedule.NextRunDate,
+			"updated_at":    schedule.UpdatedAt,
+		},
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// Delete удаляет расписание
+func (r *billingScheduleRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// SetStatus переводит расписание из статуса fromStatus в status с новым последним периодом и датой следующего счета.
+// Возвращает false, если статус расписания уже изменился.
+func (r *billingScheduleRepository) SetStatus(ctx context.Context, id primitive.ObjectID, fromStatus, status string, lastPeriod int, nextRunDate *time.Time) (bool, error) {
+	update := bson.M{
+		"$set": bson.M{
+			"status":        status,
+			"last_period":   lastPeriod,
+			"next_run_date": nextRunDate,
+			"updated_at":    time.Now(),
+		},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": id, "status": fromStatus}, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// GetDue получает активные расписания, следующий период которых начался не позже asOf
+func (r *billingScheduleRepository) GetDue(ctx context.Context, asOf time.Time) ([]*models.BillingSchedule, error) {
+	filter := bson.M{
+		"status":        models.BillingScheduleStatusActive,
+		"next_run_date": bson.M{"$lte": asOf},
+	}
+	opts := options.Find().SetSort(bson.M{"next_run_date": 1})
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	schedules := []*models.BillingSchedule{}
+	if err = cursor.All(ctx, &schedules); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// Advance отмечает период period выставленным, если предыдущий период был последним, а расписание активно.
+// Возвращает false, если расписание приостановлено или период уже отмечен.
+func (r *billingScheduleRepository) Advance(ctx context.Context, id primitive.ObjectID, period int, nextRunDate *time.Time, status string) (bool, error) {
+	filter := bson.M{
+		"_id":         id,
+		"status":      models.BillingScheduleStatusActive,
+		"last_period": period - 1,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":        status,
+			"last_period":   period,
+			"next_run_date": nextRunDate,
+		},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
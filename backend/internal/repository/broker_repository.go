@@ -3,6 +3,7 @@ package repository
 import (
 	"billing-system/internal/models"
 	"context"
+	"math"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -14,12 +15,16 @@ import (
 // brokerRepository реализация BrokerRepository
 type brokerRepository struct {
 	collection *mongo.Collection
+	invoices   *mongo.Collection
+	payments   *mongo.Collection
 }
 
 // NewBrokerRepository создает новый BrokerRepository
 func NewBrokerRepository(db *Database) BrokerRepository {
 	return &brokerRepository{
 		collection: db.GetCollection("brokers"),
+		invoices:   db.GetCollection("invoices"),
+		payments:   db.GetCollection("payments"),
 	}
 }
 
@@ -30,7 +35,7 @@ func (r *brokerRepository) Create(ctx context.Context, broker *models.Broker) er
 	broker.UpdatedAt = time.Now()
 
 	if broker.Status == "" {
-		broker.Status = "active"
+		broker.Status = models.BrokerStatusActive
 	}
 
 	_, err := r.collection.InsertOne(ctx, broker)
@@ -47,6 +52,23 @@ func (r *brokerRepository) GetByID(ctx context.Context, id primitive.ObjectID) (
 	return &broker, nil
 }
 
+// Lock блокирует брокера в текущей транзакции и возвращает его.
+// Проверки кредитного лимита одного брокера в конкурентных транзакциях завершаются конфликтом записи и повторяются.
+func (r *brokerRepository) Lock(ctx context.Context, id primitive.ObjectID) (*models.Broker, error) {
+	update := bson.M{
+		"$currentDate": bson.M{"locked_at": true},
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var broker models.Broker
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&broker)
+	if err != nil {
+		return nil, err
+	}
+	return &broker, nil
+}
+
 // GetAll получает всех брокеров с пагинацией
 func (r *brokerRepository) GetAll(ctx context.Context, limit, offset int) ([]*models.Broker, int64, error) {
 	// Подсчет общего количества
@@ -75,22 +97,57 @@ func (r *brokerRepository) GetAll(ctx context.Context, limit, offset int) ([]*mo
 	return brokers, total, nil
 }
 
+// Iterate обходит всех брокеров через курсор, без ограничения по количеству
+func (r *brokerRepository) Iterate(ctx context.Context, fn func(*models.Broker) error) error {
+	cursor, err := r.OpenCursor(ctx)
+	if err != nil {
+		return err
+	}
+	return cursor.Each(ctx, fn)
+}
+
+// OpenCursor открывает выборку всех брокеров по названию компании
+func (r *brokerRepository) OpenCursor(ctx context.Context) (*Cursor[models.Broker], error) {
+	opts := options.Find().SetSort(bson.M{"company_name": 1})
+
+	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newCursor[models.Broker](cursor, nil), nil
+}
+
 // Update обновляет брокера
 func (r *brokerRepository) Update(ctx context.Context, id primitive.ObjectID, broker *models.Broker) error {
 	broker.UpdatedAt = time.Now()
 
 	update := bson.M{
 		"$set": bson.M{
-			"company_name":      broker.CompanyName,
-			"contact_person":    broker.ContactPerson,
-			"email":             broker.Email,
-			"phone":             broker.Phone,
-			"address":           broker.Address,
-			"credit_limit":      broker.CreditLimit,
-			"reliability_score": broker.ReliabilityScore,
-			"status":            broker.Status,
-			"notes":             broker.Notes,
-			"updated_at":        broker.UpdatedAt,
+			"company_name":          broker.CompanyName,
+			"contact_person":        broker.ContactPerson,
+			"email":                 broker.Email,
+			"phone":                 broker.Phone,
+			"address":               broker.Address,
+			"credit_limit":          broker.CreditLimit,
+			"credit_limit_currency": broker.CreditLimitCurrency,
+			"payment_terms":         broker.PaymentTerms,
+			"late_fee_policy":       broker.LateFeePolicy,
+			"reliability_score":     broker.ReliabilityScore,
+			"notes":                 broker.Notes,
+			"updated_at":            broker.UpdatedAt,
+		},
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// UpdateStatus обновляет статус брокера
+func (r *brokerRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, status string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"status":     status,
+			"updated_at": time.Now(),
 		},
 	}
 
@@ -98,6 +155,42 @@ func (r *brokerRepository) Update(ctx context.Context, id primitive.ObjectID, br
 	return err
 }
 
+// ReleaseCreditHold снимает кредитный стоп и возвращает брокера в статус active.
+// Возвращает false, если брокер не на кредитном стопе.
+func (r *brokerRepository) ReleaseCreditHold(ctx context.Context, id primitive.ObjectID, release *models.CreditHoldRelease) (bool, error) {
+	filter := bson.M{"_id": id, "status": models.BrokerStatusCreditHold}
+	update := bson.M{
+		"$set": bson.M{
+			"status":              models.BrokerStatusActive,
+			"credit_hold_release": release,
+			"updated_at":          time.Now(),
+		},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// SetCreditAlert отмечает отправку предупреждения о приближении к кредитному лимиту (sentAt) или снимает отметку (nil).
+// Возвращает false, если отметка уже была в нужном состоянии, чтобы предупреждение не отправлялось повторно.
+func (r *brokerRepository) SetCreditAlert(ctx context.Context, id primitive.ObjectID, sentAt *time.Time) (bool, error) {
+	filter := bson.M{"_id": id, "credit_alert_at": nil}
+	update := bson.M{"$set": bson.M{"credit_alert_at": sentAt}}
+	if sentAt == nil {
+		filter = bson.M{"_id": id, "credit_alert_at": bson.M{"$ne": nil}}
+		update = bson.M{"$unset": bson.M{"credit_alert_at": ""}}
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
 // Delete удаляет брокера
 func (r *brokerRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
 	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
@@ -143,17 +236,288 @@ func (r *brokerRepository) Search(ctx context.Context, query string, limit, offs
 
 // GetStats получает статистику по брокеру
 func (r *brokerRepository) GetStats(ctx context.Context, brokerID primitive.ObjectID) (*models.BrokerStats, error) {
-	// Эта функция будет реализована через агрегацию с другими коллекциями
-	// Сейчас возвращаем базовую структуру
 	stats := &models.BrokerStats{
-		BrokerID:        brokerID,
-		TotalDebt:       0,
-		OverdueAmount:   0,
-		PaidThisMonth:   0,
-		InvoicesCount:   0,
-		OverdueInvoices: 0,
-		LastPayment:     nil,
+		BrokerID:   brokerID,
+		Currencies: []models.BrokerCurrencyStats{},
+	}
+
+	if err := r.collectInvoiceStats(ctx, stats); err != nil {
+		return nil, err
+	}
+
+	if err := r.collectPaymentStats(ctx, stats); err != nil {
+		return nil, err
 	}
 
 	return stats, nil
 }
+
+// collectInvoiceStats считает задолженность и платежную дисциплину по счетам брокера
+func (r *brokerRepository) collectInvoiceStats(ctx context.Context, stats *models.BrokerStats) error {
+	now := time.Now()
+	isOpen := bson.M{"$in": []interface{}{"$status", []string{
+		models.InvoiceStatusIssued,
+		models.InvoiceStatusPartial,
+		models.InvoiceStatusOverdue,
+	}}}
+	isOverdue := bson.M{"$and": []interface{}{
+		isOpen,
+		bson.M{"$lt": []interface{}{"$due_date", now}},
+	}}
+	remaining := bson.M{"$max": []interface{}{remainingAmountExpr(), 0}}
+
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{"broker_id": stats.BrokerID},
+		},
+		{
+			"$facet": bson.M{
+				"by_currency": []bson.M{
+					{
+						"$group": bson.M{
+							"_id":            "$currency",
+							"invoices_count": bson.M{"$sum": 1},
+							"total_debt": bson.M{"$sum": bson.M{
+								"$cond": []interface{}{isOpen, remaining, 0},
+							}},
+							"overdue_amount": bson.M{"$sum": bson.M{
+								"$cond": []interface{}{isOverdue, remaining, 0},
+							}},
+							"overdue_invoices": bson.M{"$sum": bson.M{
+								"$cond": []interface{}{isOverdue, 1, 0},
+							}},
+						},
+					},
+					{
+						"$project": bson.M{
+							"_id":              0,
+							"currency":         "$_id",
+							"invoices_count":   1,
+							"total_debt":       1,
+							"overdue_amount":   1,
+							"overdue_invoices": 1,
+						},
+					},
+					{
+						"$sort": bson.M{"currency": 1},
+					},
+				},
+				// Дни до оплаты считаем от даты выставления до даты полной оплаты
+				"discipline": []bson.M{
+					{
+						"$match": bson.M{
+							"status":  models.InvoiceStatusPaid,
+							"paid_at": bson.M{"$ne": nil},
+						},
+					},
+					{
+						"$group": bson.M{
+							"_id": nil,
+							"avg_days_to_pay": bson.M{"$avg": bson.M{
+								"$divide": []interface{}{
+									bson.M{"$subtract": []interface{}{"$paid_at", "$created_at"}},
+									24 * 60 * 60 * 1000,
+								},
+							}},
+							"paid_invoices_count": bson.M{"$sum": 1},
+							"on_time_invoices_count": bson.M{"$sum": bson.M{
+								"$cond": []interface{}{
+									bson.M{"$lte": []interface{}{"$paid_at", "$due_date"}},
+									1,
+									0,
+								},
+							}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	cursor, err := r.invoices.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		ByCurrency []models.BrokerCurrencyStats `bson:"by_currency"`
+		Discipline []struct {
+			AvgDaysToPay        float64 `bson:"avg_days_to_pay"`
+			PaidInvoicesCount   int     `bson:"paid_invoices_count"`
+			OnTimeInvoicesCount int     `bson:"on_time_invoices_count"`
+		} `bson:"discipline"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	for _, currencyStats := range result.ByCurrency {
+		stats.TotalDebt += currencyStats.TotalDebt
+		stats.OverdueAmount += currencyStats.OverdueAmount
+		stats.InvoicesCount += currencyStats.InvoicesCount
+		stats.OverdueInvoices += currencyStats.OverdueInvoices
+		stats.Currencies = append(stats.Currencies, currencyStats)
+	}
+
+	if len(result.Discipline) > 0 {
+		discipline := result.Discipline[0]
+		stats.AvgDaysToPay = math.Round(discipline.AvgDaysToPay*10) / 10
+		stats.PaidInvoicesCount = discipline.PaidInvoicesCount
+		stats.OnTimeInvoicesCount = discipline.OnTimeInvoicesCount
+		if discipline.PaidInvoicesCount > 0 {
+			rate := float64(discipline.OnTimeInvoicesCount) / float64(discipline.PaidInvoicesCount) * 100
+			stats.OnTimePaymentRate = math.Round(rate*10) / 10
+		}
+	}
+
+	return nil
+}
+
+// collectPaymentStats считает оплаты брокера за текущий месяц, дату последнего платежа и неразнесенный кредит
+func (r *brokerRepository) collectPaymentStats(ctx context.Context, stats *models.BrokerStats) error {
+	now := time.Now()
+	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{"broker_id": stats.BrokerID},
+		},
+		{
+			"$facet": bson.M{
+				"this_month": []bson.M{
+					{
+						"$match": bson.M{"payment_date": bson.M{"$gte": startOfMonth}},
+					},
+					{
+						"$group": bson.M{
+							"_id":    "$currency",
+							"amount": bson.M{"$sum": "$amount"},
+						},
+					},
+				},
+				"last_payment": []bson.M{
+					{
+						"$match": bson.M{"amount": bson.M{"$gt": 0}},
+					},
+					{
+						"$group": bson.M{
+							"_id":          nil,
+							"payment_date": bson.M{"$max": "$payment_date"},
+						},
+					},
+				},
+				"unapplied": []bson.M{
+					{
+						"$match": bson.M{"unapplied_amount": bson.M{"$ne": 0}},
+					},
+					{
+						"$group": bson.M{
+							"_id":    "$currency",
+							"amount": bson.M{"$sum": "$unapplied_amount"},
+						},
+					},
+					// Сторно гасит неразнесенный остаток исходного платежа
+					{
+						"$match": bson.M{"amount": bson.M{"$ne": 0}},
+					},
+				},
+			},
+		},
+	}
+
+	cursor, err := r.payments.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		ThisMonth []struct {
+			Currency string        `bson:"_id"`
+			Amount   models.Amount `bson:"amount"`
+		} `bson:"this_month"`
+		LastPayment []struct {
+			PaymentDate time.Time `bson:"payment_date"`
+		} `bson:"last_payment"`
+		Unapplied []struct {
+			Currency string        `bson:"_id"`
+			Amount   models.Amount `bson:"amount"`
+		} `bson:"unapplied"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	for _, paid := range result.ThisMonth {
+		stats.PaidThisMonth += paid.Amount
+		brokerCurrencyStats(stats, paid.Currency).PaidThisMonth = paid.Amount
+	}
+
+	for _, credit := range result.Unapplied {
+		stats.UnappliedCredit += credit.Amount
+		brokerCurrencyStats(stats, credit.Currency).UnappliedCredit = credit.Amount
+	}
+
+	if len(result.LastPayment) > 0 && !result.LastPayment[0].PaymentDate.IsZero() {
+		lastPayment := result.LastPayment[0].PaymentDate
+		stats.LastPayment = &lastPayment
+	}
+
+	return nil
+}
+
+// brokerCurrencyStats возвращает статистику брокера в валюте currency, добавляя ее при отсутствии
+func brokerCurrencyStats(stats *models.BrokerStats, currency string) *models.BrokerCurrencyStats {
+	for i := range stats.Currencies {
+		if stats.Currencies[i].Currency == currency {
+			return &stats.Currencies[i]
+		}
+	}
+
+	stats.Currencies = append(stats.Currencies, models.BrokerCurrencyStats{Currency: currency})
+	return &stats.Currencies[len(stats.Currencies)-1]
+}
+
+// CountByStatus получает количество брокеров по статусам
+func (r *brokerRepository) CountByStatus(ctx context.Context) (map[string]int64, error) {
+	pipeline := []bson.M{
+		{
+			"$group": bson.M{
+				"_id":   "$status",
+				"count": bson.M{"$sum": 1},
+			},
+		},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Status string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(results))
+	for _, result := range results {
+		counts[result.Status] = result.Count
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"billing-system/config"
+	"billing-system/internal/models"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// creditNoteRepository реализация CreditNoteRepository
+type creditNoteRepository struct {
+	collection *mongo.Collection
+	numbers    *sequence
+}
+
+// NewCreditNoteRepository создает новый CreditNoteRepository с форматом номеров кредит-нот numberFormat
+func NewCreditNoteRepository(db *Database, numberFormat config.NumberFormat) CreditNoteRepository {
+	collection := db.GetCollection("credit_notes")
+
+	// Кредит-ноты выбираются по счету; уникальный индекс номера создается миграцией 0006_unique_indexes
+	indexModels := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "invoice_id", Value: 1}},
+		},
+	}
+
+	collection.Indexes().CreateMany(context.Background(), indexModels)
+
+	return &creditNoteRepository{
+		collection: collection,
+		numbers:    newSequence(db, SequenceCreditNote, numberFormat),
+	}
+}
+
+// Create создает новую кредит-ноту
+func (r *creditNoteRepository) Create(ctx context.Context, note *models.CreditNote) error {
+	note.ID = primitive.NewObjectID()
+	note.CreatedAt = time.Now()
+
+	// Генерируем номер кредит-ноты если не указан
+	if note.CreditNoteNumber == "" {
+		number, err := r.GenerateCreditNoteNumber(ctx)
+		if err != nil {
+			return err
+		}
+		note.CreditNoteNumber = number
+	}
+
+	_, err := r.collection.InsertOne(ctx, note)
+	return err
+}
+
+// GetByID получает кредит-ноту по ID
+func (r *creditNoteRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.CreditNote, error) {
+	var note models.CreditNote
+	if err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&note); err != nil {
+		return nil, err
+	}
+
+	return &note, nil
+}
+
+// GetByInvoice получает кредит-ноты по счету в порядке выпуска
+func (r *creditNoteRepository) GetByInvoice(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.CreditNote, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+
+	cursor, err := r.collection.Find(ctx, bson.M{"invoice_id": invoiceID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	notes := []*models.CreditNote{}
+	if err = cursor.All(ctx, &notes); err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
+
+// GetTotalByInvoice получает общую сумму кредит-нот по счету
+func (r *creditNoteRepository) GetTotalByInvoice(ctx context.Context, invoiceID primitive.ObjectID) (models.Amount, error) {
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{"invoice_id": invoiceID},
+		},
+		{
+			"$group": bson.M{
+				"_id":   nil,
+				"total": bson.M{"$sum": "$amount"},
+			},
+		},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Total models.Amount `bson:"total"`
+	}
+
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, err
+		}
+	}
+
+	return result.Total, nil
+}
+
+// CountByInvoice получает количество кредит-нот по счету
+func (r *creditNoteRepository) CountByInvoice(ctx context.Context, invoiceID primitive.ObjectID) (int64, error) {
+	return r.collection.CountDocuments(ctx, bson.M{"invoice_id": invoiceID})
+}
+
+// GenerateCreditNoteNumber выдает следующий номер кредит-ноты из собственной последовательности
+func (r *creditNoteRepository) GenerateCreditNoteNumber(ctx context.Context) (string, error) {
+	return r.numbers.Next(ctx, time.Now())
+}
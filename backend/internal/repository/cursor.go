@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Cursor открытая выборка документов. Запрос уже выполнен, поэтому его ошибки возвращаются
+// при открытии, а не при обходе.
+type Cursor[T any] struct {
+	cursor  *mongo.Cursor
+	prepare func(*T) // дополняет документ вычисляемыми полями
+}
+
+// newCursor оборачивает курсор MongoDB
+func newCursor[T any](cursor *mongo.Cursor, prepare func(*T)) *Cursor[T] {
+	return &Cursor[T]{cursor: cursor, prepare: prepare}
+}
+
+// Each вызывает fn для каждого документа выборки и закрывает курсор
+func (c *Cursor[T]) Each(ctx context.Context, fn func(*T) error) error {
+	defer c.cursor.Close(ctx)
+
+	for c.cursor.Next(ctx) {
+		var item T
+		if err := c.cursor.Decode(&item); err != nil {
+			return err
+		}
+		if c.prepare != nil {
+			c.prepare(&item)
+		}
+
+		if err := fn(&item); err != nil {
+			return err
+		}
+	}
+
+	return c.cursor.Err()
+}
+
+// Close закрывает курсор без обхода
+func (c *Cursor[T]) Close(ctx context.Context) error {
+	return c.cursor.Close(ctx)
+}
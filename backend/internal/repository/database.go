@@ -1,7 +1,9 @@
 package repository
 
 import (
+	"billing-system/config"
 	"context"
+	"errors"
 	"log"
 	"time"
 
@@ -36,6 +38,12 @@ func NewDatabase(mongoURI, dbName string) (*Database, error) {
 
 	log.Printf("Connected to MongoDB: %s", dbName)
 
+	// Блокировки счетов и пересчет балансов корректны только в транзакциях
+	if !supportsTransactions(ctx, client) {
+		client.Disconnect(ctx)
+		return nil, errors.New("MongoDB must run as a replica set or sharded cluster: multi-document transactions are required")
+	}
+
 	return &Database{
 		Client: client,
 		DB:     client.Database(dbName),
@@ -57,18 +65,32 @@ func (d *Database) GetCollection(name string) *mongo.Collection {
 
 // Repositories структура для всех репозиториев
 type Repositories struct {
-	Broker  BrokerRepository
-	Invoice InvoiceRepository
-	Payment PaymentRepository
-	Load    LoadRepository
+	Broker          BrokerRepository
+	Invoice         InvoiceRepository
+	Payment         PaymentRepository
+	CreditNote      CreditNoteRepository
+	BankTransaction BankTransactionRepository
+	BillingSchedule BillingScheduleRepository
+	Load            LoadRepository
+	Job             JobRepository
+	Dunning         DunningRepository
+	ExchangeRate    ExchangeRateRepository
+	UnitOfWork      UnitOfWork
 }
 
-// NewRepositories создает новые репозитории
-func NewRepositories(db *Database) *Repositories {
+// NewRepositories создает новые репозитории; numbering задает форматы номеров документов
+func NewRepositories(db *Database, numbering config.NumberingConfig) *Repositories {
 	return &Repositories{
-		Broker:  NewBrokerRepository(db),
-		Invoice: NewInvoiceRepository(db),
-		Payment: NewPaymentRepository(db),
-		Load:    NewLoadRepository(db),
+		Broker:          NewBrokerRepository(db),
+		Invoice:         NewInvoiceRepository(db, numbering.Invoice),
+		Payment:         NewPaymentRepository(db),
+		CreditNote:      NewCreditNoteRepository(db, numbering.CreditNote),
+		BankTransaction: NewBankTransactionRepository(db),
+		BillingSchedule: NewBillingScheduleRepository(db),
+		Load:            NewLoadRepository(db, numbering.Load),
+		Job:             NewJobRepository(db),
+		Dunning:         NewDunningRepository(db),
+		ExchangeRate:    NewExchangeRateRepository(db),
+		UnitOfWork:      db,
 	}
 }
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"billing-system/internal/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// dunningRepository реализация DunningRepository
+type dunningRepository struct {
+	collection *mongo.Collection
+}
+
+// NewDunningRepository создает новый DunningRepository
+// Уникальный индекс, по которому этап по счету отправляется один раз, создается миграцией 0006_unique_indexes
+func NewDunningRepository(db *Database) DunningRepository {
+	return &dunningRepository{collection: db.GetCollection("dunning_history")}
+}
+
+// Claim записывает этап по счету; возвращает false, если этап уже был отправлен
+func (r *dunningRepository) Claim(ctx context.Context, record *models.DunningRecord) (bool, error) {
+	record.ID = primitive.NewObjectID()
+
+	_, err := r.collection.InsertOne(ctx, record)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Delete удаляет запись об этапе, например если письмо не удалось отправить
+func (r *dunningRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// GetByInvoice получает историю напоминаний по счету
+func (r *dunningRepository) GetByInvoice(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.DunningRecord, error) {
+	opts := options.Find().SetSort(bson.M{"sent_at": 1})
+
+	cursor, err := r.collection.Find(ctx, bson.M{"invoice_id": invoiceID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []*models.DunningRecord
+	if err = cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// GetLastStageDays получает порог просрочки последнего отправленного этапа по каждому счету
+func (r *dunningRepository) GetLastStageDays(ctx context.Context, invoiceIDs []primitive.ObjectID) (map[primitive.ObjectID]int, error) {
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{"invoice_id": bson.M{"$in": invoiceIDs}},
+		},
+		{
+			"$group": bson.M{
+				"_id":        "$invoice_id",
+				"stage_days": bson.M{"$max": "$stage_days"},
+			},
+		},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		InvoiceID primitive.ObjectID `bson:"_id"`
+		StageDays int                `bson:"stage_days"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	lastStage := make(map[primitive.ObjectID]int, len(results))
+	for _, result := range results {
+		lastStage[result.InvoiceID] = result.StageDays
+	}
+
+	return lastStage, nil
+}
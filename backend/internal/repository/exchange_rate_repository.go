@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"billing-system/internal/models"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// exchangeRatesCollection коллекция дневных курсов валют
+const exchangeRatesCollection = "exchange_rates"
+
+// exchangeRateRepository реализация ExchangeRateRepository
+type exchangeRateRepository struct {
+	collection *mongo.Collection
+}
+
+// NewExchangeRateRepository создает новый ExchangeRateRepository
+// Уникальный индекс одного курса валюты на день, по которому ищется и последний курс на дату,
+// создается миграцией 0006_unique_indexes
+func NewExchangeRateRepository(db *Database) ExchangeRateRepository {
+	return &exchangeRateRepository{collection: db.GetCollection(exchangeRatesCollection)}
+}
+
+// Upsert сохраняет курс валюты на день, заменяя ранее загруженный
+func (r *exchangeRateRepository) Upsert(ctx context.Context, rate *models.ExchangeRate) error {
+	now := time.Now()
+	rate.UpdatedAt = now
+
+	filter := bson.M{
+		"currency":      rate.Currency,
+		"base_currency": rate.BaseCurrency,
+		"date":          rate.Date,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"rate":       rate.Rate,
+			"source":     rate.Source,
+			"updated_at": rate.UpdatedAt,
+		},
+		"$setOnInsert": bson.M{
+			"created_at": now,
+		},
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	return r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(rate)
+}
+
+// GetOnOrBefore получает последний курс валюты на дату date или раньше
+func (r *exchangeRateRepository) GetOnOrBefore(ctx context.Context, currency, baseCurrency string, date time.Time) (*models.ExchangeRate, error) {
+	filter := bson.M{
+		"currency":      currency,
+		"base_currency": baseCurrency,
+		"date":          bson.M{"$lte": date},
+	}
+	opts := options.FindOne().SetSort(bson.M{"date": -1})
+
+	var rate models.ExchangeRate
+	if err := r.collection.FindOne(ctx, filter, opts).Decode(&rate); err != nil {
+		return nil, err
+	}
+
+	return &rate, nil
+}
+
+// GetList получает курсы к базовой валюте по фильтру, новые первыми
+func (r *exchangeRateRepository) GetList(ctx context.Context, baseCurrency string, filter *models.ExchangeRateFilter) ([]*models.ExchangeRate, error) {
+	query := bson.M{"base_currency": baseCurrency}
+	if filter != nil {
+		if filter.Currency != "" {
+			query["currency"] = filter.Currency
+		}
+		if filter.DateFrom != nil || filter.DateTo != nil {
+			dateFilter := bson.M{}
+			if filter.DateFrom != nil {
+				dateFilter["$gte"] = *filter.DateFrom
+			}
+			if filter.DateTo != nil {
+				dateFilter["$lte"] = *filter.DateTo
+			}
+			query["date"] = dateFilter
+		}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "currency", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rates := []*models.ExchangeRate{}
+	if err = cursor.All(ctx, &rates); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+// baseRateStages добавляет документу поле base_rate — курс его валюты к базовой на дату dateField.
+// Курс, сохраненный в документе (exchange_rate), имеет приоритет, если он зафиксирован к той же базовой валюте
+// (exchange_rate_base); курсы к прежней базовой валюте и курсы документов без нее не используются.
+// Для базовой валюты курс равен 1.
+// Если курса нет, base_rate отсутствует и сумма в базовой валюте не считается.
+func baseRateStages(baseCurrency, dateField string) []bson.M {
+	return []bson.M{
+		{
+			"$lookup": bson.M{
+				"from": exchangeRatesCollection,
+				"let":  bson.M{"currency": "$currency", "date": "$" + dateField},
+				"pipeline": []bson.M{
+					{
+						"$match": bson.M{
+							"$expr": bson.M{
+								"$and": []interface{}{
+									bson.M{"$eq": []interface{}{"$currency", "$$currency"}},
+									bson.M{"$eq": []interface{}{"$base_currency", baseCurrency}},
+									bson.M{"$lte": []interface{}{"$date", "$$date"}},
+								},
+							},
+						},
+					},
+					{"$sort": bson.M{"date": -1}},
+					{"$limit": 1},
+					{"$project": bson.M{"_id": 0, "rate": 1}},
+				},
+				"as": "fx_rate",
+			},
+		},
+		{
+			"$addFields": bson.M{
+				"base_rate": bson.M{
+					"$cond": []interface{}{
+						bson.M{"$eq": []interface{}{"$currency", baseCurrency}},
+						1,
+						bson.M{
+							"$cond": []interface{}{
+								bson.M{"$and": []interface{}{
+									bson.M{"$eq": []interface{}{"$exchange_rate_base", baseCurrency}},
+									bson.M{"$gt": []interface{}{"$exchange_rate", 0}},
+								}},
+								"$exchange_rate",
+								bson.M{"$arrayElemAt": []interface{}{"$fx_rate.rate", 0}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// baseAmountExpr выражение суммы в базовой валюте в центах; требует стадий baseRateStages
+func baseAmountExpr(amount interface{}) bson.M {
+	return bson.M{
+		"$toLong": bson.M{
+			"$round": []interface{}{
+				bson.M{"$multiply": []interface{}{amount, "$base_rate"}},
+				0,
+			},
+		},
+	}
+}
+
+// missingRateExpr выражение для подсчета документов без курса к базовой валюте
+func missingRateExpr() bson.M {
+	return bson.M{
+		"$cond": []interface{}{
+			bson.M{"$gt": []interface{}{"$base_rate", 0}},
+			0,
+			1,
+		},
+	}
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"billing-system/internal/models"
 	"context"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -11,11 +12,18 @@ import (
 type BrokerRepository interface {
 	Create(ctx context.Context, broker *models.Broker) error
 	GetByID(ctx context.Context, id primitive.ObjectID) (*models.Broker, error)
+	Lock(ctx context.Context, id primitive.ObjectID) (*models.Broker, error)
 	GetAll(ctx context.Context, limit, offset int) ([]*models.Broker, int64, error)
+	Iterate(ctx context.Context, fn func(*models.Broker) error) error
+	OpenCursor(ctx context.Context) (*Cursor[models.Broker], error)
 	Update(ctx context.Context, id primitive.ObjectID, broker *models.Broker) error
+	UpdateStatus(ctx context.Context, id primitive.ObjectID, status string) error
+	ReleaseCreditHold(ctx context.Context, id primitive.ObjectID, release *models.CreditHoldRelease) (bool, error)
 	Delete(ctx context.Context, id primitive.ObjectID) error
 	Search(ctx context.Context, query string, limit, offset int) ([]*models.Broker, int64, error)
 	GetStats(ctx context.Context, brokerID primitive.ObjectID) (*models.BrokerStats, error)
+	CountByStatus(ctx context.Context) (map[string]int64, error)
+	SetCreditAlert(ctx context.Context, id primitive.ObjectID, sentAt *time.Time) (bool, error)
 }
 
 // InvoiceRepository интерфейс для работы со счетами
@@ -23,13 +31,32 @@ type InvoiceRepository interface {
 	Create(ctx context.Context, invoice *models.Invoice) error
 	GetByID(ctx context.Context, id primitive.ObjectID) (*models.Invoice, error)
 	GetAll(ctx context.Context, filter *models.InvoiceFilter, limit, offset int) ([]*models.Invoice, int64, error)
+	Iterate(ctx context.Context, filter *models.InvoiceFilter, fn func(*models.Invoice) error) error
+	OpenCursor(ctx context.Context, filter *models.InvoiceFilter) (*Cursor[models.Invoice], error)
 	Update(ctx context.Context, id primitive.ObjectID, invoice *models.Invoice) error
 	Delete(ctx context.Context, id primitive.ObjectID) error
 	GetByStatus(ctx context.Context, status string, limit, offset int) ([]*models.Invoice, int64, error)
 	GetByBroker(ctx context.Context, brokerID primitive.ObjectID, limit, offset int) ([]*models.Invoice, int64, error)
 	GetOverdue(ctx context.Context, limit, offset int) ([]*models.Invoice, int64, error)
-	UpdateStatus(ctx context.Context, id primitive.ObjectID, status string, paidAmount float64) error
+	GetOpenByBroker(ctx context.Context, brokerID primitive.ObjectID, currency string) ([]*models.Invoice, error)
+	GetByNumbers(ctx context.Context, numbers []string) ([]*models.Invoice, error)
+	GetOpenByRemaining(ctx context.Context, currency string, remaining models.Amount) ([]*models.Invoice, error)
+	UpdateStatus(ctx context.Context, id primitive.ObjectID, status string, paidAmount, creditedAmount, earlyDiscount models.Amount, paidAt *time.Time) error
+	Issue(ctx context.Context, id primitive.ObjectID, issueDate, dueDate time.Time, creditOverride *models.CreditOverride) error
+	GetLateFees(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.Invoice, error)
+	GetByBillingPeriod(ctx context.Context, scheduleID primitive.ObjectID, period int) (*models.Invoice, error)
+	GetLastLateFeePeriods(ctx context.Context, invoiceIDs []primitive.ObjectID) (map[primitive.ObjectID]int, error)
+	Lock(ctx context.Context, id primitive.ObjectID) (*models.Invoice, error)
 	GenerateInvoiceNumber(ctx context.Context) (string, error)
+	GetTopDebtors(ctx context.Context, limit int, baseCurrency string) ([]models.TopDebtor, error)
+	GetStatusSummary(ctx context.Context, baseCurrency string) ([]models.InvoiceByStatus, error)
+	GetRevenueByMonth(ctx context.Context, from time.Time, baseCurrency string) ([]models.RevenueByMonth, error)
+	Count(ctx context.Context, filter *models.InvoiceFilter) (int64, error)
+	CountByStatus(ctx context.Context) (map[string]int64, error)
+	SumRemaining(ctx context.Context, filter *models.InvoiceFilter, baseCurrency string) ([]models.CurrencyAmount, error)
+	Void(ctx context.Context, id primitive.ObjectID, from, reason, voidedBy string) error
+	MarkOverdue(ctx context.Context, asOf time.Time) (int64, error)
+	GetOpenAsOf(ctx context.Context, asOf time.Time, brokerID primitive.ObjectID, baseCurrency string) ([]*models.Invoice, error)
 }
 
 // PaymentRepository интерфейс для работы с платежами
@@ -37,11 +64,51 @@ type PaymentRepository interface {
 	Create(ctx context.Context, payment *models.Payment) error
 	GetByID(ctx context.Context, id primitive.ObjectID) (*models.Payment, error)
 	GetAll(ctx context.Context, filter *models.PaymentFilter, limit, offset int) ([]*models.Payment, int64, error)
+	Iterate(ctx context.Context, filter *models.PaymentFilter, fn func(*models.Payment) error) error
+	OpenCursor(ctx context.Context, filter *models.PaymentFilter) (*Cursor[models.Payment], error)
 	Update(ctx context.Context, id primitive.ObjectID, payment *models.Payment) error
 	Delete(ctx context.Context, id primitive.ObjectID) error
 	GetByInvoice(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.Payment, error)
 	GetByBroker(ctx context.Context, brokerID primitive.ObjectID, limit, offset int) ([]*models.Payment, int64, error)
-	GetTotalPaidAmount(ctx context.Context, invoiceID primitive.ObjectID) (float64, error)
+	GetTotalPaidAmount(ctx context.Context, invoiceID primitive.ObjectID) (models.Amount, error)
+	MarkReversed(ctx context.Context, id, reversalID primitive.ObjectID) (bool, error)
+	AddRefund(ctx context.Context, id primitive.ObjectID, amount models.Amount) (bool, error)
+	GetByOriginal(ctx context.Context, originalID primitive.ObjectID) ([]*models.Payment, error)
+	GetUnapplied(ctx context.Context, brokerID primitive.ObjectID, currency string) ([]*models.Payment, error)
+	GetBrokerCredit(ctx context.Context, brokerID primitive.ObjectID) ([]models.BrokerCredit, error)
+	GetDailyTotals(ctx context.Context, from, to time.Time, baseCurrency string) ([]models.PaymentByDay, error)
+	SumByFilter(ctx context.Context, filter *models.PaymentFilter, baseCurrency string) ([]models.CurrencyAmount, error)
+	SumFXGainLoss(ctx context.Context, filter *models.PaymentFilter) (models.Amount, error)
+}
+
+// CreditNoteRepository интерфейс для работы с кредит-нотами
+type CreditNoteRepository interface {
+	Create(ctx context.Context, note *models.CreditNote) error
+	GetByID(ctx context.Context, id primitive.ObjectID) (*models.CreditNote, error)
+	GetByInvoice(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.CreditNote, error)
+	GetTotalByInvoice(ctx context.Context, invoiceID primitive.ObjectID) (models.Amount, error)
+	CountByInvoice(ctx context.Context, invoiceID primitive.ObjectID) (int64, error)
+	GenerateCreditNoteNumber(ctx context.Context) (string, error)
+}
+
+// BillingScheduleRepository интерфейс для расписаний регулярных счетов
+type BillingScheduleRepository interface {
+	Create(ctx context.Context, schedule *models.BillingSchedule) error
+	GetByID(ctx context.Context, id primitive.ObjectID) (*models.BillingSchedule, error)
+	GetAll(ctx context.Context, filter *models.BillingScheduleFilter, limit, offset int) ([]*models.BillingSchedule, int64, error)
+	Update(ctx context.Context, id primitive.ObjectID, schedule *models.BillingSchedule) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	SetStatus(ctx context.Context, id primitive.ObjectID, fromStatus, status string, lastPeriod int, nextRunDate *time.Time) (bool, error)
+	GetDue(ctx context.Context, asOf time.Time) ([]*models.BillingSchedule, error)
+	Advance(ctx context.Context, id primitive.ObjectID, period int, nextRunDate *time.Time, status string) (bool, error)
+}
+
+// BankTransactionRepository интерфейс для операций из банковских выписок
+type BankTransactionRepository interface {
+	Insert(ctx context.Context, transaction *models.BankTransaction) (bool, error)
+	GetByID(ctx context.Context, id primitive.ObjectID) (*models.BankTransaction, error)
+	GetAll(ctx context.Context, filter *models.BankTransactionFilter, limit, offset int) ([]*models.BankTransaction, int64, error)
+	Resolve(ctx context.Context, transaction *models.BankTransaction) (bool, error)
 }
 
 // LoadRepository интерфейс для работы с грузами
@@ -53,7 +120,36 @@ type LoadRepository interface {
 	Delete(ctx context.Context, id primitive.ObjectID) error
 	GetByBroker(ctx context.Context, brokerID primitive.ObjectID, limit, offset int) ([]*models.Load, int64, error)
 	GetByInvoice(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.Load, error)
+	GetByIDs(ctx context.Context, ids []primitive.ObjectID) ([]*models.Load, error)
+	AssignInvoice(ctx context.Context, ids []primitive.ObjectID, invoiceID primitive.ObjectID) (int64, error)
+	ReleaseInvoice(ctx context.Context, invoiceID primitive.ObjectID) error
 	UpdateStatus(ctx context.Context, id primitive.ObjectID, status string) error
 	GenerateLoadNumber(ctx context.Context) (string, error)
 	GetUnbilledByBroker(ctx context.Context, brokerID primitive.ObjectID, limit, offset int) ([]*models.Load, int64, error)
+	SumUnbilledByBroker(ctx context.Context, brokerID primitive.ObjectID) ([]models.CurrencyAmount, error)
+	CountByStatus(ctx context.Context) (map[string]int64, error)
+}
+
+// JobRepository интерфейс для журнала и блокировок фоновых задач
+type JobRepository interface {
+	CreateRun(ctx context.Context, run *models.JobRun) error
+	FinishRun(ctx context.Context, run *models.JobRun) error
+	GetRuns(ctx context.Context, job string, limit int) ([]*models.JobRun, error)
+	AcquireLock(ctx context.Context, job, owner string, slot time.Time, ttl time.Duration) (bool, error)
+	ReleaseLock(ctx context.Context, job, owner string) error
+}
+
+// DunningRepository интерфейс для истории напоминаний по счетам
+type DunningRepository interface {
+	Claim(ctx context.Context, record *models.DunningRecord) (bool, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	GetByInvoice(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.DunningRecord, error)
+	GetLastStageDays(ctx context.Context, invoiceIDs []primitive.ObjectID) (map[primitive.ObjectID]int, error)
+}
+
+// ExchangeRateRepository интерфейс для дневных курсов валют
+type ExchangeRateRepository interface {
+	Upsert(ctx context.Context, rate *models.ExchangeRate) error
+	GetOnOrBefore(ctx context.Context, currency, baseCurrency string, date time.Time) (*models.ExchangeRate, error)
+	GetList(ctx context.Context, baseCurrency string, filter *models.ExchangeRateFilter) ([]*models.ExchangeRate, error)
 }
@@ -1,9 +1,9 @@
 package repository
 
 import (
+	"billing-system/config"
 	"billing-system/internal/models"
 	"context"
-	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -12,15 +12,28 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// openInvoiceStatuses статусы счетов, по которым есть задолженность
+var openInvoiceStatuses = []string{
+	models.InvoiceStatusIssued,
+	models.InvoiceStatusPartial,
+	models.InvoiceStatusOverdue,
+}
+
 // invoiceRepository реализация InvoiceRepository
 type invoiceRepository struct {
 	collection *mongo.Collection
+	numbers    *sequence
 }
 
-// NewInvoiceRepository создает новый InvoiceRepository
-func NewInvoiceRepository(db *Database) InvoiceRepository {
+// NewInvoiceRepository создает новый InvoiceRepository с форматом номеров счетов numberFormat
+func NewInvoiceRepository(db *Database, numberFormat config.NumberFormat) InvoiceRepository {
+	// Уникальные индексы номера счета, пени за период просрочки и счета за период расписания
+	// создаются миграцией 0006_unique_indexes
+	collection := db.GetCollection("invoices")
+
 	return &invoiceRepository{
-		collection: db.GetCollection("invoices"),
+		collection: collection,
+		numbers:    newSequence(db, SequenceInvoice, numberFormat),
 	}
 }
 
@@ -29,9 +42,11 @@ func (r *invoiceRepository) Create(ctx context.Context, invoice *models.Invoice)
 	invoice.ID = primitive.NewObjectID()
 	invoice.CreatedAt = time.Now()
 	invoice.PaidAmount = 0
+	invoice.CreditedAmount = 0
+	invoice.EarlyDiscount = 0
 
 	if invoice.Status == "" {
-		invoice.Status = models.InvoiceStatusPending
+		invoice.Status = models.InvoiceStatusIssued
 	}
 
 	// Генерируем номер счета если не указан
@@ -63,56 +78,10 @@ func (r *invoiceRepository) GetByID(ctx context.Context, id primitive.ObjectID)
 
 // GetAll получает все счета с фильтрацией и пагинацией
 func (r *invoiceRepository) GetAll(ctx context.Context, filter *models.InvoiceFilter, limit, offset int) ([]*models.Invoice, int64, error) {
-	// Используем aggregation pipeline для JOIN с brokers
-	pipeline := []bson.M{
-		// Первичная фильтрация
-		{
-			"$match": r.buildFilter(filter),
-		},
-		// JOIN с brokers для получения имени брокера
-		{
-			"$lookup": bson.M{
-				"from": "brokers",
-				"let":  bson.M{"broker_id": "$broker_id"},
-				"pipeline": []bson.M{
-					{
-						"$match": bson.M{
-							"$expr": bson.M{
-								"$eq": []interface{}{"$_id", "$$broker_id"},
-							},
-						},
-					},
-				},
-				"as": "broker",
-			},
-		},
-		// Добавляем поле broker_name
-		{
-			"$addFields": bson.M{
-				"broker_name": bson.M{
-					"$ifNull": []interface{}{
-						bson.M{"$arrayElemAt": []interface{}{"$broker.company_name", 0}},
-						"Unknown Broker",
-					},
-				},
-			},
-		},
-		// Удаляем временное поле
-		{
-			"$project": bson.M{
-				"broker": 0,
-			},
-		},
-		// Сортировка
-		{
-			"$sort": bson.M{"created_at": -1},
-		},
-	}
+	pipeline := r.listPipeline(filter)
 
 	// Подсчет общего количества
-	countPipeline := make([]bson.M, 2)
-	copy(countPipeline, pipeline[:2]) // Копируем только match и lookup для подсчета
-	countPipeline = append(countPipeline, bson.M{"$count": "total"})
+	countPipeline := []bson.M{pipeline[0], {"$count": "total"}} // Для подсчета достаточно фильтрации
 
 	countCursor, err := r.collection.Aggregate(ctx, countPipeline)
 	if err != nil {
@@ -134,13 +103,13 @@ func (r *invoiceRepository) GetAll(ctx context.Context, filter *models.InvoiceFi
 		}
 	}
 
-	// Добавляем пагинацию к основному pipeline
-	dataPipeline := make([]bson.M, len(pipeline))
-	copy(dataPipeline, pipeline)
-	dataPipeline = append(dataPipeline, bson.M{"$skip": offset})
-	dataPipeline = append(dataPipeline, bson.M{"$limit": limit})
+	// Пагинация сразу после фильтрации и сортировки, чтобы JOIN выполнялся только для страницы
+	dataPipeline := make([]bson.M, 0, len(pipeline)+2)
+	dataPipeline = append(dataPipeline, pipeline[:2]...)
+	dataPipeline = append(dataPipeline, bson.M{"$skip": offset}, bson.M{"$limit": limit})
+	dataPipeline = append(dataPipeline, pipeline[2:]...)
 
-	cursor, err := r.collection.Aggregate(ctx, dataPipeline)
+	cursor, err := r.collection.Aggregate(ctx, dataPipeline, options.Aggregate().SetAllowDiskUse(true))
 	if err != nil {
 		return nil, 0, err
 	}
@@ -159,21 +128,48 @@ func (r *invoiceRepository) GetAll(ctx context.Context, filter *models.InvoiceFi
 	return invoices, total, nil
 }
 
-// Update обновляет счет
+// Iterate обходит все счета по фильтру через курсор, без ограничения по количеству
+func (r *invoiceRepository) Iterate(ctx context.Context, filter *models.InvoiceFilter, fn func(*models.Invoice) error) error {
+	cursor, err := r.OpenCursor(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return cursor.Each(ctx, fn)
+}
+
+// OpenCursor выполняет выборку счетов по фильтру и открывает курсор для ее обхода
+func (r *invoiceRepository) OpenCursor(ctx context.Context, filter *models.InvoiceFilter) (*Cursor[models.Invoice], error) {
+	cursor, err := r.collection.Aggregate(ctx, r.listPipeline(filter), options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	return newCursor(cursor, r.calculateFields), nil
+}
+
+// Update обновляет счет. Грузы счета не меняются: они привязываются при создании через AssignInvoice
 func (r *invoiceRepository) Update(ctx context.Context, id primitive.ObjectID, invoice *models.Invoice) error {
-	update := bson.M{
-		"$set": bson.M{
-			"broker_id":   invoice.BrokerID,
-			"amount":      invoice.Amount,
-			"currency":    invoice.Currency,
-			"due_date":    invoice.DueDate,
-			"description": invoice.Description,
-			"load_ids":    invoice.LoadIDs,
-			"notes":       invoice.Notes,
-		},
+	set := bson.M{
+		"broker_id":          invoice.BrokerID,
+		"amount":             invoice.Amount,
+		"line_items":         invoice.LineItems,
+		"discounts":          invoice.Discounts,
+		"subtotal":           invoice.Subtotal,
+		"discount_total":     invoice.DiscountTotal,
+		"tax_total":          invoice.TaxTotal,
+		"currency":           invoice.Currency,
+		"exchange_rate":      invoice.ExchangeRate,
+		"exchange_rate_base": invoice.ExchangeRateBase,
+		"due_date":           invoice.DueDate,
+		"payment_terms":      invoice.PaymentTerms,
+		"description":        invoice.Description,
+		"notes":              invoice.Notes,
+	}
+	// Разрешение превысить кредитный лимит только дополняется: без нового сохраняется прежнее
+	if invoice.CreditOverride != nil {
+		set["credit_override"] = invoice.CreditOverride
 	}
 
-	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
 	return err
 }
 
@@ -258,7 +254,7 @@ func (r *invoiceRepository) GetOverdue(ctx context.Context, limit, offset int) (
 	now := time.Now()
 	filter := bson.M{
 		"due_date": bson.M{"$lt": now},
-		"status":   bson.M{"$nin": []string{models.InvoiceStatusPaid, models.InvoiceStatusCanceled}},
+		"status":   bson.M{"$in": openInvoiceStatuses},
 	}
 
 	// Подсчет общего количества
@@ -267,11 +263,11 @@ func (r *invoiceRepository) GetOverdue(ctx context.Context, limit, offset int) (
 		return nil, 0, err
 	}
 
-	// Опции для пагинации
+	// Опции для пагинации; _id делает порядок однозначным, чтобы счета с одинаковым сроком не терялись между страницами
 	opts := options.Find().
 		SetLimit(int64(limit)).
 		SetSkip(int64(offset)).
-		SetSort(bson.M{"due_date": 1})
+		SetSort(bson.D{{Key: "due_date", Value: 1}, {Key: "_id", Value: 1}})
 
 	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
@@ -292,63 +288,722 @@ func (r *invoiceRepository) GetOverdue(ctx context.Context, limit, offset int) (
 	return invoices, total, nil
 }
 
-// UpdateStatus обновляет статус счета и сумму к оплате
-func (r *invoiceRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, status string, paidAmount float64) error {
+// GetOpenByBroker получает неоплаченные счета брокера в валюте currency, начиная с самого раннего срока оплаты
+func (r *invoiceRepository) GetOpenByBroker(ctx context.Context, brokerID primitive.ObjectID, currency string) ([]*models.Invoice, error) {
+	filter := bson.M{
+		"broker_id": brokerID,
+		"currency":  currency,
+		"status":    bson.M{"$in": openInvoiceStatuses},
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "due_date", Value: 1}, {Key: "created_at", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	invoices := []*models.Invoice{}
+	if err = cursor.All(ctx, &invoices); err != nil {
+		return nil, err
+	}
+
+	for _, invoice := range invoices {
+		r.calculateFields(invoice)
+	}
+
+	return invoices, nil
+}
+
+// GetByNumbers получает счета по номерам
+func (r *invoiceRepository) GetByNumbers(ctx context.Context, numbers []string) ([]*models.Invoice, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"invoice_number": bson.M{"$in": numbers}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	invoices := []*models.Invoice{}
+	if err = cursor.All(ctx, &invoices); err != nil {
+		return nil, err
+	}
+
+	for _, invoice := range invoices {
+		r.calculateFields(invoice)
+	}
+
+	return invoices, nil
+}
+
+// GetOpenByRemaining получает неоплаченные счета в валюте currency, остаток которых равен remaining
+func (r *invoiceRepository) GetOpenByRemaining(ctx context.Context, currency string, remaining models.Amount) ([]*models.Invoice, error) {
+	filter := bson.M{
+		"currency": currency,
+		"status":   bson.M{"$in": openInvoiceStatuses},
+		"$expr": bson.M{
+			"$eq": []interface{}{remainingAmountExpr(), remaining},
+		},
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "due_date", Value: 1}, {Key: "created_at", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	invoices := []*models.Invoice{}
+	if err = cursor.All(ctx, &invoices); err != nil {
+		return nil, err
+	}
+
+	for _, invoice := range invoices {
+		r.calculateFields(invoice)
+	}
+
+	return invoices, nil
+}
+
+// UpdateStatus обновляет статус счета, оплаченную и списанную кредит-нотами суммы и скидку за раннюю оплату
+func (r *invoiceRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, status string, paidAmount, creditedAmount, earlyDiscount models.Amount, paidAt *time.Time) error {
 	update := bson.M{
 		"$set": bson.M{
-			"status":      status,
-			"paid_amount": paidAmount,
+			"status":          status,
+			"paid_amount":     paidAmount,
+			"credited_amount": creditedAmount,
+			"early_discount":  earlyDiscount,
+			"paid_at":         paidAt,
 		},
 	}
 
-	// Если статус "оплачен", ставим дату оплаты
-	if status == models.InvoiceStatusPaid {
-		update["$set"].(bson.M)["paid_at"] = time.Now()
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// Issue выставляет счет с датой выставления issueDate и сроком оплаты dueDate.
+// Разрешение превысить кредитный лимит сохраняется, если выставление его потребовало.
+func (r *invoiceRepository) Issue(ctx context.Context, id primitive.ObjectID, issueDate, dueDate time.Time, creditOverride *models.CreditOverride) error {
+	set := bson.M{
+		"status":     models.InvoiceStatusIssued,
+		"issue_date": issueDate,
+		"due_date":   dueDate,
 	}
+	if creditOverride != nil {
+		set["credit_override"] = creditOverride
+	}
+	update := bson.M{"$set": set}
 
 	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
 	return err
 }
 
-// GenerateInvoiceNumber генерирует номер счета
+// Void аннулирует счет с указанием причины; from - статус счета до аннулирования
+func (r *invoiceRepository) Void(ctx context.Context, id primitive.ObjectID, from, reason, voidedBy string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"status":      models.InvoiceStatusVoid,
+			"voided_from": from,
+			"void_reason": reason,
+			"voided_by":   voidedBy,
+			"voided_at":   time.Now(),
+		},
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// GetLateFees получает счета на пени, начисленные за просрочку счета invoiceID, включая списанные
+func (r *invoiceRepository) GetLateFees(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.Invoice, error) {
+	opts := options.Find().SetSort(bson.M{"late_fee_period": 1})
+
+	cursor, err := r.collection.Find(ctx, bson.M{"late_fee_for": invoiceID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	invoices := []*models.Invoice{}
+	if err = cursor.All(ctx, &invoices); err != nil {
+		return nil, err
+	}
+
+	for _, invoice := range invoices {
+		r.calculateFields(invoice)
+	}
+
+	return invoices, nil
+}
+
+// GetByBillingPeriod получает счет, выставленный по расписанию за период period
+func (r *invoiceRepository) GetByBillingPeriod(ctx context.Context, scheduleID primitive.ObjectID, period int) (*models.Invoice, error) {
+	var invoice models.Invoice
+	err := r.collection.FindOne(ctx, bson.M{"billing_schedule_id": scheduleID, "billing_period": period}).Decode(&invoice)
+	if err != nil {
+		return nil, err
+	}
+
+	r.calculateFields(&invoice)
+	return &invoice, nil
+}
+
+// GetLastLateFeePeriods получает последний начисленный период пени по каждому из счетов invoiceIDs.
+// Списанные пени учитываются, чтобы период не начислялся повторно.
+func (r *invoiceRepository) GetLastLateFeePeriods(ctx context.Context, invoiceIDs []primitive.ObjectID) (map[primitive.ObjectID]int, error) {
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{"late_fee_for": bson.M{"$in": invoiceIDs}},
+		},
+		{
+			"$group": bson.M{
+				"_id":    "$late_fee_for",
+				"period": bson.M{"$max": "$late_fee_period"},
+			},
+		},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		InvoiceID primitive.ObjectID `bson:"_id"`
+		Period    int                `bson:"period"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	periods := make(map[primitive.ObjectID]int, len(results))
+	for _, result := range results {
+		periods[result.InvoiceID] = result.Period
+	}
+
+	return periods, nil
+}
+
+// MarkOverdue переводит неоплаченные и частично оплаченные счета со сроком оплаты до asOf в статус overdue
+func (r *invoiceRepository) MarkOverdue(ctx context.Context, asOf time.Time) (int64, error) {
+	filter := bson.M{
+		"status":   bson.M{"$in": []string{models.InvoiceStatusIssued, models.InvoiceStatusPartial}},
+		"due_date": bson.M{"$lt": asOf},
+	}
+
+	update := bson.M{
+		"$set": bson.M{"status": models.InvoiceStatusOverdue},
+	}
+
+	result, err := r.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// Lock блокирует счет в текущей транзакции и возвращает его.
+// Запись в документ заставляет конкурентные транзакции по этому счету завершаться конфликтом записи и повторяться.
+func (r *invoiceRepository) Lock(ctx context.Context, id primitive.ObjectID) (*models.Invoice, error) {
+	update := bson.M{
+		"$currentDate": bson.M{"locked_at": true},
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var invoice models.Invoice
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&invoice)
+	if err != nil {
+		return nil, err
+	}
+
+	r.calculateFields(&invoice)
+
+	return &invoice, nil
+}
+
+// GenerateInvoiceNumber выдает следующий номер счета из последовательности
 func (r *invoiceRepository) GenerateInvoiceNumber(ctx context.Context) (string, error) {
+	return r.numbers.Next(ctx, time.Now())
+}
+
+// GetTopDebtors получает брокеров с наибольшей задолженностью в разрезе валют
+func (r *invoiceRepository) GetTopDebtors(ctx context.Context, limit int, baseCurrency string) ([]models.TopDebtor, error) {
 	now := time.Now()
-	prefix := fmt.Sprintf("INV-%d%02d-", now.Year(), now.Month())
+	remaining := remainingAmountExpr()
 
-	// Ищем последний номер за текущий месяц
-	filter := bson.M{
-		"invoice_number": bson.M{
-			"$regex": fmt.Sprintf("^%s", prefix),
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{"status": bson.M{"$in": openInvoiceStatuses}},
 		},
 	}
+	pipeline = append(pipeline, baseRateStages(baseCurrency, "created_at")...)
+	pipeline = append(pipeline, []bson.M{
+		// Суммы разных валют не складываем - группируем по брокеру и валюте,
+		// а для сортировки пересчитываем долг в базовую валюту
+		{
+			"$group": bson.M{
+				"_id": bson.M{
+					"broker_id": "$broker_id",
+					"currency":  "$currency",
+				},
+				"total_debt":      bson.M{"$sum": remaining},
+				"base_total_debt": bson.M{"$sum": baseAmountExpr(remaining)},
+				"overdue_amount": bson.M{
+					"$sum": bson.M{
+						"$cond": []interface{}{
+							bson.M{"$lt": []interface{}{"$due_date", now}},
+							remaining,
+							0,
+						},
+					},
+				},
+			},
+		},
+		{
+			"$match": bson.M{"total_debt": bson.M{"$gt": 0}},
+		},
+		{
+			"$sort": bson.D{{Key: "base_total_debt", Value: -1}, {Key: "total_debt", Value: -1}},
+		},
+		{
+			"$limit": limit,
+		},
+		// JOIN с brokers для получения названия компании
+		{
+			"$lookup": bson.M{
+				"from":         "brokers",
+				"localField":   "_id.broker_id",
+				"foreignField": "_id",
+				"as":           "broker",
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":             0,
+				"broker_id":       "$_id.broker_id",
+				"currency":        "$_id.currency",
+				"total_debt":      1,
+				"base_total_debt": 1,
+				"overdue_amount":  1,
+				"company_name": bson.M{
+					"$ifNull": []interface{}{
+						bson.M{"$arrayElemAt": []interface{}{"$broker.company_name", 0}},
+						"Unknown Broker",
+					},
+				},
+			},
+		},
+	}...)
 
-	opts := options.Find().
-		SetSort(bson.M{"created_at": -1}).
-		SetLimit(1)
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
 
-	cursor, err := r.collection.Find(ctx, filter, opts)
+	debtors := []models.TopDebtor{}
+	if err = cursor.All(ctx, &debtors); err != nil {
+		return nil, err
+	}
+
+	return debtors, nil
+}
+
+// GetStatusSummary получает количество и сумму счетов по статусам в разрезе валют
+func (r *invoiceRepository) GetStatusSummary(ctx context.Context, baseCurrency string) ([]models.InvoiceByStatus, error) {
+	pipeline := baseRateStages(baseCurrency, "created_at")
+	pipeline = append(pipeline, []bson.M{
+		{
+			"$group": bson.M{
+				"_id": bson.M{
+					"status":   "$status",
+					"currency": "$currency",
+				},
+				"count":       bson.M{"$sum": 1},
+				"amount":      bson.M{"$sum": "$amount"},
+				"base_amount": bson.M{"$sum": baseAmountExpr("$amount")},
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":         0,
+				"status":      "$_id.status",
+				"currency":    "$_id.currency",
+				"count":       1,
+				"amount":      1,
+				"base_amount": 1,
+			},
+		},
+		{
+			"$sort": bson.M{"status": 1, "currency": 1},
+		},
+	}...)
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var lastInvoice models.Invoice
-	if cursor.Next(ctx) {
-		if err := cursor.Decode(&lastInvoice); err != nil {
-			return "", err
-		}
+	summary := []models.InvoiceByStatus{}
+	if err = cursor.All(ctx, &summary); err != nil {
+		return nil, err
 	}
 
-	// Извлекаем номер и увеличиваем на 1
-	nextNumber := 1
-	if lastInvoice.InvoiceNumber != "" {
-		// Парсим номер из строки вида "INV-2024-01-0001"
-		var num int
-		fmt.Sscanf(lastInvoice.InvoiceNumber, prefix+"%d", &num)
-		nextNumber = num + 1
+	return summary, nil
+}
+
+// GetRevenueByMonth получает выставленные суммы по месяцам в разрезе валют начиная с from.
+// Месяцы считаются в часовом поясе from.
+func (r *invoiceRepository) GetRevenueByMonth(ctx context.Context, from time.Time, baseCurrency string) ([]models.RevenueByMonth, error) {
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"created_at": bson.M{"$gte": from},
+				"status":     bson.M{"$nin": []string{models.InvoiceStatusDraft, models.InvoiceStatusVoid}},
+			},
+		},
 	}
+	pipeline = append(pipeline, baseRateStages(baseCurrency, "created_at")...)
+	pipeline = append(pipeline, []bson.M{
+		{
+			"$group": bson.M{
+				"_id": bson.M{
+					"month": bson.M{
+						"$dateToString": bson.M{"format": "%Y-%m", "date": "$created_at", "timezone": from.Location().String()},
+					},
+					"currency": "$currency",
+				},
+				"revenue":      bson.M{"$sum": "$amount"},
+				"base_revenue": bson.M{"$sum": baseAmountExpr("$amount")},
+				"count":        bson.M{"$sum": 1},
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":          0,
+				"month":        "$_id.month",
+				"currency":     "$_id.currency",
+				"revenue":      1,
+				"base_revenue": 1,
+				"count":        1,
+			},
+		},
+		{
+			"$sort": bson.M{"month": 1, "currency": 1},
+		},
+	}...)
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
 
-	return fmt.Sprintf("%s%04d", prefix, nextNumber), nil
+	revenue := []models.RevenueByMonth{}
+	if err = cursor.All(ctx, &revenue); err != nil {
+		return nil, err
+	}
+
+	return revenue, nil
+}
+
+// Count получает количество счетов по фильтру
+func (r *invoiceRepository) Count(ctx context.Context, filter *models.InvoiceFilter) (int64, error) {
+	return r.collection.CountDocuments(ctx, r.buildFilter(filter))
+}
+
+// CountByStatus получает количество счетов по статусам
+func (r *invoiceRepository) CountByStatus(ctx context.Context) (map[string]int64, error) {
+	pipeline := []bson.M{
+		{
+			"$group": bson.M{
+				"_id":   "$status",
+				"count": bson.M{"$sum": 1},
+			},
+		},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Status string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(results))
+	for _, result := range results {
+		counts[result.Status] = result.Count
+	}
+
+	return counts, nil
+}
+
+// SumRemaining получает сумму остатков к оплате по фильтру в разрезе валют
+func (r *invoiceRepository) SumRemaining(ctx context.Context, filter *models.InvoiceFilter, baseCurrency string) ([]models.CurrencyAmount, error) {
+	remaining := bson.M{"$max": []interface{}{remainingAmountExpr(), 0}}
+
+	pipeline := []bson.M{
+		{
+			"$match": r.buildFilter(filter),
+		},
+	}
+	pipeline = append(pipeline, baseRateStages(baseCurrency, "created_at")...)
+	pipeline = append(pipeline, []bson.M{
+		{
+			"$group": bson.M{
+				"_id":           "$currency",
+				"amount":        bson.M{"$sum": remaining},
+				"base_amount":   bson.M{"$sum": baseAmountExpr(remaining)},
+				"count":         bson.M{"$sum": 1},
+				"missing_rates": bson.M{"$sum": missingRateExpr()},
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":           0,
+				"currency":      "$_id",
+				"amount":        1,
+				"base_amount":   1,
+				"count":         1,
+				"missing_rates": 1,
+			},
+		},
+		{
+			"$sort": bson.M{"currency": 1},
+		},
+	}...)
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	totals := []models.CurrencyAmount{}
+	if err = cursor.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+
+	return totals, nil
+}
+
+// GetOpenAsOf получает счета, открытые на дату asOf, с оплаченной суммой и кредит-нотами на эту дату.
+// Срок оплаты и скидка за раннюю оплату берутся текущими.
+func (r *invoiceRepository) GetOpenAsOf(ctx context.Context, asOf time.Time, brokerID primitive.ObjectID, baseCurrency string) ([]*models.Invoice, error) {
+	// Счет, аннулированный после даты отчета, на эту дату был открыт; черновики открытыми не были
+	match := bson.M{
+		"created_at": bson.M{"$lte": asOf},
+		"$or": []bson.M{
+			{"status": bson.M{"$nin": []string{models.InvoiceStatusDraft, models.InvoiceStatusVoid}}},
+			{
+				"status":      models.InvoiceStatusVoid,
+				"voided_at":   bson.M{"$gt": asOf},
+				"voided_from": bson.M{"$ne": models.InvoiceStatusDraft},
+			},
+		},
+	}
+	if !brokerID.IsZero() {
+		match["broker_id"] = brokerID
+	}
+
+	pipeline := []bson.M{
+		{
+			"$match": match,
+		},
+		// Учитываем только платежи, поступившие не позже даты отчета
+		{
+			"$lookup": bson.M{
+				"from": "payments",
+				"let":  bson.M{"invoice_id": "$_id"},
+				"pipeline": []bson.M{
+					{
+						"$match": bson.M{
+							"$expr": bson.M{
+								"$and": []interface{}{
+									bson.M{"$in": []interface{}{
+										"$$invoice_id",
+										bson.M{"$ifNull": []interface{}{"$allocations.invoice_id", []interface{}{}}},
+									}},
+									bson.M{"$lte": []interface{}{"$payment_date", asOf}},
+								},
+							},
+						},
+					},
+					{
+						"$unwind": "$allocations",
+					},
+					{
+						"$match": bson.M{
+							"$expr": bson.M{"$eq": []interface{}{"$allocations.invoice_id", "$$invoice_id"}},
+						},
+					},
+					{
+						"$group": bson.M{
+							"_id":   nil,
+							"total": bson.M{"$sum": "$allocations.amount"},
+						},
+					},
+				},
+				"as": "paid_as_of",
+			},
+		},
+		// И только кредит-ноты, выпущенные не позже даты отчета
+		{
+			"$lookup": bson.M{
+				"from": "credit_notes",
+				"let":  bson.M{"invoice_id": "$_id"},
+				"pipeline": []bson.M{
+					{
+						"$match": bson.M{
+							"$expr": bson.M{
+								"$and": []interface{}{
+									bson.M{"$eq": []interface{}{"$invoice_id", "$$invoice_id"}},
+									bson.M{"$lte": []interface{}{"$created_at", asOf}},
+								},
+							},
+						},
+					},
+					{
+						"$group": bson.M{
+							"_id":   nil,
+							"total": bson.M{"$sum": "$amount"},
+						},
+					},
+				},
+				"as": "credited_as_of",
+			},
+		},
+		// JOIN с brokers для получения имени брокера
+		{
+			"$lookup": bson.M{
+				"from":         "brokers",
+				"localField":   "broker_id",
+				"foreignField": "_id",
+				"as":           "broker",
+			},
+		},
+		{
+			"$addFields": bson.M{
+				"paid_amount": bson.M{
+					"$ifNull": []interface{}{
+						bson.M{"$arrayElemAt": []interface{}{"$paid_as_of.total", 0}},
+						0,
+					},
+				},
+				"credited_amount": bson.M{
+					"$ifNull": []interface{}{
+						bson.M{"$arrayElemAt": []interface{}{"$credited_as_of.total", 0}},
+						0,
+					},
+				},
+				"broker_name": bson.M{
+					"$ifNull": []interface{}{
+						bson.M{"$arrayElemAt": []interface{}{"$broker.company_name", 0}},
+						"Unknown Broker",
+					},
+				},
+			},
+		},
+		{
+			"$match": bson.M{
+				"$expr": bson.M{"$gt": []interface{}{remainingAmountExpr(), 0}},
+			},
+		},
+		{
+			"$project": bson.M{
+				"broker":         0,
+				"paid_as_of":     0,
+				"credited_as_of": 0,
+			},
+		},
+	}
+	// Курс к базовой валюте на дату счета возвращается в поле exchange_rate
+	pipeline = append(pipeline, baseRateStages(baseCurrency, "created_at")...)
+	pipeline = append(pipeline,
+		bson.M{"$addFields": bson.M{"exchange_rate": "$base_rate", "exchange_rate_base": baseCurrency}},
+		bson.M{"$sort": bson.M{"broker_name": 1, "due_date": 1}},
+	)
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var invoices []*models.Invoice
+	for cursor.Next(ctx) {
+		var invoice models.Invoice
+		if err := cursor.Decode(&invoice); err != nil {
+			return nil, err
+		}
+		r.calculateFields(&invoice)
+		invoices = append(invoices, &invoice)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return invoices, nil
+}
+
+// listPipeline строит pipeline выборки счетов с именем брокера
+func (r *invoiceRepository) listPipeline(filter *models.InvoiceFilter) []bson.M {
+	// Используем aggregation pipeline для JOIN с brokers
+	return []bson.M{
+		// Первичная фильтрация
+		{
+			"$match": r.buildFilter(filter),
+		},
+		// Сортировка сразу после фильтрации, до JOIN: она идет по индексу, а не по результатам lookup
+		{
+			"$sort": bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+		},
+		// JOIN с brokers для получения имени брокера
+		{
+			"$lookup": bson.M{
+				"from": "brokers",
+				"let":  bson.M{"broker_id": "$broker_id"},
+				"pipeline": []bson.M{
+					{
+						"$match": bson.M{
+							"$expr": bson.M{
+								"$eq": []interface{}{"$_id", "$$broker_id"},
+							},
+						},
+					},
+				},
+				"as": "broker",
+			},
+		},
+		// Добавляем поле broker_name
+		{
+			"$addFields": bson.M{
+				"broker_name": bson.M{
+					"$ifNull": []interface{}{
+						bson.M{"$arrayElemAt": []interface{}{"$broker.company_name", 0}},
+						"Unknown Broker",
+					},
+				},
+			},
+		},
+		// Удаляем временное поле
+		{
+			"$project": bson.M{
+				"broker": 0,
+			},
+		},
+	}
 }
 
 // buildFilter строит MongoDB фильтр из структуры фильтра
@@ -395,19 +1050,49 @@ func (r *invoiceRepository) buildFilter(filter *models.InvoiceFilter) bson.M {
 
 	if filter.IsOverdue != nil && *filter.IsOverdue {
 		mongoFilter["due_date"] = bson.M{"$lt": time.Now()}
-		mongoFilter["status"] = bson.M{"$nin": []string{models.InvoiceStatusPaid, models.InvoiceStatusCanceled}}
+		mongoFilter["status"] = bson.M{"$in": openInvoiceStatuses}
 	}
 
 	return mongoFilter
 }
 
+// remainingAmountExpr выражение остатка к оплате: сумма счета за вычетом оплат, кредит-нот и скидки за раннюю оплату.
+// У счетов, созданных до появления кредит-нот и условий оплаты, поля credited_amount и early_discount отсутствуют.
+func remainingAmountExpr() bson.M {
+	return bson.M{
+		"$subtract": []interface{}{
+			"$amount",
+			bson.M{"$add": []interface{}{
+				"$paid_amount",
+				bson.M{"$ifNull": []interface{}{"$credited_amount", 0}},
+				bson.M{"$ifNull": []interface{}{"$early_discount", 0}},
+			}},
+		},
+	}
+}
+
 // calculateFields вычисляет дополнительные поля
 func (r *invoiceRepository) calculateFields(invoice *models.Invoice) {
 	// Проверяем, просрочен ли счет
-	invoice.IsOverdue = time.Now().After(invoice.DueDate) &&
-		invoice.Status != models.InvoiceStatusPaid &&
-		invoice.Status != models.InvoiceStatusCanceled
+	invoice.IsOverdue = time.Now().After(invoice.DueDate) && invoice.IsOpen()
 
 	// Вычисляем оставшуюся сумму
-	invoice.RemainingAmount = invoice.Amount - invoice.PaidAmount
+	invoice.RemainingAmount = invoice.Amount - invoice.PaidAmount - invoice.CreditedAmount - invoice.EarlyDiscount
+
+	// Пока срок скидки за раннюю оплату не истек, показываем остаток к оплате со скидкой
+	if terms := invoice.PaymentTerms; terms != nil && terms.HasDiscount() && invoice.IsOpen() {
+		issued := invoice.IssuedOn()
+		if terms.DiscountAppliesTo(issued, time.Now()) {
+			deadline := terms.DiscountDeadline(issued)
+			invoice.DiscountDeadline = &deadline
+			if remaining := invoice.RemainingAmount - terms.Discount(invoice.Amount); remaining > 0 {
+				invoice.EarlyPaymentAmount = remaining
+			}
+		}
+	}
+
+	// У счетов, созданных до появления позиций, сумма позиций равна итогу
+	if len(invoice.LineItems) == 0 && invoice.Subtotal == 0 {
+		invoice.Subtotal = invoice.Amount
+	}
 }
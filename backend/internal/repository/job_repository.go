@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"billing-system/internal/models"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// jobRepository реализация JobRepository
+type jobRepository struct {
+	runs  *mongo.Collection
+	locks *mongo.Collection
+}
+
+// NewJobRepository создает новый JobRepository
+func NewJobRepository(db *Database) JobRepository {
+	return &jobRepository{
+		runs:  db.GetCollection("job_runs"),
+		locks: db.GetCollection("job_locks"),
+	}
+}
+
+// CreateRun сохраняет запись о начале запуска задачи
+func (r *jobRepository) CreateRun(ctx context.Context, run *models.JobRun) error {
+	run.ID = primitive.NewObjectID()
+
+	_, err := r.runs.InsertOne(ctx, run)
+	return err
+}
+
+// FinishRun сохраняет результат запуска задачи
+func (r *jobRepository) FinishRun(ctx context.Context, run *models.JobRun) error {
+	update := bson.M{
+		"$set": bson.M{
+			"status":      run.Status,
+			"finished_at": run.FinishedAt,
+			"duration_ms": run.DurationMs,
+			"result":      run.Result,
+			"error":       run.Error,
+		},
+	}
+
+	_, err := r.runs.UpdateOne(ctx, bson.M{"_id": run.ID}, update)
+	return err
+}
+
+// GetRuns получает последние запуски задач, job пустой — по всем задачам
+func (r *jobRepository) GetRuns(ctx context.Context, job string, limit int) ([]*models.JobRun, error) {
+	filter := bson.M{}
+	if job != "" {
+		filter["job"] = job
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"started_at": -1}).
+		SetLimit(int64(limit))
+
+	cursor, err := r.runs.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var runs []*models.JobRun
+	if err = cursor.All(ctx, &runs); err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// AcquireLock берет блокировку задачи на запуск slot.
+// Блокировка не выдается, если задача уже выполняется или этот запуск уже взят другим экземпляром.
+func (r *jobRepository) AcquireLock(ctx context.Context, job, owner string, slot time.Time, ttl time.Duration) (bool, error) {
+	now := time.Now()
+
+	filter := bson.M{
+		"_id":          job,
+		"slot":         bson.M{"$lt": slot},
+		"locked_until": bson.M{"$lte": now},
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"owner":        owner,
+			"slot":         slot,
+			"locked_until": now.Add(ttl),
+			"acquired_at":  now,
+		},
+	}
+
+	// Upsert создает документ при первом запуске; если документ есть, но не подходит под фильтр,
+	// вставка нарушает уникальность _id — значит блокировку держит другой экземпляр
+	_, err := r.locks.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseLock снимает блокировку задачи, если она принадлежит owner
+func (r *jobRepository) ReleaseLock(ctx context.Context, job, owner string) error {
+	update := bson.M{
+		"$set": bson.M{"locked_until": time.Now()},
+	}
+
+	_, err := r.locks.UpdateOne(ctx, bson.M{"_id": job, "owner": owner}, update)
+	return err
+}
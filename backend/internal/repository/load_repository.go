@@ -1,9 +1,9 @@
 package repository
 
 import (
+	"billing-system/config"
 	"billing-system/internal/models"
 	"context"
-	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,12 +15,17 @@ import (
 // loadRepository реализация LoadRepository
 type loadRepository struct {
 	collection *mongo.Collection
+	numbers    *sequence
 }
 
-// NewLoadRepository создает новый LoadRepository
-func NewLoadRepository(db *Database) LoadRepository {
+// NewLoadRepository создает новый LoadRepository с форматом номеров грузов numberFormat
+func NewLoadRepository(db *Database, numberFormat config.NumberFormat) LoadRepository {
+	// Уникальный индекс номера груза создается миграцией 0006_unique_indexes
+	collection := db.GetCollection("loads")
+
 	return &loadRepository{
-		collection: db.GetCollection("loads"),
+		collection: collection,
+		numbers:    newSequence(db, SequenceLoad, numberFormat),
 	}
 }
 
@@ -178,26 +183,28 @@ func (r *loadRepository) GetAll(ctx context.Context, filter *models.LoadFilter,
 func (r *loadRepository) Update(ctx context.Context, id primitive.ObjectID, load *models.Load) error {
 	load.UpdatedAt = time.Now()
 
-	update := bson.M{
-		"$set": bson.M{
-			"broker_id":     load.BrokerID,
-			"invoice_id":    load.InvoiceID,
-			"route":         load.Route,
-			"pickup_date":   load.PickupDate,
-			"delivery_date": load.DeliveryDate,
-			"cost":          load.Cost,
-			"currency":      load.Currency,
-			"status":        load.Status,
-			"weight":        load.Weight,
-			"distance":      load.Distance,
-			"equipment":     load.Equipment,
-			"driver_info":   load.DriverInfo,
-			"notes":         load.Notes,
-			"updated_at":    load.UpdatedAt,
-		},
-	}
-
-	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	set := bson.M{
+		"broker_id":     load.BrokerID,
+		"invoice_id":    load.InvoiceID,
+		"route":         load.Route,
+		"pickup_date":   load.PickupDate,
+		"delivery_date": load.DeliveryDate,
+		"cost":          load.Cost,
+		"currency":      load.Currency,
+		"status":        load.Status,
+		"weight":        load.Weight,
+		"distance":      load.Distance,
+		"equipment":     load.Equipment,
+		"driver_info":   load.DriverInfo,
+		"notes":         load.Notes,
+		"updated_at":    load.UpdatedAt,
+	}
+	// Разрешение превысить кредитный лимит только дополняется: без нового сохраняется прежнее
+	if load.CreditOverride != nil {
+		set["credit_override"] = load.CreditOverride
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
 	return err
 }
 
@@ -257,6 +264,57 @@ func (r *loadRepository) GetByInvoice(ctx context.Context, invoiceID primitive.O
 	return loads, nil
 }
 
+// GetByIDs получает грузы по списку ID
+func (r *loadRepository) GetByIDs(ctx context.Context, ids []primitive.ObjectID) ([]*models.Load, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var loads []*models.Load
+	if err = cursor.All(ctx, &loads); err != nil {
+		return nil, err
+	}
+
+	return loads, nil
+}
+
+// AssignInvoice привязывает к счету грузы, которые еще не выставлены.
+// Возвращает количество обновленных грузов: если оно меньше len(ids), часть грузов уже выставлена.
+func (r *loadRepository) AssignInvoice(ctx context.Context, ids []primitive.ObjectID, invoiceID primitive.ObjectID) (int64, error) {
+	filter := bson.M{
+		"_id":        bson.M{"$in": ids},
+		"invoice_id": bson.M{"$in": []interface{}{nil, primitive.NilObjectID}},
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"invoice_id": invoiceID,
+			"updated_at": time.Now(),
+		},
+	}
+
+	result, err := r.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// ReleaseInvoice отвязывает грузы от счета
+func (r *loadRepository) ReleaseInvoice(ctx context.Context, invoiceID primitive.ObjectID) error {
+	update := bson.M{
+		"$set": bson.M{
+			"invoice_id": primitive.NilObjectID,
+			"updated_at": time.Now(),
+		},
+	}
+
+	_, err := r.collection.UpdateMany(ctx, bson.M{"invoice_id": invoiceID}, update)
+	return err
+}
+
 // UpdateStatus обновляет статус груза
 func (r *loadRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, status string) error {
 	update := bson.M{
@@ -270,80 +328,64 @@ func (r *loadRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID
 	return err
 }
 
-// GenerateLoadNumber генерирует номер груза
+// GenerateLoadNumber выдает следующий номер груза из последовательности
 func (r *loadRepository) GenerateLoadNumber(ctx context.Context) (string, error) {
-	now := time.Now()
-	prefix := fmt.Sprintf("LD-%d%02d%02d-", now.Year(), now.Month(), now.Day())
+	return r.numbers.Next(ctx, time.Now())
+}
 
-	// Ищем последний номер за текущий день
-	filter := bson.M{
-		"load_number": bson.M{
-			"$regex": fmt.Sprintf("^%s", prefix),
+// SumUnbilledByBroker получает стоимость невыставленных и неотмененных грузов брокера в разрезе валют
+func (r *loadRepository) SumUnbilledByBroker(ctx context.Context, brokerID primitive.ObjectID) ([]models.CurrencyAmount, error) {
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"broker_id":  brokerID,
+				"status":     bson.M{"$ne": models.LoadStatusCanceled},
+				"invoice_id": bson.M{"$in": []interface{}{nil, primitive.NilObjectID}},
+			},
+		},
+		{
+			"$group": bson.M{
+				"_id":    "$currency",
+				"amount": bson.M{"$sum": "$cost"},
+				"count":  bson.M{"$sum": 1},
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":      0,
+				"currency": "$_id",
+				"amount":   1,
+				"count":    1,
+			},
+		},
+		{
+			"$sort": bson.M{"currency": 1},
 		},
 	}
 
-	opts := options.Find().
-		SetSort(bson.M{"created_at": -1}).
-		SetLimit(1)
-
-	cursor, err := r.collection.Find(ctx, filter, opts)
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var lastLoad models.Load
-	if cursor.Next(ctx) {
-		if err := cursor.Decode(&lastLoad); err != nil {
-			return "", err
-		}
-	}
-
-	// Извлекаем номер и увеличиваем на 1
-	nextNumber := 1
-	if lastLoad.LoadNumber != "" {
-		// Парсим номер из строки вида "LD-20240115-001"
-		var num int
-		fmt.Sscanf(lastLoad.LoadNumber, prefix+"%d", &num)
-		nextNumber = num + 1
+	totals := []models.CurrencyAmount{}
+	if err = cursor.All(ctx, &totals); err != nil {
+		return nil, err
 	}
 
-	return fmt.Sprintf("%s%03d", prefix, nextNumber), nil
+	return totals, nil
 }
 
-// GetUnbilledByBroker получает грузы брокера, которые не оплачены или не привязаны к инвойсу
+// GetUnbilledByBroker получает доставленные грузы брокера, которые еще не привязаны к счету
 func (r *loadRepository) GetUnbilledByBroker(ctx context.Context, brokerID primitive.ObjectID, limit, offset int) ([]*models.Load, int64, error) {
-	// Aggregation pipeline для поиска грузов, которые:
-	// 1. Принадлежат указанному брокеру
-	// 2. Либо не имеют invoice_id (равен null или ObjectID("000000000000000000000000"))
-	// 3. Либо имеют invoice_id, но статус инвойса pending, partial, overdue
+	// Грузы без invoice_id (равен null или ObjectID("000000000000000000000000"))
 	pipeline := []bson.M{
 		{
 			"$match": bson.M{
-				"broker_id": brokerID,
-			},
-		},
-		{
-			"$lookup": bson.M{
-				"from":         "invoices",
-				"localField":   "invoice_id",
-				"foreignField": "_id",
-				"as":           "invoice",
-			},
-		},
-		{
-			"$match": bson.M{
-				"$or": []bson.M{
-					// Грузы без инвойса
-					{"invoice_id": bson.M{"$in": []interface{}{nil, primitive.NilObjectID}}},
-					// Грузы с неоплаченными инвойсами
-					{"invoice.status": bson.M{"$in": []string{"pending", "partial", "overdue"}}},
-				},
-			},
-		},
-		{
-			"$project": bson.M{
-				"invoice": 0, // Убираем invoice из результата
+				"broker_id":  brokerID,
+				"status":     models.LoadStatusDelivered,
+				"invoice_id": bson.M{"$in": []interface{}{nil, primitive.NilObjectID}},
 			},
 		},
 		{
@@ -390,6 +432,39 @@ func (r *loadRepository) GetUnbilledByBroker(ctx context.Context, brokerID primi
 	return loads, total, nil
 }
 
+// CountByStatus получает количество грузов по статусам
+func (r *loadRepository) CountByStatus(ctx context.Context) (map[string]int64, error) {
+	pipeline := []bson.M{
+		{
+			"$group": bson.M{
+				"_id":   "$status",
+				"count": bson.M{"$sum": 1},
+			},
+		},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Status string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(results))
+	for _, result := range results {
+		counts[result.Status] = result.Count
+	}
+
+	return counts, nil
+}
+
 // buildFilter строит MongoDB фильтр из структуры фильтра
 func (r *loadRepository) buildFilter(filter *models.LoadFilter) bson.M {
 	mongoFilter := bson.M{}
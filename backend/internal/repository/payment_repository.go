@@ -18,9 +18,23 @@ type paymentRepository struct {
 
 // NewPaymentRepository создает новый PaymentRepository
 func NewPaymentRepository(db *Database) PaymentRepository {
-	return &paymentRepository{
-		collection: db.GetCollection("payments"),
+	collection := db.GetCollection("payments")
+
+	// Платежи по счету выбираются по разнесениям; уникальный индекс операции выписки
+	// создается миграцией 0006_unique_indexes
+	indexModels := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "allocations.invoice_id", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "original_payment_id", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
 	}
+
+	collection.Indexes().CreateMany(context.Background(), indexModels)
+
+	return &paymentRepository{collection: collection}
 }
 
 // Create создает новый платеж
@@ -39,85 +53,16 @@ func (r *paymentRepository) GetByID(ctx context.Context, id primitive.ObjectID)
 	if err != nil {
 		return nil, err
 	}
+	r.calculateFields(&payment)
 	return &payment, nil
 }
 
 // GetAll получает все платежи с фильтрацией и пагинацией
 func (r *paymentRepository) GetAll(ctx context.Context, filter *models.PaymentFilter, limit, offset int) ([]*models.Payment, int64, error) {
-	// Используем aggregation pipeline для JOIN с brokers и invoices
-	pipeline := []bson.M{
-		// Первичная фильтрация
-		{
-			"$match": r.buildFilter(filter),
-		},
-		// JOIN с brokers для получения имени брокера
-		{
-			"$lookup": bson.M{
-				"from": "brokers",
-				"let":  bson.M{"broker_id": "$broker_id"},
-				"pipeline": []bson.M{
-					{
-						"$match": bson.M{
-							"$expr": bson.M{
-								"$eq": []interface{}{"$_id", "$$broker_id"},
-							},
-						},
-					},
-				},
-				"as": "broker",
-			},
-		},
-		// JOIN с invoices для получения номера инвойса
-		{
-			"$lookup": bson.M{
-				"from": "invoices",
-				"let":  bson.M{"invoice_id": "$invoice_id"},
-				"pipeline": []bson.M{
-					{
-						"$match": bson.M{
-							"$expr": bson.M{
-								"$eq": []interface{}{"$_id", "$$invoice_id"},
-							},
-						},
-					},
-				},
-				"as": "invoice",
-			},
-		},
-		// Добавляем поля broker_name и invoice_number
-		{
-			"$addFields": bson.M{
-				"broker_name": bson.M{
-					"$ifNull": []interface{}{
-						bson.M{"$arrayElemAt": []interface{}{"$broker.company_name", 0}},
-						"Unknown Broker",
-					},
-				},
-				"invoice_number": bson.M{
-					"$ifNull": []interface{}{
-						bson.M{"$arrayElemAt": []interface{}{"$invoice.invoice_number", 0}},
-						"Unknown Invoice",
-					},
-				},
-			},
-		},
-		// Удаляем временные поля
-		{
-			"$project": bson.M{
-				"broker":  0,
-				"invoice": 0,
-			},
-		},
-		// Сортировка
-		{
-			"$sort": bson.M{"payment_date": -1},
-		},
-	}
+	pipeline := r.listPipeline(filter)
 
 	// Подсчет общего количества
-	countPipeline := make([]bson.M, 3)
-	copy(countPipeline, pipeline[:3]) // Копируем только match и lookup'ы для подсчета
-	countPipeline = append(countPipeline, bson.M{"$count": "total"})
+	countPipeline := []bson.M{pipeline[0], {"$count": "total"}} // Для подсчета достаточно фильтрации
 
 	countCursor, err := r.collection.Aggregate(ctx, countPipeline)
 	if err != nil {
@@ -139,13 +84,13 @@ func (r *paymentRepository) GetAll(ctx context.Context, filter *models.PaymentFi
 		}
 	}
 
-	// Добавляем пагинацию к основному pipeline
-	dataPipeline := make([]bson.M, len(pipeline))
-	copy(dataPipeline, pipeline)
-	dataPipeline = append(dataPipeline, bson.M{"$skip": offset})
-	dataPipeline = append(dataPipeline, bson.M{"$limit": limit})
+	// Пагинация сразу после фильтрации и сортировки, чтобы JOIN выполнялся только для страницы
+	dataPipeline := make([]bson.M, 0, len(pipeline)+2)
+	dataPipeline = append(dataPipeline, pipeline[:2]...)
+	dataPipeline = append(dataPipeline, bson.M{"$skip": offset}, bson.M{"$limit": limit})
+	dataPipeline = append(dataPipeline, pipeline[2:]...)
 
-	cursor, err := r.collection.Aggregate(ctx, dataPipeline)
+	cursor, err := r.collection.Aggregate(ctx, dataPipeline, options.Aggregate().SetAllowDiskUse(true))
 	if err != nil {
 		return nil, 0, err
 	}
@@ -156,23 +101,50 @@ func (r *paymentRepository) GetAll(ctx context.Context, filter *models.PaymentFi
 		return nil, 0, err
 	}
 
+	for _, payment := range payments {
+		r.calculateFields(payment)
+	}
+
 	return payments, total, nil
 }
 
+// Iterate обходит все платежи по фильтру через курсор, без ограничения по количеству
+func (r *paymentRepository) Iterate(ctx context.Context, filter *models.PaymentFilter, fn func(*models.Payment) error) error {
+	cursor, err := r.OpenCursor(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return cursor.Each(ctx, fn)
+}
+
+// OpenCursor выполняет выборку платежей по фильтру и открывает курсор для ее обхода
+func (r *paymentRepository) OpenCursor(ctx context.Context, filter *models.PaymentFilter) (*Cursor[models.Payment], error) {
+	cursor, err := r.collection.Aggregate(ctx, r.listPipeline(filter), options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	return newCursor(cursor, r.calculateFields), nil
+}
+
 // Update обновляет платеж
 func (r *paymentRepository) Update(ctx context.Context, id primitive.ObjectID, payment *models.Payment) error {
 	update := bson.M{
 		"$set": bson.M{
-			"invoice_id":       payment.InvoiceID,
-			"broker_id":        payment.BrokerID,
-			"amount":           payment.Amount,
-			"currency":         payment.Currency,
-			"payment_date":     payment.PaymentDate,
-			"payment_method":   payment.PaymentMethod,
-			"transaction_id":   payment.TransactionID,
-			"reference_number": payment.ReferenceNumber,
-			"notes":            payment.Notes,
-			"created_by":       payment.CreatedBy,
+			"broker_id":          payment.BrokerID,
+			"amount":             payment.Amount,
+			"allocations":        payment.Allocations,
+			"unapplied_amount":   payment.UnappliedAmount,
+			"currency":           payment.Currency,
+			"exchange_rate":      payment.ExchangeRate,
+			"exchange_rate_base": payment.ExchangeRateBase,
+			"base_amount":        payment.BaseAmount,
+			"fx_gain_loss":       payment.FXGainLoss,
+			"payment_date":       payment.PaymentDate,
+			"payment_method":     payment.PaymentMethod,
+			"transaction_id":     payment.TransactionID,
+			"reference_number":   payment.ReferenceNumber,
+			"notes":              payment.Notes,
+			"created_by":         payment.CreatedBy,
 		},
 	}
 
@@ -186,9 +158,9 @@ func (r *paymentRepository) Delete(ctx context.Context, id primitive.ObjectID) e
 	return err
 }
 
-// GetByInvoice получает все платежи по счету
+// GetByInvoice получает все платежи, разнесенные на счет, с суммой разнесения на этот счет
 func (r *paymentRepository) GetByInvoice(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.Payment, error) {
-	filter := bson.M{"invoice_id": invoiceID}
+	filter := bson.M{"allocations.invoice_id": invoiceID}
 
 	opts := options.Find().SetSort(bson.M{"payment_date": -1})
 
@@ -203,6 +175,14 @@ func (r *paymentRepository) GetByInvoice(ctx context.Context, invoiceID primitiv
 		return nil, err
 	}
 
+	for _, payment := range payments {
+		r.calculateFields(payment)
+		payment.InvoiceID = invoiceID
+		if allocation := payment.AllocationFor(invoiceID); allocation != nil {
+			payment.AllocatedAmount = allocation.Amount
+		}
+	}
+
 	return payments, nil
 }
 
@@ -233,19 +213,284 @@ func (r *paymentRepository) GetByBroker(ctx context.Context, brokerID primitive.
 		return nil, 0, err
 	}
 
+	for _, payment := range payments {
+		r.calculateFields(payment)
+	}
+
 	return payments, total, nil
 }
 
-// GetTotalPaidAmount получает общую сумму платежей по счету
-func (r *paymentRepository) GetTotalPaidAmount(ctx context.Context, invoiceID primitive.ObjectID) (float64, error) {
+// GetTotalPaidAmount получает сумму платежей, разнесенную на счет
+func (r *paymentRepository) GetTotalPaidAmount(ctx context.Context, invoiceID primitive.ObjectID) (models.Amount, error) {
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{"allocations.invoice_id": invoiceID},
+		},
+		{
+			"$unwind": "$allocations",
+		},
+		{
+			"$match": bson.M{"allocations.invoice_id": invoiceID},
+		},
+		{
+			"$group": bson.M{
+				"_id":   nil,
+				"total": bson.M{"$sum": "$allocations.amount"},
+			},
+		},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Total models.Amount `bson:"total"`
+	}
+
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, err
+		}
+	}
+
+	return result.Total, nil
+}
+
+// MarkReversed связывает платеж с его сторно. Возвращает false, если платеж уже отменен.
+func (r *paymentRepository) MarkReversed(ctx context.Context, id, reversalID primitive.ObjectID) (bool, error) {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "reversal_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"reversal_id": reversalID}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// AddRefund списывает возврат с неразнесенного остатка платежа.
+// Возвращает false, если платеж отменен или остатка недостаточно.
+func (r *paymentRepository) AddRefund(ctx context.Context, id primitive.ObjectID, amount models.Amount) (bool, error) {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{
+			"_id":              id,
+			"type":             models.PaymentTypePayment,
+			"reversal_id":      bson.M{"$exists": false},
+			"unapplied_amount": bson.M{"$gte": amount},
+		},
+		bson.M{"$inc": bson.M{"refunded_amount": amount, "unapplied_amount": -amount}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// GetByOriginal получает сторно и возвраты платежа
+func (r *paymentRepository) GetByOriginal(ctx context.Context, originalID primitive.ObjectID) ([]*models.Payment, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "payment_date", Value: 1}, {Key: "created_at", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, bson.M{"original_payment_id": originalID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	payments := []*models.Payment{}
+	if err = cursor.All(ctx, &payments); err != nil {
+		return nil, err
+	}
+
+	for _, payment := range payments {
+		r.calculateFields(payment)
+	}
+
+	return payments, nil
+}
+
+// GetUnapplied получает платежи брокера с неразнесенным остатком, начиная с самых ранних.
+// Отмененные платежи не учитываются. Пустая валюта означает все валюты.
+func (r *paymentRepository) GetUnapplied(ctx context.Context, brokerID primitive.ObjectID, currency string) ([]*models.Payment, error) {
+	filter := bson.M{
+		"broker_id":        brokerID,
+		"unapplied_amount": bson.M{"$gt": 0},
+		"reversal_id":      bson.M{"$exists": false},
+	}
+	if currency != "" {
+		filter["currency"] = currency
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "payment_date", Value: 1}, {Key: "created_at", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	payments := []*models.Payment{}
+	if err = cursor.All(ctx, &payments); err != nil {
+		return nil, err
+	}
+
+	for _, payment := range payments {
+		r.calculateFields(payment)
+	}
+
+	return payments, nil
+}
+
+// GetBrokerCredit получает неразнесенный остаток платежей брокера по валютам
+func (r *paymentRepository) GetBrokerCredit(ctx context.Context, brokerID primitive.ObjectID) ([]models.BrokerCredit, error) {
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"broker_id":        brokerID,
+				"unapplied_amount": bson.M{"$ne": 0},
+			},
+		},
+		{
+			"$group": bson.M{
+				"_id":    "$currency",
+				"amount": bson.M{"$sum": "$unapplied_amount"},
+			},
+		},
+		// Сторно гасит неразнесенный остаток исходного платежа
+		{
+			"$match": bson.M{"amount": bson.M{"$ne": 0}},
+		},
+		{
+			"$sort": bson.M{"_id": 1},
+		},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	credits := []models.BrokerCredit{}
+	if err = cursor.All(ctx, &credits); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// GetDailyTotals получает суммы платежей по дням в разрезе валют за период. Дни считаются в часовом поясе from.
+func (r *paymentRepository) GetDailyTotals(ctx context.Context, from, to time.Time, baseCurrency string) ([]models.PaymentByDay, error) {
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"payment_date": bson.M{"$gte": from, "$lte": to},
+			},
+		},
+	}
+	pipeline = append(pipeline, baseRateStages(baseCurrency, "payment_date")...)
+	pipeline = append(pipeline, []bson.M{
+		{
+			"$group": bson.M{
+				"_id": bson.M{
+					"day": bson.M{
+						"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$payment_date", "timezone": from.Location().String()},
+					},
+					"currency": "$currency",
+				},
+				"amount":      bson.M{"$sum": "$amount"},
+				"base_amount": bson.M{"$sum": baseAmountExpr("$amount")},
+				"count":       bson.M{"$sum": 1},
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":         0,
+				"date":        bson.M{"$dateFromString": bson.M{"dateString": "$_id.day"}},
+				"currency":    "$_id.currency",
+				"amount":      1,
+				"base_amount": 1,
+				"count":       1,
+			},
+		},
+		{
+			"$sort": bson.M{"date": 1, "currency": 1},
+		},
+	}...)
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	totals := []models.PaymentByDay{}
+	if err = cursor.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+
+	return totals, nil
+}
+
+// SumByFilter получает сумму платежей по фильтру в разрезе валют
+func (r *paymentRepository) SumByFilter(ctx context.Context, filter *models.PaymentFilter, baseCurrency string) ([]models.CurrencyAmount, error) {
+	pipeline := []bson.M{
+		{
+			"$match": r.buildFilter(filter),
+		},
+	}
+	pipeline = append(pipeline, baseRateStages(baseCurrency, "payment_date")...)
+	pipeline = append(pipeline, []bson.M{
+		{
+			"$group": bson.M{
+				"_id":           "$currency",
+				"amount":        bson.M{"$sum": "$amount"},
+				"base_amount":   bson.M{"$sum": baseAmountExpr("$amount")},
+				"count":         bson.M{"$sum": 1},
+				"missing_rates": bson.M{"$sum": missingRateExpr()},
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":           0,
+				"currency":      "$_id",
+				"amount":        1,
+				"base_amount":   1,
+				"count":         1,
+				"missing_rates": 1,
+			},
+		},
+		{
+			"$sort": bson.M{"currency": 1},
+		},
+	}...)
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	totals := []models.CurrencyAmount{}
+	if err = cursor.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+
+	return totals, nil
+}
+
+// SumFXGainLoss получает реализованную курсовую разницу по платежам фильтра в базовой валюте
+func (r *paymentRepository) SumFXGainLoss(ctx context.Context, filter *models.PaymentFilter) (models.Amount, error) {
 	pipeline := []bson.M{
 		{
-			"$match": bson.M{"invoice_id": invoiceID},
+			"$match": r.buildFilter(filter),
 		},
 		{
 			"$group": bson.M{
 				"_id":   nil,
-				"total": bson.M{"$sum": "$amount"},
+				"total": bson.M{"$sum": "$fx_gain_loss"},
 			},
 		},
 	}
@@ -257,7 +502,7 @@ func (r *paymentRepository) GetTotalPaidAmount(ctx context.Context, invoiceID pr
 	defer cursor.Close(ctx)
 
 	var result struct {
-		Total float64 `bson:"total"`
+		Total models.Amount `bson:"total"`
 	}
 
 	if cursor.Next(ctx) {
@@ -269,6 +514,104 @@ func (r *paymentRepository) GetTotalPaidAmount(ctx context.Context, invoiceID pr
 	return result.Total, nil
 }
 
+// listPipeline строит pipeline выборки платежей с именем брокера и номером счета
+func (r *paymentRepository) listPipeline(filter *models.PaymentFilter) []bson.M {
+	// Используем aggregation pipeline для JOIN с brokers и invoices
+	return []bson.M{
+		// Первичная фильтрация
+		{
+			"$match": r.buildFilter(filter),
+		},
+		// Сортировка сразу после фильтрации, до JOIN: она идет по индексу, а не по результатам lookup
+		{
+			"$sort": bson.D{{Key: "payment_date", Value: -1}, {Key: "_id", Value: -1}},
+		},
+		// JOIN с brokers для получения имени брокера
+		{
+			"$lookup": bson.M{
+				"from": "brokers",
+				"let":  bson.M{"broker_id": "$broker_id"},
+				"pipeline": []bson.M{
+					{
+						"$match": bson.M{
+							"$expr": bson.M{
+								"$eq": []interface{}{"$_id", "$$broker_id"},
+							},
+						},
+					},
+				},
+				"as": "broker",
+			},
+		},
+		// JOIN с invoices для получения номеров счетов разнесений
+		{
+			"$lookup": bson.M{
+				"from": "invoices",
+				"let":  bson.M{"invoice_ids": bson.M{"$ifNull": []interface{}{"$allocations.invoice_id", []interface{}{}}}},
+				"pipeline": []bson.M{
+					{
+						"$match": bson.M{
+							"$expr": bson.M{
+								"$in": []interface{}{"$_id", "$$invoice_ids"},
+							},
+						},
+					},
+					{
+						"$project": bson.M{"invoice_number": 1},
+					},
+				},
+				"as": "invoices",
+			},
+		},
+		// Добавляем имя брокера и номер счета в каждое разнесение
+		{
+			"$addFields": bson.M{
+				"broker_name": bson.M{
+					"$ifNull": []interface{}{
+						bson.M{"$arrayElemAt": []interface{}{"$broker.company_name", 0}},
+						"Unknown Broker",
+					},
+				},
+				"allocations": bson.M{
+					"$map": bson.M{
+						"input": bson.M{"$ifNull": []interface{}{"$allocations", []interface{}{}}},
+						"as":    "allocation",
+						"in": bson.M{
+							"$mergeObjects": []interface{}{
+								"$$allocation",
+								bson.M{"invoice_number": allocationInvoiceNumberExpr()},
+							},
+						},
+					},
+				},
+			},
+		},
+		// Номера счетов платежа одной строкой; у неразнесенного платежа строка пустая
+		{
+			"$addFields": bson.M{
+				"invoice_number": bson.M{
+					"$reduce": bson.M{
+						"input":        "$allocations.invoice_number",
+						"initialValue": "",
+						"in": bson.M{"$cond": []interface{}{
+							bson.M{"$eq": []interface{}{"$$value", ""}},
+							"$$this",
+							bson.M{"$concat": []interface{}{"$$value", ", ", "$$this"}},
+						}},
+					},
+				},
+			},
+		},
+		// Удаляем временные поля
+		{
+			"$project": bson.M{
+				"broker":   0,
+				"invoices": 0,
+			},
+		},
+	}
+}
+
 // buildFilter строит MongoDB фильтр из структуры фильтра
 func (r *paymentRepository) buildFilter(filter *models.PaymentFilter) bson.M {
 	mongoFilter := bson.M{}
@@ -278,7 +621,7 @@ func (r *paymentRepository) buildFilter(filter *models.PaymentFilter) bson.M {
 	}
 
 	if !filter.InvoiceID.IsZero() {
-		mongoFilter["invoice_id"] = filter.InvoiceID
+		mongoFilter["allocations.invoice_id"] = filter.InvoiceID
 	}
 
 	if !filter.BrokerID.IsZero() {
@@ -289,6 +632,10 @@ func (r *paymentRepository) buildFilter(filter *models.PaymentFilter) bson.M {
 		mongoFilter["payment_method"] = filter.PaymentMethod
 	}
 
+	if filter.Type != "" {
+		mongoFilter["type"] = filter.Type
+	}
+
 	if filter.Currency != "" {
 		mongoFilter["currency"] = filter.Currency
 	}
@@ -317,3 +664,31 @@ func (r *paymentRepository) buildFilter(filter *models.PaymentFilter) bson.M {
 
 	return mongoFilter
 }
+
+// allocationInvoiceNumberExpr выражение номера счета разнесения $$allocation среди найденных счетов $invoices
+func allocationInvoiceNumberExpr() bson.M {
+	matched := bson.M{
+		"$filter": bson.M{
+			"input": "$invoices",
+			"as":    "invoice",
+			"cond":  bson.M{"$eq": []interface{}{"$$invoice._id", "$$allocation.invoice_id"}},
+		},
+	}
+
+	return bson.M{
+		"$ifNull": []interface{}{
+			bson.M{"$arrayElemAt": []interface{}{
+				bson.M{"$map": bson.M{"input": matched, "as": "invoice", "in": "$$invoice.invoice_number"}},
+				0,
+			}},
+			"Unknown Invoice",
+		},
+	}
+}
+
+// calculateFields вычисляет дополнительные поля: платеж с одним разнесением показывает его счет
+func (r *paymentRepository) calculateFields(payment *models.Payment) {
+	if len(payment.Allocations) == 1 {
+		payment.InvoiceID = payment.Allocations[0].InvoiceID
+	}
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"billing-system/config"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Последовательности номеров документов
+const (
+	SequenceInvoice    = "invoice"
+	SequenceLoad       = "load"
+	SequenceCreditNote = "credit_note"
+)
+
+// maxSequenceAttempts повторы выдачи номера при одновременном создании счетчика нового периода
+const maxSequenceAttempts = 3
+
+// sequence атомарный счетчик номеров документа в коллекции counters.
+// Для каждого периода формата (например, месяца) заводится свой счетчик с ключом "<имя>:<номер без {seq}>".
+type sequence struct {
+	counters *mongo.Collection
+	name     string
+	format   config.NumberFormat
+}
+
+// newSequence создает последовательность name с форматом номера format
+func newSequence(db *Database, name string, format config.NumberFormat) *sequence {
+	return &sequence{
+		counters: db.GetCollection("counters"),
+		name:     name,
+		format:   format,
+	}
+}
+
+// Next выдает следующий номер на дату date. Счетчик увеличивается одной операцией findOneAndUpdate,
+// поэтому одновременные запросы получают разные номера.
+func (s *sequence) Next(ctx context.Context, date time.Time) (string, error) {
+	key := s.name + ":" + s.format.Period(date)
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+
+	var err error
+	for attempt := 0; attempt < maxSequenceAttempts; attempt++ {
+		err = s.counters.FindOneAndUpdate(ctx, bson.M{"_id": key}, bson.M{"$inc": bson.M{"seq": 1}}, opts).Decode(&counter)
+		// Два первых номера периода могут одновременно создавать счетчик; проигравший повторяет инкремент
+		if !mongo.IsDuplicateKeyError(err) {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return s.format.Render(date, counter.Seq), nil
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UnitOfWork выполняет несколько операций репозиториев атомарно
+type UnitOfWork interface {
+	// Do выполняет fn в транзакции; все репозитории внутри fn должны получать переданный ctx.
+	// Ошибка fn откатывает все изменения, сделанные внутри fn.
+	Do(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Do выполняет fn в транзакции MongoDB.
+// Вложенный вызов выполняется в уже открытой транзакции.
+func (d *Database) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	session, err := d.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+// supportsTransactions проверяет, что MongoDB запущена как replica set или mongos
+func supportsTransactions(ctx context.Context, client *mongo.Client) bool {
+	var result bson.M
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&result)
+	if err != nil {
+		return false
+	}
+
+	if _, ok := result["setName"]; ok {
+		return true
+	}
+	return result["msg"] == "isdbgrid"
+}
@@ -0,0 +1,85 @@
+package scheduler
+
+import (
+	"billing-system/internal/services"
+	"context"
+	"fmt"
+)
+
+// Имена фоновых задач
+const (
+	JobOverdueSweep      = "overdue_sweep"
+	JobOverdueReminders  = "overdue_reminders"
+	JobLateFees          = "late_fees"
+	JobRecurringInvoices = "recurring_invoices"
+)
+
+// OverdueSweepJob переводит просроченные неоплаченные счета в статус overdue
+func OverdueSweepJob(schedule string, invoiceService services.InvoiceService) Job {
+	return Job{
+		Name:     JobOverdueSweep,
+		Schedule: schedule,
+		Run: func(ctx context.Context) (string, error) {
+			count, err := invoiceService.MarkOverdueInvoices(ctx)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d invoices marked overdue", count), nil
+		},
+	}
+}
+
+// OverdueRemindersJob рассылает поэтапные напоминания о просроченных счетах
+func OverdueRemindersJob(schedule string, dunningService services.DunningService) Job {
+	return Job{
+		Name:     JobOverdueReminders,
+		Schedule: schedule,
+		Run: func(ctx context.Context) (string, error) {
+			summary, err := dunningService.Run(ctx)
+			if err != nil {
+				return "", err
+			}
+			if summary.Failed > 0 {
+				return "", fmt.Errorf("%d of %d notices failed", summary.Failed, summary.NoticesSent+summary.Failed)
+			}
+			return fmt.Sprintf("%d invoices checked, %d notices sent, %d credit holds",
+				summary.InvoicesChecked, summary.NoticesSent, summary.CreditHolds), nil
+		},
+	}
+}
+
+// LateFeesJob начисляет пени по просроченным счетам
+func LateFeesJob(schedule string, lateFeeService services.LateFeeService) Job {
+	return Job{
+		Name:     JobLateFees,
+		Schedule: schedule,
+		Run: func(ctx context.Context) (string, error) {
+			summary, err := lateFeeService.Run(ctx)
+			if err != nil {
+				return "", err
+			}
+			if summary.Failed > 0 {
+				return "", fmt.Errorf("%d of %d late fees failed", summary.Failed, summary.FeesCreated+summary.Failed)
+			}
+			return fmt.Sprintf("%d invoices checked, %d late fees created", summary.InvoicesChecked, summary.FeesCreated), nil
+		},
+	}
+}
+
+// RecurringInvoicesJob выставляет счета по наступившим периодам расписаний регулярных счетов
+func RecurringInvoicesJob(schedule string, billingScheduleService services.BillingScheduleService) Job {
+	return Job{
+		Name:     JobRecurringInvoices,
+		Schedule: schedule,
+		Run: func(ctx context.Context) (string, error) {
+			summary, err := billingScheduleService.Run(ctx)
+			if err != nil {
+				return "", err
+			}
+			if summary.Failed > 0 {
+				return "", fmt.Errorf("%d of %d scheduled invoices failed", summary.Failed, summary.InvoicesCreated+summary.Failed)
+			}
+			return fmt.Sprintf("%d schedules checked, %d invoices created", summary.SchedulesChecked, summary.InvoicesCreated), nil
+		},
+	}
+}
@@ -0,0 +1,178 @@
+package scheduler
+
+import (
+	"billing-system/config"
+	"billing-system/internal/models"
+	"billing-system/internal/repository"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Job фоновая задача по расписанию
+type Job struct {
+	Name     string
+	Schedule string // cron-выражение, пустое отключает задачу
+	// Run выполняет задачу и возвращает краткий итог для журнала запусков
+	Run func(ctx context.Context) (string, error)
+}
+
+// Scheduler запускает фоновые задачи по расписанию.
+// Каждый запуск записывается в job_runs, а блокировка в job_locks не дает двум экземплярам выполнить один и тот же запуск.
+type Scheduler struct {
+	cron     *cron.Cron
+	jobRepo  repository.JobRepository
+	owner    string
+	lockTTL  time.Duration
+	location *time.Location
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New создает новый Scheduler
+func New(jobRepo repository.JobRepository, cfg config.SchedulerConfig) (*Scheduler, error) {
+	location, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scheduler timezone %q: %w", cfg.Timezone, err)
+	}
+
+	lockTTL := time.Duration(cfg.LockTTLMinutes) * time.Minute
+	if lockTTL <= 0 {
+		lockTTL = 30 * time.Minute
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Scheduler{
+		cron:     cron.New(cron.WithLocation(location)),
+		jobRepo:  jobRepo,
+		owner:    instanceName(),
+		lockTTL:  lockTTL,
+		location: location,
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// Register добавляет задачу в расписание
+func (s *Scheduler) Register(job Job) error {
+	if job.Schedule == "" {
+		log.Printf("Задача %s отключена: расписание не задано", job.Name)
+		return nil
+	}
+
+	_, err := s.cron.AddFunc(job.Schedule, func() {
+		s.run(job)
+	})
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q for job %s: %w", job.Schedule, job.Name, err)
+	}
+
+	log.Printf("Задача %s запланирована: %s", job.Name, job.Schedule)
+	return nil
+}
+
+// Start запускает планировщик
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop останавливает планировщик и ждет завершения выполняющихся задач
+func (s *Scheduler) Stop(ctx context.Context) {
+	cronCtx := s.cron.Stop()
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		<-cronCtx.Done()
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Фоновые задачи не завершились до остановки сервера")
+	}
+}
+
+// run выполняет задачу, если удалось взять блокировку на текущий запуск
+func (s *Scheduler) run(job Job) {
+	s.wg.Add(1)
+	defer s.wg.Done()
+
+	// Запуск по расписанию определяется минутой срабатывания, она одинакова у всех экземпляров
+	slot := time.Now().In(s.location).Truncate(time.Minute)
+
+	acquired, err := s.jobRepo.AcquireLock(s.ctx, job.Name, s.owner, slot, s.lockTTL)
+	if err != nil {
+		log.Printf("Ошибка блокировки задачи %s: %v", job.Name, err)
+		return
+	}
+	if !acquired {
+		return
+	}
+	defer func() {
+		if err := s.jobRepo.ReleaseLock(context.Background(), job.Name, s.owner); err != nil {
+			log.Printf("Ошибка снятия блокировки задачи %s: %v", job.Name, err)
+		}
+	}()
+
+	run := &models.JobRun{
+		Job:       job.Name,
+		Status:    models.JobRunStatusRunning,
+		Owner:     s.owner,
+		StartedAt: time.Now(),
+	}
+	if err := s.jobRepo.CreateRun(s.ctx, run); err != nil {
+		log.Printf("Ошибка записи запуска задачи %s: %v", job.Name, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.lockTTL)
+	defer cancel()
+
+	result, err := s.execute(ctx, job)
+
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	run.DurationMs = finishedAt.Sub(run.StartedAt).Milliseconds()
+	run.Result = result
+	run.Status = models.JobRunStatusSuccess
+	if err != nil {
+		run.Status = models.JobRunStatusFailed
+		run.Error = err.Error()
+		log.Printf("Задача %s завершилась с ошибкой: %v", job.Name, err)
+	}
+
+	if err := s.jobRepo.FinishRun(context.Background(), run); err != nil {
+		log.Printf("Ошибка записи результата задачи %s: %v", job.Name, err)
+	}
+}
+
+// execute выполняет задачу, превращая panic в ошибку
+func (s *Scheduler) execute(ctx context.Context, job Job) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return job.Run(ctx)
+}
+
+// instanceName возвращает имя экземпляра приложения для блокировок
+func instanceName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
@@ -0,0 +1,354 @@
+package services
+
+import (
+	"billing-system/config"
+	"billing-system/internal/models"
+	"billing-system/internal/repository"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// numberSeparatorPattern разделитель номера, который в назначении платежа может быть заменен или пропущен
+const numberSeparatorPattern = `[\s\-_/.]?`
+
+// legalFormWords организационно-правовые формы, не влияющие на сравнение имен
+var legalFormWords = map[string]bool{
+	"llc": true, "inc": true, "ltd": true, "corp": true, "corporation": true, "co": true, "company": true,
+	"lp": true, "llp": true, "plc": true, "gmbh": true, "ооо": true, "зао": true, "оао": true, "пао": true, "ао": true, "ип": true,
+}
+
+// minBrokerNameLength минимальная длина имени для сравнения по вхождению
+const minBrokerNameLength = 4
+
+// brokerName нормализованное имя брокера для сопоставления с плательщиком
+type brokerName struct {
+	id   primitive.ObjectID
+	name string
+}
+
+// bankMatcher предлагает брокера и разнесение для поступлений одной выписки.
+// Суммы, уже предложенные другим поступлениям, резервируются, чтобы не разносить один остаток дважды.
+type bankMatcher struct {
+	invoiceRepo    repository.InvoiceRepository
+	invoiceNumbers *invoiceNumberFinder
+	brokers        []brokerName
+	reserved       map[primitive.ObjectID]models.Amount
+}
+
+// newBankMatcher загружает имена брокеров для сопоставления
+func newBankMatcher(ctx context.Context, invoiceRepo repository.InvoiceRepository, brokerRepo repository.BrokerRepository, invoiceNumbers *invoiceNumberFinder) (*bankMatcher, error) {
+	matcher := &bankMatcher{
+		invoiceRepo:    invoiceRepo,
+		invoiceNumbers: invoiceNumbers,
+		reserved:       make(map[primitive.ObjectID]models.Amount),
+	}
+
+	err := brokerRepo.Iterate(ctx, func(broker *models.Broker) error {
+		if name := normalizeCompanyName(broker.CompanyName); name != "" {
+			matcher.brokers = append(matcher.brokers, brokerName{id: broker.ID, name: name})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return matcher, nil
+}
+
+// match заполняет брокера, разнесение, признаки и статус поступления.
+// Брокер, заданный заранее (при разделении операции), не меняется.
+func (m *bankMatcher) match(ctx context.Context, transaction *models.BankTransaction) error {
+	transaction.Allocations = []models.PaymentAllocation{}
+	transaction.MatchReasons = []string{}
+	if !transaction.BrokerID.IsZero() {
+		transaction.MatchReasons = append(transaction.MatchReasons, models.MatchReasonManual)
+	}
+
+	// Номера счетов в референсе и назначении платежа
+	invoices, err := m.referencedInvoices(ctx, transaction)
+	if err != nil {
+		return err
+	}
+	if len(invoices) > 0 {
+		transaction.BrokerID = invoices[0].BrokerID
+		transaction.MatchReasons = append(transaction.MatchReasons, models.MatchReasonInvoiceNumber)
+		m.allocate(transaction, invoices)
+	}
+
+	// Имя плательщика
+	if transaction.BrokerID.IsZero() {
+		if brokerID, ok := m.brokerByName(transaction.PayerName); ok {
+			transaction.BrokerID = brokerID
+			transaction.MatchReasons = append(transaction.MatchReasons, models.MatchReasonBrokerName)
+		}
+	}
+
+	if len(transaction.Allocations) == 0 {
+		if transaction.BrokerID.IsZero() {
+			err = m.matchByAmount(ctx, transaction)
+		} else {
+			err = m.matchBrokerInvoices(ctx, transaction)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	transaction.Status = models.BankTransactionStatusUnmatched
+	if !transaction.BrokerID.IsZero() {
+		transaction.Status = models.BankTransactionStatusMatched
+	}
+
+	return nil
+}
+
+// referencedInvoices находит открытые счета в валюте поступления по номерам из референса и назначения платежа.
+// Счета другого брокера, чем первый найденный или заданный, не учитываются.
+func (m *bankMatcher) referencedInvoices(ctx context.Context, transaction *models.BankTransaction) ([]*models.Invoice, error) {
+	numbers := m.invoiceNumbers.find(transaction.ReferenceNumber + " " + transaction.Memo)
+	if len(numbers) == 0 {
+		return nil, nil
+	}
+
+	found, err := m.invoiceRepo.GetByNumbers(ctx, numbers)
+	if err != nil {
+		return nil, err
+	}
+
+	byNumber := make(map[string]*models.Invoice, len(found))
+	for _, invoice := range found {
+		byNumber[invoice.InvoiceNumber] = invoice
+	}
+
+	brokerID := transaction.BrokerID
+	var invoices []*models.Invoice
+	for _, number := range numbers {
+		invoice, ok := byNumber[number]
+		if !ok || !invoice.IsOpen() || invoice.Currency != transaction.Currency {
+			continue
+		}
+		if brokerID.IsZero() {
+			brokerID = invoice.BrokerID
+		}
+		if invoice.BrokerID == brokerID {
+			invoices = append(invoices, invoice)
+		}
+	}
+
+	return invoices, nil
+}
+
+// matchBrokerInvoices разносит поступление известного брокера: на счет с остатком, равным сумме,
+// на все открытые счета, если сумма равна их общему остатку, иначе по самым ранним срокам оплаты
+func (m *bankMatcher) matchBrokerInvoices(ctx context.Context, transaction *models.BankTransaction) error {
+	open, err := m.invoiceRepo.GetOpenByBroker(ctx, transaction.BrokerID, transaction.Currency)
+	if err != nil {
+		return err
+	}
+
+	var total models.Amount
+	for _, invoice := range open {
+		remaining := m.remaining(invoice)
+		if remaining == transaction.Amount {
+			m.allocate(transaction, []*models.Invoice{invoice})
+			transaction.MatchReasons = append(transaction.MatchReasons, models.MatchReasonAmount)
+			return nil
+		}
+		total += remaining
+	}
+
+	if len(open) == 0 {
+		return nil
+	}
+
+	m.allocate(transaction, open)
+	if total == transaction.Amount {
+		transaction.MatchReasons = append(transaction.MatchReasons, models.MatchReasonAmount)
+	} else {
+		transaction.MatchReasons = append(transaction.MatchReasons, models.MatchReasonOldestDue)
+	}
+	return nil
+}
+
+// matchByAmount ищет единственный открытый счет любого брокера с остатком, равным сумме поступления
+func (m *bankMatcher) matchByAmount(ctx context.Context, transaction *models.BankTransaction) error {
+	candidates, err := m.invoiceRepo.GetOpenByRemaining(ctx, transaction.Currency, transaction.Amount)
+	if err != nil {
+		return err
+	}
+
+	var match *models.Invoice
+	for _, invoice := range candidates {
+		if m.reserved[invoice.ID] > 0 {
+			continue
+		}
+		if match != nil {
+			// Несколько счетов с такой суммой - выбор за пользователем
+			return nil
+		}
+		match = invoice
+	}
+	if match == nil {
+		return nil
+	}
+
+	transaction.BrokerID = match.BrokerID
+	transaction.MatchReasons = append(transaction.MatchReasons, models.MatchReasonAmount)
+	m.allocate(transaction, []*models.Invoice{match})
+	return nil
+}
+
+// allocate разносит поступление на счета по порядку в пределах их остатков за вычетом зарезервированного
+func (m *bankMatcher) allocate(transaction *models.BankTransaction, invoices []*models.Invoice) {
+	left := transaction.Amount - transaction.AllocatedAmount()
+	for _, invoice := range invoices {
+		if left <= 0 {
+			break
+		}
+
+		amount := m.remaining(invoice)
+		if amount <= 0 {
+			continue
+		}
+		if amount > left {
+			amount = left
+		}
+
+		transaction.Allocations = append(transaction.Allocations, models.PaymentAllocation{
+			InvoiceID: invoice.ID,
+			Amount:    amount,
+		})
+		m.reserved[invoice.ID] += amount
+		left -= amount
+	}
+}
+
+// remaining остаток счета, еще не предложенный другим поступлениям
+func (m *bankMatcher) remaining(invoice *models.Invoice) models.Amount {
+	return invoice.RemainingAmount - m.reserved[invoice.ID]
+}
+
+// brokerByName находит брокера по имени плательщика: точное совпадение нормализованных имен
+// или, если его нет, единственное вхождение одного имени в другое
+func (m *bankMatcher) brokerByName(payer string) (primitive.ObjectID, bool) {
+	name := normalizeCompanyName(payer)
+	if name == "" {
+		return primitive.NilObjectID, false
+	}
+
+	var exact, partial []primitive.ObjectID
+	for _, broker := range m.brokers {
+		switch {
+		case broker.name == name:
+			exact = append(exact, broker.id)
+		case len(broker.name) >= minBrokerNameLength && len(name) >= minBrokerNameLength &&
+			(strings.Contains(name, broker.name) || strings.Contains(broker.name, name)):
+			partial = append(partial, broker.id)
+		}
+	}
+
+	if len(exact) == 1 {
+		return exact[0], true
+	}
+	if len(exact) == 0 && len(partial) == 1 {
+		return partial[0], true
+	}
+	return primitive.NilObjectID, false
+}
+
+// invoiceNumberFinder находит в тексте номера счетов заданного формата. Разделители формата могут быть
+// заменены пробелом или пропущены, регистр букв не важен: INV-202401-0001, inv 202401 0001, INV2024010001.
+type invoiceNumberFinder struct {
+	pattern  *regexp.Regexp
+	literals []string // части формата по порядку; на месте токенов пустые строки
+	tokens   []bool
+}
+
+// newInvoiceNumberFinder строит выражение поиска по формату номера счета
+func newInvoiceNumberFinder(format config.NumberFormat) *invoiceNumberFinder {
+	parts, tokens := format.Tokens()
+
+	var expr strings.Builder
+	expr.WriteString(`(?i)\b`)
+	for i, part := range parts {
+		if !tokens[i] {
+			for _, r := range part {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) {
+					expr.WriteString(regexp.QuoteMeta(string(r)))
+				} else {
+					expr.WriteString(numberSeparatorPattern)
+				}
+			}
+			continue
+		}
+
+		switch {
+		case part == "YYYY":
+			expr.WriteString(`(\d{4})`)
+		case part == "YY" || part == "MM" || part == "DD":
+			expr.WriteString(`(\d{2})`)
+		default:
+			width := 1
+			if _, digits, ok := strings.Cut(part, ":"); ok {
+				if n, err := strconv.Atoi(digits); err == nil && n > 0 {
+					width = n
+				}
+			}
+			fmt.Fprintf(&expr, `(\d{%d,})`, width)
+		}
+	}
+	expr.WriteString(`\b`)
+
+	return &invoiceNumberFinder{
+		pattern:  regexp.MustCompile(expr.String()),
+		literals: parts,
+		tokens:   tokens,
+	}
+}
+
+// find извлекает номера счетов из текста в каноническом виде без повторов
+func (f *invoiceNumberFinder) find(text string) []string {
+	var numbers []string
+	seen := make(map[string]bool)
+	for _, match := range f.pattern.FindAllStringSubmatch(text, -1) {
+		var number strings.Builder
+		group := 1
+		for i, part := range f.literals {
+			if f.tokens[i] {
+				number.WriteString(match[group])
+				group++
+			} else {
+				number.WriteString(part)
+			}
+		}
+
+		if !seen[number.String()] {
+			seen[number.String()] = true
+			numbers = append(numbers, number.String())
+		}
+	}
+	return numbers
+}
+
+// normalizeCompanyName приводит имя компании к виду для сравнения:
+// нижний регистр, без знаков препинания и организационно-правовой формы
+func normalizeCompanyName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	result := words[:0]
+	for _, word := range words {
+		if !legalFormWords[word] {
+			result = append(result, word)
+		}
+	}
+	return strings.Join(result, " ")
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"billing-system/config"
+	"billing-system/internal/models"
+	"billing-system/internal/repository"
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// openInvoiceRepo InvoiceRepository с открытыми счетами в памяти; остальные методы не реализованы
+type openInvoiceRepo struct {
+	repository.InvoiceRepository
+	open []*models.Invoice
+}
+
+// GetOpenByBroker возвращает открытые счета брокера в валюте currency в порядке хранения
+func (r *openInvoiceRepo) GetOpenByBroker(ctx context.Context, brokerID primitive.ObjectID, currency string) ([]*models.Invoice, error) {
+	var invoices []*models.Invoice
+	for _, invoice := range r.open {
+		if invoice.BrokerID == brokerID && invoice.Currency == currency {
+			invoices = append(invoices, invoice)
+		}
+	}
+	return invoices, nil
+}
+
+func TestInvoiceNumberFinderFind(t *testing.T) {
+	invoiceFormat := config.NumberFormat{Prefix: "INV-", Pattern: "{YYYY}{MM}-{seq:4}"}
+
+	tests := []struct {
+		name   string
+		format config.NumberFormat
+		text   string
+		want   []string
+	}{
+		{name: "canonical", format: invoiceFormat, text: "Payment for INV-202401-0001", want: []string{"INV-202401-0001"}},
+		{name: "lower case with spaces", format: invoiceFormat, text: "inv 202401 0001", want: []string{"INV-202401-0001"}},
+		{name: "separators omitted", format: invoiceFormat, text: "INV2024010001", want: []string{"INV-202401-0001"}},
+		{name: "other separators", format: invoiceFormat, text: "ref inv_202401/0001.", want: []string{"INV-202401-0001"}},
+		{name: "longer sequence", format: invoiceFormat, text: "INV-202401-12345", want: []string{"INV-202401-12345"}},
+		{
+			name:   "several without repeats",
+			format: invoiceFormat,
+			text:   "INV-202401-0001, INV-202401-0002 and inv-202401-0001",
+			want:   []string{"INV-202401-0001", "INV-202401-0002"},
+		},
+		{name: "short sequence", format: invoiceFormat, text: "INV-202401-001", want: nil},
+		{name: "inside a word", format: invoiceFormat, text: "XINV-202401-0001", want: nil},
+		{name: "followed by letters", format: invoiceFormat, text: "INV-202401-0001A", want: nil},
+		{name: "no number", format: invoiceFormat, text: "Freight March", want: nil},
+		{
+			name:   "two-digit year and unpadded sequence",
+			format: config.NumberFormat{Prefix: "CN-", Pattern: "{YY}.{MM}/{seq}"},
+			text:   "credit cn 24 03 7 and CN-24.03/15",
+			want:   []string{"CN-24.03/7", "CN-24.03/15"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newInvoiceNumberFinder(tt.format).find(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("find(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBankMatcherBrokerByName(t *testing.T) {
+	ids := make(map[string]primitive.ObjectID)
+	matcher := &bankMatcher{}
+	for _, name := range []string{
+		"Acme Logistics LLC",
+		"Nordfracht GmbH",
+		"Blue Line Transport",
+		"Blue Line Transport Services",
+		"ООО Ромашка",
+		"Abc Co",
+		"Swift Freight LLC",
+		"Swift Freight Inc",
+	} {
+		ids[name] = primitive.NewObjectID()
+		matcher.brokers = append(matcher.brokers, brokerName{id: ids[name], name: normalizeCompanyName(name)})
+	}
+
+	tests := []struct {
+		name   string
+		payer  string
+		broker string // пустая строка - брокер не определен
+	}{
+		{name: "exact ignoring case and legal form", payer: "ACME LOGISTICS, LLC", broker: "Acme Logistics LLC"},
+		{name: "exact without legal form", payer: "Nordfracht", broker: "Nordfracht GmbH"},
+		{name: "exact preferred over partial", payer: "Blue Line Transport Inc.", broker: "Blue Line Transport"},
+		{name: "cyrillic", payer: "Ромашка", broker: "ООО Ромашка"},
+		{name: "broker name inside payer", payer: "Payment from Nordfracht Hamburg", broker: "Nordfracht GmbH"},
+		{name: "payer inside broker name", payer: "Acme Logist", broker: "Acme Logistics LLC"},
+		{name: "short exact name", payer: "ABC", broker: "Abc Co"},
+		{name: "short name is not matched partially", payer: "Abc Trading"},
+		{name: "several partial matches", payer: "Blue Line"},
+		{name: "several exact matches", payer: "Swift Freight"},
+		{name: "unknown", payer: "Unknown Carrier"},
+		{name: "legal form only", payer: "LLC"},
+		{name: "empty", payer: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := matcher.brokerByName(tt.payer)
+			if tt.broker == "" {
+				if ok {
+					t.Errorf("brokerByName(%q) = %s, want no match", tt.payer, got.Hex())
+				}
+				return
+			}
+			if !ok || got != ids[tt.broker] {
+				t.Errorf("brokerByName(%q) = %s, %v; want %s", tt.payer, got.Hex(), ok, tt.broker)
+			}
+		})
+	}
+}
+
+func TestBankMatcherMatchBrokerInvoices(t *testing.T) {
+	brokerID := primitive.NewObjectID()
+	openInvoice := func(number string, remaining models.Amount) *models.Invoice {
+		return &models.Invoice{
+			ID:              primitive.NewObjectID(),
+			InvoiceNumber:   number,
+			BrokerID:        brokerID,
+			Currency:        "USD",
+			Status:          models.InvoiceStatusIssued,
+			RemainingAmount: remaining,
+		}
+	}
+	// Счета по возрастанию срока оплаты, как их возвращает репозиторий
+	first := openInvoice("INV-202401-0001", 10000)
+	second := openInvoice("INV-202401-0002", 25000)
+	third := openInvoice("INV-202401-0003", 40000)
+	otherCurrency := &models.Invoice{ID: primitive.NewObjectID(), BrokerID: brokerID, Currency: "EUR", RemainingAmount: 30000}
+	open := []*models.Invoice{first, second, third, otherCurrency}
+
+	allocation := func(invoice *models.Invoice, amount models.Amount) models.PaymentAllocation {
+		return models.PaymentAllocation{InvoiceID: invoice.ID, Amount: amount}
+	}
+
+	tests := []struct {
+		name        string
+		amount      models.Amount
+		open        []*models.Invoice
+		reserved    map[primitive.ObjectID]models.Amount
+		allocations []models.PaymentAllocation
+		reasons     []string
+	}{
+		{
+			name:        "equal to one invoice",
+			amount:      25000,
+			open:        open,
+			allocations: []models.PaymentAllocation{allocation(second, 25000)},
+			reasons:     []string{models.MatchReasonAmount},
+		},
+		{
+			name:        "equal to all open invoices",
+			amount:      75000,
+			open:        open,
+			allocations: []models.PaymentAllocation{allocation(first, 10000), allocation(second, 25000), allocation(third, 40000)},
+			reasons:     []string{models.MatchReasonAmount},
+		},
+		{
+			name:        "partial payment by oldest due",
+			amount:      30000,
+			open:        open,
+			allocations: []models.PaymentAllocation{allocation(first, 10000), allocation(second, 20000)},
+			reasons:     []string{models.MatchReasonOldestDue},
+		},
+		{
+			name:        "overpayment leaves the rest unallocated",
+			amount:      100000,
+			open:        open,
+			allocations: []models.PaymentAllocation{allocation(first, 10000), allocation(second, 25000), allocation(third, 40000)},
+			reasons:     []string{models.MatchReasonOldestDue},
+		},
+		{
+			name:        "reserved by another transaction",
+			amount:      4000,
+			open:        open,
+			reserved:    map[primitive.ObjectID]models.Amount{first.ID: 6000},
+			allocations: []models.PaymentAllocation{allocation(first, 4000)},
+			reasons:     []string{models.MatchReasonAmount},
+		},
+		{
+			name:        "fully reserved invoice is skipped",
+			amount:      30000,
+			open:        open,
+			reserved:    map[primitive.ObjectID]models.Amount{first.ID: 10000},
+			allocations: []models.PaymentAllocation{allocation(second, 25000), allocation(third, 5000)},
+			reasons:     []string{models.MatchReasonOldestDue},
+		},
+		{
+			name:        "no open invoices",
+			amount:      25000,
+			open:        []*models.Invoice{otherCurrency},
+			allocations: []models.PaymentAllocation{},
+			reasons:     []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reserved := make(map[primitive.ObjectID]models.Amount)
+			for id, amount := range tt.reserved {
+				reserved[id] = amount
+			}
+			matcher := &bankMatcher{
+				invoiceRepo: &openInvoiceRepo{open: tt.open},
+				reserved:    reserved,
+			}
+			transaction := &models.BankTransaction{
+				BrokerID:     brokerID,
+				Amount:       tt.amount,
+				Currency:     "USD",
+				Allocations:  []models.PaymentAllocation{},
+				MatchReasons: []string{},
+			}
+
+			if err := matcher.matchBrokerInvoices(context.Background(), transaction); err != nil {
+				t.Fatalf("matchBrokerInvoices error: %v", err)
+			}
+			if !reflect.DeepEqual(transaction.Allocations, tt.allocations) {
+				t.Errorf("allocations = %+v, want %+v", transaction.Allocations, tt.allocations)
+			}
+			if !reflect.DeepEqual(transaction.MatchReasons, tt.reasons) {
+				t.Errorf("match reasons = %q, want %q", transaction.MatchReasons, tt.reasons)
+			}
+
+			// Разнесенные суммы резервируются для следующих поступлений выписки
+			for _, allocation := range transaction.Allocations {
+				if want := tt.reserved[allocation.InvoiceID] + allocation.Amount; matcher.reserved[allocation.InvoiceID] != want {
+					t.Errorf("reserved for %s = %s, want %s", allocation.InvoiceID.Hex(), matcher.reserved[allocation.InvoiceID], want)
+				}
+			}
+		})
+	}
+}
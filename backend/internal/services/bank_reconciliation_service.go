@@ -0,0 +1,393 @@
+package services
+
+import (
+	"billing-system/config"
+	"billing-system/internal/models"
+	"billing-system/internal/repository"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// bankReconciliationService реализация BankReconciliationService
+type bankReconciliationService struct {
+	transactionRepo repository.BankTransactionRepository
+	invoiceRepo     repository.InvoiceRepository
+	brokerRepo      repository.BrokerRepository
+	unitOfWork      repository.UnitOfWork
+	paymentService  PaymentService
+	exchangeRates   ExchangeRateService
+	invoiceNumbers  *invoiceNumberFinder
+}
+
+// NewBankReconciliationService создает новый BankReconciliationService
+func NewBankReconciliationService(
+	transactionRepo repository.BankTransactionRepository,
+	invoiceRepo repository.InvoiceRepository,
+	brokerRepo repository.BrokerRepository,
+	unitOfWork repository.UnitOfWork,
+	paymentService PaymentService,
+	exchangeRates ExchangeRateService,
+	invoiceNumberFormat config.NumberFormat,
+) BankReconciliationService {
+	return &bankReconciliationService{
+		transactionRepo: transactionRepo,
+		invoiceRepo:     invoiceRepo,
+		brokerRepo:      brokerRepo,
+		unitOfWork:      unitOfWork,
+		paymentService:  paymentService,
+		exchangeRates:   exchangeRates,
+		invoiceNumbers:  newInvoiceNumberFinder(invoiceNumberFormat),
+	}
+}
+
+// ImportStatement загружает выписку, сохраняет новые поступления и предлагает для них брокера и разнесение.
+// Пустой format определяется по содержимому; currency используется для операций без валюты в выписке.
+// Списания и уже загруженные операции пропускаются.
+func (s *bankReconciliationService) ImportStatement(ctx context.Context, format, currency string, r io.Reader, importedBy string) (*models.BankImportResult, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, &ValidationError{Message: "Statement file is empty"}
+	}
+
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" {
+		format = detectStatementFormat(data)
+	}
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency != "" {
+		if err := s.exchangeRates.ValidateCurrency(currency); err != nil {
+			return nil, err
+		}
+	}
+
+	transactions, err := parseBankStatement(format, data, currency)
+	if err != nil {
+		return nil, err
+	}
+	if len(transactions) == 0 {
+		return nil, &ValidationError{Message: "Statement contains no transactions"}
+	}
+
+	result := &models.BankImportResult{
+		ImportID: primitive.NewObjectID(),
+		Format:   format,
+		Total:    len(transactions),
+	}
+
+	// Проверяем поступления до записи, чтобы выписка с ошибкой не загружалась частично
+	var credits []*models.BankTransaction
+	occurrences := make(map[string]int)
+	for i, transaction := range transactions {
+		if transaction.Amount <= 0 {
+			result.Skipped++
+			continue
+		}
+		if transaction.Currency == "" {
+			return nil, &ValidationError{Message: fmt.Sprintf("Transaction %d: currency is not specified in the statement, pass currency with the file", i+1)}
+		}
+		if err := s.exchangeRates.ValidateCurrency(transaction.Currency); err != nil {
+			return nil, &ValidationError{Message: fmt.Sprintf("Transaction %d: %s", i+1, err.Error())}
+		}
+
+		content := bankTransactionFingerprint(transaction, 0)
+		transaction.Fingerprint = bankTransactionFingerprint(transaction, occurrences[content])
+		occurrences[content]++
+
+		transaction.ImportID = result.ImportID
+		transaction.ImportedBy = importedBy
+		credits = append(credits, transaction)
+	}
+
+	matcher, err := newBankMatcher(ctx, s.invoiceRepo, s.brokerRepo, s.invoiceNumbers)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, transaction := range credits {
+		if err := matcher.match(ctx, transaction); err != nil {
+			return nil, err
+		}
+
+		inserted, err := s.transactionRepo.Insert(ctx, transaction)
+		if err != nil {
+			return nil, err
+		}
+		if !inserted {
+			result.Duplicates++
+			continue
+		}
+
+		result.Imported++
+		if transaction.Status == models.BankTransactionStatusMatched {
+			result.Matched++
+		} else {
+			result.Unmatched++
+		}
+	}
+
+	return result, nil
+}
+
+// GetTransaction получает банковскую операцию по ID
+func (s *bankReconciliationService) GetTransaction(ctx context.Context, id primitive.ObjectID) (*models.BankTransaction, error) {
+	return s.transactionRepo.GetByID(ctx, id)
+}
+
+// GetTransactions получает банковские операции с фильтрацией и пагинацией
+func (s *bankReconciliationService) GetTransactions(ctx context.Context, filter *models.BankTransactionFilter, page, limit int) ([]*models.BankTransaction, *models.Pagination, error) {
+	offset := (page - 1) * limit
+
+	transactions, total, err := s.transactionRepo.GetAll(ctx, filter, limit, offset)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pagination := &models.Pagination{
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: int(math.Ceil(float64(total) / float64(limit))),
+		HasNext:    int64(page*limit) < total,
+		HasPrev:    page > 1,
+	}
+
+	return transactions, pagination, nil
+}
+
+// ConfirmTransaction проводит операцию как платеж через PaymentService по предложенному
+// или заданному в запросе сопоставлению. Платеж и отметка операции записываются в одной транзакции.
+func (s *bankReconciliationService) ConfirmTransaction(ctx context.Context, id primitive.ObjectID, req *models.ConfirmBankTransactionRequest, reviewedBy string) (*models.Payment, error) {
+	transaction, err := s.openTransaction(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &models.Payment{
+		BrokerID:          transaction.BrokerID,
+		Amount:            transaction.Amount,
+		Allocations:       make([]models.PaymentAllocation, 0, len(transaction.Allocations)),
+		Currency:          transaction.Currency,
+		PaymentDate:       transaction.BookingDate,
+		PaymentMethod:     models.PaymentMethodWireTransfer,
+		TransactionID:     transaction.ExternalID,
+		ReferenceNumber:   transaction.ReferenceNumber,
+		Notes:             transaction.Memo,
+		BankTransactionID: transaction.ID,
+		CreatedBy:         reviewedBy,
+	}
+	for _, allocation := range transaction.Allocations {
+		template.Allocations = append(template.Allocations, models.PaymentAllocation{
+			InvoiceID: allocation.InvoiceID,
+			Amount:    allocation.Amount,
+		})
+	}
+
+	// Брокер или разнесение из запроса заменяют предложенное сопоставление
+	if !req.BrokerID.IsZero() || len(req.Allocations) > 0 || req.AutoAllocate {
+		template.Allocations = req.Allocations
+		template.AutoAllocate = req.AutoAllocate
+		if !req.BrokerID.IsZero() {
+			template.BrokerID = req.BrokerID
+		} else if template.BrokerID.IsZero() && len(req.Allocations) > 0 {
+			invoice, err := s.invoiceRepo.GetByID(ctx, req.Allocations[0].InvoiceID)
+			if err == mongo.ErrNoDocuments {
+				return nil, &ValidationError{Message: "Invoice not found"}
+			}
+			if err != nil {
+				return nil, err
+			}
+			template.BrokerID = invoice.BrokerID
+		}
+	}
+	if template.BrokerID.IsZero() {
+		return nil, &ValidationError{Message: "Broker ID is required to confirm an unmatched bank transaction"}
+	}
+	if req.PaymentMethod != "" {
+		template.PaymentMethod = req.PaymentMethod
+	}
+	if req.Notes != "" {
+		template.Notes = req.Notes
+	}
+
+	var payment *models.Payment
+	err = s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		// CreatePayment заполняет разнесение в платеже, поэтому при повторе транзакции платеж собирается заново
+		payment = new(models.Payment)
+		*payment = *template
+		payment.Allocations = append([]models.PaymentAllocation{}, template.Allocations...)
+
+		err := s.paymentService.CreatePayment(ctx, payment)
+		if mongo.IsDuplicateKeyError(err) {
+			return &ValidationError{Message: "Bank transaction is already confirmed"}
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		transaction.Status = models.BankTransactionStatusConfirmed
+		transaction.BrokerID = payment.BrokerID
+		transaction.Allocations = payment.Allocations
+		transaction.PaymentID = payment.ID
+		transaction.ReviewedBy = reviewedBy
+		transaction.ReviewedAt = &now
+
+		return s.resolve(ctx, transaction)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// SplitTransaction делит операцию на части (например, пакетное зачисление от нескольких брокеров).
+// Каждая часть сопоставляется заново и разбирается отдельно.
+func (s *bankReconciliationService) SplitTransaction(ctx context.Context, id primitive.ObjectID, req *models.SplitBankTransactionRequest, reviewedBy string) ([]*models.BankTransaction, error) {
+	transaction, err := s.openTransaction(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.Parts) < 2 {
+		return nil, &ValidationError{Message: "At least two parts are required to split a bank transaction"}
+	}
+
+	var total models.Amount
+	for i, part := range req.Parts {
+		if part.Amount <= 0 {
+			return nil, &ValidationError{Message: fmt.Sprintf("Part %d: amount must be greater than zero", i+1)}
+		}
+		if !part.BrokerID.IsZero() {
+			_, err := s.brokerRepo.GetByID(ctx, part.BrokerID)
+			if err == mongo.ErrNoDocuments {
+				return nil, &ValidationError{Message: fmt.Sprintf("Part %d: broker not found", i+1)}
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		total += part.Amount
+	}
+	if total != transaction.Amount {
+		return nil, &ValidationError{Message: fmt.Sprintf("Parts must add up to the transaction amount %s", transaction.Amount)}
+	}
+
+	matcher, err := newBankMatcher(ctx, s.invoiceRepo, s.brokerRepo, s.invoiceNumbers)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]*models.BankTransaction, len(req.Parts))
+	for i, part := range req.Parts {
+		child := &models.BankTransaction{
+			ImportID:        transaction.ImportID,
+			ParentID:        transaction.ID,
+			Format:          transaction.Format,
+			AccountID:       transaction.AccountID,
+			ExternalID:      transaction.ExternalID,
+			Fingerprint:     fmt.Sprintf("%s/%d", transaction.Fingerprint, i+1),
+			BookingDate:     transaction.BookingDate,
+			Amount:          part.Amount,
+			Currency:        transaction.Currency,
+			PayerName:       transaction.PayerName,
+			Memo:            transaction.Memo,
+			ReferenceNumber: transaction.ReferenceNumber,
+			BrokerID:        part.BrokerID,
+			ImportedBy:      reviewedBy,
+		}
+		if memo := strings.TrimSpace(part.Memo); memo != "" {
+			child.Memo = memo
+		}
+
+		if err := matcher.match(ctx, child); err != nil {
+			return nil, err
+		}
+		parts[i] = child
+	}
+
+	err = s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		now := time.Now()
+		transaction.Status = models.BankTransactionStatusSplit
+		transaction.Allocations = []models.PaymentAllocation{}
+		transaction.ReviewedBy = reviewedBy
+		transaction.ReviewedAt = &now
+
+		if err := s.resolve(ctx, transaction); err != nil {
+			return err
+		}
+
+		for _, part := range parts {
+			inserted, err := s.transactionRepo.Insert(ctx, part)
+			if err != nil {
+				return err
+			}
+			if !inserted {
+				return &ValidationError{Message: "Bank transaction is already split"}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return parts, nil
+}
+
+// RejectTransaction отклоняет операцию, не являющуюся оплатой брокера (проценты банка, возвраты и т.п.)
+func (s *bankReconciliationService) RejectTransaction(ctx context.Context, id primitive.ObjectID, req *models.RejectBankTransactionRequest, reviewedBy string) (*models.BankTransaction, error) {
+	transaction, err := s.openTransaction(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	transaction.Status = models.BankTransactionStatusRejected
+	transaction.Allocations = []models.PaymentAllocation{}
+	transaction.RejectReason = strings.TrimSpace(req.Reason)
+	transaction.ReviewedBy = reviewedBy
+	transaction.ReviewedAt = &now
+
+	if err := s.resolve(ctx, transaction); err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
+}
+
+// openTransaction получает операцию, которая еще ждет разбора
+func (s *bankReconciliationService) openTransaction(ctx context.Context, id primitive.ObjectID) (*models.BankTransaction, error) {
+	transaction, err := s.transactionRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !transaction.IsOpen() {
+		return nil, &ValidationError{Message: fmt.Sprintf("Bank transaction is already %s", transaction.Status)}
+	}
+
+	return transaction, nil
+}
+
+// resolve фиксирует итог разбора; операция, разобранная параллельно, не перезаписывается
+func (s *bankReconciliationService) resolve(ctx context.Context, transaction *models.BankTransaction) error {
+	resolved, err := s.transactionRepo.Resolve(ctx, transaction)
+	if err != nil {
+		return err
+	}
+	if !resolved {
+		return &ValidationError{Message: "Bank transaction has already been reviewed"}
+	}
+	return nil
+}
@@ -0,0 +1,502 @@
+package services
+
+import (
+	"billing-system/internal/models"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+)
+
+// csvStatementColumns допустимые названия колонок CSV выписки
+var csvStatementColumns = map[string]string{
+	"date":           "date",
+	"booking_date":   "date",
+	"amount":         "amount",
+	"currency":       "currency",
+	"payer":          "payer",
+	"name":           "payer",
+	"counterparty":   "payer",
+	"memo":           "memo",
+	"description":    "memo",
+	"details":        "memo",
+	"reference":      "reference",
+	"id":             "id",
+	"transaction_id": "id",
+	"account":        "account",
+}
+
+// csvStatementDateLayouts форматы дат CSV выписки
+var csvStatementDateLayouts = []string{"2006-01-02", "01/02/2006", "02.01.2006"}
+
+// detectStatementFormat определяет формат выписки по содержимому
+func detectStatementFormat(data []byte) string {
+	head := data
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	upper := bytes.ToUpper(head)
+
+	switch {
+	case bytes.Contains(upper, []byte("CAMT.053")), bytes.Contains(upper, []byte("<BKTOCSTMRSTMT")):
+		return models.BankStatementFormatCamt053
+	case bytes.Contains(upper, []byte("OFXHEADER")), bytes.Contains(upper, []byte("<OFX>")):
+		return models.BankStatementFormatOFX
+	default:
+		return models.BankStatementFormatCSV
+	}
+}
+
+// parseBankStatement разбирает выписку; currency — валюта операций, для которых выписка ее не указывает.
+// Списания возвращаются с отрицательной суммой.
+func parseBankStatement(format string, data []byte, currency string) ([]*models.BankTransaction, error) {
+	var (
+		transactions []*models.BankTransaction
+		err          error
+	)
+
+	switch format {
+	case models.BankStatementFormatCSV:
+		transactions, err = parseCSVStatement(data)
+	case models.BankStatementFormatOFX:
+		transactions, err = parseOFXStatement(data)
+	case models.BankStatementFormatCamt053:
+		transactions, err = parseCamt053Statement(data)
+	default:
+		return nil, &ValidationError{Message: "Statement format must be csv, ofx or camt053"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, transaction := range transactions {
+		transaction.Format = format
+		transaction.Currency = strings.ToUpper(strings.TrimSpace(transaction.Currency))
+		if transaction.Currency == "" {
+			transaction.Currency = currency
+		}
+		transaction.PayerName = strings.TrimSpace(transaction.PayerName)
+		transaction.Memo = strings.TrimSpace(transaction.Memo)
+		transaction.ReferenceNumber = strings.TrimSpace(transaction.ReferenceNumber)
+	}
+
+	return transactions, nil
+}
+
+// parseCSVStatement разбирает CSV со строкой заголовка. Обязательны колонки date и amount,
+// необязательны currency, payer, memo, reference, id и account. Разделитель - запятая или точка с запятой.
+func parseCSVStatement(data []byte) ([]*models.BankTransaction, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	if firstLine, _, _ := bytes.Cut(data, []byte("\n")); bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = ';'
+	}
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, &ValidationError{Message: "Statement file is empty"}
+	}
+	if err != nil {
+		return nil, &ValidationError{Message: fmt.Sprintf("Invalid CSV: %s", err.Error())}
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if column, ok := csvStatementColumns[name]; ok {
+			columns[column] = i
+		}
+	}
+	if _, ok := columns["date"]; !ok {
+		return nil, &ValidationError{Message: "CSV statement must have a date column"}
+	}
+	if _, ok := columns["amount"]; !ok {
+		return nil, &ValidationError{Message: "CSV statement must have an amount column"}
+	}
+
+	field := func(record []string, column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var transactions []*models.BankTransaction
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &ValidationError{Message: fmt.Sprintf("Invalid CSV: %s", err.Error())}
+		}
+
+		date, err := parseStatementDate(field(record, "date"), csvStatementDateLayouts)
+		if err != nil {
+			return nil, &ValidationError{Message: fmt.Sprintf("Line %d: date must be in YYYY-MM-DD, MM/DD/YYYY or DD.MM.YYYY format", line)}
+		}
+		amount, err := parseStatementAmount(field(record, "amount"))
+		if err != nil {
+			return nil, &ValidationError{Message: fmt.Sprintf("Line %d: amount must be a number", line)}
+		}
+
+		transactions = append(transactions, &models.BankTransaction{
+			AccountID:       field(record, "account"),
+			ExternalID:      field(record, "id"),
+			BookingDate:     date,
+			Amount:          amount,
+			Currency:        field(record, "currency"),
+			PayerName:       field(record, "payer"),
+			Memo:            field(record, "memo"),
+			ReferenceNumber: field(record, "reference"),
+		})
+	}
+
+	return transactions, nil
+}
+
+// parseOFXStatement разбирает OFX 1.x (SGML) и OFX 2.x (XML): берутся операции STMTTRN
+// с валютой выписки CURDEF или валютой операции CURSYM
+func parseOFXStatement(data []byte) ([]*models.BankTransaction, error) {
+	var (
+		transactions []*models.BankTransaction
+		current      *models.BankTransaction
+		account      string
+		currency     string
+		checkNumber  string
+	)
+
+	for _, element := range strings.Split(string(data), "<")[1:] {
+		tag, value, found := strings.Cut(element, ">")
+		if !found || strings.HasPrefix(tag, "?") {
+			continue
+		}
+		tag = strings.ToUpper(strings.TrimSpace(tag))
+		value = html.UnescapeString(strings.TrimSpace(value))
+
+		switch tag {
+		case "ACCTID":
+			account = value
+		case "CURDEF":
+			currency = value
+		case "STMTTRN":
+			current = &models.BankTransaction{AccountID: account, Currency: currency}
+			checkNumber = ""
+		case "/STMTTRN":
+			if current == nil {
+				continue
+			}
+			if current.BookingDate.IsZero() {
+				return nil, &ValidationError{Message: fmt.Sprintf("OFX transaction %d: DTPOSTED is required", len(transactions)+1)}
+			}
+			if current.ReferenceNumber == "" {
+				current.ReferenceNumber = checkNumber
+			}
+			transactions = append(transactions, current)
+			current = nil
+		}
+
+		if current == nil {
+			continue
+		}
+
+		switch tag {
+		case "DTPOSTED":
+			date, err := parseOFXDate(value)
+			if err != nil {
+				return nil, &ValidationError{Message: fmt.Sprintf("OFX transaction %d: invalid DTPOSTED %q", len(transactions)+1, value)}
+			}
+			current.BookingDate = date
+		case "TRNAMT":
+			amount, err := parseStatementAmount(value)
+			if err != nil {
+				return nil, &ValidationError{Message: fmt.Sprintf("OFX transaction %d: invalid TRNAMT %q", len(transactions)+1, value)}
+			}
+			current.Amount = amount
+		case "FITID":
+			current.ExternalID = value
+		case "NAME":
+			current.PayerName = value
+		case "MEMO":
+			current.Memo = value
+		case "REFNUM":
+			current.ReferenceNumber = value
+		case "CHECKNUM":
+			checkNumber = value
+		case "CURSYM":
+			current.Currency = value
+		}
+	}
+
+	if len(transactions) == 0 && !strings.Contains(strings.ToUpper(string(data)), "<OFX>") {
+		return nil, &ValidationError{Message: "Invalid OFX statement"}
+	}
+
+	return transactions, nil
+}
+
+// camtDocument выписка ISO 20022 camt.053; пространство имен версии не проверяется
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtStatement struct {
+	IBAN         string      `xml:"Acct>Id>IBAN"`
+	OtherAccount string      `xml:"Acct>Id>Othr>Id"`
+	Currency     string      `xml:"Acct>Ccy"`
+	Entries      []camtEntry `xml:"Ntry"`
+}
+
+type camtEntry struct {
+	Amount         camtAmount      `xml:"Amt"`
+	CreditDebit    string          `xml:"CdtDbtInd"`
+	Status         camtStatus      `xml:"Sts"`
+	BookingDate    camtDate        `xml:"BookgDt"`
+	ValueDate      camtDate        `xml:"ValDt"`
+	Reference      string          `xml:"AcctSvcrRef"`
+	AdditionalInfo string          `xml:"AddtlNtryInf"`
+	Details        []camtTxDetails `xml:"NtryDtls>TxDtls"`
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+// camtStatus статус проводки: <Sts>BOOK</Sts> до версии 08 и <Sts><Cd>BOOK</Cd></Sts> начиная с нее
+type camtStatus struct {
+	Value string `xml:",chardata"`
+	Code  string `xml:"Cd"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+type camtTxDetails struct {
+	AccountReference string     `xml:"Refs>AcctSvcrRef"`
+	EndToEndID       string     `xml:"Refs>EndToEndId"`
+	Amount           camtAmount `xml:"Amt"`
+	TxAmount         camtAmount `xml:"AmtDtls>TxAmt>Amt"`
+	DebtorName       string     `xml:"RltdPties>Dbtr>Nm"`
+	DebtorPartyName  string     `xml:"RltdPties>Dbtr>Pty>Nm"`
+	Unstructured     []string   `xml:"RmtInf>Ustrd"`
+	CreditorRefs     []string   `xml:"RmtInf>Strd>CdtrRefInf>Ref"`
+	AdditionalInfo   string     `xml:"AddtlTxInf"`
+}
+
+// parseCamt053Statement разбирает выписку camt.053. Проводка с несколькими операциями (пакетное зачисление)
+// делится на отдельные поступления, если у каждой операции указана сумма. Незавершенные проводки пропускаются.
+func parseCamt053Statement(data []byte) ([]*models.BankTransaction, error) {
+	var document camtDocument
+	if err := xml.Unmarshal(data, &document); err != nil {
+		return nil, &ValidationError{Message: fmt.Sprintf("Invalid camt.053 statement: %s", err.Error())}
+	}
+	if len(document.Statements) == 0 {
+		return nil, &ValidationError{Message: "Invalid camt.053 statement: no Stmt elements"}
+	}
+
+	var transactions []*models.BankTransaction
+	for _, statement := range document.Statements {
+		account := statement.IBAN
+		if account == "" {
+			account = statement.OtherAccount
+		}
+
+		for n, entry := range statement.Entries {
+			status := strings.TrimSpace(entry.Status.Code + entry.Status.Value)
+			if status != "" && status != "BOOK" {
+				continue
+			}
+
+			date, err := entry.BookingDate.parse()
+			if err != nil {
+				date, err = entry.ValueDate.parse()
+			}
+			if err != nil {
+				return nil, &ValidationError{Message: fmt.Sprintf("camt.053 entry %d: booking date is required", n+1)}
+			}
+
+			parts, err := entry.parts()
+			if err != nil {
+				return nil, &ValidationError{Message: fmt.Sprintf("camt.053 entry %d: %s", n+1, err.Error())}
+			}
+
+			for i, part := range parts {
+				transaction := &models.BankTransaction{
+					AccountID:   account,
+					ExternalID:  entry.Reference,
+					BookingDate: date,
+					Amount:      part.amount,
+					Currency:    part.currency,
+					Memo:        entry.AdditionalInfo,
+				}
+				if transaction.Currency == "" {
+					transaction.Currency = statement.Currency
+				}
+				if entry.CreditDebit == "DBIT" {
+					transaction.Amount = -transaction.Amount
+				}
+
+				if details := part.details; details != nil {
+					transaction.PayerName = details.DebtorName
+					if transaction.PayerName == "" {
+						transaction.PayerName = details.DebtorPartyName
+					}
+
+					memo := append(append([]string{}, details.Unstructured...), details.AdditionalInfo, entry.AdditionalInfo)
+					transaction.Memo = strings.Join(nonEmpty(memo), " ")
+
+					if len(details.CreditorRefs) > 0 {
+						transaction.ReferenceNumber = details.CreditorRefs[0]
+					} else if details.EndToEndID != "NOTPROVIDED" {
+						transaction.ReferenceNumber = details.EndToEndID
+					}
+
+					if details.AccountReference != "" {
+						transaction.ExternalID = details.AccountReference
+					}
+				}
+				if len(parts) > 1 && transaction.ExternalID == entry.Reference && entry.Reference != "" {
+					transaction.ExternalID = fmt.Sprintf("%s/%d", entry.Reference, i+1)
+				}
+
+				transactions = append(transactions, transaction)
+			}
+		}
+	}
+
+	return transactions, nil
+}
+
+// camtEntryPart поступление внутри проводки camt.053
+type camtEntryPart struct {
+	amount   models.Amount
+	currency string
+	details  *camtTxDetails
+}
+
+// parts делит проводку на операции с собственными суммами или возвращает ее целиком
+func (e *camtEntry) parts() ([]camtEntryPart, error) {
+	total, err := parseStatementAmount(e.Amount.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount %q", e.Amount.Value)
+	}
+
+	whole := []camtEntryPart{{amount: total, currency: e.Amount.Currency}}
+	if len(e.Details) > 0 {
+		whole[0].details = &e.Details[0]
+	}
+	if len(e.Details) < 2 {
+		return whole, nil
+	}
+
+	parts := make([]camtEntryPart, 0, len(e.Details))
+	var sum models.Amount
+	for i := range e.Details {
+		details := &e.Details[i]
+		amount := details.TxAmount
+		if amount.Value == "" {
+			amount = details.Amount
+		}
+		if amount.Value == "" {
+			return whole, nil
+		}
+
+		value, err := parseStatementAmount(amount.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transaction amount %q", amount.Value)
+		}
+		sum += value
+		parts = append(parts, camtEntryPart{amount: value, currency: amount.Currency, details: details})
+	}
+
+	// Суммы операций в другой валюте или не сходящиеся с проводкой не делятся
+	if sum != total {
+		return whole, nil
+	}
+	return parts, nil
+}
+
+// parse возвращает дату из Dt или DtTm
+func (d camtDate) parse() (time.Time, error) {
+	if d.Date != "" {
+		return time.Parse("2006-01-02", strings.TrimSpace(d.Date))
+	}
+	value := strings.TrimSpace(d.DateTime)
+	if len(value) < 10 {
+		return time.Time{}, fmt.Errorf("date is missing")
+	}
+	return time.Parse("2006-01-02", value[:10])
+}
+
+// parseOFXDate разбирает дату OFX вида YYYYMMDD[HHMMSS[.XXX]][[gmt offset:tz name]]; время отбрасывается
+func parseOFXDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("invalid OFX date %q", value)
+	}
+	return time.Parse("20060102", value[:8])
+}
+
+// parseStatementDate разбирает дату в одном из форматов layouts
+func parseStatementDate(value string, layouts []string) (time.Time, error) {
+	for _, layout := range layouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// parseStatementAmount разбирает сумму выписки: "1234.56", "1,234.56", "1234,56", "+1 234.56"
+func parseStatementAmount(value string) (models.Amount, error) {
+	value = strings.NewReplacer(" ", "", "\u00a0", "", "+", "").Replace(strings.TrimSpace(value))
+	if strings.Contains(value, ".") {
+		value = strings.ReplaceAll(value, ",", "")
+	} else {
+		value = strings.ReplaceAll(value, ",", ".")
+	}
+	return models.ParseAmount(value)
+}
+
+// bankTransactionFingerprint ключ повторной загрузки: идентификатор операции в банке или, если его нет,
+// содержимое операции с порядковым номером среди одинаковых операций выписки
+func bankTransactionFingerprint(transaction *models.BankTransaction, occurrence int) string {
+	var key string
+	if transaction.ExternalID != "" {
+		key = strings.Join([]string{"id", transaction.AccountID, transaction.ExternalID}, "|")
+	} else {
+		key = strings.Join([]string{
+			"content",
+			transaction.AccountID,
+			transaction.BookingDate.Format("2006-01-02"),
+			transaction.Amount.String(),
+			transaction.Currency,
+			transaction.PayerName,
+			transaction.Memo,
+			transaction.ReferenceNumber,
+			fmt.Sprint(occurrence),
+		}, "|")
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// nonEmpty возвращает непустые строки
+func nonEmpty(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
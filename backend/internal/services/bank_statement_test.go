@@ -0,0 +1,380 @@
+package services
+
+import (
+	"billing-system/internal/models"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readFixture читает файл выписки из testdata
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// day дата выписки в UTC
+func day(year int, month time.Month, dayOfMonth int) time.Time {
+	return time.Date(year, month, dayOfMonth, 0, 0, 0, 0, time.UTC)
+}
+
+// assertTransactions сравнивает разобранные операции с ожидаемыми
+func assertTransactions(t *testing.T, got, want []*models.BankTransaction) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d transactions, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("transaction %d:\n got  %+v\n want %+v", i+1, *got[i], *want[i])
+		}
+	}
+}
+
+// assertValidationError проверяет, что разбор вернул ошибку валидации с текстом message
+func assertValidationError(t *testing.T, err error, message string) {
+	t.Helper()
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("error = %v, want *ValidationError", err)
+	}
+	if !strings.Contains(validationErr.Message, message) {
+		t.Errorf("error = %q, want it to contain %q", validationErr.Message, message)
+	}
+}
+
+func TestDetectStatementFormat(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    string
+	}{
+		{fixture: "statement.csv", want: models.BankStatementFormatCSV},
+		{fixture: "statement_semicolon.csv", want: models.BankStatementFormatCSV},
+		{fixture: "statement.ofx", want: models.BankStatementFormatOFX},
+		{fixture: "statement_v2.ofx", want: models.BankStatementFormatOFX},
+		{fixture: "statement.camt053.xml", want: models.BankStatementFormatCamt053},
+	}
+
+	for _, tt := range tests {
+		if got := detectStatementFormat(readFixture(t, tt.fixture)); got != tt.want {
+			t.Errorf("detectStatementFormat(%s) = %q, want %q", tt.fixture, got, tt.want)
+		}
+	}
+}
+
+func TestParseCSVStatement(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []*models.BankTransaction
+	}{
+		{
+			fixture: "statement.csv",
+			want: []*models.BankTransaction{
+				{
+					Format:          models.BankStatementFormatCSV,
+					AccountID:       "US-ACC-1",
+					ExternalID:      "TX-1",
+					BookingDate:     day(2024, time.March, 1),
+					Amount:          125000,
+					Currency:        "USD",
+					PayerName:       "Acme Logistics LLC",
+					Memo:            "Payment INV-202402-0001",
+					ReferenceNumber: "REF-1",
+				},
+				{
+					Format:      models.BankStatementFormatCSV,
+					AccountID:   "US-ACC-1",
+					ExternalID:  "TX-2",
+					BookingDate: day(2024, time.March, 2),
+					Amount:      -4510,
+					Currency:    "EUR",
+					PayerName:   "Bank",
+					Memo:        "Monthly fee",
+				},
+				{
+					Format:      models.BankStatementFormatCSV,
+					AccountID:   "US-ACC-1",
+					BookingDate: day(2024, time.March, 4),
+					Amount:      30000,
+					Currency:    "USD",
+					PayerName:   "Blue Line Transport",
+					Memo:        "Invoices INV-202402-0002, INV-202402-0003",
+				},
+			},
+		},
+		{
+			fixture: "statement_semicolon.csv",
+			want: []*models.BankTransaction{
+				{
+					Format:      models.BankStatementFormatCSV,
+					BookingDate: day(2024, time.March, 1),
+					Amount:      123456,
+					Currency:    "EUR",
+					PayerName:   "ООО Ромашка",
+					Memo:        "Оплата по счету INV-202402-0004",
+				},
+				{
+					Format:      models.BankStatementFormatCSV,
+					BookingDate: day(2024, time.March, 5),
+					Amount:      -1200,
+					Currency:    "EUR",
+					PayerName:   "Банк",
+					Memo:        "Комиссия",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			got, err := parseBankStatement(models.BankStatementFormatCSV, readFixture(t, tt.fixture), "EUR")
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			assertTransactions(t, got, tt.want)
+		})
+	}
+}
+
+func TestParseCSVStatementErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		message string
+	}{
+		{name: "empty", data: "", message: "Statement file is empty"},
+		{name: "no date column", data: "amount,payer\n10.00,Acme\n", message: "must have a date column"},
+		{name: "no amount column", data: "date,payer\n2024-03-01,Acme\n", message: "must have an amount column"},
+		{name: "invalid date", data: "date,amount\n2024-03-01,10.00\n2024/03/02,5.00\n", message: "Line 3: date must be"},
+		{name: "invalid amount", data: "date,amount\n2024-03-01,ten\n", message: "Line 2: amount must be a number"},
+		{name: "unbalanced quote", data: "date,amount\n\"2024-03-01,10.00\n", message: "Invalid CSV"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseCSVStatement([]byte(tt.data))
+			assertValidationError(t, err, tt.message)
+		})
+	}
+}
+
+func TestParseOFXStatement(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []*models.BankTransaction
+	}{
+		{
+			fixture: "statement.ofx",
+			want: []*models.BankTransaction{
+				{
+					Format:      models.BankStatementFormatOFX,
+					AccountID:   "123456789",
+					ExternalID:  "202403051",
+					BookingDate: day(2024, time.March, 5),
+					Amount:      250000,
+					Currency:    "USD",
+					PayerName:   "Acme Logistics & Co",
+					Memo:        "INV-202402-0001",
+				},
+				{
+					Format:          models.BankStatementFormatOFX,
+					AccountID:       "123456789",
+					ExternalID:      "202403061",
+					BookingDate:     day(2024, time.March, 6),
+					Amount:          -10025,
+					Currency:        "USD",
+					PayerName:       "Office supplies",
+					ReferenceNumber: "1042",
+				},
+			},
+		},
+		{
+			fixture: "statement_v2.ofx",
+			want: []*models.BankTransaction{
+				{
+					Format:          models.BankStatementFormatOFX,
+					AccountID:       "DE89370400440532013000",
+					ExternalID:      "E-1",
+					BookingDate:     day(2024, time.March, 10),
+					Amount:          98040,
+					Currency:        "EUR",
+					PayerName:       "Nordfracht GmbH",
+					Memo:            "Rechnung INV-202402-0005",
+					ReferenceNumber: "INV-202402-0005",
+				},
+				{
+					Format:      models.BankStatementFormatOFX,
+					AccountID:   "DE89370400440532013000",
+					ExternalID:  "E-2",
+					BookingDate: day(2024, time.March, 11),
+					Amount:      120000,
+					Currency:    "CHF",
+					PayerName:   "Alpen Cargo AG",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			got, err := parseBankStatement(models.BankStatementFormatOFX, readFixture(t, tt.fixture), "GBP")
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			assertTransactions(t, got, tt.want)
+		})
+	}
+}
+
+func TestParseOFXStatementErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		message string
+	}{
+		{name: "not OFX", data: "date,amount\n2024-03-01,10.00\n", message: "Invalid OFX statement"},
+		{name: "missing date", data: "<OFX><STMTTRN><TRNAMT>10.00</STMTTRN></OFX>", message: "OFX transaction 1: DTPOSTED is required"},
+		{name: "invalid date", data: "<OFX><STMTTRN><DTPOSTED>2024</STMTTRN></OFX>", message: `invalid DTPOSTED "2024"`},
+		{name: "invalid amount", data: "<OFX><STMTTRN><DTPOSTED>20240301<TRNAMT>abc</STMTTRN></OFX>", message: `invalid TRNAMT "abc"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseOFXStatement([]byte(tt.data))
+			assertValidationError(t, err, tt.message)
+		})
+	}
+}
+
+func TestParseOFXStatementWithoutTransactions(t *testing.T) {
+	got, err := parseOFXStatement([]byte("<OFX><BANKTRANLIST></BANKTRANLIST></OFX>"))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got %d transactions, want none", len(got))
+	}
+}
+
+func TestParseCamt053Statement(t *testing.T) {
+	const account = "DE89370400440532013000"
+	want := []*models.BankTransaction{
+		{
+			Format:          models.BankStatementFormatCamt053,
+			AccountID:       account,
+			ExternalID:      "E-100",
+			BookingDate:     day(2024, time.March, 4),
+			Amount:          150000,
+			Currency:        "EUR",
+			PayerName:       "Nordfracht GmbH",
+			ReferenceNumber: "INV-202402-0003",
+		},
+		{
+			Format:          models.BankStatementFormatCamt053,
+			AccountID:       account,
+			ExternalID:      "E-101/1",
+			BookingDate:     day(2024, time.March, 5),
+			Amount:          10000,
+			Currency:        "EUR",
+			PayerName:       "Alpha Freight",
+			Memo:            "INV-202402-0004 Batch credit",
+			ReferenceNumber: "E2E-1",
+		},
+		{
+			Format:          models.BankStatementFormatCamt053,
+			AccountID:       account,
+			ExternalID:      "E-101-B",
+			BookingDate:     day(2024, time.March, 5),
+			Amount:          20000,
+			Currency:        "EUR",
+			PayerName:       "Beta Cargo",
+			Memo:            "Invoice INV-202402-0005 Batch credit",
+			ReferenceNumber: "E2E-2",
+		},
+		{
+			Format:      models.BankStatementFormatCamt053,
+			AccountID:   account,
+			ExternalID:  "E-103",
+			BookingDate: day(2024, time.March, 7),
+			Amount:      -1250,
+			Currency:    "EUR",
+			Memo:        "Account fee",
+		},
+		{
+			Format:          models.BankStatementFormatCamt053,
+			AccountID:       account,
+			ExternalID:      "E-104",
+			BookingDate:     day(2024, time.March, 8),
+			Amount:          50000,
+			Currency:        "EUR",
+			PayerName:       "Gamma Trucking",
+			ReferenceNumber: "E2E-3",
+		},
+	}
+
+	got, err := parseBankStatement(models.BankStatementFormatCamt053, readFixture(t, "statement.camt053.xml"), "USD")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	assertTransactions(t, got, want)
+}
+
+func TestParseCamt053StatementErrors(t *testing.T) {
+	entry := func(body string) string {
+		return "<Document><BkToCstmrStmt><Stmt><Acct><Ccy>EUR</Ccy></Acct><Ntry>" + body + "</Ntry></Stmt></BkToCstmrStmt></Document>"
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		message string
+	}{
+		{name: "not XML", data: "date,amount\n", message: "Invalid camt.053 statement"},
+		{name: "no statements", data: "<Document><BkToCstmrStmt></BkToCstmrStmt></Document>", message: "no Stmt elements"},
+		{name: "missing date", data: entry(`<Amt Ccy="EUR">10.00</Amt><CdtDbtInd>CRDT</CdtDbtInd>`), message: "camt.053 entry 1: booking date is required"},
+		{
+			name:    "invalid amount",
+			data:    entry(`<Amt Ccy="EUR">ten</Amt><CdtDbtInd>CRDT</CdtDbtInd><BookgDt><Dt>2024-03-01</Dt></BookgDt>`),
+			message: `camt.053 entry 1: invalid amount "ten"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseCamt053Statement([]byte(tt.data))
+			assertValidationError(t, err, tt.message)
+		})
+	}
+}
+
+func TestParseStatementAmount(t *testing.T) {
+	tests := []struct {
+		value string
+		want  models.Amount
+	}{
+		{value: "1234.56", want: 123456},
+		{value: "1,234.56", want: 123456},
+		{value: "1234,56", want: 123456},
+		{value: "+1 234.56", want: 123456},
+		{value: "1\u00a0234,56", want: 123456},
+		{value: "-45.1", want: -4510},
+	}
+
+	for _, tt := range tests {
+		got, err := parseStatementAmount(tt.value)
+		if err != nil {
+			t.Errorf("parseStatementAmount(%q) error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseStatementAmount(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package services
+
+import (
+	"billing-system/internal/models"
+	"billing-system/internal/repository"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxSchedulePreview максимальное число счетов в предпросмотре расписания
+const maxSchedulePreview = 24
+
+// billingIntervals допустимая периодичность расписаний
+var billingIntervals = map[string]bool{
+	models.BillingIntervalWeekly:    true,
+	models.BillingIntervalMonthly:   true,
+	models.BillingIntervalQuarterly: true,
+	models.BillingIntervalYearly:    true,
+}
+
+// billingScheduleService реализация BillingScheduleService
+type billingScheduleService struct {
+	scheduleRepo   repository.BillingScheduleRepository
+	brokerRepo     repository.BrokerRepository
+	invoiceRepo    repository.InvoiceRepository
+	invoiceService InvoiceService
+	exchangeRates  ExchangeRateService
+}
+
+// NewBillingScheduleService создает новый BillingScheduleService
+func NewBillingScheduleService(
+	scheduleRepo repository.BillingScheduleRepository,
+	brokerRepo repository.BrokerRepository,
+	invoiceRepo repository.InvoiceRepository,
+	invoiceService InvoiceService,
+	exchangeRates ExchangeRateService,
+) BillingScheduleService {
+	return &billingScheduleService{
+		scheduleRepo:   scheduleRepo,
+		brokerRepo:     brokerRepo,
+		invoiceRepo:    invoiceRepo,
+		invoiceService: invoiceService,
+		exchangeRates:  exchangeRates,
+	}
+}
+
+// CreateSchedule создает активное расписание; первый счет выставляется в дату начала
+func (s *billingScheduleService) CreateSchedule(ctx context.Context, schedule *models.BillingSchedule, createdBy string) error {
+	if err := s.validateSchedule(ctx, schedule); err != nil {
+		return err
+	}
+
+	schedule.LastPeriod = 0
+	schedule.CreatedBy = createdBy
+	setScheduleProgress(schedule, models.BillingScheduleStatusActive)
+
+	return s.scheduleRepo.Create(ctx, schedule)
+}
+
+// GetSchedule получает расписание по ID
+func (s *billingScheduleService) GetSchedule(ctx context.Context, id primitive.ObjectID) (*models.BillingSchedule, error) {
+	return s.scheduleRepo.GetByID(ctx, id)
+}
+
+// GetSchedules получает расписания с фильтрацией и пагинацией
+func (s *billingScheduleService) GetSchedules(ctx context.Context, filter *models.BillingScheduleFilter, page, limit int) ([]*models.BillingSchedule, *models.Pagination, error) {
+	offset := (page - 1) * limit
+
+	schedules, total, err := s.scheduleRepo.GetAll(ctx, filter, limit, offset)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pagination := &models.Pagination{
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: int(math.Ceil(float64(total) / float64(limit))),
+		HasNext:    int64(page*limit) < total,
+		HasPrev:    page > 1,
+	}
+
+	return schedules, pagination, nil
+}
+
+// UpdateSchedule обновляет условия расписания. Изменения применяются к счетам следующих периодов;
+// после выставления первого счета дата начала и периодичность не меняются, чтобы не сдвинуть нумерацию периодов.
+func (s *billingScheduleService) UpdateSchedule(ctx context.Context, id primitive.ObjectID, schedule *models.BillingSchedule) error {
+	existing, err := s.scheduleRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if existing.LastPeriod > 0 && (!schedule.StartDate.Equal(existing.StartDate) || schedule.Interval != existing.Interval) {
+		return &ValidationError{Message: "Start date and interval cannot be changed after invoices were generated"}
+	}
+	if err := s.validateSchedule(ctx, schedule); err != nil {
+		return err
+	}
+
+	// Приостановленное расписание остается на паузе, завершенное возобновляется, если дата окончания продлена
+	schedule.LastPeriod = existing.LastPeriod
+	status := models.BillingScheduleStatusActive
+	if existing.Status == models.BillingScheduleStatusPaused {
+		status = models.BillingScheduleStatusPaused
+	}
+	setScheduleProgress(schedule, status)
+
+	return s.scheduleRepo.Update(ctx, id, schedule)
+}
+
+// DeleteSchedule удаляет расписание, по которому еще не выставлено ни одного счета
+func (s *billingScheduleService) DeleteSchedule(ctx context.Context, id primitive.ObjectID) error {
+	schedule, err := s.scheduleRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if schedule.LastPeriod > 0 {
+		return &ValidationError{Message: "Schedule has generated invoices; pause it or set an end date instead"}
+	}
+
+	return s.scheduleRepo.Delete(ctx, id)
+}
+
+// PauseSchedule приостанавливает выставление счетов по расписанию
+func (s *billingScheduleService) PauseSchedule(ctx context.Context, id primitive.ObjectID) (*models.BillingSchedule, error) {
+	schedule, err := s.scheduleRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Status != models.BillingScheduleStatusActive {
+		return nil, &ValidationError{Message: "Only active schedules can be paused"}
+	}
+
+	paused, err := s.scheduleRepo.SetStatus(ctx, id, models.BillingScheduleStatusActive, models.BillingScheduleStatusPaused, schedule.LastPeriod, schedule.NextRunDate)
+	if err != nil {
+		return nil, err
+	}
+	if !paused {
+		return nil, &ValidationError{Message: "Only active schedules can be paused"}
+	}
+
+	return s.scheduleRepo.GetByID(ctx, id)
+}
+
+// ResumeSchedule возобновляет расписание. Периоды, начавшиеся до сегодняшнего дня, пока расписание
+// было на паузе, не выставляются.
+func (s *billingScheduleService) ResumeSchedule(ctx context.Context, id primitive.ObjectID) (*models.BillingSchedule, error) {
+	schedule, err := s.scheduleRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Status != models.BillingScheduleStatusPaused {
+		return nil, &ValidationError{Message: "Only paused schedules can be resumed"}
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for schedule.HasPeriod(schedule.LastPeriod+1) && schedule.PeriodStart(schedule.LastPeriod+1).Before(today) {
+		schedule.LastPeriod++
+	}
+	setScheduleProgress(schedule, models.BillingScheduleStatusActive)
+
+	resumed, err := s.scheduleRepo.SetStatus(ctx, id, models.BillingScheduleStatusPaused, schedule.Status, schedule.LastPeriod, schedule.NextRunDate)
+	if err != nil {
+		return nil, err
+	}
+	if !resumed {
+		return nil, &ValidationError{Message: "Only paused schedules can be resumed"}
+	}
+
+	return s.scheduleRepo.GetByID(ctx, id)
+}
+
+// PreviewSchedule строит count следующих счетов расписания без сохранения
+func (s *billingScheduleService) PreviewSchedule(ctx context.Context, id primitive.ObjectID, count int) ([]*models.Invoice, error) {
+	if count < 1 || count > maxSchedulePreview {
+		return nil, &ValidationError{Message: fmt.Sprintf("Preview count must be between 1 and %d", maxSchedulePreview)}
+	}
+
+	schedule, err := s.scheduleRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	broker, err := s.brokerRepo.GetByID(ctx, schedule.BrokerID)
+	if err != nil {
+		return nil, err
+	}
+
+	invoices := []*models.Invoice{}
+	for period := schedule.LastPeriod + 1; len(invoices) < count && schedule.HasPeriod(period); period++ {
+		invoice := newScheduledInvoice(schedule, period)
+		if err := calculateInvoiceTotals(invoice); err != nil {
+			return nil, err
+		}
+		if err := applyPaymentTerms(invoice, broker); err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, invoice)
+	}
+
+	return invoices, nil
+}
+
+// Run выставляет счета по всем наступившим периодам активных расписаний. Каждый период выставляется
+// один раз: повторный запуск после сбоя находит уже созданный счет периода и только отмечает период.
+func (s *billingScheduleService) Run(ctx context.Context) (*models.BillingScheduleSummary, error) {
+	summary := &models.BillingScheduleSummary{}
+	now := time.Now()
+
+	schedules, err := s.scheduleRepo.GetDue(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, schedule := range schedules {
+		summary.SchedulesChecked++
+		if err := s.generate(ctx, schedule, now, summary); err != nil {
+			return nil, err
+		}
+	}
+
+	return summary, nil
+}
+
+// generate выставляет счета по наступившим периодам расписания и отмечает каждый период
+func (s *billingScheduleService) generate(ctx context.Context, schedule *models.BillingSchedule, now time.Time, summary *models.BillingScheduleSummary) error {
+	for period := schedule.LastPeriod + 1; schedule.HasPeriod(period) && !schedule.PeriodStart(period).After(now); period++ {
+		invoice := newScheduledInvoice(schedule, period)
+		invoice.CreditOverride = systemCreditOverride("Recurring invoice")
+		err := s.invoiceService.CreateInvoice(ctx, invoice)
+		created := err == nil
+		// Счет периода мог быть создан прошлым запуском, тогда остается отметить период. Повтор номера счета
+		// тоже дает ошибку уникальности, поэтому период отмечается, только если его счет действительно есть.
+		if mongo.IsDuplicateKeyError(err) {
+			_, lookupErr := s.invoiceRepo.GetByBillingPeriod(ctx, schedule.ID, period)
+			if lookupErr == nil {
+				err = nil
+			} else if lookupErr != mongo.ErrNoDocuments {
+				err = lookupErr
+			}
+		}
+		if err != nil {
+			summary.Failed++
+			log.Printf("Ошибка выставления счета по расписанию %s за период %d: %v", schedule.ID.Hex(), period, err)
+			return nil
+		}
+		if created {
+			summary.InvoicesCreated++
+		}
+
+		schedule.LastPeriod = period
+		setScheduleProgress(schedule, models.BillingScheduleStatusActive)
+
+		advanced, err := s.scheduleRepo.Advance(ctx, schedule.ID, period, schedule.NextRunDate, schedule.Status)
+		if err != nil {
+			return err
+		}
+		// Расписание приостановлено или обработано другим экземпляром приложения
+		if !advanced {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// validateSchedule проверяет расписание и считает сумму счета за период по шаблону позиций.
+// Без позиций счет состоит из одной позиции на сумму расписания.
+func (s *billingScheduleService) validateSchedule(ctx context.Context, schedule *models.BillingSchedule) error {
+	if schedule.Name == "" {
+		return &ValidationError{Message: "Schedule name is required"}
+	}
+	if schedule.BrokerID.IsZero() {
+		return &ValidationError{Message: "Broker ID is required"}
+	}
+	broker, err := s.brokerRepo.GetByID(ctx, schedule.BrokerID)
+	if err != nil {
+		return &ValidationError{Message: "Broker not found"}
+	}
+
+	if err := s.exchangeRates.ValidateCurrency(schedule.Currency); err != nil {
+		return err
+	}
+	if !billingIntervals[schedule.Interval] {
+		return &ValidationError{Message: "Invalid billing interval"}
+	}
+	if schedule.StartDate.IsZero() {
+		return &ValidationError{Message: "Start date is required"}
+	}
+	if schedule.EndDate != nil && schedule.EndDate.Before(schedule.StartDate) {
+		return &ValidationError{Message: "End date cannot be before start date"}
+	}
+
+	if schedule.PaymentTerms != nil {
+		if err := validatePaymentTerms(schedule.PaymentTerms); err != nil {
+			return err
+		}
+	} else if broker.PaymentTerms == nil {
+		return &ValidationError{Message: "Payment terms are required when the broker has no default terms"}
+	}
+
+	if len(schedule.LineItems) == 0 {
+		if schedule.Amount <= 0 {
+			return &ValidationError{Message: "Schedule amount must be greater than zero"}
+		}
+		schedule.LineItems = []models.LineItem{{
+			Type:        models.LineItemTypeOther,
+			Description: schedule.Name,
+			Quantity:    1,
+			UnitPrice:   schedule.Amount,
+		}}
+	}
+
+	template := newScheduledInvoice(schedule, 1)
+	if err := calculateInvoiceTotals(template); err != nil {
+		return err
+	}
+	if template.Amount <= 0 {
+		return &ValidationError{Message: "Schedule amount must be greater than zero"}
+	}
+	schedule.LineItems = template.LineItems
+	schedule.Amount = template.Amount
+
+	return nil
+}
+
+// setScheduleProgress устанавливает дату следующего счета после последнего периода; расписание без следующих
+// периодов завершается
+func setScheduleProgress(schedule *models.BillingSchedule, status string) {
+	next := schedule.LastPeriod + 1
+	if !schedule.HasPeriod(next) {
+		schedule.Status = models.BillingScheduleStatusEnded
+		schedule.NextRunDate = nil
+		return
+	}
+
+	nextRunDate := schedule.PeriodStart(next)
+	schedule.Status = status
+	schedule.NextRunDate = &nextRunDate
+}
+
+// newScheduledInvoice строит счет за период расписания с датой выставления в начале периода
+func newScheduledInvoice(schedule *models.BillingSchedule, period int) *models.Invoice {
+	description := schedule.Description
+	if description == "" {
+		description = schedule.Name
+	}
+	description = fmt.Sprintf("%s (%s - %s)", description,
+		schedule.PeriodStart(period).Format("2006-01-02"), schedule.PeriodEnd(period).Format("2006-01-02"))
+
+	invoice := &models.Invoice{
+		BrokerID:          schedule.BrokerID,
+		Currency:          schedule.Currency,
+		Status:            models.InvoiceStatusIssued,
+		IssueDate:         schedule.PeriodStart(period),
+		LineItems:         append([]models.LineItem(nil), schedule.LineItems...),
+		Description:       description,
+		Notes:             schedule.Notes,
+		BillingScheduleID: schedule.ID,
+		BillingPeriod:     period,
+	}
+	if schedule.PaymentTerms != nil {
+		terms := *schedule.PaymentTerms
+		invoice.PaymentTerms = &terms
+	}
+
+	return invoice
+}
@@ -1,10 +1,14 @@
 package services
 
 import (
+	"billing-system/config"
 	"billing-system/internal/models"
 	"billing-system/internal/repository"
 	"context"
+	"log"
 	"math"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -12,12 +16,14 @@ import (
 // brokerService реализация BrokerService
 type brokerService struct {
 	brokerRepo repository.BrokerRepository
+	currencies config.CurrencyConfig
 }
 
 // NewBrokerService создает новый BrokerService
-func NewBrokerService(brokerRepo repository.BrokerRepository) BrokerService {
+func NewBrokerService(brokerRepo repository.BrokerRepository, currencies config.CurrencyConfig) BrokerService {
 	return &brokerService{
 		brokerRepo: brokerRepo,
+		currencies: currencies,
 	}
 }
 
@@ -76,6 +82,52 @@ func (s *brokerService) UpdateBroker(ctx context.Context, id primitive.ObjectID,
 	return s.brokerRepo.Update(ctx, id, broker)
 }
 
+// UpdateBrokerStatus меняет статус брокера. Кредитный стоп снимается только через ReleaseCreditHold,
+// чтобы снятие было осознанным и сохранялось с причиной.
+func (s *brokerService) UpdateBrokerStatus(ctx context.Context, id primitive.ObjectID, status string) error {
+	if !isValidBrokerStatus(status) {
+		return &ValidationError{Message: "Invalid broker status"}
+	}
+
+	existing, err := s.brokerRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if existing.Status == models.BrokerStatusCreditHold && status != models.BrokerStatusCreditHold {
+		return &ValidationError{Message: "Broker is on credit hold; release the hold to change its status"}
+	}
+
+	return s.brokerRepo.UpdateStatus(ctx, id, status)
+}
+
+// ReleaseCreditHold снимает кредитный стоп брокера с указанием причины и возвращает его в статус active
+func (s *brokerService) ReleaseCreditHold(ctx context.Context, id primitive.ObjectID, req *models.ReleaseCreditHoldRequest, releasedBy string) (*models.Broker, error) {
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		return nil, &ValidationError{Message: "Release reason is required"}
+	}
+
+	released, err := s.brokerRepo.ReleaseCreditHold(ctx, id, &models.CreditHoldRelease{
+		Reason:     reason,
+		ReleasedBy: releasedBy,
+		ReleasedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	broker, err := s.brokerRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !released {
+		return nil, &ValidationError{Message: "Broker is not on credit hold"}
+	}
+
+	log.Printf("Кредитный стоп брокера %s снят пользователем %s: %s", broker.CompanyName, releasedBy, reason)
+	return broker, nil
+}
+
 // DeleteBroker удаляет брокера
 func (s *brokerService) DeleteBroker(ctx context.Context, id primitive.ObjectID) error {
 	// Проверяем, существует ли брокер
@@ -148,6 +200,26 @@ func (s *brokerService) validateBroker(broker *models.Broker) error {
 		return &ValidationError{Message: "Credit limit cannot be negative"}
 	}
 
+	// Кредитный лимит задается в конкретной валюте, по умолчанию - в базовой
+	if broker.CreditLimitCurrency == "" {
+		broker.CreditLimitCurrency = s.currencies.Base
+	}
+	if !s.currencies.IsSupported(broker.CreditLimitCurrency) {
+		return &ValidationError{Message: "Unsupported credit limit currency"}
+	}
+
+	if broker.PaymentTerms != nil {
+		if err := validatePaymentTerms(broker.PaymentTerms); err != nil {
+			return err
+		}
+	}
+
+	if broker.LateFeePolicy != nil {
+		if err := validateLateFeePolicy(broker.LateFeePolicy); err != nil {
+			return err
+		}
+	}
+
 	if broker.ReliabilityScore < 0 || broker.ReliabilityScore > 10 {
 		return &ValidationError{Message: "Reliability score must be between 0 and 10"}
 	}
@@ -155,6 +227,23 @@ func (s *brokerService) validateBroker(broker *models.Broker) error {
 	return nil
 }
 
+// isValidBrokerStatus проверяет валидность статуса брокера
+func isValidBrokerStatus(status string) bool {
+	validStatuses := []string{
+		models.BrokerStatusActive,
+		models.BrokerStatusInactive,
+		models.BrokerStatusSuspended,
+		models.BrokerStatusCreditHold,
+	}
+
+	for _, valid := range validStatuses {
+		if status == valid {
+			return true
+		}
+	}
+	return false
+}
+
 // contains проверяет, содержит ли строка подстроку
 func contains(str, substr string) bool {
 	for i := 0; i <= len(str)-len(substr); i++ {
@@ -0,0 +1,325 @@
+package services
+
+import (
+	"billing-system/config"
+	"billing-system/internal/models"
+	"billing-system/internal/repository"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreditLimitError документ выводит нагрузку брокера за кредитный лимит
+type CreditLimitError struct {
+	Exposure *models.CreditExposure // нагрузка до документа
+	Amount   models.Amount          // сумма документа в валюте лимита
+	Message  string
+}
+
+func (e *CreditLimitError) Error() string {
+	return e.Message
+}
+
+// creditLimitService реализация CreditLimitService
+type creditLimitService struct {
+	invoiceRepo   repository.InvoiceRepository
+	loadRepo      repository.LoadRepository
+	brokerRepo    repository.BrokerRepository
+	exchangeRates ExchangeRateService
+	emailService  EmailService
+	config        config.CreditConfig
+}
+
+// NewCreditLimitService создает новый CreditLimitService
+func NewCreditLimitService(
+	invoiceRepo repository.InvoiceRepository,
+	loadRepo repository.LoadRepository,
+	brokerRepo repository.BrokerRepository,
+	exchangeRates ExchangeRateService,
+	emailService EmailService,
+	config config.CreditConfig,
+) CreditLimitService {
+	return &creditLimitService{
+		invoiceRepo:   invoiceRepo,
+		loadRepo:      loadRepo,
+		brokerRepo:    brokerRepo,
+		exchangeRates: exchangeRates,
+		emailService:  emailService,
+		config:        config,
+	}
+}
+
+// GetExposure рассчитывает текущую кредитную нагрузку брокера
+func (s *creditLimitService) GetExposure(ctx context.Context, brokerID primitive.ObjectID) (*models.CreditExposure, error) {
+	broker, err := s.brokerRepo.GetByID(ctx, brokerID)
+	if err != nil {
+		return nil, err
+	}
+	return s.exposure(ctx, broker)
+}
+
+// CheckLimit проверяет, что документ на сумму amount в валюте currency не выводит брокера за кредитный лимит.
+// При превышении документ принимается только с разрешением override, подтвержденным администратором;
+// возвращается заполненное разрешение для сохранения в документе или nil, если оно не понадобилось.
+// Вызывается в транзакции, которая сохраняет документ (см. CheckChange).
+func (s *creditLimitService) CheckLimit(ctx context.Context, broker *models.Broker, amount models.Amount, currency string, override *models.CreditOverride) (*models.CreditOverride, error) {
+	return s.CheckChange(ctx, broker, 0, currency, amount, currency, override)
+}
+
+// CheckChange проверяет изменение документа, который уже входит в нагрузку брокера суммой previous
+// в валюте previousCurrency (0, если не входит), на сумму amount в валюте currency.
+// Проверяется только прирост нагрузки: уменьшение суммы разрешено и брокеру сверх лимита.
+//
+// Вызывается в транзакции, которая сохраняет документ: брокер блокируется до ее фиксации, поэтому
+// конкурентные документы одного брокера проверяются по очереди и видят нагрузку друг друга.
+// Без курса документа или части нагрузки лимит проверить нельзя, и документ тоже требует разрешения.
+func (s *creditLimitService) CheckChange(ctx context.Context, broker *models.Broker, previous models.Amount, previousCurrency string, amount models.Amount, currency string, override *models.CreditOverride) (*models.CreditOverride, error) {
+	if broker.CreditLimit <= 0 {
+		return nil, nil
+	}
+
+	// Лимит мог измениться с момента чтения брокера вызывающим
+	broker, err := s.brokerRepo.Lock(ctx, broker.ID)
+	if err != nil {
+		return nil, err
+	}
+	if broker.CreditLimit <= 0 {
+		return nil, nil
+	}
+
+	exposure, err := s.exposure(ctx, broker)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	rate, ok, err := s.rateTo(ctx, currency, exposure.Currency, now)
+	if err != nil {
+		return nil, err
+	}
+
+	missingRates := exposure.MissingRates
+	var added models.Amount
+	if ok {
+		added = amount.Mul(rate)
+		// Сумма в валюте без курса не входит в нагрузку, поэтому и не вычитается
+		if previous != 0 {
+			previousRate, ok, err := s.rateTo(ctx, previousCurrency, exposure.Currency, now)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				added -= previous.Mul(previousRate)
+			}
+		}
+		if added <= 0 {
+			return nil, nil
+		}
+	} else {
+		if currency == previousCurrency && amount <= previous {
+			return nil, nil
+		}
+		missingRates = appendMissingRate(missingRates, currency)
+	}
+
+	projected := exposure.Exposure + added
+	if len(missingRates) == 0 && projected <= exposure.CreditLimit {
+		return nil, nil
+	}
+
+	if override == nil || strings.TrimSpace(override.Reason) == "" || override.ApprovedBy == "" {
+		message := fmt.Sprintf("Credit limit of %s %s would be exceeded: exposure %s %s plus %s %s; an administrator can override with a reason",
+			exposure.CreditLimit, exposure.Currency, exposure.Exposure, exposure.Currency, added, exposure.Currency)
+		if len(missingRates) > 0 {
+			message = fmt.Sprintf("Credit limit cannot be checked without exchange rates for %s to %s; an administrator can override with a reason",
+				strings.Join(missingRates, ", "), exposure.Currency)
+		}
+		return nil, &CreditLimitError{
+			Exposure: exposure,
+			Amount:   added,
+			Message:  message,
+		}
+	}
+
+	if len(missingRates) > 0 {
+		log.Printf("Документ брокера %s без проверки кредитного лимита (нет курса %s) разрешен %s: %s",
+			broker.CompanyName, strings.Join(missingRates, ", "), override.ApprovedBy, override.Reason)
+	} else {
+		log.Printf("Превышение кредитного лимита брокера %s разрешено %s: %s", broker.CompanyName, override.ApprovedBy, override.Reason)
+	}
+
+	return &models.CreditOverride{
+		Reason:      strings.TrimSpace(override.Reason),
+		ApprovedBy:  override.ApprovedBy,
+		ApprovedAt:  time.Now(),
+		Exposure:    projected,
+		CreditLimit: exposure.CreditLimit,
+		Currency:    exposure.Currency,
+	}, nil
+}
+
+// NotifyUtilization отправляет предупреждение, когда нагрузка брокера достигает порога от кредитного лимита.
+// Предупреждение отправляется один раз; отметка снимается, когда нагрузка опускается ниже порога.
+func (s *creditLimitService) NotifyUtilization(ctx context.Context, brokerID primitive.ObjectID) error {
+	broker, err := s.brokerRepo.GetByID(ctx, brokerID)
+	if err != nil {
+		return err
+	}
+	exposure, err := s.exposure(ctx, broker)
+	if err != nil {
+		return err
+	}
+
+	if exposure.Status != models.CreditStatusNearLimit && exposure.Status != models.CreditStatusOverLimit {
+		_, err := s.brokerRepo.SetCreditAlert(ctx, brokerID, nil)
+		return err
+	}
+
+	now := time.Now()
+	marked, err := s.brokerRepo.SetCreditAlert(ctx, brokerID, &now)
+	if err != nil || !marked {
+		return err
+	}
+	exposure.AlertSentAt = &now
+
+	log.Printf("Брокер %s использовал %.2f%% кредитного лимита (%s из %s %s)",
+		broker.CompanyName, exposure.UtilizationPercent, exposure.Exposure, exposure.CreditLimit, exposure.Currency)
+
+	if s.emailService == nil || s.config.AlertEmail == "" {
+		return nil
+	}
+	return s.emailService.SendCreditLimitAlert(ctx, s.config.AlertEmail, broker, exposure)
+}
+
+// exposure суммирует остатки открытых счетов и стоимость невыставленных грузов брокера
+// в валюте кредитного лимита по курсу на сегодня
+func (s *creditLimitService) exposure(ctx context.Context, broker *models.Broker) (*models.CreditExposure, error) {
+	currency := broker.CreditLimitCurrency
+	if currency == "" {
+		currency = s.exchangeRates.Currencies().BaseCurrency
+	}
+
+	exposure := &models.CreditExposure{
+		BrokerID:     broker.ID,
+		Currency:     currency,
+		CreditLimit:  broker.CreditLimit,
+		AlertPercent: s.config.AlertPercent,
+		AlertSentAt:  broker.CreditAlertAt,
+		Currencies:   []models.CreditExposureCurrency{},
+		MissingRates: []string{},
+	}
+
+	invoices, err := s.invoiceRepo.SumRemaining(ctx, &models.InvoiceFilter{
+		BrokerID: broker.ID,
+		Status: []string{
+			models.InvoiceStatusIssued,
+			models.InvoiceStatusPartial,
+			models.InvoiceStatusOverdue,
+		},
+	}, s.exchangeRates.Currencies().BaseCurrency)
+	if err != nil {
+		return nil, err
+	}
+	loads, err := s.loadRepo.SumUnbilledByBroker(ctx, broker.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	byCurrency := make(map[string]*models.CreditExposureCurrency)
+	var currencies []string
+	entry := func(currency string) *models.CreditExposureCurrency {
+		if _, ok := byCurrency[currency]; !ok {
+			byCurrency[currency] = &models.CreditExposureCurrency{Currency: currency}
+			currencies = append(currencies, currency)
+		}
+		return byCurrency[currency]
+	}
+	for _, total := range invoices {
+		entry(total.Currency).OpenInvoices += total.Amount
+	}
+	for _, total := range loads {
+		entry(total.Currency).UnbilledLoads += total.Amount
+	}
+
+	now := time.Now()
+	for _, code := range currencies {
+		amounts := byCurrency[code]
+		rate, ok, err := s.rateTo(ctx, code, currency, now)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			amounts.ExchangeRate = rate
+			exposure.OpenInvoices += amounts.OpenInvoices.Mul(rate)
+			exposure.UnbilledLoads += amounts.UnbilledLoads.Mul(rate)
+		} else {
+			exposure.MissingRates = append(exposure.MissingRates, code)
+		}
+		exposure.Currencies = append(exposure.Currencies, *amounts)
+	}
+
+	exposure.Exposure = exposure.OpenInvoices + exposure.UnbilledLoads
+	exposure.Status = models.CreditStatusNoLimit
+	if exposure.HasLimit() {
+		exposure.Available = exposure.CreditLimit - exposure.Exposure
+		exposure.UtilizationPercent = math.Round(float64(exposure.Exposure)/float64(exposure.CreditLimit)*10000) / 100
+
+		switch {
+		case exposure.Exposure > exposure.CreditLimit:
+			exposure.Status = models.CreditStatusOverLimit
+		case exposure.UtilizationPercent >= s.config.AlertPercent:
+			exposure.Status = models.CreditStatusNearLimit
+		default:
+			exposure.Status = models.CreditStatusOK
+		}
+	}
+
+	return exposure, nil
+}
+
+// rateTo курс пересчета из валюты from в валюту to через базовую валюту; false означает, что курса одной из валют нет
+func (s *creditLimitService) rateTo(ctx context.Context, from, to string, date time.Time) (float64, bool, error) {
+	if from == to {
+		return 1, true, nil
+	}
+
+	fromRate, ok, err := s.exchangeRates.FindRate(ctx, from, date)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	toRate, ok, err := s.exchangeRates.FindRate(ctx, to, date)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+
+	return fromRate / toRate, true, nil
+}
+
+// appendMissingRate добавляет валюту без курса, если ее еще нет в списке
+func appendMissingRate(missingRates []string, currency string) []string {
+	for _, code := range missingRates {
+		if code == currency {
+			return missingRates
+		}
+	}
+	return append(append([]string{}, missingRates...), currency)
+}
+
+// systemCreditOverride разрешение превысить кредитный лимит для счета, который выставляет сама система:
+// такие счета не блокируются, а отмечаются для проверки
+func systemCreditOverride(reason string) *models.CreditOverride {
+	return &models.CreditOverride{Reason: reason, ApprovedBy: models.CreditOverrideSystem}
+}
+
+// notifyCreditUtilization проверяет порог кредитного лимита брокера в фоне после создания документа
+func notifyCreditUtilization(creditLimits CreditLimitService, brokerID primitive.ObjectID) {
+	go func() {
+		if err := creditLimits.NotifyUtilization(context.Background(), brokerID); err != nil {
+			log.Printf("Ошибка проверки кредитного лимита брокера %s: %v", brokerID.Hex(), err)
+		}
+	}()
+}
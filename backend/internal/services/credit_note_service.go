@@ -0,0 +1,135 @@
+package services
+
+import (
+	"billing-system/internal/models"
+	"billing-system/internal/repository"
+	"context"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// creditNoteService реализация CreditNoteService
+type creditNoteService struct {
+	creditNoteRepo  repository.CreditNoteRepository
+	invoiceRepo     repository.InvoiceRepository
+	paymentRepo     repository.PaymentRepository
+	unitOfWork      repository.UnitOfWork
+	invoiceBalancer InvoiceBalancer
+	pdfService      PDFService
+}
+
+// NewCreditNoteService создает новый CreditNoteService
+func NewCreditNoteService(
+	creditNoteRepo repository.CreditNoteRepository,
+	invoiceRepo repository.InvoiceRepository,
+	paymentRepo repository.PaymentRepository,
+	unitOfWork repository.UnitOfWork,
+	invoiceBalancer InvoiceBalancer,
+	pdfService PDFService,
+) CreditNoteService {
+	return &creditNoteService{
+		creditNoteRepo:  creditNoteRepo,
+		invoiceRepo:     invoiceRepo,
+		paymentRepo:     paymentRepo,
+		unitOfWork:      unitOfWork,
+		invoiceBalancer: invoiceBalancer,
+		pdfService:      pdfService,
+	}
+}
+
+// CreateCreditNote выписывает кредит-ноту к счету и уменьшает его остаток
+func (s *creditNoteService) CreateCreditNote(ctx context.Context, invoiceID primitive.ObjectID, note *models.CreditNote) error {
+	note.Reason = strings.TrimSpace(note.Reason)
+	if note.Reason == "" {
+		return &ValidationError{Message: "Credit note reason is required"}
+	}
+
+	// Итоги считаются на сервере
+	if err := calculateCreditNoteTotals(note); err != nil {
+		return err
+	}
+
+	return s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		// Блокируем счет от конкурентных платежей и кредит-нот
+		invoice, err := s.invoiceRepo.Lock(ctx, invoiceID)
+		if err == mongo.ErrNoDocuments {
+			return &ValidationError{Message: "Invoice not found"}
+		}
+		if err != nil {
+			return err
+		}
+
+		if invoice.Status == models.InvoiceStatusDraft || invoice.Status == models.InvoiceStatusVoid {
+			return &ValidationError{Message: "Cannot credit a " + invoice.Status + " invoice"}
+		}
+
+		// Кредит-нота выписывается в валюте счета тому же брокеру
+		if note.Currency != "" && note.Currency != invoice.Currency {
+			return &ValidationError{Message: "Credit note currency must match invoice currency"}
+		}
+		note.Currency = invoice.Currency
+		note.InvoiceID = invoice.ID
+		note.BrokerID = invoice.BrokerID
+
+		// Проверяем, что кредит не превышает оставшуюся к оплате сумму
+		totalPaid, err := s.paymentRepo.GetTotalPaidAmount(ctx, invoiceID)
+		if err != nil {
+			return err
+		}
+		totalCredited, err := s.creditNoteRepo.GetTotalByInvoice(ctx, invoiceID)
+		if err != nil {
+			return err
+		}
+		if note.Amount > invoice.Amount-totalPaid-totalCredited-invoice.EarlyDiscount {
+			return &ValidationError{Message: "Credit note amount exceeds remaining amount due"}
+		}
+
+		if err := s.creditNoteRepo.Create(ctx, note); err != nil {
+			return err
+		}
+
+		return s.invoiceBalancer.Recalculate(ctx, invoiceID)
+	})
+}
+
+// GetCreditNote получает кредит-ноту счета по ID
+func (s *creditNoteService) GetCreditNote(ctx context.Context, invoiceID, id primitive.ObjectID) (*models.CreditNote, error) {
+	note, err := s.creditNoteRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Кредит-нота другого счета считается не найденной
+	if note.InvoiceID != invoiceID {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return note, nil
+}
+
+// GetInvoiceCreditNotes получает кредит-ноты счета
+func (s *creditNoteService) GetInvoiceCreditNotes(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.CreditNote, error) {
+	return s.creditNoteRepo.GetByInvoice(ctx, invoiceID)
+}
+
+// RenderCreditNote формирует PDF кредит-ноты счета
+func (s *creditNoteService) RenderCreditNote(ctx context.Context, invoiceID, id primitive.ObjectID) (*models.CreditNote, []byte, error) {
+	note, err := s.GetCreditNote(ctx, invoiceID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	invoice, err := s.invoiceRepo.GetByID(ctx, invoiceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := s.pdfService.RenderCreditNote(ctx, note, invoice)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return note, data, nil
+}
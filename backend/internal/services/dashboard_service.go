@@ -1,184 +1,230 @@
 package services
 
 import (
+	"billing-system/config"
 	"billing-system/internal/models"
 	"billing-system/internal/repository"
 	"context"
+	"sort"
 	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
 // dashboardService реализация DashboardService
 type dashboardService struct {
-	repos *repository.Repositories
+	repos      *repository.Repositories
+	currencies config.CurrencyConfig
+	location   *time.Location // часовой пояс, в котором считаются дни и месяцы периодов
 }
 
 // NewDashboardService создает новый DashboardService
-func NewDashboardService(repos *repository.Repositories) DashboardService {
+func NewDashboardService(repos *repository.Repositories, currencies config.CurrencyConfig, location *time.Location) DashboardService {
 	return &dashboardService{
-		repos: repos,
+		repos:      repos,
+		currencies: currencies,
+		location:   location,
 	}
 }
 
 // GetDashboardMetrics получает все метрики для дашборда
-func (s *dashboardService) GetDashboardMetrics(ctx context.Context) (*models.DashboardMetrics, error) {
-	metrics := &models.DashboardMetrics{}
-
-	// Получаем базовые метрики параллельно
-	totalDebt, err := s.calculateTotalDebt(ctx)
-	if err != nil {
-		return nil, err
-	}
-	metrics.TotalDebt = totalDebt
-
-	overdueAmount, err := s.calculateOverdueAmount(ctx)
-	if err != nil {
-		return nil, err
-	}
-	metrics.OverdueAmount = overdueAmount
-
-	paidThisMonth, err := s.calculatePaidThisMonth(ctx)
-	if err != nil {
-		return nil, err
-	}
-	metrics.PaidThisMonth = paidThisMonth
-
-	paidLastMonth, err := s.calculatePaidLastMonth(ctx)
-	if err != nil {
+func (s *dashboardService) GetDashboardMetrics(ctx context.Context, period *models.DashboardPeriod) (*models.DashboardMetrics, error) {
+	metrics := &models.DashboardMetrics{BaseCurrency: s.currencies.Base}
+	period = normalizeDashboardPeriod(period)
+
+	var (
+		totalDebt     []models.CurrencyAmount
+		overdueAmount []models.CurrencyAmount
+		paidThisMonth []models.CurrencyAmount
+		paidLastMonth []models.CurrencyAmount
+		invoiceCounts *InvoiceCounts
+		loadCounts    *LoadCounts
+	)
+
+	// Независимые запросы выполняем параллельно
+	g, gctx := errgroup.WithContext(ctx)
+
+	g.Go(func() (err error) {
+		totalDebt, err = s.calculateTotalDebt(gctx)
+		return err
+	})
+	g.Go(func() (err error) {
+		overdueAmount, err = s.calculateOverdueAmount(gctx)
+		return err
+	})
+	g.Go(func() (err error) {
+		paidThisMonth, err = s.calculatePaidThisMonth(gctx)
+		return err
+	})
+	g.Go(func() (err error) {
+		paidLastMonth, err = s.calculatePaidLastMonth(gctx)
+		return err
+	})
+	g.Go(func() (err error) {
+		metrics.RealizedFXThisMonth, err = s.calculateRealizedFXThisMonth(gctx)
+		return err
+	})
+	g.Go(func() (err error) {
+		invoiceCounts, err = s.calculateInvoiceCounts(gctx)
+		return err
+	})
+	g.Go(func() (err error) {
+		metrics.ActiveBrokers, err = s.calculateActiveBrokers(gctx)
+		return err
+	})
+	g.Go(func() (err error) {
+		loadCounts, err = s.calculateLoadCounts(gctx)
+		return err
+	})
+	g.Go(func() (err error) {
+		metrics.TopDebtors, err = s.GetTopDebtors(gctx, period.TopDebtors)
+		return err
+	})
+	g.Go(func() (err error) {
+		metrics.PaymentsByDay, err = s.GetPaymentsByPeriod(gctx, period.Days)
+		return err
+	})
+	g.Go(func() (err error) {
+		metrics.InvoicesByStatus, err = s.GetInvoicesByStatus(gctx)
+		return err
+	})
+	g.Go(func() (err error) {
+		metrics.RevenueByMonth, err = s.GetRevenueByMonth(gctx, period.Months)
+		return err
+	})
+
+	if err := g.Wait(); err != nil {
 		return nil, err
 	}
-	metrics.PaidLastMonth = paidLastMonth
 
-	// Подсчет счетов
-	invoiceCounts, err := s.calculateInvoiceCounts(ctx)
-	if err != nil {
-		return nil, err
-	}
 	metrics.TotalInvoices = invoiceCounts.Total
 	metrics.OverdueInvoices = invoiceCounts.Overdue
 	metrics.PendingInvoices = invoiceCounts.Pending
-
-	// Подсчет брокеров
-	activeBrokers, err := s.calculateActiveBrokers(ctx)
-	if err != nil {
-		return nil, err
-	}
-	metrics.ActiveBrokers = activeBrokers
-
-	// Подсчет грузов
-	loadCounts, err := s.calculateLoadCounts(ctx)
-	if err != nil {
-		return nil, err
-	}
 	metrics.TotalLoads = loadCounts.Total
 	metrics.CompletedLoads = loadCounts.Completed
 
-	// Получаем топ должников
-	topDebtors, err := s.GetTopDebtors(ctx, 10)
-	if err != nil {
-		return nil, err
+	// Разбивка денежных метрик по валютам, сводные суммы - в базовой валюте
+	byCurrency := make(map[string]*models.CurrencyMetrics)
+	currencyMetrics := func(currency string) *models.CurrencyMetrics {
+		if m, ok := byCurrency[currency]; ok {
+			return m
+		}
+		m := &models.CurrencyMetrics{Currency: currency}
+		byCurrency[currency] = m
+		return m
+	}
+	missingRates := make(map[string]bool)
+	addTotal := func(total models.CurrencyAmount, byCurrencyField, baseField *models.Amount) {
+		*byCurrencyField = total.Amount
+		*baseField += total.BaseAmount
+		if total.MissingRates > 0 {
+			missingRates[total.Currency] = true
+		}
 	}
-	metrics.TopDebtors = topDebtors
 
-	// Получаем платежи по дням (последние 30 дней)
-	paymentsByDay, err := s.GetPaymentsByPeriod(ctx, 30)
-	if err != nil {
-		return nil, err
+	for _, total := range totalDebt {
+		addTotal(total, &currencyMetrics(total.Currency).TotalDebt, &metrics.TotalDebt)
+	}
+	for _, total := range overdueAmount {
+		addTotal(total, &currencyMetrics(total.Currency).OverdueAmount, &metrics.OverdueAmount)
+	}
+	for _, total := range paidThisMonth {
+		addTotal(total, &currencyMetrics(total.Currency).PaidThisMonth, &metrics.PaidThisMonth)
+	}
+	for _, total := range paidLastMonth {
+		addTotal(total, &currencyMetrics(total.Currency).PaidLastMonth, &metrics.PaidLastMonth)
 	}
-	metrics.PaymentsByDay = paymentsByDay
 
-	// Получаем счета по статусам
-	invoicesByStatus, err := s.GetInvoicesByStatus(ctx)
-	if err != nil {
-		return nil, err
+	metrics.MissingRates = make([]string, 0, len(missingRates))
+	for currency := range missingRates {
+		metrics.MissingRates = append(metrics.MissingRates, currency)
 	}
-	metrics.InvoicesByStatus = invoicesByStatus
+	sort.Strings(metrics.MissingRates)
 
-	// Получаем доходы по месяцам (последние 12 месяцев)
-	revenueByMonth, err := s.GetRevenueByMonth(ctx, 12)
-	if err != nil {
-		return nil, err
+	metrics.Currencies = make([]models.CurrencyMetrics, 0, len(byCurrency))
+	for _, m := range byCurrency {
+		metrics.Currencies = append(metrics.Currencies, *m)
 	}
-	metrics.RevenueByMonth = revenueByMonth
+	sort.Slice(metrics.Currencies, func(i, j int) bool {
+		return metrics.Currencies[i].Currency < metrics.Currencies[j].Currency
+	})
 
 	return metrics, nil
 }
 
 // GetTopDebtors получает топ должников
 func (s *dashboardService) GetTopDebtors(ctx context.Context, limit int) ([]models.TopDebtor, error) {
-	// Используем агрегацию MongoDB для подсчета задолженности по брокерам
-	// Это упрощенная версия - в реальности нужно джоинить коллекции
-
-	// Пока возвращаем пустой массив
-	// TODO: Реализовать агрегацию с джоинами между brokers и invoices
-	return []models.TopDebtor{}, nil
+	return s.repos.Invoice.GetTopDebtors(ctx, limit, s.currencies.Base)
 }
 
-// GetPaymentsByPeriod получает платежи за период
+// GetPaymentsByPeriod получает платежи по дням за последние days дней
 func (s *dashboardService) GetPaymentsByPeriod(ctx context.Context, days int) ([]models.PaymentByDay, error) {
-	// Пока возвращаем пустой массив
-	// TODO: Реализовать агрегацию платежей по дням
-	return []models.PaymentByDay{}, nil
+	now := time.Now().In(s.location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	from := today.AddDate(0, 0, -(days - 1))
+
+	return s.repos.Payment.GetDailyTotals(ctx, from, now, s.currencies.Base)
 }
 
 // GetInvoicesByStatus получает счета по статусам
 func (s *dashboardService) GetInvoicesByStatus(ctx context.Context) ([]models.InvoiceByStatus, error) {
-	// Пока возвращаем пустой массив
-	// TODO: Реализовать агрегацию счетов по статусам
-	return []models.InvoiceByStatus{}, nil
+	return s.repos.Invoice.GetStatusSummary(ctx, s.currencies.Base)
 }
 
-// GetRevenueByMonth получает доходы по месяцам
+// GetRevenueByMonth получает доходы по месяцам за последние months месяцев
 func (s *dashboardService) GetRevenueByMonth(ctx context.Context, months int) ([]models.RevenueByMonth, error) {
-	// Пока возвращаем пустой массив
-	// TODO: Реализовать агрегацию доходов по месяцам
-	return []models.RevenueByMonth{}, nil
+	now := time.Now().In(s.location)
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -(months - 1), 0)
+
+	return s.repos.Invoice.GetRevenueByMonth(ctx, from, s.currencies.Base)
 }
 
-// calculateTotalDebt вычисляет общую задолженность
-func (s *dashboardService) calculateTotalDebt(ctx context.Context) (float64, error) {
-	// Получаем все неоплаченные инвойсы (pending, partial, overdue)
-	filter := &models.InvoiceFilter{
-		Status: []string{"pending", "partial", "overdue"},
+// normalizeDashboardPeriod подставляет значения по умолчанию и ограничивает период
+func normalizeDashboardPeriod(period *models.DashboardPeriod) *models.DashboardPeriod {
+	normalized := models.DashboardPeriod{Days: 30, Months: 12, TopDebtors: 10}
+	if period == nil {
+		return &normalized
 	}
 
-	invoices, _, err := s.repos.Invoice.GetAll(ctx, filter, 1000, 0) // Получаем все неоплаченные инвойсы
-	if err != nil {
-		return 0, err
+	if period.Days > 0 && period.Days <= 366 {
+		normalized.Days = period.Days
 	}
-
-	var totalDebt float64
-	for _, invoice := range invoices {
-		remainingAmount := invoice.Amount - invoice.PaidAmount
-		if remainingAmount > 0 {
-			totalDebt += remainingAmount
-		}
+	if period.Months > 0 && period.Months <= 36 {
+		normalized.Months = period.Months
+	}
+	if period.TopDebtors > 0 && period.TopDebtors <= 100 {
+		normalized.TopDebtors = period.TopDebtors
 	}
 
-	return totalDebt, nil
+	return &normalized
 }
 
-// calculateOverdueAmount вычисляет сумму просроченных платежей
-func (s *dashboardService) calculateOverdueAmount(ctx context.Context) (float64, error) {
-	// Получаем все просроченные инвойсы
-	overdueInvoices, _, err := s.repos.Invoice.GetOverdue(ctx, 1000, 0)
-	if err != nil {
-		return 0, err
+// calculateTotalDebt вычисляет общую задолженность по валютам
+func (s *dashboardService) calculateTotalDebt(ctx context.Context) ([]models.CurrencyAmount, error) {
+	filter := &models.InvoiceFilter{
+		Status: []string{
+			models.InvoiceStatusIssued,
+			models.InvoiceStatusPartial,
+			models.InvoiceStatusOverdue,
+		},
 	}
 
-	var overdueAmount float64
-	for _, invoice := range overdueInvoices {
-		remainingAmount := invoice.Amount - invoice.PaidAmount
-		if remainingAmount > 0 {
-			overdueAmount += remainingAmount
-		}
+	return s.repos.Invoice.SumRemaining(ctx, filter, s.currencies.Base)
+}
+
+// calculateOverdueAmount вычисляет сумму просроченной задолженности по валютам
+func (s *dashboardService) calculateOverdueAmount(ctx context.Context) ([]models.CurrencyAmount, error) {
+	isOverdue := true
+	filter := &models.InvoiceFilter{
+		IsOverdue: &isOverdue,
 	}
 
-	return overdueAmount, nil
+	return s.repos.Invoice.SumRemaining(ctx, filter, s.currencies.Base)
 }
 
-// calculatePaidThisMonth вычисляет сумму оплаченного в этом месяце
-func (s *dashboardService) calculatePaidThisMonth(ctx context.Context) (float64, error) {
-	now := time.Now()
+// calculatePaidThisMonth вычисляет сумму оплаченного в этом месяце по валютам
+func (s *dashboardService) calculatePaidThisMonth(ctx context.Context) ([]models.CurrencyAmount, error) {
+	now := time.Now().In(s.location)
 	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
 	endOfMonth := startOfMonth.AddDate(0, 1, 0).Add(-time.Nanosecond)
 
@@ -187,22 +233,12 @@ func (s *dashboardService) calculatePaidThisMonth(ctx context.Context) (float64,
 		DateTo:   &endOfMonth,
 	}
 
-	payments, _, err := s.repos.Payment.GetAll(ctx, filter, 1000, 0) // Получаем все платежи
-	if err != nil {
-		return 0, err
-	}
-
-	var total float64
-	for _, payment := range payments {
-		total += payment.Amount
-	}
-
-	return total, nil
+	return s.repos.Payment.SumByFilter(ctx, filter, s.currencies.Base)
 }
 
-// calculatePaidLastMonth вычисляет сумму оплаченного в прошлом месяце
-func (s *dashboardService) calculatePaidLastMonth(ctx context.Context) (float64, error) {
-	now := time.Now()
+// calculatePaidLastMonth вычисляет сумму оплаченного в прошлом месяце по валютам
+func (s *dashboardService) calculatePaidLastMonth(ctx context.Context) ([]models.CurrencyAmount, error) {
+	now := time.Now().In(s.location)
 	startOfLastMonth := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, now.Location())
 	endOfLastMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).Add(-time.Nanosecond)
 
@@ -211,17 +247,21 @@ func (s *dashboardService) calculatePaidLastMonth(ctx context.Context) (float64,
 		DateTo:   &endOfLastMonth,
 	}
 
-	payments, _, err := s.repos.Payment.GetAll(ctx, filter, 1000, 0) // Получаем все платежи
-	if err != nil {
-		return 0, err
-	}
+	return s.repos.Payment.SumByFilter(ctx, filter, s.currencies.Base)
+}
 
-	var total float64
-	for _, payment := range payments {
-		total += payment.Amount
+// calculateRealizedFXThisMonth вычисляет курсовую разницу по платежам этого месяца в базовой валюте
+func (s *dashboardService) calculateRealizedFXThisMonth(ctx context.Context) (models.Amount, error) {
+	now := time.Now().In(s.location)
+	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	endOfMonth := startOfMonth.AddDate(0, 1, 0).Add(-time.Nanosecond)
+
+	filter := &models.PaymentFilter{
+		DateFrom: &startOfMonth,
+		DateTo:   &endOfMonth,
 	}
 
-	return total, nil
+	return s.repos.Payment.SumFXGainLoss(ctx, filter)
 }
 
 // InvoiceCounts структура для подсчета счетов
@@ -233,38 +273,37 @@ type InvoiceCounts struct {
 
 // calculateInvoiceCounts вычисляет количество счетов по категориям
 func (s *dashboardService) calculateInvoiceCounts(ctx context.Context) (*InvoiceCounts, error) {
-	// Общее количество инвойсов
-	_, total, err := s.repos.Invoice.GetAll(ctx, nil, 1, 0)
+	byStatus, err := s.repos.Invoice.CountByStatus(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// Просроченные инвойсы
-	_, overdueTotal, err := s.repos.Invoice.GetOverdue(ctx, 1, 0)
+	// Просроченные считаем по сроку оплаты, а не по сохраненному статусу
+	isOverdue := true
+	overdueTotal, err := s.repos.Invoice.Count(ctx, &models.InvoiceFilter{IsOverdue: &isOverdue})
 	if err != nil {
 		return nil, err
 	}
 
-	// Pending инвойсы
-	_, pendingTotal, err := s.repos.Invoice.GetByStatus(ctx, "pending", 1, 0)
-	if err != nil {
-		return nil, err
+	var total int64
+	for _, count := range byStatus {
+		total += count
 	}
 
 	return &InvoiceCounts{
 		Total:   int(total),
 		Overdue: int(overdueTotal),
-		Pending: int(pendingTotal),
+		Pending: int(byStatus[models.InvoiceStatusIssued]),
 	}, nil
 }
 
 // calculateActiveBrokers вычисляет количество активных брокеров
 func (s *dashboardService) calculateActiveBrokers(ctx context.Context) (int, error) {
-	_, total, err := s.repos.Broker.GetAll(ctx, 1, 0)
+	byStatus, err := s.repos.Broker.CountByStatus(ctx)
 	if err != nil {
 		return 0, err
 	}
-	return int(total), nil
+	return int(byStatus[models.BrokerStatusActive]), nil
 }
 
 // LoadCounts структура для подсчета грузов
@@ -275,23 +314,18 @@ type LoadCounts struct {
 
 // calculateLoadCounts вычисляет количество грузов
 func (s *dashboardService) calculateLoadCounts(ctx context.Context) (*LoadCounts, error) {
-	// Общее количество грузов
-	_, total, err := s.repos.Load.GetAll(ctx, nil, 1, 0)
+	byStatus, err := s.repos.Load.CountByStatus(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// Завершенные грузы (статус delivered)
-	filter := &models.LoadFilter{
-		Status: []string{"delivered"},
-	}
-	_, completedTotal, err := s.repos.Load.GetAll(ctx, filter, 1, 0)
-	if err != nil {
-		return nil, err
+	var total int64
+	for _, count := range byStatus {
+		total += count
 	}
 
 	return &LoadCounts{
 		Total:     int(total),
-		Completed: int(completedTotal),
+		Completed: int(byStatus[models.LoadStatusDelivered]),
 	}, nil
 }
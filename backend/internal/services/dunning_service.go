@@ -0,0 +1,219 @@
+package services
+
+import (
+	"billing-system/config"
+	"billing-system/internal/models"
+	"billing-system/internal/repository"
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// dunningPageSize размер страницы при обходе просроченных счетов
+const dunningPageSize = 500
+
+// dunningService реализация DunningService
+type dunningService struct {
+	invoiceRepo  repository.InvoiceRepository
+	brokerRepo   repository.BrokerRepository
+	dunningRepo  repository.DunningRepository
+	emailService EmailService
+	stages       []config.DunningStage
+}
+
+// NewDunningService создает новый DunningService
+func NewDunningService(
+	invoiceRepo repository.InvoiceRepository,
+	brokerRepo repository.BrokerRepository,
+	dunningRepo repository.DunningRepository,
+	emailService EmailService,
+	cfg config.DunningConfig,
+) DunningService {
+	return &dunningService{
+		invoiceRepo:  invoiceRepo,
+		brokerRepo:   brokerRepo,
+		dunningRepo:  dunningRepo,
+		emailService: emailService,
+		stages:       cfg.Stages,
+	}
+}
+
+// dunningNotice письмо брокеру по счетам, достигшим одного этапа
+type dunningNotice struct {
+	brokerID primitive.ObjectID
+	stage    int
+	invoices []*models.Invoice
+}
+
+// Run отправляет напоминания по просроченным счетам.
+// Каждый счет получает только самый старший достигнутый этап, если он еще не отправлялся;
+// письма группируются по брокеру и этапу.
+func (s *dunningService) Run(ctx context.Context) (*models.DunningSummary, error) {
+	summary := &models.DunningSummary{}
+	if len(s.stages) == 0 {
+		return summary, nil
+	}
+
+	now := time.Now()
+
+	var notices []*dunningNotice
+	noticeIndex := make(map[primitive.ObjectID]map[int]*dunningNotice)
+
+	for offset := 0; ; offset += dunningPageSize {
+		invoices, total, err := s.invoiceRepo.GetOverdue(ctx, dunningPageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		if len(invoices) == 0 {
+			break
+		}
+
+		ids := make([]primitive.ObjectID, len(invoices))
+		for i, invoice := range invoices {
+			ids[i] = invoice.ID
+		}
+
+		lastStageDays, err := s.dunningRepo.GetLastStageDays(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, invoice := range invoices {
+			summary.InvoicesChecked++
+
+			lastDays, sent := lastStageDays[invoice.ID]
+			stage := s.nextStage(daysPastDue(invoice.DueDate, now), lastDays, sent)
+			if stage < 0 {
+				continue
+			}
+
+			byStage, ok := noticeIndex[invoice.BrokerID]
+			if !ok {
+				byStage = make(map[int]*dunningNotice)
+				noticeIndex[invoice.BrokerID] = byStage
+			}
+			notice, ok := byStage[stage]
+			if !ok {
+				notice = &dunningNotice{brokerID: invoice.BrokerID, stage: stage}
+				byStage[stage] = notice
+				notices = append(notices, notice)
+			}
+			notice.invoices = append(notice.invoices, invoice)
+		}
+
+		if int64(offset+dunningPageSize) >= total {
+			break
+		}
+	}
+
+	for _, notice := range notices {
+		if err := s.sendNotice(ctx, notice, now, summary); err != nil {
+			summary.Failed++
+			log.Printf("Ошибка отправки напоминания %s брокеру %s: %v",
+				s.stages[notice.stage].Name, notice.brokerID.Hex(), err)
+		}
+	}
+
+	return summary, nil
+}
+
+// GetInvoiceHistory получает историю напоминаний по счету
+func (s *dunningService) GetInvoiceHistory(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.DunningRecord, error) {
+	return s.dunningRepo.GetByInvoice(ctx, invoiceID)
+}
+
+// nextStage возвращает индекс самого старшего достигнутого этапа, который старше уже отправленного, или -1
+func (s *dunningService) nextStage(days, lastStageDays int, sent bool) int {
+	next := -1
+	for i, stage := range s.stages {
+		if stage.DaysPastDue > days {
+			break
+		}
+		next = i
+	}
+
+	if next >= 0 && sent && s.stages[next].DaysPastDue <= lastStageDays {
+		return -1
+	}
+	return next
+}
+
+// sendNotice фиксирует этап по счетам и отправляет письмо.
+// Этап записывается до отправки, чтобы параллельный прогон не отправил его повторно;
+// при ошибке отправки записи удаляются и этап будет отправлен в следующий раз.
+func (s *dunningService) sendNotice(ctx context.Context, notice *dunningNotice, now time.Time, summary *models.DunningSummary) error {
+	stage := s.stages[notice.stage]
+
+	broker, err := s.brokerRepo.GetByID(ctx, notice.brokerID)
+	if err != nil {
+		return err
+	}
+
+	var claimed []*models.DunningRecord
+	var invoices []*models.Invoice
+	for _, invoice := range notice.invoices {
+		record := &models.DunningRecord{
+			InvoiceID:     invoice.ID,
+			BrokerID:      invoice.BrokerID,
+			InvoiceNumber: invoice.InvoiceNumber,
+			Stage:         stage.Name,
+			StageDays:     stage.DaysPastDue,
+			DaysPastDue:   daysPastDue(invoice.DueDate, now),
+			Amount:        invoice.RemainingAmount,
+			Currency:      invoice.Currency,
+			CreditHold:    stage.CreditHold,
+			SentAt:        now,
+		}
+
+		ok, err := s.dunningRepo.Claim(ctx, record)
+		if err != nil {
+			s.releaseClaims(claimed)
+			return err
+		}
+		if ok {
+			claimed = append(claimed, record)
+			invoices = append(invoices, invoice)
+		}
+	}
+
+	if len(invoices) == 0 {
+		return nil
+	}
+
+	if err := s.emailService.SendDunningNotice(ctx, broker, stage, invoices); err != nil {
+		s.releaseClaims(claimed)
+		return err
+	}
+
+	summary.NoticesSent++
+	summary.StagesRecorded += len(claimed)
+
+	if stage.CreditHold && broker.Status != models.BrokerStatusCreditHold {
+		if err := s.brokerRepo.UpdateStatus(ctx, broker.ID, models.BrokerStatusCreditHold); err != nil {
+			return err
+		}
+		summary.CreditHolds++
+	}
+
+	return nil
+}
+
+// releaseClaims удаляет записи об этапах, письмо по которым не было отправлено
+func (s *dunningService) releaseClaims(records []*models.DunningRecord) {
+	for _, record := range records {
+		if err := s.dunningRepo.Delete(context.Background(), record.ID); err != nil {
+			log.Printf("Ошибка удаления записи напоминания по счету %s: %v", record.InvoiceNumber, err)
+		}
+	}
+}
+
+// daysPastDue возвращает количество календарных дней просрочки, как в отчете о возрасте задолженности
+func daysPastDue(dueDate, now time.Time) int {
+	days := int(truncateToDay(now).Sub(truncateToDay(dueDate)).Hours() / 24)
+	if days < 0 {
+		return 0
+	}
+	return days
+}
@@ -5,6 +5,9 @@ import (
 	"billing-system/internal/models"
 	"context"
 	"fmt"
+	"html"
+	"io"
+	"strconv"
 	"strings"
 
 	"gopkg.in/gomail.v2"
@@ -22,22 +25,23 @@ func NewEmailService(config config.EmailConfig) EmailService {
 	}
 }
 
-// SendOverdueNotification отправляет уведомление о просроченных счетах
-func (s *emailService) SendOverdueNotification(ctx context.Context, broker *models.Broker, invoices []*models.Invoice) error {
+// SendDunningNotice отправляет напоминание о просроченных счетах для этапа stage
+func (s *emailService) SendDunningNotice(ctx context.Context, broker *models.Broker, stage config.DunningStage, invoices []*models.Invoice) error {
 	if !s.isConfigured() {
 		return nil // Пропускаем отправку если email не настроен
 	}
 
-	subject := fmt.Sprintf("Уведомление о просроченных счетах - %s", broker.CompanyName)
+	template := dunningTemplateFor(stage)
+	subject := fmt.Sprintf("%s - %s", template.Subject, broker.CompanyName)
 
 	// Формируем тело письма
-	body := s.buildOverdueEmailBody(broker, invoices)
+	body := s.buildDunningEmailBody(broker, template, invoices)
 
 	return s.sendEmail(broker.Email, subject, body)
 }
 
 // SendInvoiceCreated отправляет уведомление о создании счета
-func (s *emailService) SendInvoiceCreated(ctx context.Context, broker *models.Broker, invoice *models.Invoice) error {
+func (s *emailService) SendInvoiceCreated(ctx context.Context, broker *models.Broker, invoice *models.Invoice, attachments ...EmailAttachment) error {
 	if !s.isConfigured() {
 		return nil
 	}
@@ -46,30 +50,57 @@ func (s *emailService) SendInvoiceCreated(ctx context.Context, broker *models.Br
 
 	body := s.buildInvoiceCreatedEmailBody(broker, invoice)
 
-	return s.sendEmail(broker.Email, subject, body)
+	return s.sendEmail(broker.Email, subject, body, attachments...)
 }
 
 // SendPaymentReceived отправляет уведомление о получении платежа
-func (s *emailService) SendPaymentReceived(ctx context.Context, broker *models.Broker, payment *models.Payment, invoice *models.Invoice) error {
+func (s *emailService) SendPaymentReceived(ctx context.Context, broker *models.Broker, payment *models.Payment, invoices []*models.Invoice) error {
 	if !s.isConfigured() {
 		return nil
 	}
 
-	subject := fmt.Sprintf("Платеж получен для счета %s - %s", invoice.InvoiceNumber, broker.CompanyName)
+	subject := fmt.Sprintf("Платеж получен для счета %s - %s", invoiceNumbers(invoices), broker.CompanyName)
+	if len(invoices) > 1 {
+		subject = fmt.Sprintf("Платеж получен для счетов %s - %s", invoiceNumbers(invoices), broker.CompanyName)
+	}
 
-	body := s.buildPaymentReceivedEmailBody(broker, payment, invoice)
+	body := s.buildPaymentReceivedEmailBody(broker, payment, invoices)
 
 	return s.sendEmail(broker.Email, subject, body)
 }
 
+// SendCreditLimitAlert предупреждает получателя to о том, что брокер приблизился к кредитному лимиту или превысил его
+func (s *emailService) SendCreditLimitAlert(ctx context.Context, to string, broker *models.Broker, exposure *models.CreditExposure) error {
+	if !s.isConfigured() {
+		return nil
+	}
+
+	subject := fmt.Sprintf("Кредитный лимит использован на %s%% - %s", strconv.FormatFloat(exposure.UtilizationPercent, 'f', -1, 64), broker.CompanyName)
+
+	body := s.buildCreditLimitAlertEmailBody(broker, exposure)
+
+	return s.sendEmail(to, subject, body)
+}
+
 // sendEmail отправляет email
-func (s *emailService) sendEmail(to, subject, body string) error {
+func (s *emailService) sendEmail(to, subject, body string, attachments ...EmailAttachment) error {
 	m := gomail.NewMessage()
 	m.SetHeader("From", fmt.Sprintf("%s <%s>", s.config.FromName, s.config.FromEmail))
 	m.SetHeader("To", to)
 	m.SetHeader("Subject", subject)
 	m.SetBody("text/html", body)
 
+	for _, attachment := range attachments {
+		data := attachment.Data
+		m.Attach(attachment.Filename,
+			gomail.SetHeader(map[string][]string{"Content-Type": {attachment.ContentType}}),
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(data)
+				return err
+			}),
+		)
+	}
+
 	d := gomail.NewDialer(s.config.SMTPHost, s.config.SMTPPort, s.config.SMTPUsername, s.config.SMTPPassword)
 
 	return d.DialAndSend(m)
@@ -82,17 +113,67 @@ func (s *emailService) isConfigured() bool {
 		s.config.SMTPPassword != ""
 }
 
-// buildOverdueEmailBody формирует тело письма для просроченных счетов
-func (s *emailService) buildOverdueEmailBody(broker *models.Broker, invoices []*models.Invoice) string {
-	var totalAmount float64
+// dunningTemplate тексты письма для этапа напоминаний
+type dunningTemplate struct {
+	Subject string
+	Title   string
+	Color   string
+	Intro   string
+	Closing string
+}
+
+// dunningTemplates шаблоны писем по именам этапов
+var dunningTemplates = map[string]dunningTemplate{
+	"reminder": {
+		Subject: "Напоминание об оплате счетов",
+		Title:   "🔔 Напоминание об оплате",
+		Color:   "#faad14",
+		Intro:   "Возможно, вы упустили из виду, что срок оплаты следующих счетов уже прошел.",
+		Closing: "Если оплата уже отправлена, пожалуйста, не обращайте внимания на это письмо.",
+	},
+	"firm": {
+		Subject: "Просроченные счета",
+		Title:   "⚠️ Уведомление о просроченных счетах",
+		Color:   "#ff4d4f",
+		Intro:   "Обращаем ваше внимание на то, что следующие счета остаются неоплаченными после срока оплаты.",
+		Closing: "Просим вас произвести оплату в кратчайшие сроки. При возникновении вопросов обращайтесь к нашим менеджерам.",
+	},
+	"final": {
+		Subject: "Последнее уведомление о задолженности",
+		Title:   "⛔ Последнее уведомление",
+		Color:   "#a8071a",
+		Intro:   "Несмотря на предыдущие напоминания, следующие счета до сих пор не оплачены.",
+		Closing: "Это последнее уведомление перед приостановкой сотрудничества. Просим немедленно погасить задолженность.",
+	},
+}
+
+// dunningTemplateFor выбирает шаблон письма по имени этапа
+func dunningTemplateFor(stage config.DunningStage) dunningTemplate {
+	if template, ok := dunningTemplates[stage.Name]; ok {
+		return template
+	}
+	if stage.CreditHold {
+		return dunningTemplates["final"]
+	}
+	return dunningTemplates["firm"]
+}
+
+// buildDunningEmailBody формирует тело письма-напоминания о просроченных счетах
+func (s *emailService) buildDunningEmailBody(broker *models.Broker, template dunningTemplate, invoices []*models.Invoice) string {
 	var invoicesList strings.Builder
+	totals := make(map[string]models.Amount)
+	var currencies []string
 
 	for _, invoice := range invoices {
-		totalAmount += invoice.RemainingAmount
+		if _, ok := totals[invoice.Currency]; !ok {
+			currencies = append(currencies, invoice.Currency)
+		}
+		totals[invoice.Currency] += invoice.RemainingAmount
+
 		invoicesList.WriteString(fmt.Sprintf(`
 			<tr>
 				<td>%s</td>
-				<td>%s %.2f</td>
+				<td>%s %s</td>
 				<td>%s</td>
 			</tr>
 		`, invoice.InvoiceNumber,
@@ -101,6 +182,12 @@ func (s *emailService) buildOverdueEmailBody(broker *models.Broker, invoices []*
 			invoice.DueDate.Format("02.01.2006")))
 	}
 
+	// Суммы в разных валютах не складываются
+	totalParts := make([]string, len(currencies))
+	for i, currency := range currencies {
+		totalParts[i] = fmt.Sprintf("%s %s", getCurrencySymbol(currency), totals[currency])
+	}
+
 	return fmt.Sprintf(`
 <!DOCTYPE html>
 <html>
@@ -110,13 +197,13 @@ func (s *emailService) buildOverdueEmailBody(broker *models.Broker, invoices []*
 </head>
 <body style="font-family: Arial, sans-serif; margin: 0; padding: 20px; background-color: #f5f5f5;">
 	<div style="max-width: 600px; margin: 0 auto; background-color: white; padding: 30px; border-radius: 8px; box-shadow: 0 2px 10px rgba(0,0,0,0.1);">
-		<h1 style="color: #ff4d4f; border-bottom: 2px solid #ff4d4f; padding-bottom: 10px;">
-			⚠️ Уведомление о просроченных счетах
+		<h1 style="color: %s; border-bottom: 2px solid %s; padding-bottom: 10px;">
+			%s
 		</h1>
 		
 		<p>Уважаемые коллеги из <strong>%s</strong>!</p>
 		
-		<p>Обращаем ваше внимание на то, что у вас имеются просроченные счета на общую сумму <strong style="color: #ff4d4f;">%.2f</strong>.</p>
+		<p>%s Общая сумма задолженности: <strong style="color: %s;">%s</strong>.</p>
 		
 		<h3>Детали просроченных счетов:</h3>
 		<table style="width: 100%%; border-collapse: collapse; margin: 20px 0;">
@@ -132,7 +219,7 @@ func (s *emailService) buildOverdueEmailBody(broker *models.Broker, invoices []*
 			</tbody>
 		</table>
 		
-		<p style="color: #666;">Просим вас произвести оплату в кратчайшие сроки. При возникновении вопросов обращайтесь к нашим менеджерам.</p>
+		<p style="color: #666;">%s</p>
 		
 		<div style="margin-top: 30px; padding-top: 20px; border-top: 1px solid #ddd; color: #888; font-size: 12px;">
 			<p>С уважением,<br>Команда биллинг-системы</p>
@@ -141,7 +228,11 @@ func (s *emailService) buildOverdueEmailBody(broker *models.Broker, invoices []*
 	</div>
 </body>
 </html>
-	`, broker.CompanyName, totalAmount, invoicesList.String())
+	`, template.Color, template.Color, template.Title,
+		broker.CompanyName,
+		template.Intro, template.Color, strings.Join(totalParts, ", "),
+		invoicesList.String(),
+		template.Closing)
 }
 
 // buildInvoiceCreatedEmailBody формирует тело письма для нового счета
@@ -171,7 +262,7 @@ func (s *emailService) buildInvoiceCreatedEmailBody(broker *models.Broker, invoi
 				</tr>
 				<tr>
 					<td><strong>Сумма:</strong></td>
-					<td style="color: #1890ff; font-size: 18px; font-weight: bold;">%s %.2f</td>
+					<td style="color: #1890ff; font-size: 18px; font-weight: bold;">%s %s</td>
 				</tr>
 				<tr>
 					<td><strong>Срок оплаты:</strong></td>
@@ -184,6 +275,7 @@ func (s *emailService) buildInvoiceCreatedEmailBody(broker *models.Broker, invoi
 			</table>
 		</div>
 		
+		%s
 		<p style="color: #666;">Просим произвести оплату до указанного срока. Спасибо за сотрудничество!</p>
 		
 		<div style="margin-top: 30px; padding-top: 20px; border-top: 1px solid #ddd; color: #888; font-size: 12px;">
@@ -197,11 +289,87 @@ func (s *emailService) buildInvoiceCreatedEmailBody(broker *models.Broker, invoi
 		getCurrencySymbol(invoice.Currency),
 		invoice.Amount,
 		invoice.DueDate.Format("02.01.2006"),
-		invoice.Description)
+		invoice.Description,
+		buildLineItemsHTML(invoice))
+}
+
+// buildLineItemsHTML формирует таблицу позиций счета с расчетом итога; для счета без позиций возвращает пустую строку
+func buildLineItemsHTML(invoice *models.Invoice) string {
+	if len(invoice.LineItems) == 0 {
+		return ""
+	}
+
+	symbol := getCurrencySymbol(invoice.Currency)
+	cell := `style="border: 1px solid #ddd; padding: 8px;"`
+	amountCell := `style="border: 1px solid #ddd; padding: 8px; text-align: right;"`
+
+	var rows strings.Builder
+	for _, item := range invoice.LineItems {
+		tax := "-"
+		if item.TaxRate > 0 {
+			tax = fmt.Sprintf("%s%%", strconv.FormatFloat(item.TaxRate, 'f', -1, 64))
+		}
+		rows.WriteString(fmt.Sprintf(`
+				<tr>
+					<td %s>%s</td>
+					<td %s>%s</td>
+					<td %s>%s %s</td>
+					<td %s>%s</td>
+					<td %s>%s %s</td>
+				</tr>`,
+			cell, html.EscapeString(item.Description),
+			amountCell, strconv.FormatFloat(item.Quantity, 'f', -1, 64),
+			amountCell, symbol, item.UnitPrice,
+			amountCell, tax,
+			amountCell, symbol, item.Amount))
+	}
+
+	totalRow := func(label string, amount models.Amount) string {
+		return fmt.Sprintf(`
+				<tr>
+					<td colspan="4" %s><strong>%s</strong></td>
+					<td %s>%s %s</td>
+				</tr>`, amountCell, label, amountCell, symbol, amount)
+	}
+
+	rows.WriteString(totalRow("Сумма позиций", invoice.Subtotal))
+	for _, discount := range invoice.Discounts {
+		label := "Скидка"
+		if discount.Description != "" {
+			label += ": " + html.EscapeString(discount.Description)
+		}
+		rows.WriteString(totalRow(label, -discount.Amount))
+	}
+	if invoice.TaxTotal > 0 {
+		rows.WriteString(totalRow("Налог", invoice.TaxTotal))
+	}
+	rows.WriteString(totalRow("Итого к оплате", invoice.Amount))
+
+	return fmt.Sprintf(`
+		<h3>Позиции счета:</h3>
+		<table style="width: 100%%; border-collapse: collapse; margin: 20px 0;">
+			<thead>
+				<tr style="background-color: #f0f0f0;">
+					<th %s>Описание</th>
+					<th %s>Кол-во</th>
+					<th %s>Цена</th>
+					<th %s>Налог</th>
+					<th %s>Сумма</th>
+				</tr>
+			</thead>
+			<tbody>%s
+			</tbody>
+		</table>
+		`, cell, amountCell, amountCell, amountCell, amountCell, rows.String())
 }
 
 // buildPaymentReceivedEmailBody формирует тело письма для полученного платежа
-func (s *emailService) buildPaymentReceivedEmailBody(broker *models.Broker, payment *models.Payment, invoice *models.Invoice) string {
+func (s *emailService) buildPaymentReceivedEmailBody(broker *models.Broker, payment *models.Payment, invoices []*models.Invoice) string {
+	label := "счету"
+	if len(invoices) > 1 {
+		label = "счетам"
+	}
+
 	return fmt.Sprintf(`
 <!DOCTYPE html>
 <html>
@@ -217,13 +385,13 @@ func (s *emailService) buildPaymentReceivedEmailBody(broker *models.Broker, paym
 		
 		<p>Уважаемые коллеги из <strong>%s</strong>!</p>
 		
-		<p>Мы получили ваш платеж по счету <strong>%s</strong>.</p>
+		<p>Мы получили ваш платеж по %s <strong>%s</strong>.</p>
 		
 		<div style="background-color: #f6ffed; padding: 20px; border-radius: 4px; margin: 20px 0; border-left: 4px solid #52c41a;">
 			<table style="width: 100%%;">
 				<tr>
 					<td><strong>Сумма платежа:</strong></td>
-					<td style="color: #52c41a; font-size: 18px; font-weight: bold;">%s %.2f</td>
+					<td style="color: #52c41a; font-size: 18px; font-weight: bold;">%s %s</td>
 				</tr>
 				<tr>
 					<td><strong>Дата платежа:</strong></td>
@@ -249,7 +417,8 @@ func (s *emailService) buildPaymentReceivedEmailBody(broker *models.Broker, paym
 </body>
 </html>
 	`, broker.CompanyName,
-		invoice.InvoiceNumber,
+		label,
+		invoiceNumbers(invoices),
 		getCurrencySymbol(payment.Currency),
 		payment.Amount,
 		payment.PaymentDate.Format("02.01.2006 15:04"),
@@ -257,6 +426,71 @@ func (s *emailService) buildPaymentReceivedEmailBody(broker *models.Broker, paym
 		payment.TransactionID)
 }
 
+// buildCreditLimitAlertEmailBody формирует тело предупреждения о кредитном лимите
+func (s *emailService) buildCreditLimitAlertEmailBody(broker *models.Broker, exposure *models.CreditExposure) string {
+	symbol := getCurrencySymbol(exposure.Currency)
+
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="UTF-8">
+	<title>Кредитный лимит брокера</title>
+</head>
+<body style="font-family: Arial, sans-serif; margin: 0; padding: 20px; background-color: #f5f5f5;">
+	<div style="max-width: 600px; margin: 0 auto; background-color: white; padding: 30px; border-radius: 8px; box-shadow: 0 2px 10px rgba(0,0,0,0.1);">
+		<h1 style="color: #faad14; border-bottom: 2px solid #faad14; padding-bottom: 10px;">
+			⚠️ Кредитный лимит брокера
+		</h1>
+		
+		<p>Брокер <strong>%s</strong> использовал <strong>%s%%</strong> кредитного лимита.</p>
+		
+		<div style="background-color: #fffbe6; padding: 20px; border-radius: 4px; margin: 20px 0; border-left: 4px solid #faad14;">
+			<table style="width: 100%%;">
+				<tr>
+					<td><strong>Кредитный лимит:</strong></td>
+					<td>%s %s</td>
+				</tr>
+				<tr>
+					<td><strong>Открытые счета:</strong></td>
+					<td>%s %s</td>
+				</tr>
+				<tr>
+					<td><strong>Невыставленные грузы:</strong></td>
+					<td>%s %s</td>
+				</tr>
+				<tr>
+					<td><strong>Доступно:</strong></td>
+					<td style="font-size: 18px; font-weight: bold;">%s %s</td>
+				</tr>
+			</table>
+		</div>
+		
+		<p style="color: #666;">Новые грузы и счета сверх лимита будут отклоняться без разрешения администратора.</p>
+		
+		<div style="margin-top: 30px; padding-top: 20px; border-top: 1px solid #ddd; color: #888; font-size: 12px;">
+			<p>Биллинг-система</p>
+		</div>
+	</div>
+</body>
+</html>
+	`, html.EscapeString(broker.CompanyName),
+		strconv.FormatFloat(exposure.UtilizationPercent, 'f', -1, 64),
+		symbol, exposure.CreditLimit,
+		symbol, exposure.OpenInvoices,
+		symbol, exposure.UnbilledLoads,
+		symbol, exposure.Available)
+}
+
+// invoiceNumbers возвращает номера счетов через запятую
+func invoiceNumbers(invoices []*models.Invoice) string {
+	numbers := make([]string, len(invoices))
+	for i, invoice := range invoices {
+		numbers[i] = invoice.InvoiceNumber
+	}
+	return strings.Join(numbers, ", ")
+}
+
 // getCurrencySymbol возвращает символ валюты
 func getCurrencySymbol(currency string) string {
 	switch currency {
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"billing-system/config"
+	"billing-system/internal/models"
+	"billing-system/internal/repository"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// exchangeRateService реализация ExchangeRateService
+type exchangeRateService struct {
+	rateRepo   repository.ExchangeRateRepository
+	currencies config.CurrencyConfig
+}
+
+// NewExchangeRateService создает новый ExchangeRateService
+func NewExchangeRateService(rateRepo repository.ExchangeRateRepository, currencies config.CurrencyConfig) ExchangeRateService {
+	return &exchangeRateService{
+		rateRepo:   rateRepo,
+		currencies: currencies,
+	}
+}
+
+// Currencies возвращает базовую валюту и список разрешенных валют
+func (s *exchangeRateService) Currencies() *models.CurrencySettings {
+	return &models.CurrencySettings{
+		BaseCurrency: s.currencies.Base,
+		Supported:    s.currencies.Supported,
+	}
+}
+
+// ValidateCurrency проверяет, что валюта задана и разрешена
+func (s *exchangeRateService) ValidateCurrency(currency string) error {
+	return validateCurrency(s.currencies, currency)
+}
+
+// GetRate получает курс валюты к базовой на дату date (последний загруженный не позже этой даты)
+func (s *exchangeRateService) GetRate(ctx context.Context, currency string, date time.Time) (float64, error) {
+	rate, ok, err := s.FindRate(ctx, currency, date)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, &ValidationError{Message: fmt.Sprintf("No %s/%s exchange rate on or before %s", currency, s.currencies.Base, date.Format("2006-01-02"))}
+	}
+	return rate, nil
+}
+
+// FindRate получает курс валюты к базовой на дату date; false означает, что курс еще не загружен
+func (s *exchangeRateService) FindRate(ctx context.Context, currency string, date time.Time) (float64, bool, error) {
+	if currency == s.currencies.Base {
+		return 1, true, nil
+	}
+
+	rate, err := s.rateRepo.GetOnOrBefore(ctx, currency, s.currencies.Base, truncateToDay(date))
+	if err == mongo.ErrNoDocuments {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return rate.Rate, true, nil
+}
+
+// SaveRates проверяет и сохраняет курсы; курс на уже загруженный день заменяется
+func (s *exchangeRateService) SaveRates(ctx context.Context, rates []*models.ExchangeRate) (int, error) {
+	if len(rates) == 0 {
+		return 0, &ValidationError{Message: "At least one exchange rate is required"}
+	}
+
+	for i, rate := range rates {
+		if err := s.validateRate(rate); err != nil {
+			return 0, &ValidationError{Message: fmt.Sprintf("Rate %d: %s", i+1, err.Error())}
+		}
+	}
+
+	for _, rate := range rates {
+		if err := s.rateRepo.Upsert(ctx, rate); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(rates), nil
+}
+
+// ImportCSV загружает курсы из CSV с колонками date (YYYY-MM-DD), currency, rate и строкой заголовка
+func (s *exchangeRateService) ImportCSV(ctx context.Context, r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return 0, &ValidationError{Message: "CSV file is empty"}
+	}
+	if err != nil {
+		return 0, &ValidationError{Message: fmt.Sprintf("Invalid CSV: %s", err.Error())}
+	}
+	if strings.ToLower(header[0]) != "date" || strings.ToLower(header[1]) != "currency" || strings.ToLower(header[2]) != "rate" {
+		return 0, &ValidationError{Message: "CSV header must be: date,currency,rate"}
+	}
+
+	var rates []*models.ExchangeRate
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, &ValidationError{Message: fmt.Sprintf("Invalid CSV: %s", err.Error())}
+		}
+
+		date, err := time.Parse("2006-01-02", record[0])
+		if err != nil {
+			return 0, &ValidationError{Message: fmt.Sprintf("Line %d: date must be in YYYY-MM-DD format", line)}
+		}
+		value, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			return 0, &ValidationError{Message: fmt.Sprintf("Line %d: rate must be a number", line)}
+		}
+
+		rate := &models.ExchangeRate{
+			Currency: record[1],
+			Date:     date,
+			Rate:     value,
+			Source:   models.ExchangeRateSourceCSV,
+		}
+		if err := s.validateRate(rate); err != nil {
+			return 0, &ValidationError{Message: fmt.Sprintf("Line %d: %s", line, err.Error())}
+		}
+		rates = append(rates, rate)
+	}
+
+	if len(rates) == 0 {
+		return 0, &ValidationError{Message: "CSV file contains no rates"}
+	}
+
+	return s.SaveRates(ctx, rates)
+}
+
+// GetRates получает загруженные курсы к базовой валюте
+func (s *exchangeRateService) GetRates(ctx context.Context, filter *models.ExchangeRateFilter) ([]*models.ExchangeRate, error) {
+	return s.rateRepo.GetList(ctx, s.currencies.Base, filter)
+}
+
+// validateRate проверяет курс и приводит его к базовой валюте и началу дня
+func (s *exchangeRateService) validateRate(rate *models.ExchangeRate) error {
+	rate.Currency = strings.ToUpper(strings.TrimSpace(rate.Currency))
+	if err := s.ValidateCurrency(rate.Currency); err != nil {
+		return err
+	}
+	if rate.Currency == s.currencies.Base {
+		return errors.New("rate of the base currency is always 1")
+	}
+	if rate.Date.IsZero() {
+		return errors.New("date is required")
+	}
+	if rate.Rate <= 0 {
+		return errors.New("rate must be greater than zero")
+	}
+
+	rate.BaseCurrency = s.currencies.Base
+	rate.Date = truncateToDay(rate.Date)
+	if rate.Source == "" {
+		rate.Source = models.ExchangeRateSourceAPI
+	}
+
+	return nil
+}
+
+// validateCurrency проверяет, что валюта задана и входит в список разрешенных
+func validateCurrency(currencies config.CurrencyConfig, currency string) error {
+	if currency == "" {
+		return &ValidationError{Message: "Currency is required"}
+	}
+	if !currencies.IsSupported(currency) {
+		return &ValidationError{Message: fmt.Sprintf("Unsupported currency %s", currency)}
+	}
+	return nil
+}
@@ -0,0 +1,537 @@
+package services
+
+import (
+	"billing-system/internal/models"
+	"billing-system/internal/repository"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// exportService реализация ExportService
+type exportService struct {
+	invoiceRepo repository.InvoiceRepository
+	paymentRepo repository.PaymentRepository
+	brokerRepo  repository.BrokerRepository
+}
+
+// NewExportService создает новый ExportService
+func NewExportService(
+	invoiceRepo repository.InvoiceRepository,
+	paymentRepo repository.PaymentRepository,
+	brokerRepo repository.BrokerRepository,
+) ExportService {
+	return &exportService{
+		invoiceRepo: invoiceRepo,
+		paymentRepo: paymentRepo,
+		brokerRepo:  brokerRepo,
+	}
+}
+
+// ExportInvoices готовит экспорт счетов по фильтрам запроса
+func (s *exportService) ExportInvoices(ctx context.Context, req *models.ExportRequest) (ExportFunc, error) {
+	format, err := NormalizeExportFormat(req.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := invoiceFilterFromExport(req)
+	if err != nil {
+		return nil, err
+	}
+
+	header := []string{
+		"Invoice Number", "Broker", "Status", "Currency", "Subtotal", "Discount", "Tax", "Amount", "Paid", "Credited", "Remaining",
+		"Created", "Due Date", "Paid At", "Description", "Line Items",
+	}
+
+	cursor, err := s.invoiceRepo.OpenCursor(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, w io.Writer) error {
+		rows, err := newExportRowWriter(format, w, "Invoices", header)
+		if err != nil {
+			cursor.Close(ctx)
+			return err
+		}
+
+		err = cursor.Each(ctx, func(invoice *models.Invoice) error {
+			return rows.WriteRow([]interface{}{
+				invoice.InvoiceNumber,
+				invoice.BrokerName,
+				invoice.Status,
+				invoice.Currency,
+				invoice.Subtotal,
+				invoice.DiscountTotal,
+				invoice.TaxTotal,
+				invoice.Amount,
+				invoice.PaidAmount,
+				invoice.CreditedAmount,
+				invoice.RemainingAmount,
+				invoice.CreatedAt,
+				invoice.DueDate,
+				invoice.PaidAt,
+				invoice.Description,
+				formatLineItems(invoice.LineItems),
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		return rows.Close()
+	}, nil
+}
+
+// formatLineItems описывает позиции счета одной ячейкой: "Linehaul 1 x 1500.00 = 1500.00; ..."
+func formatLineItems(items []models.LineItem) string {
+	parts := make([]string, len(items))
+	for i, item := range items {
+		part := fmt.Sprintf("%s %s x %s = %s", item.Description,
+			strconv.FormatFloat(item.Quantity, 'f', -1, 64), formatAmount(item.UnitPrice), formatAmount(item.Amount))
+		if item.TaxRate > 0 {
+			part += fmt.Sprintf(" (tax %s%%)", strconv.FormatFloat(item.TaxRate, 'f', -1, 64))
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, "; ")
+}
+
+// formatAllocations описывает разнесение платежа одной ячейкой: "INV-202401-0001: 1500.00; ..."
+func formatAllocations(allocations []models.PaymentAllocation) string {
+	parts := make([]string, len(allocations))
+	for i, allocation := range allocations {
+		parts[i] = fmt.Sprintf("%s: %s", allocation.InvoiceNumber, formatAmount(allocation.Amount))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ExportPayments готовит экспорт платежей по фильтрам запроса
+func (s *exportService) ExportPayments(ctx context.Context, req *models.ExportRequest) (ExportFunc, error) {
+	format, err := NormalizeExportFormat(req.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := paymentFilterFromExport(req)
+	if err != nil {
+		return nil, err
+	}
+
+	header := []string{
+		"Payment Date", "Type", "Reason", "Broker", "Invoice Number", "Allocations", "Currency", "Amount", "Unapplied", "Exchange Rate", "Base Amount", "FX Gain/Loss",
+		"Method", "Transaction ID", "Reference Number", "Notes",
+	}
+
+	cursor, err := s.paymentRepo.OpenCursor(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, w io.Writer) error {
+		rows, err := newExportRowWriter(format, w, "Payments", header)
+		if err != nil {
+			cursor.Close(ctx)
+			return err
+		}
+
+		err = cursor.Each(ctx, func(payment *models.Payment) error {
+			return rows.WriteRow([]interface{}{
+				payment.PaymentDate,
+				payment.Type,
+				payment.ReasonCode,
+				payment.BrokerName,
+				payment.InvoiceNumber,
+				formatAllocations(payment.Allocations),
+				payment.Currency,
+				payment.Amount,
+				payment.UnappliedAmount,
+				payment.ExchangeRate,
+				payment.BaseAmount,
+				payment.FXGainLoss,
+				payment.PaymentMethod,
+				payment.TransactionID,
+				payment.ReferenceNumber,
+				payment.Notes,
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		return rows.Close()
+	}, nil
+}
+
+// ExportBrokers готовит экспорт брокеров
+func (s *exportService) ExportBrokers(ctx context.Context, req *models.ExportRequest) (ExportFunc, error) {
+	format, err := NormalizeExportFormat(req.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	header := []string{
+		"Company Name", "Contact Person", "Email", "Phone", "Street", "City", "State",
+		"Country", "Zip Code", "Credit Limit", "Reliability Score", "Status", "Created",
+	}
+
+	cursor, err := s.brokerRepo.OpenCursor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, w io.Writer) error {
+		rows, err := newExportRowWriter(format, w, "Brokers", header)
+		if err != nil {
+			cursor.Close(ctx)
+			return err
+		}
+
+		err = cursor.Each(ctx, func(broker *models.Broker) error {
+			return rows.WriteRow([]interface{}{
+				broker.CompanyName,
+				broker.ContactPerson,
+				broker.Email,
+				broker.Phone,
+				broker.Address.Street,
+				broker.Address.City,
+				broker.Address.State,
+				broker.Address.Country,
+				broker.Address.ZipCode,
+				broker.CreditLimit,
+				broker.ReliabilityScore,
+				broker.Status,
+				broker.CreatedAt,
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		return rows.Close()
+	}, nil
+}
+
+// NormalizeExportFormat приводит формат экспорта к поддерживаемому значению
+func NormalizeExportFormat(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "", models.ExportFormatCSV:
+		return models.ExportFormatCSV, nil
+	case models.ExportFormatXLSX, "excel":
+		return models.ExportFormatXLSX, nil
+	default:
+		return "", &ValidationError{Message: "Unsupported export format"}
+	}
+}
+
+// invoiceFilterFromExport строит фильтр счетов из запроса экспорта
+func invoiceFilterFromExport(req *models.ExportRequest) (*models.InvoiceFilter, error) {
+	filter := &models.InvoiceFilter{
+		DateFrom: req.DateFrom,
+		DateTo:   req.DateTo,
+	}
+
+	var err error
+	if filter.Status, err = exportFilterStrings(req.Filters, "status"); err != nil {
+		return nil, err
+	}
+	if filter.BrokerID, err = exportFilterObjectID(req.Filters, "broker_id"); err != nil {
+		return nil, err
+	}
+	if filter.Currency, err = exportFilterString(req.Filters, "currency"); err != nil {
+		return nil, err
+	}
+	if filter.AmountFrom, err = exportFilterAmount(req.Filters, "amount_from"); err != nil {
+		return nil, err
+	}
+	if filter.AmountTo, err = exportFilterAmount(req.Filters, "amount_to"); err != nil {
+		return nil, err
+	}
+	if filter.IsOverdue, err = exportFilterBool(req.Filters, "is_overdue"); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// paymentFilterFromExport строит фильтр платежей из запроса экспорта
+func paymentFilterFromExport(req *models.ExportRequest) (*models.PaymentFilter, error) {
+	filter := &models.PaymentFilter{
+		DateFrom: req.DateFrom,
+		DateTo:   req.DateTo,
+	}
+
+	var err error
+	if filter.InvoiceID, err = exportFilterObjectID(req.Filters, "invoice_id"); err != nil {
+		return nil, err
+	}
+	if filter.BrokerID, err = exportFilterObjectID(req.Filters, "broker_id"); err != nil {
+		return nil, err
+	}
+	if filter.PaymentMethod, err = exportFilterString(req.Filters, "payment_method"); err != nil {
+		return nil, err
+	}
+	if filter.Type, err = exportFilterString(req.Filters, "type"); err != nil {
+		return nil, err
+	}
+	if filter.Currency, err = exportFilterString(req.Filters, "currency"); err != nil {
+		return nil, err
+	}
+	if filter.AmountFrom, err = exportFilterAmount(req.Filters, "amount_from"); err != nil {
+		return nil, err
+	}
+	if filter.AmountTo, err = exportFilterAmount(req.Filters, "amount_to"); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// exportFilterString получает строковый фильтр
+func exportFilterString(filters map[string]interface{}, key string) (string, error) {
+	value, ok := filters[key]
+	if !ok || value == nil {
+		return "", nil
+	}
+
+	str, ok := value.(string)
+	if !ok {
+		return "", &ValidationError{Message: fmt.Sprintf("Filter %s must be a string", key)}
+	}
+
+	return str, nil
+}
+
+// exportFilterStrings получает список строк (массив или строка через запятую)
+func exportFilterStrings(filters map[string]interface{}, key string) ([]string, error) {
+	value, ok := filters[key]
+	if !ok || value == nil {
+		return nil, nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		return strings.Split(v, ","), nil
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return nil, &ValidationError{Message: fmt.Sprintf("Filter %s must contain strings", key)}
+			}
+			result = append(result, str)
+		}
+		return result, nil
+	default:
+		return nil, &ValidationError{Message: fmt.Sprintf("Filter %s must be a string or a list", key)}
+	}
+}
+
+// exportFilterObjectID получает фильтр по ID
+func exportFilterObjectID(filters map[string]interface{}, key string) (primitive.ObjectID, error) {
+	str, err := exportFilterString(filters, key)
+	if err != nil || str == "" {
+		return primitive.NilObjectID, err
+	}
+
+	id, err := primitive.ObjectIDFromHex(str)
+	if err != nil {
+		return primitive.NilObjectID, &ValidationError{Message: fmt.Sprintf("Filter %s must be a valid ID", key)}
+	}
+
+	return id, nil
+}
+
+// exportFilterAmount получает фильтр по сумме
+func exportFilterAmount(filters map[string]interface{}, key string) (*models.Amount, error) {
+	value, ok := filters[key]
+	if !ok || value == nil {
+		return nil, nil
+	}
+
+	switch v := value.(type) {
+	case float64:
+		amount := models.AmountFromFloat(v)
+		return &amount, nil
+	case string:
+		amount, err := models.ParseAmount(v)
+		if err != nil {
+			return nil, &ValidationError{Message: fmt.Sprintf("Filter %s must be a number", key)}
+		}
+		return &amount, nil
+	default:
+		return nil, &ValidationError{Message: fmt.Sprintf("Filter %s must be a number", key)}
+	}
+}
+
+// exportFilterBool получает логический фильтр
+func exportFilterBool(filters map[string]interface{}, key string) (*bool, error) {
+	value, ok := filters[key]
+	if !ok || value == nil {
+		return nil, nil
+	}
+
+	b, ok := value.(bool)
+	if !ok {
+		return nil, &ValidationError{Message: fmt.Sprintf("Filter %s must be a boolean", key)}
+	}
+
+	return &b, nil
+}
+
+// exportRowWriter построчная запись экспорта
+type exportRowWriter interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// newExportRowWriter создает writer для указанного формата и записывает заголовок
+func newExportRowWriter(format string, w io.Writer, sheet string, header []string) (exportRowWriter, error) {
+	var rows exportRowWriter
+	switch format {
+	case models.ExportFormatXLSX:
+		xlsx, err := newXLSXRowWriter(w, sheet)
+		if err != nil {
+			return nil, err
+		}
+		rows = xlsx
+	default:
+		rows = &csvRowWriter{writer: csv.NewWriter(w)}
+	}
+
+	values := make([]interface{}, len(header))
+	for i, title := range header {
+		values[i] = title
+	}
+	if err := rows.WriteRow(values); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// csvRowWriter запись экспорта в CSV
+type csvRowWriter struct {
+	writer *csv.Writer
+}
+
+// WriteRow записывает строку CSV
+func (r *csvRowWriter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = formatExportValue(value)
+	}
+	return r.writer.Write(record)
+}
+
+// Close сбрасывает буфер CSV
+func (r *csvRowWriter) Close() error {
+	r.writer.Flush()
+	return r.writer.Error()
+}
+
+// xlsxRowWriter потоковая запись экспорта в XLSX
+type xlsxRowWriter struct {
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	out    io.Writer
+	row    int
+}
+
+// newXLSXRowWriter создает книгу с одним листом для потоковой записи
+func newXLSXRowWriter(w io.Writer, sheet string) (*xlsxRowWriter, error) {
+	file := excelize.NewFile()
+	if err := file.SetSheetName(file.GetSheetName(0), sheet); err != nil {
+		return nil, err
+	}
+
+	stream, err := file.NewStreamWriter(sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	return &xlsxRowWriter{file: file, stream: stream, out: w, row: 1}, nil
+}
+
+// WriteRow записывает строку листа
+func (r *xlsxRowWriter) WriteRow(values []interface{}) error {
+	cells := make([]interface{}, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case time.Time:
+			if v.IsZero() {
+				cells[i] = ""
+			} else {
+				cells[i] = v.Format("2006-01-02")
+			}
+		case *time.Time:
+			if v == nil {
+				cells[i] = ""
+			} else {
+				cells[i] = v.Format("2006-01-02")
+			}
+		case models.Amount:
+			// Сумма записывается числом, чтобы в Excel работали формулы
+			cells[i] = v.Float64()
+		default:
+			cells[i] = v
+		}
+	}
+
+	cell, err := excelize.CoordinatesToCellName(1, r.row)
+	if err != nil {
+		return err
+	}
+	r.row++
+
+	return r.stream.SetRow(cell, cells)
+}
+
+// Close завершает лист и записывает книгу
+func (r *xlsxRowWriter) Close() error {
+	defer r.file.Close()
+
+	if err := r.stream.Flush(); err != nil {
+		return err
+	}
+
+	return r.file.Write(r.out)
+}
+
+// formatExportValue форматирует значение ячейки для CSV
+func formatExportValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case models.Amount:
+		return formatAmount(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format("2006-01-02")
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.Format("2006-01-02")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -1,8 +1,11 @@
 package services
 
 import (
+	"billing-system/config"
 	"billing-system/internal/models"
 	"context"
+	"io"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -13,6 +16,8 @@ type BrokerService interface {
 	GetBroker(ctx context.Context, id primitive.ObjectID) (*models.Broker, error)
 	GetAllBrokers(ctx context.Context, page, limit int) ([]*models.Broker, *models.Pagination, error)
 	UpdateBroker(ctx context.Context, id primitive.ObjectID, broker *models.Broker) error
+	UpdateBrokerStatus(ctx context.Context, id primitive.ObjectID, status string) error
+	ReleaseCreditHold(ctx context.Context, id primitive.ObjectID, req *models.ReleaseCreditHoldRequest, releasedBy string) (*models.Broker, error)
 	DeleteBroker(ctx context.Context, id primitive.ObjectID) error
 	SearchBrokers(ctx context.Context, query string, page, limit int) ([]*models.Broker, *models.Pagination, error)
 	GetBrokerStats(ctx context.Context, brokerID primitive.ObjectID) (*models.BrokerStats, error)
@@ -21,15 +26,34 @@ type BrokerService interface {
 // InvoiceService интерфейс для работы со счетами
 type InvoiceService interface {
 	CreateInvoice(ctx context.Context, invoice *models.Invoice) error
+	InsertInvoice(ctx context.Context, invoice *models.Invoice) error
+	NotifyInvoiceCreated(invoice *models.Invoice)
+	CreateInvoiceFromLoads(ctx context.Context, req *models.InvoiceFromLoadsRequest) ([]*models.Invoice, error)
 	GetInvoice(ctx context.Context, id primitive.ObjectID) (*models.Invoice, error)
 	GetAllInvoices(ctx context.Context, filter *models.InvoiceFilter, page, limit int) ([]*models.Invoice, *models.Pagination, error)
 	UpdateInvoice(ctx context.Context, id primitive.ObjectID, invoice *models.Invoice) error
 	DeleteInvoice(ctx context.Context, id primitive.ObjectID) error
+	IssueInvoice(ctx context.Context, id primitive.ObjectID, req *models.IssueInvoiceRequest) (*models.Invoice, error)
+	VoidInvoice(ctx context.Context, id primitive.ObjectID, req *models.VoidInvoiceRequest, voidedBy string) (*models.Invoice, error)
 	GetInvoicesByStatus(ctx context.Context, status string, page, limit int) ([]*models.Invoice, *models.Pagination, error)
 	GetInvoicesByBroker(ctx context.Context, brokerID primitive.ObjectID, page, limit int) ([]*models.Invoice, *models.Pagination, error)
 	GetOverdueInvoices(ctx context.Context, page, limit int) ([]*models.Invoice, *models.Pagination, error)
 	UpdateInvoiceStatus(ctx context.Context, invoiceID primitive.ObjectID) error
-	SendOverdueNotifications(ctx context.Context) error
+	MarkOverdueInvoices(ctx context.Context) (int64, error)
+}
+
+// InvoiceBalancer пересчитывает баланс счета по разнесениям платежей и кредит-нотам.
+// Используется и счетами, и платежами, поэтому каждое изменение платежа обновляет счет.
+type InvoiceBalancer interface {
+	Recalculate(ctx context.Context, invoiceID primitive.ObjectID) error
+}
+
+// CreditNoteService интерфейс для работы с кредит-нотами
+type CreditNoteService interface {
+	CreateCreditNote(ctx context.Context, invoiceID primitive.ObjectID, note *models.CreditNote) error
+	GetCreditNote(ctx context.Context, invoiceID, id primitive.ObjectID) (*models.CreditNote, error)
+	GetInvoiceCreditNotes(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.CreditNote, error)
+	RenderCreditNote(ctx context.Context, invoiceID, id primitive.ObjectID) (*models.CreditNote, []byte, error)
 }
 
 // PaymentService интерфейс для работы с платежами
@@ -41,6 +65,21 @@ type PaymentService interface {
 	DeletePayment(ctx context.Context, id primitive.ObjectID) error
 	GetPaymentsByInvoice(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.Payment, error)
 	GetPaymentsByBroker(ctx context.Context, brokerID primitive.ObjectID, page, limit int) ([]*models.Payment, *models.Pagination, error)
+	GetBrokerCredit(ctx context.Context, brokerID primitive.ObjectID) ([]models.BrokerCredit, error)
+	ApplyBrokerCredit(ctx context.Context, brokerID primitive.ObjectID, req *models.ApplyCreditRequest) (*models.ApplyCreditResult, error)
+	ReversePayment(ctx context.Context, id primitive.ObjectID, req *models.ReversePaymentRequest, createdBy string) (*models.PaymentReversalResult, error)
+	RefundPayment(ctx context.Context, id primitive.ObjectID, req *models.RefundPaymentRequest, createdBy string) (*models.Payment, error)
+	GetPaymentAdjustments(ctx context.Context, id primitive.ObjectID) ([]*models.Payment, error)
+}
+
+// BankReconciliationService интерфейс для загрузки банковских выписок и сверки поступлений с платежами
+type BankReconciliationService interface {
+	ImportStatement(ctx context.Context, format, currency string, r io.Reader, importedBy string) (*models.BankImportResult, error)
+	GetTransaction(ctx context.Context, id primitive.ObjectID) (*models.BankTransaction, error)
+	GetTransactions(ctx context.Context, filter *models.BankTransactionFilter, page, limit int) ([]*models.BankTransaction, *models.Pagination, error)
+	ConfirmTransaction(ctx context.Context, id primitive.ObjectID, req *models.ConfirmBankTransactionRequest, reviewedBy string) (*models.Payment, error)
+	SplitTransaction(ctx context.Context, id primitive.ObjectID, req *models.SplitBankTransactionRequest, reviewedBy string) ([]*models.BankTransaction, error)
+	RejectTransaction(ctx context.Context, id primitive.ObjectID, req *models.RejectBankTransactionRequest, reviewedBy string) (*models.BankTransaction, error)
 }
 
 // LoadService интерфейс для работы с грузами
@@ -58,23 +97,92 @@ type LoadService interface {
 
 // DashboardService интерфейс для дашборда
 type DashboardService interface {
-	GetDashboardMetrics(ctx context.Context) (*models.DashboardMetrics, error)
+	GetDashboardMetrics(ctx context.Context, period *models.DashboardPeriod) (*models.DashboardMetrics, error)
 	GetTopDebtors(ctx context.Context, limit int) ([]models.TopDebtor, error)
 	GetPaymentsByPeriod(ctx context.Context, days int) ([]models.PaymentByDay, error)
 	GetInvoicesByStatus(ctx context.Context) ([]models.InvoiceByStatus, error)
 	GetRevenueByMonth(ctx context.Context, months int) ([]models.RevenueByMonth, error)
 }
 
+// ReportService интерфейс для финансовых отчетов
+type ReportService interface {
+	GetAgingReport(ctx context.Context, asOf time.Time, brokerID primitive.ObjectID) (*models.AgingReport, error)
+	WriteAgingReportCSV(w io.Writer, report *models.AgingReport) error
+}
+
+// DunningService интерфейс для поэтапных напоминаний о задолженности
+type DunningService interface {
+	Run(ctx context.Context) (*models.DunningSummary, error)
+	GetInvoiceHistory(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.DunningRecord, error)
+}
+
+// LateFeeService интерфейс для начисления и списания пеней за просрочку
+type LateFeeService interface {
+	Run(ctx context.Context) (*models.LateFeeSummary, error)
+	GetInvoiceLateFees(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.Invoice, error)
+	WaiveLateFee(ctx context.Context, id primitive.ObjectID, req *models.WaiveLateFeeRequest, waivedBy string) (*models.Invoice, error)
+}
+
+// CreditLimitService интерфейс для контроля кредитных лимитов брокеров
+type CreditLimitService interface {
+	GetExposure(ctx context.Context, brokerID primitive.ObjectID) (*models.CreditExposure, error)
+	CheckLimit(ctx context.Context, broker *models.Broker, amount models.Amount, currency string, override *models.CreditOverride) (*models.CreditOverride, error)
+	CheckChange(ctx context.Context, broker *models.Broker, previous models.Amount, previousCurrency string, amount models.Amount, currency string, override *models.CreditOverride) (*models.CreditOverride, error)
+	NotifyUtilization(ctx context.Context, brokerID primitive.ObjectID) error
+}
+
+// BillingScheduleService интерфейс для расписаний регулярных счетов
+type BillingScheduleService interface {
+	CreateSchedule(ctx context.Context, schedule *models.BillingSchedule, createdBy string) error
+	GetSchedule(ctx context.Context, id primitive.ObjectID) (*models.BillingSchedule, error)
+	GetSchedules(ctx context.Context, filter *models.BillingScheduleFilter, page, limit int) ([]*models.BillingSchedule, *models.Pagination, error)
+	UpdateSchedule(ctx context.Context, id primitive.ObjectID, schedule *models.BillingSchedule) error
+	DeleteSchedule(ctx context.Context, id primitive.ObjectID) error
+	PauseSchedule(ctx context.Context, id primitive.ObjectID) (*models.BillingSchedule, error)
+	ResumeSchedule(ctx context.Context, id primitive.ObjectID) (*models.BillingSchedule, error)
+	PreviewSchedule(ctx context.Context, id primitive.ObjectID, count int) ([]*models.Invoice, error)
+	Run(ctx context.Context) (*models.BillingScheduleSummary, error)
+}
+
+// ExchangeRateService интерфейс для курсов валют и пересчета в базовую валюту
+type ExchangeRateService interface {
+	Currencies() *models.CurrencySettings
+	ValidateCurrency(currency string) error
+	GetRate(ctx context.Context, currency string, date time.Time) (float64, error)
+	FindRate(ctx context.Context, currency string, date time.Time) (float64, bool, error)
+	SaveRates(ctx context.Context, rates []*models.ExchangeRate) (int, error)
+	ImportCSV(ctx context.Context, r io.Reader) (int, error)
+	GetRates(ctx context.Context, filter *models.ExchangeRateFilter) ([]*models.ExchangeRate, error)
+}
+
+// PDFService интерфейс для формирования PDF документов
+type PDFService interface {
+	RenderInvoice(ctx context.Context, invoice *models.Invoice) ([]byte, error)
+	RenderCreditNote(ctx context.Context, note *models.CreditNote, invoice *models.Invoice) ([]byte, error)
+}
+
+// EmailAttachment вложение письма
+type EmailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
 // EmailService интерфейс для отправки email
 type EmailService interface {
-	SendOverdueNotification(ctx context.Context, broker *models.Broker, invoices []*models.Invoice) error
-	SendInvoiceCreated(ctx context.Context, broker *models.Broker, invoice *models.Invoice) error
-	SendPaymentReceived(ctx context.Context, broker *models.Broker, payment *models.Payment, invoice *models.Invoice) error
+	SendDunningNotice(ctx context.Context, broker *models.Broker, stage config.DunningStage, invoices []*models.Invoice) error
+	SendInvoiceCreated(ctx context.Context, broker *models.Broker, invoice *models.Invoice, attachments ...EmailAttachment) error
+	SendPaymentReceived(ctx context.Context, broker *models.Broker, payment *models.Payment, invoices []*models.Invoice) error
+	SendCreditLimitAlert(ctx context.Context, to string, broker *models.Broker, exposure *models.CreditExposure) error
 }
 
+// ExportFunc записывает подготовленный экспорт в w. Выборка выполняется при подготовке экспорта,
+// чтобы ее ошибка вернулась до начала ответа; ExportFunc обходит ее и закрывает.
+type ExportFunc func(ctx context.Context, w io.Writer) error
+
 // ExportService интерфейс для экспорта данных
 type ExportService interface {
-	ExportInvoices(ctx context.Context, format string, filter *models.InvoiceFilter) ([]byte, error)
-	ExportPayments(ctx context.Context, format string, filter *models.PaymentFilter) ([]byte, error)
-	ExportBrokers(ctx context.Context, format string) ([]byte, error)
+	ExportInvoices(ctx context.Context, req *models.ExportRequest) (ExportFunc, error)
+	ExportPayments(ctx context.Context, req *models.ExportRequest) (ExportFunc, error)
+	ExportBrokers(ctx context.Context, req *models.ExportRequest) (ExportFunc, error)
 }
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"billing-system/internal/models"
+	"billing-system/internal/repository"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// invoiceBalancer реализация InvoiceBalancer
+type invoiceBalancer struct {
+	invoiceRepo    repository.InvoiceRepository
+	paymentRepo    repository.PaymentRepository
+	creditNoteRepo repository.CreditNoteRepository
+}
+
+// NewInvoiceBalancer создает новый InvoiceBalancer
+func NewInvoiceBalancer(
+	invoiceRepo repository.InvoiceRepository,
+	paymentRepo repository.PaymentRepository,
+	creditNoteRepo repository.CreditNoteRepository,
+) InvoiceBalancer {
+	return &invoiceBalancer{
+		invoiceRepo:    invoiceRepo,
+		paymentRepo:    paymentRepo,
+		creditNoteRepo: creditNoteRepo,
+	}
+}
+
+// Recalculate пересчитывает оплаченную и кредитованную суммы, статус и дату оплаты счета
+// по его платежам и кредит-нотам
+func (b *invoiceBalancer) Recalculate(ctx context.Context, invoiceID primitive.ObjectID) error {
+	// Получаем счет
+	invoice, err := b.invoiceRepo.GetByID(ctx, invoiceID)
+	if err != nil {
+		return err
+	}
+
+	// Получаем платежи по счету
+	payments, err := b.paymentRepo.GetByInvoice(ctx, invoiceID)
+	if err != nil {
+		return err
+	}
+
+	totalPaid := models.NewMoney(0, invoice.Currency)
+	var paidInDiscountPeriod models.Amount
+	var lastPaymentDate time.Time
+	for _, payment := range payments {
+		totalPaid, err = totalPaid.Add(models.NewMoney(payment.AllocatedAmount, payment.Currency))
+		if err != nil {
+			return err
+		}
+		if invoice.PaymentTerms != nil && invoice.PaymentTerms.DiscountAppliesTo(invoice.IssuedOn(), payment.PaymentDate) {
+			paidInDiscountPeriod += payment.AllocatedAmount
+		}
+		if payment.PaymentDate.After(lastPaymentDate) {
+			lastPaymentDate = payment.PaymentDate
+		}
+	}
+
+	// Кредит-ноты выписываются в валюте счета
+	totalCredited, err := b.creditNoteRepo.GetTotalByInvoice(ctx, invoiceID)
+	if err != nil {
+		return err
+	}
+
+	earlyDiscount := earlyPaymentDiscount(invoice, paidInDiscountPeriod, totalPaid.Amount, totalCredited)
+
+	// Черновик и аннулированный счет сохраняют статус, обновляются только суммы
+	newStatus := invoiceStatusForBalance(invoice, totalPaid, totalCredited+earlyDiscount)
+
+	var paidAt *time.Time
+	if newStatus == models.InvoiceStatusPaid {
+		// Счет, закрытый только кредит-нотами, считается оплаченным в момент пересчета
+		if lastPaymentDate.IsZero() {
+			lastPaymentDate = time.Now()
+		}
+		paidAt = &lastPaymentDate
+	}
+
+	return b.invoiceRepo.UpdateStatus(ctx, invoiceID, newStatus, totalPaid.Amount, totalCredited, earlyDiscount, paidAt)
+}
+
+// earlyPaymentDiscount определяет скидку за раннюю оплату: она предоставляется, если оплаты в срок скидки
+// вместе с кредит-нотами покрывают сумму счета за вычетом скидки. Сторнированные позже оплаты
+// в срок не засчитываются, поэтому учитывается не больше итоговой оплаченной суммы.
+func earlyPaymentDiscount(invoice *models.Invoice, paidInDiscountPeriod, totalPaid, totalCredited models.Amount) models.Amount {
+	if invoice.PaymentTerms == nil || !invoice.PaymentTerms.HasDiscount() {
+		return 0
+	}
+	if paidInDiscountPeriod > totalPaid {
+		paidInDiscountPeriod = totalPaid
+	}
+	if paidInDiscountPeriod <= 0 {
+		return 0
+	}
+
+	discount := invoice.PaymentTerms.Discount(invoice.Amount)
+	if paidInDiscountPeriod+totalCredited < invoice.Amount-discount {
+		return 0
+	}
+	// Скидка не превышает непокрытый оплатами и кредит-нотами остаток
+	if uncovered := invoice.Amount - totalPaid - totalCredited; discount > uncovered {
+		discount = uncovered
+	}
+	if discount < 0 {
+		return 0
+	}
+	return discount
+}
+
+// invoiceStatusForBalance определяет статус счета по оплаченной сумме и сумме, списанной кредит-нотами
+// и скидкой за раннюю оплату. Непогашенный счет с истекшим сроком просрочен, даже если частично оплачен.
+func invoiceStatusForBalance(invoice *models.Invoice, totalPaid models.Money, totalCredited models.Amount) string {
+	switch {
+	case invoice.Status == models.InvoiceStatusDraft || invoice.Status == models.InvoiceStatusVoid:
+		return invoice.Status
+	case totalPaid.Amount+totalCredited >= invoice.Amount:
+		return models.InvoiceStatusPaid
+	case time.Now().After(invoice.DueDate):
+		return models.InvoiceStatusOverdue
+	case totalPaid.IsPositive():
+		return models.InvoiceStatusPartial
+	default:
+		return models.InvoiceStatusIssued
+	}
+}
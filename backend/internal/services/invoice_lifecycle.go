@@ -0,0 +1,184 @@
+package services
+
+import (
+	"billing-system/internal/models"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// invoiceTransitions допустимые переходы между статусами счета.
+// Переходы между issued, partial, paid и overdue выполняет пересчет баланса, в void - аннулирование.
+var invoiceTransitions = map[string][]string{
+	models.InvoiceStatusDraft:   {models.InvoiceStatusIssued, models.InvoiceStatusVoid},
+	models.InvoiceStatusIssued:  {models.InvoiceStatusPartial, models.InvoiceStatusPaid, models.InvoiceStatusOverdue, models.InvoiceStatusVoid},
+	models.InvoiceStatusOverdue: {models.InvoiceStatusIssued, models.InvoiceStatusPartial, models.InvoiceStatusPaid, models.InvoiceStatusVoid},
+	models.InvoiceStatusPartial: {models.InvoiceStatusIssued, models.InvoiceStatusPaid, models.InvoiceStatusOverdue},
+	models.InvoiceStatusPaid:    {models.InvoiceStatusIssued, models.InvoiceStatusPartial, models.InvoiceStatusOverdue},
+	models.InvoiceStatusVoid:    {},
+}
+
+// InvoiceStateError действие недопустимо в текущем статусе счета
+type InvoiceStateError struct {
+	Status  string // текущий статус счета
+	Action  string // запрошенный статус или действие
+	Message string
+}
+
+func (e *InvoiceStateError) Error() string {
+	return e.Message
+}
+
+// canTransition проверяет, что счет может перейти из статуса from в статус to
+func canTransition(from, to string) bool {
+	for _, status := range invoiceTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// checkInvoiceTransition возвращает InvoiceStateError, если переход счета в статус to недопустим
+func checkInvoiceTransition(invoice *models.Invoice, to string) error {
+	if canTransition(invoice.Status, to) {
+		return nil
+	}
+	return &InvoiceStateError{
+		Status:  invoice.Status,
+		Action:  to,
+		Message: fmt.Sprintf("Invoice %s cannot change status from %s to %s", invoice.InvoiceNumber, invoice.Status, to),
+	}
+}
+
+// checkInvoiceUpdate проверяет, что изменение счета допустимо в его статусе: аннулированный счет не меняется,
+// статус меняется только выставлением и аннулированием, а у счета с оплатами или кредит-нотами
+// брокер, валюта и сумма зафиксированы. Брокер счета по грузам не меняется, так как грузы принадлежат ему
+func checkInvoiceUpdate(existing, invoice *models.Invoice) error {
+	if existing.Status == models.InvoiceStatusVoid {
+		return &InvoiceStateError{Status: existing.Status, Action: "update", Message: "Void invoice cannot be changed"}
+	}
+
+	if invoice.Status != "" && invoice.Status != existing.Status {
+		return &InvoiceStateError{
+			Status:  existing.Status,
+			Action:  invoice.Status,
+			Message: "Invoice status cannot be changed by update; use the issue or void endpoints",
+		}
+	}
+
+	if len(existing.LoadIDs) > 0 && invoice.BrokerID != existing.BrokerID {
+		return &InvoiceStateError{
+			Status:  existing.Status,
+			Action:  "update",
+			Message: "Broker cannot be changed on an invoice for loads; void it and invoice the loads again",
+		}
+	}
+
+	settled := existing.PaidAmount != 0 || existing.CreditedAmount != 0
+	if settled && (invoice.BrokerID != existing.BrokerID || invoice.Currency != existing.Currency || invoice.Amount != existing.Amount) {
+		return &InvoiceStateError{
+			Status:  existing.Status,
+			Action:  "update",
+			Message: "Broker, currency and amount cannot be changed once the invoice has payments or credit notes",
+		}
+	}
+
+	return nil
+}
+
+// IssueInvoice выставляет черновик брокеру. Счет с истекшим сроком сразу становится просроченным.
+// Выставление сверх кредитного лимита брокера требует разрешения администратора в запросе.
+func (s *invoiceService) IssueInvoice(ctx context.Context, id primitive.ObjectID, req *models.IssueInvoiceRequest) (*models.Invoice, error) {
+	var broker *models.Broker
+	err := s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		invoice, err := s.invoiceRepo.Lock(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkInvoiceTransition(invoice, models.InvoiceStatusIssued); err != nil {
+			return err
+		}
+
+		broker, err = s.brokerRepo.GetByID(ctx, invoice.BrokerID)
+		if err != nil {
+			return err
+		}
+		override, err := s.creditLimits.CheckLimit(ctx, broker, invoice.Amount, invoice.Currency, req.CreditOverride)
+		if err != nil {
+			return err
+		}
+
+		// Условия оплаты отсчитываются от даты выставления, а не от создания черновика.
+		// Срок, указанный вручную, сохраняется.
+		issueDate := time.Now()
+		dueDate := invoice.DueDate
+		if terms := invoice.PaymentTerms; terms != nil && dueDate.Equal(terms.DueDate(invoice.IssuedOn())) {
+			dueDate = terms.DueDate(issueDate)
+		}
+		if err := s.invoiceRepo.Issue(ctx, id, issueDate, dueDate, override); err != nil {
+			return err
+		}
+		return s.invoiceBalancer.Recalculate(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	invoice, err := s.invoiceRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Брокер получает счет при выставлении, а не при создании черновика
+	if s.emailService != nil {
+		go s.sendInvoiceCreated(broker, invoice)
+	}
+	notifyCreditUtilization(s.creditLimits, invoice.BrokerID)
+
+	return invoice, nil
+}
+
+// VoidInvoice аннулирует счет с указанием причины. Счет с оплатами аннулировать нельзя: платежи сначала
+// сторнируются или переносятся. Грузы счета снова становятся доступны для выставления.
+func (s *invoiceService) VoidInvoice(ctx context.Context, id primitive.ObjectID, req *models.VoidInvoiceRequest, voidedBy string) (*models.Invoice, error) {
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		return nil, &ValidationError{Message: "Void reason is required"}
+	}
+
+	err := s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		invoice, err := s.invoiceRepo.Lock(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkInvoiceTransition(invoice, models.InvoiceStatusVoid); err != nil {
+			return err
+		}
+
+		paid, err := s.paymentRepo.GetTotalPaidAmount(ctx, id)
+		if err != nil {
+			return err
+		}
+		if paid != 0 {
+			return &InvoiceStateError{
+				Status:  invoice.Status,
+				Action:  models.InvoiceStatusVoid,
+				Message: fmt.Sprintf("Invoice %s has payments; reverse or reallocate them before voiding", invoice.InvoiceNumber),
+			}
+		}
+
+		if err := s.invoiceRepo.Void(ctx, id, invoice.Status, reason, voidedBy); err != nil {
+			return err
+		}
+		return s.loadRepo.ReleaseInvoice(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.invoiceRepo.GetByID(ctx, id)
+}
@@ -4,61 +4,371 @@ import (
 	"billing-system/internal/models"
 	"billing-system/internal/repository"
 	"context"
+	"fmt"
+	"log"
 	"math"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // invoiceService реализация InvoiceService
 type invoiceService struct {
-	invoiceRepo  repository.InvoiceRepository
-	paymentRepo  repository.PaymentRepository
-	brokerRepo   repository.BrokerRepository
-	emailService EmailService
+	invoiceRepo     repository.InvoiceRepository
+	paymentRepo     repository.PaymentRepository
+	creditNoteRepo  repository.CreditNoteRepository
+	brokerRepo      repository.BrokerRepository
+	loadRepo        repository.LoadRepository
+	unitOfWork      repository.UnitOfWork
+	invoiceBalancer InvoiceBalancer
+	exchangeRates   ExchangeRateService
+	creditLimits    CreditLimitService
+	emailService    EmailService
+	pdfService      PDFService
 }
 
 // NewInvoiceService создает новый InvoiceService
 func NewInvoiceService(
 	invoiceRepo repository.InvoiceRepository,
 	paymentRepo repository.PaymentRepository,
+	creditNoteRepo repository.CreditNoteRepository,
 	brokerRepo repository.BrokerRepository,
+	loadRepo repository.LoadRepository,
+	unitOfWork repository.UnitOfWork,
+	invoiceBalancer InvoiceBalancer,
+	exchangeRates ExchangeRateService,
+	creditLimits CreditLimitService,
 	emailService EmailService,
+	pdfService PDFService,
 ) InvoiceService {
 	return &invoiceService{
-		invoiceRepo:  invoiceRepo,
-		paymentRepo:  paymentRepo,
-		brokerRepo:   brokerRepo,
-		emailService: emailService,
+		invoiceRepo:     invoiceRepo,
+		paymentRepo:     paymentRepo,
+		creditNoteRepo:  creditNoteRepo,
+		brokerRepo:      brokerRepo,
+		loadRepo:        loadRepo,
+		unitOfWork:      unitOfWork,
+		invoiceBalancer: invoiceBalancer,
+		exchangeRates:   exchangeRates,
+		creditLimits:    creditLimits,
+		emailService:    emailService,
+		pdfService:      pdfService,
 	}
 }
 
-// CreateInvoice создает новый счет
+// CreateInvoice создает новый счет и уведомляет брокера
 func (s *invoiceService) CreateInvoice(ctx context.Context, invoice *models.Invoice) error {
+	if err := s.InsertInvoice(ctx, invoice); err != nil {
+		return err
+	}
+
+	s.NotifyInvoiceCreated(invoice)
+	return nil
+}
+
+// InsertInvoice проверяет, нумерует, считает итоги и сохраняет счет без уведомлений.
+// Вызывается и внутри транзакции: уведомления отправляются через NotifyInvoiceCreated после ее фиксации.
+func (s *invoiceService) InsertInvoice(ctx context.Context, invoice *models.Invoice) error {
+	// Итоги считаются на сервере
+	if err := calculateInvoiceTotals(invoice); err != nil {
+		return err
+	}
+
+	// Срок оплаты по умолчанию считается по условиям оплаты счета или брокера от даты выставления
+	if invoice.IssueDate.IsZero() {
+		invoice.IssueDate = time.Now()
+	}
+	var broker *models.Broker
+	if !invoice.BrokerID.IsZero() {
+		var err error
+		broker, err = s.brokerRepo.GetByID(ctx, invoice.BrokerID)
+		if err != nil {
+			return &ValidationError{Message: "Broker not found"}
+		}
+	}
+	if err := applyPaymentTerms(invoice, broker); err != nil {
+		return err
+	}
+	invoice.LoadIDs = uniqueObjectIDs(invoice.LoadIDs)
+
 	// Валидация
 	if err := s.validateInvoice(invoice); err != nil {
 		return err
 	}
 
-	// Устанавливаем статус по умолчанию
-	if invoice.Status == "" {
-		invoice.Status = models.InvoiceStatusPending
+	// Счет создается черновиком или сразу выставленным; остальные статусы определяются балансом
+	switch invoice.Status {
+	case "":
+		invoice.Status = models.InvoiceStatusIssued
+	case models.InvoiceStatusDraft, models.InvoiceStatusIssued:
+	default:
+		return &ValidationError{Message: "Invoice can be created only as draft or issued"}
 	}
 
-	// Создаем счет
-	if err := s.invoiceRepo.Create(ctx, invoice); err != nil {
+	// Фиксируем курс к базовой валюте на дату счета
+	if err := s.setExchangeRate(ctx, invoice, time.Now()); err != nil {
 		return err
 	}
 
-	// Отправляем уведомление брокеру
-	broker, err := s.brokerRepo.GetByID(ctx, invoice.BrokerID)
-	if err == nil && s.emailService != nil {
-		go s.emailService.SendInvoiceCreated(context.Background(), broker, invoice)
+	// Выставленный счет увеличивает кредитную нагрузку брокера, черновик проверяется при выставлении.
+	// Нагрузка проверяется в транзакции создания счета.
+	// Номер, выданный в откаченной попытке транзакции, выдается заново
+	override, number := invoice.CreditOverride, invoice.InvoiceNumber
+	return s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		invoice.CreditOverride, invoice.InvoiceNumber = nil, number
+		if invoice.Status == models.InvoiceStatusIssued {
+			var err error
+			invoice.CreditOverride, err = s.creditLimits.CheckLimit(ctx, broker, invoice.Amount, invoice.Currency, override)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Создаем счет и привязываем к нему перечисленные грузы
+		if err := s.invoiceRepo.Create(ctx, invoice); err != nil {
+			return err
+		}
+		return s.assignLoads(ctx, invoice)
+	})
+}
+
+// NotifyInvoiceCreated отправляет брокеру созданный счет и проверяет порог его кредитного лимита.
+// Черновик брокеру не отправляется.
+func (s *invoiceService) NotifyInvoiceCreated(invoice *models.Invoice) {
+	if invoice.Status == models.InvoiceStatusDraft {
+		return
+	}
+
+	notifyCreditUtilization(s.creditLimits, invoice.BrokerID)
+
+	if s.emailService == nil {
+		return
+	}
+	go func() {
+		broker, err := s.brokerRepo.GetByID(context.Background(), invoice.BrokerID)
+		if err != nil {
+			log.Printf("Ошибка отправки счета %s: %v", invoice.InvoiceNumber, err)
+			return
+		}
+		s.sendInvoiceCreated(broker, invoice)
+	}()
+}
+
+// CreateInvoiceFromLoads выставляет счета по доставленным невыставленным грузам брокера.
+// Счета создаются и привязываются к грузам в одной транзакции, по одному счету на валюту грузов:
+// при любой ошибке не остается ни счетов, ни частично привязанных грузов.
+func (s *invoiceService) CreateInvoiceFromLoads(ctx context.Context, req *models.InvoiceFromLoadsRequest) ([]*models.Invoice, error) {
+	if req.BrokerID.IsZero() {
+		return nil, &ValidationError{Message: "Broker ID is required"}
+	}
+	if len(req.LoadIDs) == 0 {
+		return nil, &ValidationError{Message: "At least one load is required"}
+	}
+
+	broker, err := s.brokerRepo.GetByID(ctx, req.BrokerID)
+	if err != nil {
+		return nil, &ValidationError{Message: "Broker not found"}
+	}
+
+	loadIDs := uniqueObjectIDs(req.LoadIDs)
+	loads, err := s.loadRepo.GetByIDs(ctx, loadIDs)
+	if err != nil {
+		return nil, err
+	}
+	if len(loads) != len(loadIDs) {
+		return nil, &ValidationError{Message: "One or more loads not found"}
+	}
+
+	// Группируем грузы по валюте, сохраняя порядок выбора
+	loadsByID := make(map[primitive.ObjectID]*models.Load, len(loads))
+	for _, load := range loads {
+		loadsByID[load.ID] = load
 	}
 
+	var currencies []string
+	byCurrency := make(map[string][]*models.Load)
+	for _, id := range loadIDs {
+		load := loadsByID[id]
+		if err := validateBillableLoad(load, req.BrokerID); err != nil {
+			return nil, err
+		}
+		if _, ok := byCurrency[load.Currency]; !ok {
+			currencies = append(currencies, load.Currency)
+		}
+		byCurrency[load.Currency] = append(byCurrency[load.Currency], load)
+	}
+
+	var invoices []*models.Invoice
+	err = s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		// Транзакция может повторяться, поэтому счета собираются заново
+		invoices = invoices[:0]
+
+		for _, currency := range currencies {
+			invoice, err := newInvoiceFromLoads(req, currency, byCurrency[currency])
+			if err != nil {
+				return err
+			}
+			if err := applyPaymentTerms(invoice, broker); err != nil {
+				return err
+			}
+			if err := s.validateInvoice(invoice); err != nil {
+				return err
+			}
+			if err := s.setExchangeRate(ctx, invoice, time.Now()); err != nil {
+				return err
+			}
+			if err := s.invoiceRepo.Create(ctx, invoice); err != nil {
+				return err
+			}
+
+			// Часть грузов могла быть выставлена параллельным запросом: ошибка откатывает транзакцию
+			// вместе с созданными счетами и уже привязанными грузами
+			if err := s.assignLoads(ctx, invoice); err != nil {
+				return err
+			}
+
+			invoices = append(invoices, invoice)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.emailService != nil {
+		for _, invoice := range invoices {
+			go s.sendInvoiceCreated(broker, invoice)
+		}
+	}
+
+	return invoices, nil
+}
+
+// assignLoads привязывает грузы счета к созданному счету. Грузы проверяются в той же транзакции:
+// они должны быть доставлены, принадлежать брокеру счета и еще не входить в другой счет
+func (s *invoiceService) assignLoads(ctx context.Context, invoice *models.Invoice) error {
+	if len(invoice.LoadIDs) == 0 {
+		return nil
+	}
+
+	loads, err := s.loadRepo.GetByIDs(ctx, invoice.LoadIDs)
+	if err != nil {
+		return err
+	}
+	if len(loads) != len(invoice.LoadIDs) {
+		return &ValidationError{Message: "One or more loads not found"}
+	}
+	for _, load := range loads {
+		if err := validateBillableLoad(load, invoice.BrokerID); err != nil {
+			return err
+		}
+	}
+
+	assigned, err := s.loadRepo.AssignInvoice(ctx, invoice.LoadIDs, invoice.ID)
+	if err != nil {
+		return err
+	}
+	if assigned != int64(len(invoice.LoadIDs)) {
+		return &ValidationError{Message: "One or more loads are already invoiced"}
+	}
 	return nil
 }
 
+// validateBillableLoad проверяет, что груз можно включить в счет брокера
+func validateBillableLoad(load *models.Load, brokerID primitive.ObjectID) error {
+	if load.BrokerID != brokerID {
+		return &ValidationError{Message: fmt.Sprintf("Load %s belongs to another broker", load.LoadNumber)}
+	}
+	if load.Status != models.LoadStatusDelivered {
+		return &ValidationError{Message: fmt.Sprintf("Load %s is not delivered", load.LoadNumber)}
+	}
+	if !load.InvoiceID.IsZero() {
+		return &ValidationError{Message: fmt.Sprintf("Load %s is already invoiced", load.LoadNumber)}
+	}
+	return nil
+}
+
+// newInvoiceFromLoads формирует счет с позицией фрахта на каждый груз в одной валюте
+func newInvoiceFromLoads(req *models.InvoiceFromLoadsRequest, currency string, loads []*models.Load) (*models.Invoice, error) {
+	invoice := &models.Invoice{
+		BrokerID:    req.BrokerID,
+		Currency:    currency,
+		Status:      models.InvoiceStatusIssued,
+		IssueDate:   time.Now(),
+		DueDate:     req.DueDate,
+		Description: req.Description,
+		Notes:       req.Notes,
+	}
+	if req.PaymentTerms != nil {
+		terms := *req.PaymentTerms
+		invoice.PaymentTerms = &terms
+	}
+
+	var numbers []string
+	for _, load := range loads {
+		invoice.LoadIDs = append(invoice.LoadIDs, load.ID)
+		invoice.LineItems = append(invoice.LineItems, models.LineItem{
+			Type: models.LineItemTypeLinehaul,
+			Description: fmt.Sprintf("Load %s: %s, %s -> %s, %s", load.LoadNumber,
+				load.Route.Origin.City, load.Route.Origin.State,
+				load.Route.Destination.City, load.Route.Destination.State),
+			Quantity:  1,
+			UnitPrice: load.Cost,
+			LoadID:    load.ID,
+		})
+		numbers = append(numbers, load.LoadNumber)
+	}
+
+	if invoice.Description == "" {
+		invoice.Description = "Loads: " + strings.Join(numbers, ", ")
+	}
+
+	if err := calculateInvoiceTotals(invoice); err != nil {
+		return nil, err
+	}
+
+	return invoice, nil
+}
+
+// uniqueObjectIDs убирает повторяющиеся ID, сохраняя порядок
+func uniqueObjectIDs(ids []primitive.ObjectID) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	result := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	return result
+}
+
+// sendInvoiceCreated отправляет уведомление о новом счете с PDF во вложении
+func (s *invoiceService) sendInvoiceCreated(broker *models.Broker, invoice *models.Invoice) {
+	ctx := context.Background()
+
+	var attachments []EmailAttachment
+	if s.pdfService != nil {
+		pdf, err := s.pdfService.RenderInvoice(ctx, invoice)
+		if err != nil {
+			log.Printf("Ошибка формирования PDF счета %s: %v", invoice.InvoiceNumber, err)
+		} else {
+			attachments = append(attachments, EmailAttachment{
+				Filename:    invoice.InvoiceNumber + ".pdf",
+				ContentType: "application/pdf",
+				Data:        pdf,
+			})
+		}
+	}
+
+	if err := s.emailService.SendInvoiceCreated(ctx, broker, invoice, attachments...); err != nil {
+		log.Printf("Ошибка отправки счета %s: %v", invoice.InvoiceNumber, err)
+	}
+}
+
 // GetInvoice получает счет по ID
 func (s *invoiceService) GetInvoice(ctx context.Context, id primitive.ObjectID) (*models.Invoice, error) {
 	return s.invoiceRepo.GetByID(ctx, id)
@@ -85,18 +395,108 @@ func (s *invoiceService) GetAllInvoices(ctx context.Context, filter *models.Invo
 	return invoices, pagination, nil
 }
 
-// UpdateInvoice обновляет счет
+// UpdateInvoice обновляет счет. Счет блокируется в транзакции изменения, поэтому проверки статуса,
+// оплат и кредит-нот выполняются по его актуальной версии.
 func (s *invoiceService) UpdateInvoice(ctx context.Context, id primitive.ObjectID, invoice *models.Invoice) error {
-	// Валидация
-	if err := s.validateInvoice(invoice); err != nil {
+	// Итоги считаются на сервере
+	if err := calculateInvoiceTotals(invoice); err != nil {
 		return err
 	}
 
-	return s.invoiceRepo.Update(ctx, id, invoice)
+	// Транзакция может повторяться, поэтому поля, которые дополняются по текущему счету, берутся из запроса
+	terms, dueDate, override := invoice.PaymentTerms, invoice.DueDate, invoice.CreditOverride
+
+	var existing *models.Invoice
+	err := s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		var err error
+		existing, err = s.invoiceRepo.Lock(ctx, id)
+		if err == mongo.ErrNoDocuments {
+			return &ValidationError{Message: "Invoice not found"}
+		}
+		if err != nil {
+			return err
+		}
+
+		// Грузы привязываются только при создании счета, поэтому их список не меняется
+		invoice.LoadIDs = existing.LoadIDs
+
+		// Без указанного срока он пересчитывается по условиям оплаты от исходной даты выставления
+		invoice.IssueDate = existing.IssuedOn()
+		invoice.PaymentTerms, invoice.DueDate = terms, dueDate
+		if invoice.PaymentTerms == nil {
+			invoice.PaymentTerms = existing.PaymentTerms
+		}
+		if err := applyPaymentTerms(invoice, nil); err != nil {
+			return err
+		}
+
+		// Валидация
+		if err := s.validateInvoice(invoice); err != nil {
+			return err
+		}
+		if err := checkInvoiceUpdate(existing, invoice); err != nil {
+			return err
+		}
+
+		// Курс остается зафиксированным на дату счета, пока не меняется валюта
+		if invoice.Currency == existing.Currency && existing.BaseRate(s.exchangeRates.Currencies().BaseCurrency) > 0 {
+			invoice.ExchangeRate, invoice.ExchangeRateBase = existing.ExchangeRate, existing.ExchangeRateBase
+		} else if err := s.setExchangeRate(ctx, invoice, existing.CreatedAt); err != nil {
+			return err
+		}
+
+		// Остаток открытого счета входит в кредитную нагрузку, поэтому его прирост проверяется по лимиту брокера;
+		// при смене брокера нагрузка нового брокера растет на весь остаток
+		invoice.CreditOverride = nil
+		if existing.IsOpen() {
+			broker, err := s.brokerRepo.GetByID(ctx, invoice.BrokerID)
+			if err != nil {
+				return err
+			}
+			var previous models.Amount
+			if existing.BrokerID == invoice.BrokerID {
+				previous = existing.Amount - existing.PaidAmount - existing.CreditedAmount
+			}
+			remaining := invoice.Amount - existing.PaidAmount - existing.CreditedAmount
+			invoice.CreditOverride, err = s.creditLimits.CheckChange(ctx, broker, previous, existing.Currency, remaining, invoice.Currency, override)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Сумма и срок влияют на статус, поэтому баланс пересчитывается вместе с изменением
+		if err := s.invoiceRepo.Update(ctx, id, invoice); err != nil {
+			return err
+		}
+		return s.invoiceBalancer.Recalculate(ctx, id)
+	})
+	if err != nil {
+		return err
+	}
+
+	if existing.IsOpen() {
+		notifyCreditUtilization(s.creditLimits, invoice.BrokerID)
+		if existing.BrokerID != invoice.BrokerID {
+			notifyCreditUtilization(s.creditLimits, existing.BrokerID)
+		}
+	}
+	return nil
 }
 
-// DeleteInvoice удаляет счет
+// DeleteInvoice удаляет черновик счета. Выставленный счет аннулируется через VoidInvoice.
 func (s *invoiceService) DeleteInvoice(ctx context.Context, id primitive.ObjectID) error {
+	invoice, err := s.invoiceRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if invoice.Status != models.InvoiceStatusDraft {
+		return &InvoiceStateError{
+			Status:  invoice.Status,
+			Action:  "delete",
+			Message: "Only draft invoices can be deleted; void issued invoices instead",
+		}
+	}
+
 	// Проверяем, есть ли связанные платежи
 	payments, err := s.paymentRepo.GetByInvoice(ctx, id)
 	if err != nil {
@@ -107,7 +507,23 @@ func (s *invoiceService) DeleteInvoice(ctx context.Context, id primitive.ObjectI
 		return &ValidationError{Message: "Cannot delete invoice with existing payments"}
 	}
 
-	return s.invoiceRepo.Delete(ctx, id)
+	// Кредит-ноты являются учетными документами и не удаляются вместе со счетом
+	creditNotes, err := s.creditNoteRepo.CountByInvoice(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if creditNotes > 0 {
+		return &ValidationError{Message: "Cannot delete invoice with existing credit notes"}
+	}
+
+	// Грузы удаленного счета снова становятся доступны для выставления
+	return s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		if err := s.invoiceRepo.Delete(ctx, id); err != nil {
+			return err
+		}
+		return s.loadRepo.ReleaseInvoice(ctx, id)
+	})
 }
 
 // GetInvoicesByStatus получает счета по статусу
@@ -175,60 +591,29 @@ func (s *invoiceService) GetOverdueInvoices(ctx context.Context, page, limit int
 
 // UpdateInvoiceStatus обновляет статус счета на основе платежей
 func (s *invoiceService) UpdateInvoiceStatus(ctx context.Context, invoiceID primitive.ObjectID) error {
-	// Получаем счет
-	invoice, err := s.invoiceRepo.GetByID(ctx, invoiceID)
-	if err != nil {
-		return err
-	}
-
-	// Получаем общую сумму платежей
-	totalPaid, err := s.paymentRepo.GetTotalPaidAmount(ctx, invoiceID)
-	if err != nil {
-		return err
-	}
-
-	// Определяем новый статус
-	var newStatus string
-	if totalPaid >= invoice.Amount {
-		newStatus = models.InvoiceStatusPaid
-	} else if totalPaid > 0 {
-		newStatus = models.InvoiceStatusPartial
-	} else if time.Now().After(invoice.DueDate) {
-		newStatus = models.InvoiceStatusOverdue
-	} else {
-		newStatus = models.InvoiceStatusPending
-	}
+	return s.invoiceBalancer.Recalculate(ctx, invoiceID)
+}
 
-	// Обновляем статус и оплаченную сумму
-	return s.invoiceRepo.UpdateStatus(ctx, invoiceID, newStatus, totalPaid)
+// MarkOverdueInvoices переводит неоплаченные и частично оплаченные счета с истекшим сроком в статус overdue
+func (s *invoiceService) MarkOverdueInvoices(ctx context.Context) (int64, error) {
+	return s.invoiceRepo.MarkOverdue(ctx, time.Now())
 }
 
-// SendOverdueNotifications отправляет уведомления о просроченных счетах
-func (s *invoiceService) SendOverdueNotifications(ctx context.Context) error {
-	// Получаем просроченные счета
-	overdueInvoices, _, err := s.invoiceRepo.GetOverdue(ctx, 1000, 0) // Максимум 1000 за раз
+// setExchangeRate фиксирует в счете курс его валюты к базовой на дату date.
+// Если курс еще не загружен, счет сохраняется без курса: в отчетах и при разнесении оплат
+// он пересчитывается по курсу на дату счета, когда тот появится.
+func (s *invoiceService) setExchangeRate(ctx context.Context, invoice *models.Invoice, date time.Time) error {
+	base := s.exchangeRates.Currencies().BaseCurrency
+	rate, ok, err := s.exchangeRates.FindRate(ctx, invoice.Currency, date)
 	if err != nil {
 		return err
 	}
-
-	// Группируем по брокерам
-	brokerInvoices := make(map[primitive.ObjectID][]*models.Invoice)
-	for _, invoice := range overdueInvoices {
-		brokerInvoices[invoice.BrokerID] = append(brokerInvoices[invoice.BrokerID], invoice)
+	if !ok {
+		log.Printf("Нет курса %s/%s на %s: счет сохраняется без курса", invoice.Currency, base, date.Format("2006-01-02"))
+		invoice.ExchangeRate, invoice.ExchangeRateBase = 0, ""
+		return nil
 	}
-
-	// Отправляем уведомления каждому брокеру
-	for brokerID, invoices := range brokerInvoices {
-		broker, err := s.brokerRepo.GetByID(ctx, brokerID)
-		if err != nil {
-			continue // Пропускаем если брокер не найден
-		}
-
-		if s.emailService != nil {
-			go s.emailService.SendOverdueNotification(context.Background(), broker, invoices)
-		}
-	}
-
+	invoice.ExchangeRate, invoice.ExchangeRateBase = rate, base
 	return nil
 }
 
@@ -238,18 +623,12 @@ func (s *invoiceService) validateInvoice(invoice *models.Invoice) error {
 		return &ValidationError{Message: "Invoice amount must be greater than zero"}
 	}
 
-	if invoice.Currency == "" {
-		return &ValidationError{Message: "Currency is required"}
-	}
-
-	if invoice.Currency != models.CurrencyUSD &&
-		invoice.Currency != models.CurrencyEUR &&
-		invoice.Currency != models.CurrencyRUB {
-		return &ValidationError{Message: "Unsupported currency"}
+	if err := s.exchangeRates.ValidateCurrency(invoice.Currency); err != nil {
+		return err
 	}
 
 	if invoice.DueDate.IsZero() {
-		return &ValidationError{Message: "Due date is required"}
+		return &ValidationError{Message: "Due date or payment terms are required"}
 	}
 
 	if invoice.BrokerID.IsZero() {
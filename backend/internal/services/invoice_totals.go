@@ -0,0 +1,184 @@
+package services
+
+import (
+	"billing-system/internal/models"
+	"fmt"
+)
+
+// calculateInvoiceTotals рассчитывает позиции, скидки, налог и итог счета.
+// Суммы, присланные клиентом, должны совпадать с расчетом, иначе возвращается ошибка валидации.
+// Счет без позиций сохраняет прежнее поведение: итог задается полем Amount.
+func calculateInvoiceTotals(invoice *models.Invoice) error {
+	if len(invoice.LineItems) == 0 {
+		if len(invoice.Discounts) > 0 {
+			return &ValidationError{Message: "Discounts require line items"}
+		}
+		invoice.Subtotal = invoice.Amount
+		invoice.DiscountTotal = 0
+		invoice.TaxTotal = 0
+		return nil
+	}
+
+	var subtotal models.Amount
+	for i := range invoice.LineItems {
+		item := &invoice.LineItems[i]
+		if err := validateLineItem(i, item); err != nil {
+			return err
+		}
+
+		amount := item.UnitPrice.Mul(item.Quantity)
+		if item.Amount != 0 && item.Amount != amount {
+			return &ValidationError{Message: fmt.Sprintf("Line item %d: amount %s does not match quantity * unit price (%s)", i+1, item.Amount, amount)}
+		}
+		item.Amount = amount
+		subtotal += amount
+	}
+
+	var discountTotal models.Amount
+	for i := range invoice.Discounts {
+		discount := &invoice.Discounts[i]
+
+		var amount models.Amount
+		switch discount.Type {
+		case models.DiscountTypePercent:
+			if discount.Value <= 0 || discount.Value > 100 {
+				return &ValidationError{Message: fmt.Sprintf("Discount %d: percent must be between 0 and 100", i+1)}
+			}
+			amount = subtotal.Percent(discount.Value)
+		case models.DiscountTypeFixed:
+			if discount.Value <= 0 {
+				return &ValidationError{Message: fmt.Sprintf("Discount %d: value must be greater than zero", i+1)}
+			}
+			amount = models.AmountFromFloat(discount.Value)
+		default:
+			return &ValidationError{Message: fmt.Sprintf("Discount %d: unsupported type", i+1)}
+		}
+
+		if discount.Amount != 0 && discount.Amount != amount {
+			return &ValidationError{Message: fmt.Sprintf("Discount %d: amount %s does not match calculated %s", i+1, discount.Amount, amount)}
+		}
+		discount.Amount = amount
+		discountTotal += amount
+	}
+
+	if discountTotal > subtotal {
+		return &ValidationError{Message: "Discounts exceed invoice subtotal"}
+	}
+
+	// Скидка распределяется по позициям пропорционально сумме, налог считается с суммы после скидки
+	var taxTotal models.Amount
+	for i := range invoice.LineItems {
+		item := &invoice.LineItems[i]
+
+		taxable := item.Amount
+		if subtotal > 0 {
+			taxable -= discountTotal.Mul(float64(item.Amount) / float64(subtotal))
+		}
+		tax := taxable.Percent(item.TaxRate)
+
+		if item.TaxAmount != 0 && item.TaxAmount != tax {
+			return &ValidationError{Message: fmt.Sprintf("Line item %d: tax %s does not match calculated %s", i+1, item.TaxAmount, tax)}
+		}
+		item.TaxAmount = tax
+		taxTotal += tax
+	}
+
+	total := subtotal - discountTotal + taxTotal
+
+	// Итоги, присланные клиентом, должны совпадать с расчетом
+	checks := []struct {
+		name      string
+		got, want models.Amount
+	}{
+		{"Subtotal", invoice.Subtotal, subtotal},
+		{"Discount total", invoice.DiscountTotal, discountTotal},
+		{"Tax total", invoice.TaxTotal, taxTotal},
+		{"Invoice total", invoice.Amount, total},
+	}
+	for _, check := range checks {
+		if check.got != 0 && check.got != check.want {
+			return &ValidationError{Message: fmt.Sprintf("%s %s does not match calculated %s", check.name, check.got, check.want)}
+		}
+	}
+
+	invoice.Subtotal = subtotal
+	invoice.DiscountTotal = discountTotal
+	invoice.TaxTotal = taxTotal
+	invoice.Amount = total
+
+	return nil
+}
+
+// calculateCreditNoteTotals рассчитывает позиции, налог и итог кредит-ноты.
+// Кредит-нота без позиций задается суммой Amount.
+func calculateCreditNoteTotals(note *models.CreditNote) error {
+	if len(note.LineItems) == 0 {
+		if note.Amount <= 0 {
+			return &ValidationError{Message: "Credit note amount must be greater than zero"}
+		}
+		note.Subtotal = note.Amount
+		note.TaxTotal = 0
+		return nil
+	}
+
+	var subtotal, taxTotal models.Amount
+	for i := range note.LineItems {
+		item := &note.LineItems[i]
+		if err := validateLineItem(i, item); err != nil {
+			return err
+		}
+
+		item.Amount = item.UnitPrice.Mul(item.Quantity)
+		item.TaxAmount = item.Amount.Percent(item.TaxRate)
+		subtotal += item.Amount
+		taxTotal += item.TaxAmount
+	}
+
+	total := subtotal + taxTotal
+	if note.Amount != 0 && note.Amount != total {
+		return &ValidationError{Message: fmt.Sprintf("Credit note total %s does not match calculated %s", note.Amount, total)}
+	}
+	if total <= 0 {
+		return &ValidationError{Message: "Credit note amount must be greater than zero"}
+	}
+
+	note.Subtotal = subtotal
+	note.TaxTotal = taxTotal
+	note.Amount = total
+
+	return nil
+}
+
+// validateLineItem проверяет позицию счета
+func validateLineItem(index int, item *models.LineItem) error {
+	if item.Type == "" {
+		item.Type = models.LineItemTypeOther
+	}
+
+	switch item.Type {
+	case models.LineItemTypeLinehaul,
+		models.LineItemTypeFuelSurcharge,
+		models.LineItemTypeDetention,
+		models.LineItemTypeLumper,
+		models.LineItemTypeNSFFee,
+		models.LineItemTypeLateFee,
+		models.LineItemTypeOther:
+	default:
+		return &ValidationError{Message: fmt.Sprintf("Line item %d: unsupported type %q", index+1, item.Type)}
+	}
+
+	if item.Description == "" {
+		return &ValidationError{Message: fmt.Sprintf("Line item %d: description is required", index+1)}
+	}
+	if item.Quantity <= 0 {
+		return &ValidationError{Message: fmt.Sprintf("Line item %d: quantity must be greater than zero", index+1)}
+	}
+	if item.UnitPrice < 0 {
+		return &ValidationError{Message: fmt.Sprintf("Line item %d: unit price cannot be negative", index+1)}
+	}
+	if item.TaxRate < 0 || item.TaxRate > 100 {
+		return &ValidationError{Message: fmt.Sprintf("Line item %d: tax rate must be between 0 and 100", index+1)}
+	}
+
+	return nil
+}
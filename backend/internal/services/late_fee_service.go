@@ -0,0 +1,288 @@
+package services
+
+import (
+	"billing-system/config"
+	"billing-system/internal/models"
+	"billing-system/internal/repository"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// lateFeePageSize размер страницы при обходе просроченных счетов
+const lateFeePageSize = 500
+
+// lateFeeService реализация LateFeeService
+type lateFeeService struct {
+	invoiceRepo    repository.InvoiceRepository
+	brokerRepo     repository.BrokerRepository
+	invoiceService InvoiceService
+	defaults       models.LateFeePolicy // фиксированная пеня общих правил задана в базовой валюте
+	baseCurrency   string
+}
+
+// NewLateFeeService создает новый LateFeeService. Некорректные общие правила отключают начисление
+// для брокеров без собственных правил.
+func NewLateFeeService(
+	invoiceRepo repository.InvoiceRepository,
+	brokerRepo repository.BrokerRepository,
+	invoiceService InvoiceService,
+	cfg config.LateFeeConfig,
+	currencies config.CurrencyConfig,
+) LateFeeService {
+	defaults := models.LateFeePolicy{
+		Type:        cfg.Type,
+		FlatFee:     models.AmountFromFloat(cfg.FlatFee),
+		MonthlyRate: cfg.MonthlyRate,
+		GraceDays:   cfg.GraceDays,
+	}
+	if err := validateLateFeePolicy(&defaults); err != nil {
+		log.Printf("Начисление пеней отключено: %v", err)
+		defaults = models.LateFeePolicy{Type: models.LateFeeTypeNone}
+	}
+
+	return &lateFeeService{
+		invoiceRepo:    invoiceRepo,
+		brokerRepo:     brokerRepo,
+		invoiceService: invoiceService,
+		defaults:       defaults,
+		baseCurrency:   currencies.Base,
+	}
+}
+
+// periodAccrued проверяет, что пеня по счету за период уже выставлена
+func (s *lateFeeService) periodAccrued(ctx context.Context, invoiceID primitive.ObjectID, period int) (bool, error) {
+	fees, err := s.invoiceRepo.GetLateFees(ctx, invoiceID)
+	if err != nil {
+		return false, err
+	}
+	for _, fee := range fees {
+		if fee.LateFeePeriod == period {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// lateFeeAccrual периоды пени, которые нужно начислить по счету
+type lateFeeAccrual struct {
+	invoice    *models.Invoice
+	policy     models.LateFeePolicy
+	fromPeriod int
+	toPeriod   int
+}
+
+// Run начисляет пени по просроченным счетам: по отдельному счету на каждый наступивший и еще не начисленный
+// период. Пени на счета-пени не начисляются. Фиксированная пеня общих правил пересчитывается из базовой валюты
+// по курсу счета; счета без курса пропускаются.
+func (s *lateFeeService) Run(ctx context.Context) (*models.LateFeeSummary, error) {
+	summary := &models.LateFeeSummary{}
+	now := time.Now()
+
+	brokerPolicies := make(map[primitive.ObjectID]*models.LateFeePolicy)
+	var accruals []*lateFeeAccrual
+
+	// Счета на пени создаются после обхода, чтобы не сдвигать страницы просроченных счетов
+	for offset := 0; ; offset += lateFeePageSize {
+		invoices, total, err := s.invoiceRepo.GetOverdue(ctx, lateFeePageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		if len(invoices) == 0 {
+			break
+		}
+
+		ids := make([]primitive.ObjectID, len(invoices))
+		for i, invoice := range invoices {
+			ids[i] = invoice.ID
+		}
+
+		lastPeriods, err := s.invoiceRepo.GetLastLateFeePeriods(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, invoice := range invoices {
+			summary.InvoicesChecked++
+			if invoice.IsLateFee() || invoice.RemainingAmount <= 0 {
+				continue
+			}
+
+			own, ok := brokerPolicies[invoice.BrokerID]
+			if !ok {
+				own, err = s.brokerPolicy(ctx, invoice.BrokerID)
+				if err != nil {
+					return nil, err
+				}
+				brokerPolicies[invoice.BrokerID] = own
+			}
+			policy := s.defaults
+			if own != nil {
+				policy = *own
+			}
+
+			periods := policy.PeriodsDue(invoice.DueDate, now)
+			if periods > lastPeriods[invoice.ID] {
+				if own == nil && policy.Type == models.LateFeeTypeFlat {
+					fee, ok := s.defaultFlatFee(invoice)
+					if !ok {
+						log.Printf("Пеня по счету %s не начислена: нет курса %s/%s для пересчета фиксированной пени",
+							invoice.InvoiceNumber, invoice.Currency, s.baseCurrency)
+						continue
+					}
+					policy.FlatFee = fee
+				}
+				accruals = append(accruals, &lateFeeAccrual{
+					invoice:    invoice,
+					policy:     policy,
+					fromPeriod: lastPeriods[invoice.ID] + 1,
+					toPeriod:   periods,
+				})
+			}
+		}
+
+		if int64(offset+lateFeePageSize) >= total {
+			break
+		}
+	}
+
+	for _, accrual := range accruals {
+		for period := accrual.fromPeriod; period <= accrual.toPeriod; period++ {
+			fee := accrual.policy.Fee(accrual.invoice.RemainingAmount)
+			if fee <= 0 {
+				continue
+			}
+
+			feeInvoice := newLateFeeInvoice(accrual.invoice, accrual.policy, period, fee)
+			feeInvoice.CreditOverride = systemCreditOverride("Late fee")
+			err := s.invoiceService.CreateInvoice(ctx, feeInvoice)
+			// Период уже начислен другим экземпляром приложения. Повтор номера счета тоже дает
+			// ошибку уникальности, поэтому период пропускается, только если пеня за него действительно есть.
+			if mongo.IsDuplicateKeyError(err) {
+				accrued, lookupErr := s.periodAccrued(ctx, accrual.invoice.ID, period)
+				if lookupErr != nil {
+					err = lookupErr
+				} else if accrued {
+					continue
+				}
+			}
+			if err != nil {
+				summary.Failed++
+				log.Printf("Ошибка начисления пени по счету %s за период %d: %v", accrual.invoice.InvoiceNumber, period, err)
+				continue
+			}
+			summary.FeesCreated++
+		}
+	}
+
+	return summary, nil
+}
+
+// GetInvoiceLateFees получает пени, начисленные за просрочку счета
+func (s *lateFeeService) GetInvoiceLateFees(ctx context.Context, invoiceID primitive.ObjectID) ([]*models.Invoice, error) {
+	if _, err := s.invoiceRepo.GetByID(ctx, invoiceID); err != nil {
+		return nil, err
+	}
+	return s.invoiceRepo.GetLateFees(ctx, invoiceID)
+}
+
+// WaiveLateFee списывает пеню: счет на пеню аннулируется с причиной и пользователем, которые сохраняются в счете.
+// Списанный период повторно не начисляется.
+func (s *lateFeeService) WaiveLateFee(ctx context.Context, id primitive.ObjectID, req *models.WaiveLateFeeRequest, waivedBy string) (*models.Invoice, error) {
+	invoice, err := s.invoiceRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !invoice.IsLateFee() {
+		return nil, &ValidationError{Message: "Only late fee invoices can be waived"}
+	}
+
+	waived, err := s.invoiceService.VoidInvoice(ctx, id, &models.VoidInvoiceRequest{Reason: req.Reason}, waivedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Пеня %s по счету %s списана пользователем %s: %s", waived.InvoiceNumber, waived.LateFeeFor.Hex(), waivedBy, waived.VoidReason)
+	return waived, nil
+}
+
+// brokerPolicy возвращает собственные правила начисления пеней брокера; nil - действуют общие правила
+func (s *lateFeeService) brokerPolicy(ctx context.Context, brokerID primitive.ObjectID) (*models.LateFeePolicy, error) {
+	broker, err := s.brokerRepo.GetByID(ctx, brokerID)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return broker.LateFeePolicy, nil
+}
+
+// defaultFlatFee пересчитывает фиксированную пеню общих правил из базовой валюты в валюту счета
+// по курсу, зафиксированному в счете. Возвращает false, если у счета нет курса к базовой валюте.
+func (s *lateFeeService) defaultFlatFee(invoice *models.Invoice) (models.Amount, bool) {
+	if invoice.Currency == s.baseCurrency {
+		return s.defaults.FlatFee, true
+	}
+	rate := invoice.BaseRate(s.baseCurrency)
+	if rate <= 0 {
+		return 0, false
+	}
+	return s.defaults.FlatFee.Mul(1 / rate), true
+}
+
+// validateLateFeePolicy проверяет правила начисления пеней
+func validateLateFeePolicy(policy *models.LateFeePolicy) error {
+	switch policy.Type {
+	case models.LateFeeTypeNone:
+	case models.LateFeeTypeFlat:
+		if policy.FlatFee <= 0 {
+			return &ValidationError{Message: "Flat late fee must be greater than zero"}
+		}
+	case models.LateFeeTypeInterest:
+		if policy.MonthlyRate <= 0 || policy.MonthlyRate > 100 {
+			return &ValidationError{Message: "Monthly late fee rate must be between 0 and 100 percent"}
+		}
+	default:
+		return &ValidationError{Message: "Invalid late fee type"}
+	}
+
+	if policy.GraceDays < 0 {
+		return &ValidationError{Message: "Late fee grace days cannot be negative"}
+	}
+	return nil
+}
+
+// newLateFeeInvoice строит счет на пеню за период просрочки счета invoice.
+// Пеня оплачивается на условиях исходного счета без скидки за раннюю оплату, без условий - сразу.
+func newLateFeeInvoice(invoice *models.Invoice, policy models.LateFeePolicy, period int, fee models.Amount) *models.Invoice {
+	description := fmt.Sprintf("Late fee on invoice %s", invoice.InvoiceNumber)
+	if policy.Type == models.LateFeeTypeInterest {
+		description = fmt.Sprintf("Late interest %s%% for month %d on invoice %s (balance %s %s)",
+			formatRate(policy.MonthlyRate), period, invoice.InvoiceNumber, invoice.Currency, invoice.RemainingAmount)
+	}
+
+	terms := models.PaymentTerms{Type: models.PaymentTermsDueOnReceipt}
+	if invoice.PaymentTerms != nil {
+		terms = models.PaymentTerms{Type: invoice.PaymentTerms.Type, Days: invoice.PaymentTerms.Days}
+	}
+
+	return &models.Invoice{
+		BrokerID:      invoice.BrokerID,
+		Currency:      invoice.Currency,
+		PaymentTerms:  &terms,
+		LateFeeFor:    invoice.ID,
+		LateFeePeriod: period,
+		LineItems: []models.LineItem{{
+			Type:        models.LineItemTypeLateFee,
+			Description: description,
+			Quantity:    1,
+			UnitPrice:   fee,
+		}},
+		Description: description,
+	}
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"billing-system/config"
 	"billing-system/internal/models"
 	"billing-system/internal/repository"
 	"context"
@@ -11,9 +12,12 @@ import (
 
 // loadService реализация LoadService
 type loadService struct {
-	loadRepo    repository.LoadRepository
-	brokerRepo  repository.BrokerRepository
-	invoiceRepo repository.InvoiceRepository
+	loadRepo     repository.LoadRepository
+	brokerRepo   repository.BrokerRepository
+	invoiceRepo  repository.InvoiceRepository
+	unitOfWork   repository.UnitOfWork
+	creditLimits CreditLimitService
+	currencies   config.CurrencyConfig
 }
 
 // NewLoadService создает новый LoadService
@@ -21,11 +25,17 @@ func NewLoadService(
 	loadRepo repository.LoadRepository,
 	brokerRepo repository.BrokerRepository,
 	invoiceRepo repository.InvoiceRepository,
+	unitOfWork repository.UnitOfWork,
+	creditLimits CreditLimitService,
+	currencies config.CurrencyConfig,
 ) LoadService {
 	return &loadService{
-		loadRepo:    loadRepo,
-		brokerRepo:  brokerRepo,
-		invoiceRepo: invoiceRepo,
+		loadRepo:     loadRepo,
+		brokerRepo:   brokerRepo,
+		invoiceRepo:  invoiceRepo,
+		unitOfWork:   unitOfWork,
+		creditLimits: creditLimits,
+		currencies:   currencies,
 	}
 }
 
@@ -36,7 +46,33 @@ func (s *loadService) CreateLoad(ctx context.Context, load *models.Load) error {
 		return err
 	}
 
-	return s.loadRepo.Create(ctx, load)
+	// Брокеру на кредитном стопе новые грузы не назначаются
+	broker, err := s.brokerRepo.GetByID(ctx, load.BrokerID)
+	if err != nil {
+		return err
+	}
+	if broker.Status == models.BrokerStatusCreditHold {
+		return &ValidationError{Message: "Broker is on credit hold"}
+	}
+
+	// Невыставленный груз входит в кредитную нагрузку брокера; нагрузка проверяется в транзакции создания груза.
+	// Номер, выданный в откаченной попытке транзакции, выдается заново.
+	override, number := load.CreditOverride, load.LoadNumber
+	err = s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		var err error
+		load.LoadNumber = number
+		load.CreditOverride, err = s.creditLimits.CheckLimit(ctx, broker, load.Cost, load.Currency, override)
+		if err != nil {
+			return err
+		}
+		return s.loadRepo.Create(ctx, load)
+	})
+	if err != nil {
+		return err
+	}
+
+	notifyCreditUtilization(s.creditLimits, broker.ID)
+	return nil
 }
 
 // GetLoad получает груз по ID
@@ -67,21 +103,55 @@ func (s *loadService) GetAllLoads(ctx context.Context, filter *models.LoadFilter
 
 // UpdateLoad обновляет груз
 func (s *loadService) UpdateLoad(ctx context.Context, id primitive.ObjectID, load *models.Load) error {
-	// Проверяем, существует ли груз
-	existing, err := s.loadRepo.GetByID(ctx, id)
-	if err != nil {
+	// Валидация
+	if err := s.validateLoad(ctx, load); err != nil {
 		return err
 	}
-	if existing == nil {
-		return &ValidationError{Message: "Load not found"}
+
+	broker, err := s.brokerRepo.GetByID(ctx, load.BrokerID)
+	if err != nil {
+		return err
 	}
 
-	// Валидация
-	if err := s.validateLoad(ctx, load); err != nil {
+	// Прежняя версия груза и нагрузка брокера читаются в транзакции изменения
+	override := load.CreditOverride
+	var existing *models.Load
+	err = s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		var err error
+		existing, err = s.loadRepo.GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		// Проверяется прирост нагрузки: у того же брокера груз уже учтен прежней стоимостью
+		var previous models.Amount
+		if existing.BrokerID == load.BrokerID {
+			previous = unbilledCost(existing)
+		}
+		load.CreditOverride, err = s.creditLimits.CheckChange(ctx, broker, previous, existing.Currency, unbilledCost(load), load.Currency, override)
+		if err != nil {
+			return err
+		}
+
+		return s.loadRepo.Update(ctx, id, load)
+	})
+	if err != nil {
 		return err
 	}
 
-	return s.loadRepo.Update(ctx, id, load)
+	notifyCreditUtilization(s.creditLimits, load.BrokerID)
+	if existing.BrokerID != load.BrokerID {
+		notifyCreditUtilization(s.creditLimits, existing.BrokerID)
+	}
+	return nil
+}
+
+// unbilledCost стоимость груза, входящая в кредитную нагрузку брокера: учитываются невыставленные и неотмененные грузы
+func unbilledCost(load *models.Load) models.Amount {
+	if load.Status == models.LoadStatusCanceled || !load.InvoiceID.IsZero() {
+		return 0
+	}
+	return load.Cost
 }
 
 // DeleteLoad удаляет груз
@@ -203,14 +273,8 @@ func (s *loadService) validateLoad(ctx context.Context, load *models.Load) error
 		return &ValidationError{Message: "Cost must be greater than zero"}
 	}
 
-	if load.Currency == "" {
-		return &ValidationError{Message: "Currency is required"}
-	}
-
-	if load.Currency != models.CurrencyUSD &&
-		load.Currency != models.CurrencyEUR &&
-		load.Currency != models.CurrencyRUB {
-		return &ValidationError{Message: "Unsupported currency"}
+	if err := validateCurrency(s.currencies, load.Currency); err != nil {
+		return err
 	}
 
 	// Валидация маршрута
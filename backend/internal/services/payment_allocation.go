@@ -0,0 +1,326 @@
+package services
+
+import (
+	"billing-system/internal/models"
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// allocationRequest разнесение платежа, запрошенное клиентом.
+// Сохраняется до начала транзакции, чтобы при ее повторе разнесение строилось заново.
+type allocationRequest struct {
+	allocations []models.PaymentAllocation
+	invoiceID   primitive.ObjectID
+	auto        bool
+}
+
+// newAllocationRequest определяет способ разнесения: явный список, автоматически по сроку оплаты
+// или краткая запись с одним счетом (invoice_id), при которой переплата остается кредитом брокера
+func newAllocationRequest(payment *models.Payment) (*allocationRequest, error) {
+	if payment.AutoAllocate && len(payment.Allocations) > 0 {
+		return nil, &ValidationError{Message: "Use either allocations or auto_allocate"}
+	}
+
+	request := &allocationRequest{
+		allocations: make([]models.PaymentAllocation, len(payment.Allocations)),
+		auto:        payment.AutoAllocate,
+	}
+	for i, allocation := range payment.Allocations {
+		request.allocations[i] = models.PaymentAllocation{
+			InvoiceID: allocation.InvoiceID,
+			Amount:    allocation.Amount,
+		}
+	}
+	if len(request.allocations) == 0 && !request.auto {
+		request.invoiceID = payment.InvoiceID
+	}
+
+	return request, nil
+}
+
+// validatePayment валидирует данные платежа, разносит его по счетам и блокирует эти счета в текущей транзакции.
+// previous — прежняя версия платежа при обновлении, ее разнесения не учитываются в оплаченных суммах.
+// Сумма, не разнесенная на счета, записывается в UnappliedAmount и остается кредитом брокера.
+func (s *paymentService) validatePayment(ctx context.Context, payment *models.Payment, request *allocationRequest, previous *models.Payment) error {
+	if payment.Amount <= 0 {
+		return &ValidationError{Message: "Payment amount must be greater than zero"}
+	}
+
+	if err := s.exchangeRates.ValidateCurrency(payment.Currency); err != nil {
+		return err
+	}
+
+	if payment.PaymentDate.IsZero() {
+		return &ValidationError{Message: "Payment date is required"}
+	}
+
+	if payment.PaymentMethod == "" {
+		return &ValidationError{Message: "Payment method is required"}
+	}
+
+	if payment.BrokerID.IsZero() {
+		return &ValidationError{Message: "Broker ID is required"}
+	}
+
+	var (
+		invoices map[primitive.ObjectID]*models.Invoice
+		err      error
+	)
+	switch {
+	case request.auto:
+		invoices, err = s.autoAllocate(ctx, payment, previous)
+	case len(request.allocations) > 0:
+		invoices, err = s.allocateExplicit(ctx, payment, request.allocations, previous)
+	case !request.invoiceID.IsZero():
+		invoices, err = s.allocateToInvoice(ctx, payment, request.invoiceID, previous)
+	default:
+		// Платеж без счетов целиком зачисляется в кредит брокера
+		payment.Allocations = []models.PaymentAllocation{}
+		_, err = s.brokerRepo.GetByID(ctx, payment.BrokerID)
+		if err == mongo.ErrNoDocuments {
+			return &ValidationError{Message: "Broker not found"}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	payment.UnappliedAmount = payment.Amount - payment.AppliedAmount()
+	payment.InvoiceID = primitive.NilObjectID
+	if len(payment.Allocations) == 1 {
+		payment.InvoiceID = payment.Allocations[0].InvoiceID
+	}
+
+	return s.applyExchangeRates(ctx, payment, invoices)
+}
+
+// allocateToInvoice разносит платеж на один счет в пределах его остатка
+func (s *paymentService) allocateToInvoice(ctx context.Context, payment *models.Payment, invoiceID primitive.ObjectID, previous *models.Payment) (map[primitive.ObjectID]*models.Invoice, error) {
+	invoice, remaining, err := s.lockAllocationInvoice(ctx, payment, invoiceID, previous)
+	if err != nil {
+		return nil, err
+	}
+	if !remaining.IsPositive() {
+		return nil, &ValidationError{Message: "Invoice has no remaining amount due; record the payment without an invoice to keep it as broker credit"}
+	}
+
+	// Переплата сверх остатка остается на брокере неразнесенным кредитом
+	amount, err := payment.Total().Min(remaining)
+	if err != nil {
+		return nil, err
+	}
+
+	payment.Allocations = []models.PaymentAllocation{{InvoiceID: invoice.ID, Amount: amount.Amount}}
+	return map[primitive.ObjectID]*models.Invoice{invoice.ID: invoice}, nil
+}
+
+// allocateExplicit проверяет разнесение, заданное клиентом: каждая сумма не больше остатка своего счета,
+// а всего разнесено не больше суммы платежа
+func (s *paymentService) allocateExplicit(ctx context.Context, payment *models.Payment, allocations []models.PaymentAllocation, previous *models.Payment) (map[primitive.ObjectID]*models.Invoice, error) {
+	invoices := make(map[primitive.ObjectID]*models.Invoice, len(allocations))
+	total := models.NewMoney(0, payment.Currency)
+
+	payment.Allocations = make([]models.PaymentAllocation, 0, len(allocations))
+	for i, allocation := range allocations {
+		if allocation.InvoiceID.IsZero() {
+			return nil, &ValidationError{Message: fmt.Sprintf("Allocation %d: invoice ID is required", i+1)}
+		}
+		if _, ok := invoices[allocation.InvoiceID]; ok {
+			return nil, &ValidationError{Message: fmt.Sprintf("Allocation %d: invoice is allocated more than once", i+1)}
+		}
+		if allocation.Amount <= 0 {
+			return nil, &ValidationError{Message: fmt.Sprintf("Allocation %d: amount must be greater than zero", i+1)}
+		}
+
+		invoice, remaining, err := s.lockAllocationInvoice(ctx, payment, allocation.InvoiceID, previous)
+		if err != nil {
+			return nil, err
+		}
+		amount := models.NewMoney(allocation.Amount, payment.Currency)
+		if cmp, err := amount.Cmp(remaining); err != nil {
+			return nil, err
+		} else if cmp > 0 {
+			return nil, &ValidationError{Message: fmt.Sprintf("Allocation %d: amount exceeds remaining amount due on invoice %s", i+1, invoice.InvoiceNumber)}
+		}
+
+		invoices[invoice.ID] = invoice
+		if total, err = total.Add(amount); err != nil {
+			return nil, err
+		}
+		payment.Allocations = append(payment.Allocations, models.PaymentAllocation{
+			InvoiceID: invoice.ID,
+			Amount:    allocation.Amount,
+		})
+	}
+
+	if cmp, err := total.Cmp(payment.Total()); err != nil {
+		return nil, err
+	} else if cmp > 0 {
+		return nil, &ValidationError{Message: "Allocations exceed payment amount"}
+	}
+
+	return invoices, nil
+}
+
+// autoAllocate разносит платеж на открытые счета брокера в валюте платежа, начиная с самого раннего срока оплаты.
+// Остаток после погашения всех счетов остается кредитом брокера.
+func (s *paymentService) autoAllocate(ctx context.Context, payment *models.Payment, previous *models.Payment) (map[primitive.ObjectID]*models.Invoice, error) {
+	open, err := s.invoiceRepo.GetOpenByBroker(ctx, payment.BrokerID, payment.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	invoices := make(map[primitive.ObjectID]*models.Invoice)
+	payment.Allocations = []models.PaymentAllocation{}
+	left := payment.Total()
+	for _, candidate := range open {
+		if !left.IsPositive() {
+			break
+		}
+
+		invoice, remaining, err := s.lockAllocationInvoice(ctx, payment, candidate.ID, previous)
+		if err != nil {
+			return nil, err
+		}
+		if !remaining.IsPositive() {
+			continue
+		}
+
+		amount, err := left.Min(remaining)
+		if err != nil {
+			return nil, err
+		}
+
+		invoices[invoice.ID] = invoice
+		payment.Allocations = append(payment.Allocations, models.PaymentAllocation{
+			InvoiceID: invoice.ID,
+			Amount:    amount.Amount,
+		})
+		if left, err = left.Sub(amount); err != nil {
+			return nil, err
+		}
+	}
+
+	return invoices, nil
+}
+
+// lockAllocationInvoice блокирует счет разнесения, проверяет его брокера и валюту
+// и возвращает остаток к оплате в валюте счета без учета прежнего разнесения этого же платежа
+func (s *paymentService) lockAllocationInvoice(ctx context.Context, payment *models.Payment, invoiceID primitive.ObjectID, previous *models.Payment) (*models.Invoice, models.Money, error) {
+	invoice, err := s.invoiceRepo.Lock(ctx, invoiceID)
+	if err == mongo.ErrNoDocuments {
+		return nil, models.Money{}, &ValidationError{Message: "Invoice not found"}
+	}
+	if err != nil {
+		return nil, models.Money{}, err
+	}
+
+	// Черновик и аннулированный счет оплату не принимают
+	if invoice.Status == models.InvoiceStatusDraft || invoice.Status == models.InvoiceStatusVoid {
+		return nil, models.Money{}, &ValidationError{Message: fmt.Sprintf("Invoice %s is %s and cannot be paid", invoice.InvoiceNumber, invoice.Status)}
+	}
+
+	// Проверяем соответствие валют
+	if invoice.Currency != payment.Currency {
+		return nil, models.Money{}, &ValidationError{Message: "Payment currency must match invoice currency"}
+	}
+
+	// Проверяем соответствие брокера
+	if invoice.BrokerID != payment.BrokerID {
+		return nil, models.Money{}, &ValidationError{Message: "Payment broker must match invoice broker"}
+	}
+
+	// Остаток к доплате с учетом кредит-нот и предоставленной скидки за раннюю оплату.
+	// При изменении платежа скидка будет пересчитана заново, поэтому она не учитывается.
+	paid, err := s.paymentRepo.GetTotalPaidAmount(ctx, invoiceID)
+	if err != nil {
+		return nil, models.Money{}, err
+	}
+	earlyDiscount := invoice.EarlyDiscount
+	if previous != nil {
+		if allocation := previous.AllocationFor(invoiceID); allocation != nil {
+			paid -= allocation.Amount
+			earlyDiscount = 0
+		}
+	}
+
+	return invoice, models.NewMoney(invoice.Amount-invoice.CreditedAmount-earlyDiscount-paid, invoice.Currency), nil
+}
+
+// applyExchangeRates фиксирует курс платежа на дату оплаты и реализованную курсовую разницу по каждому разнесению:
+// разнесенная сумма в базовой валюте по курсу оплаты минус та же сумма по курсу счета
+func (s *paymentService) applyExchangeRates(ctx context.Context, payment *models.Payment, invoices map[primitive.ObjectID]*models.Invoice) error {
+	paymentRate, err := s.exchangeRates.GetRate(ctx, payment.Currency, payment.PaymentDate)
+	if err != nil {
+		return err
+	}
+
+	payment.ExchangeRate = paymentRate
+	payment.ExchangeRateBase = s.exchangeRates.Currencies().BaseCurrency
+	payment.BaseAmount = payment.Amount.Mul(paymentRate)
+	payment.FXGainLoss = 0
+
+	for i := range payment.Allocations {
+		allocation := &payment.Allocations[i]
+
+		invoiceRate, err := s.invoiceRate(ctx, invoices[allocation.InvoiceID])
+		if err != nil {
+			return err
+		}
+
+		allocation.FXGainLoss = allocation.Amount.Mul(paymentRate) - allocation.Amount.Mul(invoiceRate)
+		payment.FXGainLoss += allocation.FXGainLoss
+	}
+
+	return nil
+}
+
+// invoiceRate возвращает курс счета; счета без курса к текущей базовой валюте пересчитываются по курсу на дату создания
+func (s *paymentService) invoiceRate(ctx context.Context, invoice *models.Invoice) (float64, error) {
+	if rate := invoice.BaseRate(s.exchangeRates.Currencies().BaseCurrency); rate > 0 {
+		return rate, nil
+	}
+	return s.exchangeRates.GetRate(ctx, invoice.Currency, invoice.CreatedAt)
+}
+
+// paymentRate возвращает курс платежа; платежи без курса к текущей базовой валюте пересчитываются по курсу на дату оплаты
+func (s *paymentService) paymentRate(ctx context.Context, payment *models.Payment) (float64, error) {
+	if rate := payment.BaseRate(s.exchangeRates.Currencies().BaseCurrency); rate > 0 {
+		return rate, nil
+	}
+	return s.exchangeRates.GetRate(ctx, payment.Currency, payment.PaymentDate)
+}
+
+// allocateCredit разносит amount из неразнесенного остатка платежа credit на счет
+func (s *paymentService) allocateCredit(ctx context.Context, credit *models.Payment, invoice *models.Invoice, amount models.Money) error {
+	invoiceRate, err := s.invoiceRate(ctx, invoice)
+	if err != nil {
+		return err
+	}
+	creditRate, err := s.paymentRate(ctx, credit)
+	if err != nil {
+		return err
+	}
+
+	allocation := credit.AllocationFor(invoice.ID)
+	if allocation == nil {
+		credit.Allocations = append(credit.Allocations, models.PaymentAllocation{InvoiceID: invoice.ID})
+		allocation = &credit.Allocations[len(credit.Allocations)-1]
+	}
+
+	unapplied, err := credit.Unapplied().Sub(amount)
+	if err != nil {
+		return err
+	}
+
+	fxGainLoss := amount.Amount.Mul(creditRate) - amount.Amount.Mul(invoiceRate)
+	allocation.Amount += amount.Amount
+	allocation.FXGainLoss += fxGainLoss
+	credit.FXGainLoss += fxGainLoss
+	credit.UnappliedAmount = unapplied.Amount
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"billing-system/internal/models"
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// reversalReasons допустимые причины сторно
+var reversalReasons = map[string]bool{
+	models.ReversalReasonNSF:           true,
+	models.ReversalReasonChargeback:    true,
+	models.ReversalReasonStopPayment:   true,
+	models.ReversalReasonAccountClosed: true,
+	models.ReversalReasonPostingError:  true,
+}
+
+// refundReasons допустимые причины возврата
+var refundReasons = map[string]bool{
+	models.RefundReasonOverpayment:     true,
+	models.RefundReasonDuplicate:       true,
+	models.RefundReasonCustomerRequest: true,
+	models.RefundReasonOther:           true,
+}
+
+// ReversePayment отменяет платеж встречной записью с отрицательными суммами и теми же разнесениями.
+// Счета платежа пересчитываются и снова становятся открытыми; комиссия за возврат выставляется брокеру отдельным счетом
+// в той же транзакции, а уведомление о нем отправляется после ее фиксации.
+func (s *paymentService) ReversePayment(ctx context.Context, id primitive.ObjectID, req *models.ReversePaymentRequest, createdBy string) (*models.PaymentReversalResult, error) {
+	if !reversalReasons[req.ReasonCode] {
+		return nil, &ValidationError{Message: "Invalid reversal reason code"}
+	}
+	if req.NSFFee < 0 {
+		return nil, &ValidationError{Message: "NSF fee cannot be negative"}
+	}
+
+	date := req.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	var result *models.PaymentReversalResult
+	err := s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		original, err := s.paymentRepo.GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkAdjustable(original); err != nil {
+			return err
+		}
+		if original.RefundedAmount > 0 {
+			return &ValidationError{Message: "Payment has refunds and cannot be reversed"}
+		}
+
+		// Блокируем счета разнесения до пересчета
+		for _, invoiceID := range original.InvoiceIDs() {
+			if _, err := s.invoiceRepo.Lock(ctx, invoiceID); err != nil {
+				return err
+			}
+		}
+
+		reversal := newReversal(original, req, date, createdBy)
+		result = &models.PaymentReversalResult{Reversal: reversal}
+
+		if req.NSFFee > 0 {
+			feeInvoice := newNSFFeeInvoice(original, req.NSFFee, date)
+			feeInvoice.CreditOverride = systemCreditOverride("Returned payment fee")
+			if err := s.invoiceService.InsertInvoice(ctx, feeInvoice); err != nil {
+				return err
+			}
+			reversal.FeeInvoiceID = feeInvoice.ID
+			result.FeeInvoice = feeInvoice
+		}
+
+		if err := s.paymentRepo.Create(ctx, reversal); err != nil {
+			return err
+		}
+
+		marked, err := s.paymentRepo.MarkReversed(ctx, original.ID, reversal.ID)
+		if err != nil {
+			return err
+		}
+		if !marked {
+			return &ValidationError{Message: "Payment is already reversed"}
+		}
+
+		return s.recalculateInvoices(ctx, original.InvoiceIDs())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Счет комиссии отправляется брокеру только после фиксации сторно
+	if result.FeeInvoice != nil {
+		s.invoiceService.NotifyInvoiceCreated(result.FeeInvoice)
+	}
+
+	return result, nil
+}
+
+// RefundPayment возвращает брокеру часть неразнесенного остатка платежа.
+// Возврат записывается отдельной записью с отрицательной суммой по курсу на дату возврата.
+func (s *paymentService) RefundPayment(ctx context.Context, id primitive.ObjectID, req *models.RefundPaymentRequest, createdBy string) (*models.Payment, error) {
+	if req.Amount <= 0 {
+		return nil, &ValidationError{Message: "Refund amount must be greater than zero"}
+	}
+	if !refundReasons[req.ReasonCode] {
+		return nil, &ValidationError{Message: "Invalid refund reason code"}
+	}
+
+	date := req.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	var refund *models.Payment
+	err := s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		original, err := s.paymentRepo.GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkAdjustable(original); err != nil {
+			return err
+		}
+		if req.Amount > original.UnappliedAmount {
+			return &ValidationError{Message: fmt.Sprintf("Refund amount exceeds unapplied amount of the payment (%s)", original.UnappliedAmount)}
+		}
+
+		originalRate, err := s.paymentRate(ctx, original)
+		if err != nil {
+			return err
+		}
+		rate, err := s.exchangeRates.GetRate(ctx, original.Currency, date)
+		if err != nil {
+			return err
+		}
+
+		refund = newRefund(original, req, date, originalRate, rate, createdBy)
+		refund.ExchangeRateBase = s.exchangeRates.Currencies().BaseCurrency
+
+		refunded, err := s.paymentRepo.AddRefund(ctx, original.ID, req.Amount)
+		if err != nil {
+			return err
+		}
+		if !refunded {
+			return &ValidationError{Message: "Refund amount exceeds unapplied amount of the payment"}
+		}
+
+		return s.paymentRepo.Create(ctx, refund)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return refund, nil
+}
+
+// GetPaymentAdjustments получает сторно и возвраты платежа
+func (s *paymentService) GetPaymentAdjustments(ctx context.Context, id primitive.ObjectID) ([]*models.Payment, error) {
+	if _, err := s.paymentRepo.GetByID(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.paymentRepo.GetByOriginal(ctx, id)
+}
+
+// checkAdjustable проверяет, что запись является действующим платежом
+func checkAdjustable(payment *models.Payment) error {
+	if isPaymentAdjustment(payment) {
+		return &ValidationError{Message: "Reversals and refunds cannot be adjusted"}
+	}
+	if payment.IsReversed() {
+		return &ValidationError{Message: "Payment is already reversed"}
+	}
+	return nil
+}
+
+// checkEditable проверяет, что платеж можно изменить или удалить: сторно, возвраты
+// и платежи, на которые они ссылаются, неизменяемы
+func checkEditable(payment *models.Payment) error {
+	if err := checkAdjustable(payment); err != nil {
+		return err
+	}
+	if payment.RefundedAmount > 0 {
+		return &ValidationError{Message: "Payment has refunds and cannot be changed"}
+	}
+	return nil
+}
+
+// isPaymentAdjustment проверяет, что запись является сторно или возвратом
+func isPaymentAdjustment(payment *models.Payment) bool {
+	return payment.Type == models.PaymentTypeReversal || payment.Type == models.PaymentTypeRefund
+}
+
+// newReversal строит сторно платежа: все суммы, включая разнесения и курсовую разницу, с обратным знаком
+// по курсу исходного платежа, чтобы итоги в базовой валюте обнулились
+func newReversal(original *models.Payment, req *models.ReversePaymentRequest, date time.Time, createdBy string) *models.Payment {
+	allocations := make([]models.PaymentAllocation, len(original.Allocations))
+	for i, allocation := range original.Allocations {
+		allocations[i] = models.PaymentAllocation{
+			InvoiceID:  allocation.InvoiceID,
+			Amount:     -allocation.Amount,
+			FXGainLoss: -allocation.FXGainLoss,
+		}
+	}
+
+	return &models.Payment{
+		Type:              models.PaymentTypeReversal,
+		BrokerID:          original.BrokerID,
+		Amount:            -original.Amount,
+		Allocations:       allocations,
+		UnappliedAmount:   -original.UnappliedAmount,
+		Currency:          original.Currency,
+		ExchangeRate:      original.ExchangeRate,
+		ExchangeRateBase:  original.ExchangeRateBase,
+		BaseAmount:        -original.BaseAmount,
+		FXGainLoss:        -original.FXGainLoss,
+		PaymentDate:       date,
+		PaymentMethod:     original.PaymentMethod,
+		TransactionID:     original.TransactionID,
+		ReferenceNumber:   original.ReferenceNumber,
+		Notes:             req.Notes,
+		OriginalPaymentID: original.ID,
+		ReasonCode:        req.ReasonCode,
+		CreatedBy:         createdBy,
+	}
+}
+
+// newRefund строит возврат из неразнесенного остатка. Курсовая разница - остаток по курсу платежа originalRate
+// минус выплаченная сумма по курсу на дату возврата.
+func newRefund(original *models.Payment, req *models.RefundPaymentRequest, date time.Time, originalRate, rate float64, createdBy string) *models.Payment {
+	method := req.PaymentMethod
+	if method == "" {
+		method = original.PaymentMethod
+	}
+
+	return &models.Payment{
+		Type:              models.PaymentTypeRefund,
+		BrokerID:          original.BrokerID,
+		Amount:            -req.Amount,
+		Allocations:       []models.PaymentAllocation{},
+		Currency:          original.Currency,
+		ExchangeRate:      rate,
+		BaseAmount:        -req.Amount.Mul(rate),
+		FXGainLoss:        req.Amount.Mul(originalRate) - req.Amount.Mul(rate),
+		PaymentDate:       date,
+		PaymentMethod:     method,
+		ReferenceNumber:   req.ReferenceNumber,
+		Notes:             req.Notes,
+		OriginalPaymentID: original.ID,
+		ReasonCode:        req.ReasonCode,
+		CreatedBy:         createdBy,
+	}
+}
+
+// newNSFFeeInvoice строит счет на комиссию за вернувшийся платеж со сроком оплаты на дату сторно
+func newNSFFeeInvoice(original *models.Payment, fee models.Amount, date time.Time) *models.Invoice {
+	description := "Returned payment fee"
+	if original.ReferenceNumber != "" {
+		description = fmt.Sprintf("Returned payment fee (ref. %s)", original.ReferenceNumber)
+	}
+
+	return &models.Invoice{
+		BrokerID: original.BrokerID,
+		Currency: original.Currency,
+		DueDate:  date,
+		LineItems: []models.LineItem{{
+			Type:        models.LineItemTypeNSFFee,
+			Description: description,
+			Quantity:    1,
+			UnitPrice:   fee,
+		}},
+		Description: description,
+	}
+}
@@ -4,18 +4,24 @@ import (
 	"billing-system/internal/models"
 	"billing-system/internal/repository"
 	"context"
+	"fmt"
 	"math"
+	"strings"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // paymentService реализация PaymentService
 type paymentService struct {
-	paymentRepo    repository.PaymentRepository
-	invoiceRepo    repository.InvoiceRepository
-	brokerRepo     repository.BrokerRepository
-	emailService   EmailService
-	invoiceService InvoiceService
+	paymentRepo     repository.PaymentRepository
+	invoiceRepo     repository.InvoiceRepository
+	brokerRepo      repository.BrokerRepository
+	unitOfWork      repository.UnitOfWork
+	invoiceBalancer InvoiceBalancer
+	invoiceService  InvoiceService
+	exchangeRates   ExchangeRateService
+	emailService    EmailService
 }
 
 // NewPaymentService создает новый PaymentService
@@ -23,39 +29,56 @@ func NewPaymentService(
 	paymentRepo repository.PaymentRepository,
 	invoiceRepo repository.InvoiceRepository,
 	brokerRepo repository.BrokerRepository,
+	unitOfWork repository.UnitOfWork,
+	invoiceBalancer InvoiceBalancer,
+	invoiceService InvoiceService,
+	exchangeRates ExchangeRateService,
 	emailService EmailService,
 ) PaymentService {
 	return &paymentService{
-		paymentRepo:  paymentRepo,
-		invoiceRepo:  invoiceRepo,
-		brokerRepo:   brokerRepo,
-		emailService: emailService,
+		paymentRepo:     paymentRepo,
+		invoiceRepo:     invoiceRepo,
+		brokerRepo:      brokerRepo,
+		unitOfWork:      unitOfWork,
+		invoiceBalancer: invoiceBalancer,
+		invoiceService:  invoiceService,
+		exchangeRates:   exchangeRates,
+		emailService:    emailService,
 	}
 }
 
-// SetInvoiceService устанавливает ссылку на InvoiceService для избежания циклических зависимостей
-func (s *paymentService) SetInvoiceService(invoiceService InvoiceService) {
-	s.invoiceService = invoiceService
-}
-
-// CreatePayment создает новый платеж
+// CreatePayment создает новый платеж.
+// Разнесение по счетам, запись платежа и пересчет затронутых счетов выполняются в одной транзакции.
 func (s *paymentService) CreatePayment(ctx context.Context, payment *models.Payment) error {
-	// Валидация
-	if err := s.validatePayment(ctx, payment); err != nil {
+	// Сторно и возвраты создаются только через ReversePayment и RefundPayment
+	payment.Type = models.PaymentTypePayment
+	payment.OriginalPaymentID = primitive.NilObjectID
+	payment.ReversalID = primitive.NilObjectID
+	payment.ReasonCode = ""
+	payment.RefundedAmount = 0
+	payment.FeeInvoiceID = primitive.NilObjectID
+
+	request, err := newAllocationRequest(payment)
+	if err != nil {
 		return err
 	}
 
-	// Создаем платеж
-	if err := s.paymentRepo.Create(ctx, payment); err != nil {
-		return err
-	}
+	err = s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		// Валидация и разнесение
+		if err := s.validatePayment(ctx, payment, request, nil); err != nil {
+			return err
+		}
 
-	// Обновляем статус счета
-	if s.invoiceService != nil {
-		if err := s.invoiceService.UpdateInvoiceStatus(ctx, payment.InvoiceID); err != nil {
-			// Логируем ошибку, но не прерываем процесс
-			// TODO: добавить логирование
+		// Создаем платеж
+		if err := s.paymentRepo.Create(ctx, payment); err != nil {
+			return err
 		}
+
+		// Обновляем статусы всех счетов, на которые разнесен платеж
+		return s.recalculateInvoices(ctx, payment.InvoiceIDs())
+	})
+	if err != nil {
+		return err
 	}
 
 	// Отправляем уведомление
@@ -90,61 +113,72 @@ func (s *paymentService) GetAllPayments(ctx context.Context, filter *models.Paym
 	return payments, pagination, nil
 }
 
-// UpdatePayment обновляет платеж
+// UpdatePayment обновляет платеж и разносит его заново
 func (s *paymentService) UpdatePayment(ctx context.Context, id primitive.ObjectID, payment *models.Payment) error {
-	// Получаем существующий платеж
-	existingPayment, err := s.paymentRepo.GetByID(ctx, id)
+	request, err := newAllocationRequest(payment)
 	if err != nil {
 		return err
 	}
 
-	// Валидация
-	if err := s.validatePayment(ctx, payment); err != nil {
-		return err
-	}
+	return s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		// Получаем существующий платеж
+		existingPayment, err := s.paymentRepo.GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkEditable(existingPayment); err != nil {
+			return err
+		}
 
-	// Обновляем платеж
-	if err := s.paymentRepo.Update(ctx, id, payment); err != nil {
-		return err
-	}
+		// Блокируем счета прежнего разнесения
+		for _, invoiceID := range existingPayment.InvoiceIDs() {
+			if _, err := s.invoiceRepo.Lock(ctx, invoiceID); err != nil {
+				return err
+			}
+		}
 
-	// Обновляем статус счета для старого и нового счета (если изменился)
-	if s.invoiceService != nil {
-		if err := s.invoiceService.UpdateInvoiceStatus(ctx, existingPayment.InvoiceID); err != nil {
-			// Логируем ошибку
+		// Валидация и разнесение
+		if err := s.validatePayment(ctx, payment, request, existingPayment); err != nil {
+			return err
 		}
 
-		if existingPayment.InvoiceID != payment.InvoiceID {
-			if err := s.invoiceService.UpdateInvoiceStatus(ctx, payment.InvoiceID); err != nil {
-				// Логируем ошибку
-			}
+		// Обновляем платеж
+		if err := s.paymentRepo.Update(ctx, id, payment); err != nil {
+			return err
 		}
-	}
 
-	return nil
+		// Обновляем статусы счетов прежнего и нового разнесения
+		return s.recalculateInvoices(ctx, append(existingPayment.InvoiceIDs(), payment.InvoiceIDs()...))
+	})
 }
 
-// DeletePayment удаляет платеж
+// DeletePayment удаляет платеж. Отмененные платежи, платежи с возвратами, сами сторно и возвраты не удаляются:
+// вернувшийся платеж отменяется через ReversePayment, чтобы в истории остался след.
 func (s *paymentService) DeletePayment(ctx context.Context, id primitive.ObjectID) error {
-	// Получаем платеж для получения invoice_id
-	payment, err := s.paymentRepo.GetByID(ctx, id)
-	if err != nil {
-		return err
-	}
+	return s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		// Получаем платеж для получения разнесений
+		payment, err := s.paymentRepo.GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkEditable(payment); err != nil {
+			return err
+		}
 
-	// Удаляем платеж
-	if err := s.paymentRepo.Delete(ctx, id); err != nil {
-		return err
-	}
+		for _, invoiceID := range payment.InvoiceIDs() {
+			if _, err := s.invoiceRepo.Lock(ctx, invoiceID); err != nil {
+				return err
+			}
+		}
 
-	// Обновляем статус счета
-	if s.invoiceService != nil {
-		if err := s.invoiceService.UpdateInvoiceStatus(ctx, payment.InvoiceID); err != nil {
-			// Логируем ошибку
+		// Удаляем платеж
+		if err := s.paymentRepo.Delete(ctx, id); err != nil {
+			return err
 		}
-	}
 
-	return nil
+		// Обновляем статусы счетов
+		return s.recalculateInvoices(ctx, payment.InvoiceIDs())
+	})
 }
 
 // GetPaymentsByInvoice получает все платежи по счету
@@ -173,83 +207,187 @@ func (s *paymentService) GetPaymentsByBroker(ctx context.Context, brokerID primi
 	return payments, pagination, nil
 }
 
-// validatePayment валидирует данные платежа
-func (s *paymentService) validatePayment(ctx context.Context, payment *models.Payment) error {
-	if payment.Amount <= 0 {
-		return &ValidationError{Message: "Payment amount must be greater than zero"}
-	}
+// GetBrokerCredit получает неразнесенный кредит брокера по валютам
+func (s *paymentService) GetBrokerCredit(ctx context.Context, brokerID primitive.ObjectID) ([]models.BrokerCredit, error) {
+	return s.paymentRepo.GetBrokerCredit(ctx, brokerID)
+}
 
-	if payment.Currency == "" {
-		return &ValidationError{Message: "Currency is required"}
+// ApplyBrokerCredit разносит неразнесенные остатки платежей брокера на его открытые счета той же валюты.
+// Первыми зачитываются самые ранние платежи; счета без явного списка берутся по сроку оплаты.
+func (s *paymentService) ApplyBrokerCredit(ctx context.Context, brokerID primitive.ObjectID, req *models.ApplyCreditRequest) (*models.ApplyCreditResult, error) {
+	currency := strings.ToUpper(strings.TrimSpace(req.Currency))
+	if currency != "" {
+		if err := s.exchangeRates.ValidateCurrency(currency); err != nil {
+			return nil, err
+		}
 	}
 
-	if payment.Currency != models.CurrencyUSD &&
-		payment.Currency != models.CurrencyEUR &&
-		payment.Currency != models.CurrencyRUB {
-		return &ValidationError{Message: "Unsupported currency"}
-	}
+	var applications []models.CreditApplication
+	err := s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		// Транзакция может повторяться, результат собирается заново
+		applications = []models.CreditApplication{}
 
-	if payment.PaymentMethod == "" {
-		return &ValidationError{Message: "Payment method is required"}
-	}
+		credits, err := s.paymentRepo.GetUnapplied(ctx, brokerID, currency)
+		if err != nil {
+			return err
+		}
+		if len(credits) == 0 {
+			return &ValidationError{Message: "Broker has no unapplied credit"}
+		}
 
-	if payment.InvoiceID.IsZero() {
-		return &ValidationError{Message: "Invoice ID is required"}
-	}
+		invoices, err := s.creditTargets(ctx, brokerID, currency, credits, req.InvoiceIDs)
+		if err != nil {
+			return err
+		}
 
-	if payment.BrokerID.IsZero() {
-		return &ValidationError{Message: "Broker ID is required"}
-	}
+		for _, target := range invoices {
+			// Блокируем счет и берем актуальный остаток
+			invoice, err := s.invoiceRepo.Lock(ctx, target.ID)
+			if err != nil {
+				return err
+			}
 
-	// Проверяем существование счета
-	invoice, err := s.invoiceRepo.GetByID(ctx, payment.InvoiceID)
-	if err != nil || invoice == nil {
-		return &ValidationError{Message: "Invoice not found"}
-	}
+			remaining := invoice.Remaining()
+			applied := false
+			for _, credit := range credits {
+				if !remaining.IsPositive() {
+					break
+				}
+				if credit.Currency != invoice.Currency || credit.UnappliedAmount <= 0 {
+					continue
+				}
+
+				amount, err := credit.Unapplied().Min(remaining)
+				if err != nil {
+					return err
+				}
+
+				if err := s.allocateCredit(ctx, credit, invoice, amount); err != nil {
+					return err
+				}
+				if err := s.paymentRepo.Update(ctx, credit.ID, credit); err != nil {
+					return err
+				}
+				applications = append(applications, models.CreditApplication{
+					PaymentID:     credit.ID,
+					InvoiceID:     invoice.ID,
+					InvoiceNumber: invoice.InvoiceNumber,
+					Currency:      invoice.Currency,
+					Amount:        amount.Amount,
+				})
+				if remaining, err = remaining.Sub(amount); err != nil {
+					return err
+				}
+				applied = true
+			}
 
-	// Проверяем соответствие валют
-	if invoice.Currency != payment.Currency {
-		return &ValidationError{Message: "Payment currency must match invoice currency"}
-	}
+			if applied {
+				if err := s.invoiceBalancer.Recalculate(ctx, invoice.ID); err != nil {
+					return err
+				}
+			}
+		}
 
-	// Проверяем соответствие брокера
-	if invoice.BrokerID != payment.BrokerID {
-		return &ValidationError{Message: "Payment broker must match invoice broker"}
+		if len(applications) == 0 {
+			return &ValidationError{Message: "No open invoices to apply the credit to"}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Проверяем, что сумма платежа не превышает оставшуюся к доплате
-	totalPaid, err := s.paymentRepo.GetTotalPaidAmount(ctx, payment.InvoiceID)
+	remainingCredit, err := s.paymentRepo.GetBrokerCredit(ctx, brokerID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	remainingAmount := invoice.Amount - totalPaid
-	if payment.Amount > remainingAmount {
-		return &ValidationError{Message: "Payment amount exceeds remaining amount due"}
+	return &models.ApplyCreditResult{
+		Applications:    applications,
+		RemainingCredit: remainingCredit,
+	}, nil
+}
+
+// creditTargets определяет счета для зачета кредита: перечисленные в запросе в заданном порядке
+// или все открытые счета брокера в валютах кредита по сроку оплаты
+func (s *paymentService) creditTargets(ctx context.Context, brokerID primitive.ObjectID, currency string, credits []*models.Payment, invoiceIDs []primitive.ObjectID) ([]*models.Invoice, error) {
+	if len(invoiceIDs) == 0 {
+		var invoices []*models.Invoice
+		seen := make(map[string]bool)
+		for _, credit := range credits {
+			if seen[credit.Currency] {
+				continue
+			}
+			seen[credit.Currency] = true
+
+			open, err := s.invoiceRepo.GetOpenByBroker(ctx, brokerID, credit.Currency)
+			if err != nil {
+				return nil, err
+			}
+			invoices = append(invoices, open...)
+		}
+		return invoices, nil
 	}
 
+	invoices := make([]*models.Invoice, 0, len(invoiceIDs))
+	for _, id := range uniqueObjectIDs(invoiceIDs) {
+		invoice, err := s.invoiceRepo.GetByID(ctx, id)
+		if err == mongo.ErrNoDocuments {
+			return nil, &ValidationError{Message: fmt.Sprintf("Invoice %s not found", id.Hex())}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if invoice.BrokerID != brokerID {
+			return nil, &ValidationError{Message: fmt.Sprintf("Invoice %s belongs to another broker", invoice.InvoiceNumber)}
+		}
+		if !invoice.IsOpen() {
+			return nil, &ValidationError{Message: fmt.Sprintf("Invoice %s is not open", invoice.InvoiceNumber)}
+		}
+		if currency != "" && invoice.Currency != currency {
+			return nil, &ValidationError{Message: fmt.Sprintf("Invoice %s is not in %s", invoice.InvoiceNumber, currency)}
+		}
+		invoices = append(invoices, invoice)
+	}
+
+	return invoices, nil
+}
+
+// recalculateInvoices пересчитывает каждый затронутый счет один раз
+func (s *paymentService) recalculateInvoices(ctx context.Context, invoiceIDs []primitive.ObjectID) error {
+	for _, invoiceID := range uniqueObjectIDs(invoiceIDs) {
+		if err := s.invoiceBalancer.Recalculate(ctx, invoiceID); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// sendPaymentNotification отправляет уведомление о платеже
+// sendPaymentNotification отправляет уведомление о платеже со списком оплаченных счетов
 func (s *paymentService) sendPaymentNotification(payment *models.Payment) {
-	if s.emailService == nil {
+	// Письмо о платеже ссылается на счета, неразнесенный платеж уведомления не требует
+	if s.emailService == nil || len(payment.Allocations) == 0 {
 		return
 	}
 
 	ctx := context.Background()
 
-	// Получаем брокера и счет
+	// Получаем брокера и счета
 	broker, err := s.brokerRepo.GetByID(ctx, payment.BrokerID)
 	if err != nil {
 		return
 	}
 
-	invoice, err := s.invoiceRepo.GetByID(ctx, payment.InvoiceID)
-	if err != nil {
-		return
+	invoices := make([]*models.Invoice, 0, len(payment.Allocations))
+	for _, invoiceID := range payment.InvoiceIDs() {
+		invoice, err := s.invoiceRepo.GetByID(ctx, invoiceID)
+		if err != nil {
+			return
+		}
+		invoices = append(invoices, invoice)
 	}
 
 	// Отправляем уведомление
-	s.emailService.SendPaymentReceived(ctx, broker, payment, invoice)
+	s.emailService.SendPaymentReceived(ctx, broker, payment, invoices)
 }
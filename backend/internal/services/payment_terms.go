@@ -0,0 +1,66 @@
+package services
+
+import "billing-system/internal/models"
+
+// validatePaymentTerms проверяет условия оплаты
+func validatePaymentTerms(terms *models.PaymentTerms) error {
+	switch terms.Type {
+	case models.PaymentTermsNet:
+		if terms.Days <= 0 {
+			return &ValidationError{Message: "Net payment terms require a positive number of days"}
+		}
+	case models.PaymentTermsDueOnReceipt:
+		if terms.Days != 0 {
+			return &ValidationError{Message: "Due on receipt payment terms cannot have days"}
+		}
+	case models.PaymentTermsEndOfMonth:
+		if terms.Days < 0 {
+			return &ValidationError{Message: "Payment terms days cannot be negative"}
+		}
+	default:
+		return &ValidationError{Message: "Invalid payment terms type"}
+	}
+
+	if terms.DiscountPercent == 0 && terms.DiscountDays == 0 {
+		return nil
+	}
+	if terms.DiscountPercent <= 0 || terms.DiscountPercent >= 100 {
+		return &ValidationError{Message: "Early payment discount must be between 0 and 100 percent"}
+	}
+	if terms.DiscountDays <= 0 {
+		return &ValidationError{Message: "Early payment discount requires a positive number of days"}
+	}
+	// Скидка имеет смысл только если ее срок истекает раньше срока оплаты при любой дате выставления.
+	// Самый короткий срок у eom - при выставлении в последний день месяца, он равен Days, как и у net;
+	// при оплате в день выставления скидке места нет.
+	shortestTerm := terms.Days
+	if terms.Type == models.PaymentTermsDueOnReceipt {
+		shortestTerm = 0
+	}
+	if terms.DiscountDays >= shortestTerm {
+		return &ValidationError{Message: "Early payment discount period must end before the due date"}
+	}
+
+	return nil
+}
+
+// applyPaymentTerms подставляет в счет условия оплаты брокера, если свои не заданы,
+// и рассчитывает по ним срок оплаты от даты выставления, если он не указан
+func applyPaymentTerms(invoice *models.Invoice, broker *models.Broker) error {
+	if invoice.PaymentTerms == nil && broker != nil && broker.PaymentTerms != nil {
+		terms := *broker.PaymentTerms
+		invoice.PaymentTerms = &terms
+	}
+	if invoice.PaymentTerms == nil {
+		return nil
+	}
+
+	if err := validatePaymentTerms(invoice.PaymentTerms); err != nil {
+		return err
+	}
+	if invoice.DueDate.IsZero() {
+		invoice.DueDate = invoice.PaymentTerms.DueDate(invoice.IssuedOn())
+	}
+
+	return nil
+}
@@ -0,0 +1,497 @@
+package services
+
+import (
+	_ "embed"
+
+	"billing-system/config"
+	"billing-system/internal/models"
+	"billing-system/internal/repository"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// pdfFont семейство шрифта документов: DejaVu Sans с кириллицей и другими символами вне cp1252
+const pdfFont = "DejaVuSans"
+
+var (
+	//go:embed fonts/DejaVuSans.ttf
+	pdfFontRegular []byte
+	//go:embed fonts/DejaVuSans-Bold.ttf
+	pdfFontBold []byte
+)
+
+// pdfService реализация PDFService
+type pdfService struct {
+	loadRepo   repository.LoadRepository
+	brokerRepo repository.BrokerRepository
+	company    config.CompanyConfig
+}
+
+// NewPDFService создает новый PDFService
+func NewPDFService(
+	loadRepo repository.LoadRepository,
+	brokerRepo repository.BrokerRepository,
+	company config.CompanyConfig,
+) PDFService {
+	return &pdfService{
+		loadRepo:   loadRepo,
+		brokerRepo: brokerRepo,
+		company:    company,
+	}
+}
+
+// RenderInvoice формирует PDF счета со списком грузов и реквизитами для оплаты
+func (s *pdfService) RenderInvoice(ctx context.Context, invoice *models.Invoice) ([]byte, error) {
+	broker, err := s.brokerRepo.GetByID(ctx, invoice.BrokerID)
+	if err != nil {
+		return nil, err
+	}
+
+	loads, err := s.invoiceLoads(ctx, invoice)
+	if err != nil {
+		return nil, err
+	}
+
+	pdf := newPDF()
+	pdf.AddPage()
+
+	// Условия оплаты счета печатаются вместо общих условий компании
+	terms := s.company.PaymentTerms
+	if invoice.PaymentTerms != nil {
+		terms = invoice.PaymentTerms.String()
+	}
+
+	s.writeHeader(pdf, "INVOICE", "Invoice # "+invoice.InvoiceNumber, [][2]string{
+		{"Invoice date", formatPDFDate(invoice.IssuedOn())},
+		{"Due date", formatPDFDate(invoice.DueDate)},
+		{"Terms", terms},
+		{"Currency", invoice.Currency},
+	})
+	writeBillTo(pdf, broker)
+
+	// Грузы счета печатаются всегда, позиции - отдельной таблицей под ними.
+	// Счет без грузов и позиций печатается одной строкой с описанием и суммой.
+	if len(loads) > 0 || len(invoice.LineItems) == 0 {
+		writeLoadsTable(pdf, invoice, loads)
+	}
+	if len(invoice.LineItems) > 0 {
+		if len(loads) > 0 {
+			pdf.Ln(3)
+		}
+		writeLineItems(pdf, invoice.LineItems)
+	}
+
+	// Итоги
+	pdf.Ln(3)
+	money := func(amount models.Amount) string {
+		return fmt.Sprintf("%s %s", invoice.Currency, formatMoney(amount))
+	}
+	if len(invoice.LineItems) > 0 {
+		writeTotalLine(pdf, "Subtotal", money(invoice.Subtotal), false)
+		for _, discount := range invoice.Discounts {
+			label := "Discount"
+			if discount.Type == models.DiscountTypePercent {
+				label = fmt.Sprintf("Discount (%s%%)", formatRate(discount.Value))
+			}
+			if discount.Description != "" {
+				label += " - " + truncateText(discount.Description, 30)
+			}
+			writeTotalLine(pdf, label, money(-discount.Amount), false)
+		}
+		if invoice.TaxTotal > 0 {
+			writeTotalLine(pdf, "Tax", money(invoice.TaxTotal), false)
+		}
+	}
+	writeTotalLine(pdf, "Total", money(invoice.Amount), true)
+	if invoice.CreditedAmount > 0 {
+		writeTotalLine(pdf, "Credits", money(-invoice.CreditedAmount), false)
+	}
+	if invoice.EarlyDiscount > 0 {
+		writeTotalLine(pdf, "Early payment discount", money(-invoice.EarlyDiscount), false)
+	}
+	if invoice.PaidAmount > 0 {
+		writeTotalLine(pdf, "Paid", money(invoice.PaidAmount), false)
+	}
+	balance := invoice.Amount - invoice.PaidAmount - invoice.CreditedAmount - invoice.EarlyDiscount
+	if invoice.PaidAmount > 0 || invoice.CreditedAmount > 0 || invoice.EarlyDiscount > 0 {
+		writeTotalLine(pdf, "Balance due", money(balance), true)
+	}
+	if terms := invoice.PaymentTerms; terms != nil && terms.HasDiscount() && invoice.EarlyDiscount == 0 && balance > 0 {
+		label := fmt.Sprintf("Pay by %s (%s%% discount)", formatPDFDate(terms.DiscountDeadline(invoice.IssuedOn())), formatRate(terms.DiscountPercent))
+		writeTotalLine(pdf, label, money(balance-terms.Discount(invoice.Amount)), false)
+	}
+
+	s.writeRemitTo(pdf, invoice.InvoiceNumber)
+
+	if invoice.Notes != "" {
+		pdf.Ln(4)
+		pdf.SetFont(pdfFont, "B", 9)
+		pdf.CellFormat(0, 5, "Notes", "", 1, "L", false, 0, "")
+		pdf.SetFont(pdfFont, "", 9)
+		pdf.MultiCell(0, 5, invoice.Notes, "", "L", false)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// RenderCreditNote формирует PDF кредит-ноты со ссылкой на исходный счет
+func (s *pdfService) RenderCreditNote(ctx context.Context, note *models.CreditNote, invoice *models.Invoice) ([]byte, error) {
+	broker, err := s.brokerRepo.GetByID(ctx, note.BrokerID)
+	if err != nil {
+		return nil, err
+	}
+
+	pdf := newPDF()
+	pdf.AddPage()
+
+	s.writeHeader(pdf, "CREDIT NOTE", "Credit note # "+note.CreditNoteNumber, [][2]string{
+		{"Date", formatPDFDate(note.CreatedAt)},
+		{"Invoice", invoice.InvoiceNumber},
+		{"Invoice date", formatPDFDate(invoice.CreatedAt)},
+		{"Currency", note.Currency},
+	})
+	writeBillTo(pdf, broker)
+
+	pdf.SetFont(pdfFont, "B", 9)
+	pdf.CellFormat(0, 5, "Reason", "", 1, "L", false, 0, "")
+	pdf.SetFont(pdfFont, "", 9)
+	pdf.MultiCell(0, 5, note.Reason, "", "L", false)
+	pdf.Ln(3)
+
+	if len(note.LineItems) > 0 {
+		writeLineItems(pdf, note.LineItems)
+	} else {
+		widths := []float64{156, 30}
+		writeTableHeader(pdf, widths, []string{"Description", "Amount"})
+		pdf.SetFont(pdfFont, "", 9)
+		pdf.CellFormat(widths[0], 7, truncateText("Credit to invoice "+invoice.InvoiceNumber, 100), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 7, formatMoney(note.Amount), "1", 1, "R", false, 0, "")
+	}
+
+	// Итоги
+	pdf.Ln(3)
+	money := func(amount models.Amount) string {
+		return fmt.Sprintf("%s %s", note.Currency, formatMoney(amount))
+	}
+	if len(note.LineItems) > 0 {
+		writeTotalLine(pdf, "Subtotal", money(note.Subtotal), false)
+		if note.TaxTotal > 0 {
+			writeTotalLine(pdf, "Tax", money(note.TaxTotal), false)
+		}
+	}
+	writeTotalLine(pdf, "Total credit", money(note.Amount), true)
+	writeTotalLine(pdf, "Invoice balance after credits", money(invoice.RemainingAmount), false)
+
+	if note.Notes != "" {
+		pdf.Ln(4)
+		pdf.SetFont(pdfFont, "B", 9)
+		pdf.CellFormat(0, 5, "Notes", "", 1, "L", false, 0, "")
+		pdf.SetFont(pdfFont, "", 9)
+		pdf.MultiCell(0, 5, note.Notes, "", "L", false)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeLineItems выводит таблицу позиций счета или кредит-ноты
+func writeLineItems(pdf *fpdf.Fpdf, items []models.LineItem) {
+	widths := []float64{90, 18, 28, 20, 30}
+	writeTableHeader(pdf, widths, []string{"Description", "Qty", "Unit price", "Tax", "Amount"})
+
+	pdf.SetFont(pdfFont, "", 9)
+	for _, item := range items {
+		tax := ""
+		if item.TaxRate > 0 {
+			tax = formatRate(item.TaxRate) + "%"
+		}
+
+		pdf.CellFormat(widths[0], 7, truncateText(item.Description, 58), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 7, formatRate(item.Quantity), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[2], 7, formatMoney(item.UnitPrice), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 7, tax, "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[4], 7, formatMoney(item.Amount), "1", 1, "R", false, 0, "")
+	}
+}
+
+// writeLoadsTable выводит таблицу грузов счета; без грузов - строку с описанием и суммой счета
+func writeLoadsTable(pdf *fpdf.Fpdf, invoice *models.Invoice, loads []*models.Load) {
+	widths := []float64{28, 82, 24, 24, 28}
+	writeTableHeader(pdf, widths, []string{"Load #", "Route", "Pickup", "Delivery", "Amount"})
+
+	pdf.SetFont(pdfFont, "", 9)
+	if len(loads) == 0 {
+		description := invoice.Description
+		if description == "" {
+			description = "Freight services"
+		}
+		pdf.CellFormat(widths[0]+widths[1]+widths[2]+widths[3], 7, description, "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[4], 7, formatMoney(invoice.Amount), "1", 1, "R", false, 0, "")
+	}
+	for _, load := range loads {
+		route := fmt.Sprintf("%s, %s -> %s, %s",
+			load.Route.Origin.City, load.Route.Origin.State,
+			load.Route.Destination.City, load.Route.Destination.State)
+
+		pdf.CellFormat(widths[0], 7, load.LoadNumber, "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 7, truncateText(route, 52), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[2], 7, formatPDFDate(load.PickupDate), "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[3], 7, formatPDFDate(load.DeliveryDate), "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[4], 7, formatMoney(load.Cost), "1", 1, "R", false, 0, "")
+	}
+}
+
+// invoiceLoads получает грузы счета: привязанные через invoice_id и перечисленные в load_ids
+func (s *pdfService) invoiceLoads(ctx context.Context, invoice *models.Invoice) ([]*models.Load, error) {
+	loads, err := s.loadRepo.GetByInvoice(ctx, invoice.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(loads))
+	for _, load := range loads {
+		seen[load.ID.Hex()] = true
+	}
+
+	for _, loadID := range invoice.LoadIDs {
+		if seen[loadID.Hex()] {
+			continue
+		}
+		load, err := s.loadRepo.GetByID(ctx, loadID)
+		if err != nil {
+			continue // Груз мог быть удален - пропускаем
+		}
+		seen[loadID.Hex()] = true
+		loads = append(loads, load)
+	}
+
+	return loads, nil
+}
+
+// writeHeader выводит реквизиты компании и заголовок документа
+func (s *pdfService) writeHeader(pdf *fpdf.Fpdf, title, numberLabel string, details [][2]string) {
+	pdf.SetFont(pdfFont, "B", 16)
+	pdf.CellFormat(110, 8, s.company.Name, "", 0, "L", false, 0, "")
+	pdf.SetFont(pdfFont, "B", 20)
+	pdf.CellFormat(0, 8, title, "", 1, "R", false, 0, "")
+
+	pdf.SetFont(pdfFont, "", 9)
+	for _, line := range companyAddressLines(s.company) {
+		pdf.CellFormat(0, 5, line, "", 1, "L", false, 0, "")
+	}
+
+	pdf.Ln(2)
+	pdf.SetFont(pdfFont, "B", 10)
+	pdf.CellFormat(0, 6, numberLabel, "", 1, "R", false, 0, "")
+	pdf.SetFont(pdfFont, "", 9)
+	for _, detail := range details {
+		if detail[1] == "" {
+			continue
+		}
+		pdf.CellFormat(0, 5, fmt.Sprintf("%s: %s", detail[0], detail[1]), "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(4)
+}
+
+// writeRemitTo выводит реквизиты для оплаты
+func (s *pdfService) writeRemitTo(pdf *fpdf.Fpdf, reference string) {
+	lines := []string{}
+	if s.company.BankName != "" {
+		lines = append(lines, "Bank: "+s.company.BankName)
+	}
+	if s.company.AccountName != "" {
+		lines = append(lines, "Account name: "+s.company.AccountName)
+	}
+	if s.company.AccountNumber != "" {
+		lines = append(lines, "Account #: "+s.company.AccountNumber)
+	}
+	if s.company.RoutingNumber != "" {
+		lines = append(lines, "Routing #: "+s.company.RoutingNumber)
+	}
+	if s.company.SWIFT != "" {
+		lines = append(lines, "SWIFT: "+s.company.SWIFT)
+	}
+	if len(lines) == 0 {
+		lines = companyAddressLines(s.company)
+	}
+	lines = append(lines, "Please include reference "+reference+" with your payment.")
+
+	pdf.Ln(6)
+	pdf.SetFont(pdfFont, "B", 10)
+	pdf.CellFormat(0, 6, "Remit to: "+s.company.Name, "", 1, "L", false, 0, "")
+	pdf.SetFont(pdfFont, "", 9)
+	for _, line := range lines {
+		pdf.CellFormat(0, 5, line, "", 1, "L", false, 0, "")
+	}
+}
+
+// writeBillTo выводит данные плательщика
+func writeBillTo(pdf *fpdf.Fpdf, broker *models.Broker) {
+	pdf.SetFont(pdfFont, "B", 10)
+	pdf.CellFormat(0, 6, "Bill to", "", 1, "L", false, 0, "")
+	pdf.SetFont(pdfFont, "", 9)
+
+	lines := []string{broker.CompanyName}
+	if broker.ContactPerson != "" {
+		lines = append(lines, "Attn: "+broker.ContactPerson)
+	}
+	lines = append(lines, addressLines(broker.Address)...)
+	if broker.Email != "" {
+		lines = append(lines, broker.Email)
+	}
+
+	for _, line := range lines {
+		pdf.CellFormat(0, 5, line, "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(4)
+}
+
+// writeTableHeader выводит заголовок таблицы
+func writeTableHeader(pdf *fpdf.Fpdf, widths []float64, titles []string) {
+	pdf.SetFont(pdfFont, "B", 9)
+	pdf.SetFillColor(240, 240, 240)
+	for i, title := range titles {
+		align := "L"
+		if i == len(titles)-1 {
+			align = "R"
+		}
+		pdf.CellFormat(widths[i], 7, title, "1", 0, align, true, 0, "")
+	}
+	pdf.Ln(-1)
+}
+
+// writeTotalLine выводит строку итогов справа
+func writeTotalLine(pdf *fpdf.Fpdf, label, value string, bold bool) {
+	style := ""
+	if bold {
+		style = "B"
+	}
+	pdf.SetFont(pdfFont, style, 10)
+	pdf.CellFormat(146, 6, label, "", 0, "R", false, 0, "")
+	pdf.CellFormat(0, 6, value, "", 1, "R", false, 0, "")
+}
+
+// companyAddressLines формирует строки адреса и контактов компании
+func companyAddressLines(company config.CompanyConfig) []string {
+	lines := addressLines(models.Address{
+		Street:  company.Street,
+		City:    company.City,
+		State:   company.State,
+		Country: company.Country,
+		ZipCode: company.ZipCode,
+	})
+
+	contacts := []string{}
+	if company.Phone != "" {
+		contacts = append(contacts, company.Phone)
+	}
+	if company.Email != "" {
+		contacts = append(contacts, company.Email)
+	}
+	if len(contacts) > 0 {
+		lines = append(lines, strings.Join(contacts, " | "))
+	}
+
+	return lines
+}
+
+// addressLines форматирует адрес в строки
+func addressLines(address models.Address) []string {
+	lines := []string{}
+	if address.Street != "" {
+		lines = append(lines, address.Street)
+	}
+
+	cityLine := address.City
+	if address.State != "" {
+		if cityLine != "" {
+			cityLine += ", "
+		}
+		cityLine += address.State
+	}
+	if address.ZipCode != "" {
+		cityLine = strings.TrimSpace(cityLine + " " + address.ZipCode)
+	}
+	if cityLine != "" {
+		lines = append(lines, cityLine)
+	}
+
+	if address.Country != "" {
+		lines = append(lines, address.Country)
+	}
+
+	return lines
+}
+
+// formatMoney форматирует сумму с разделителями тысяч: 1,234.56
+func formatMoney(amount models.Amount) string {
+	formatted := formatAmount(amount)
+
+	sign := ""
+	if strings.HasPrefix(formatted, "-") {
+		sign = "-"
+		formatted = formatted[1:]
+	}
+
+	intPart, fracPart := formatted, ""
+	if dot := strings.IndexByte(formatted, '.'); dot >= 0 {
+		intPart, fracPart = formatted[:dot], formatted[dot:]
+	}
+
+	var grouped strings.Builder
+	for i, digit := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+
+	return sign + grouped.String() + fracPart
+}
+
+// formatRate форматирует количество или ставку без лишних нулей: 1, 2.5, 8.25
+func formatRate(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// formatPDFDate форматирует дату для документа
+func formatPDFDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format("01/02/2006")
+}
+
+// truncateText обрезает строку до указанной длины
+func truncateText(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-3]) + "..."
+}
+
+// newPDF создает документ Letter с полями и встроенным UTF-8 шрифтом
+func newPDF() *fpdf.Fpdf {
+	pdf := fpdf.New("P", "mm", "Letter", "")
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 20)
+	pdf.AddUTF8FontFromBytes(pdfFont, "", pdfFontRegular)
+	pdf.AddUTF8FontFromBytes(pdfFont, "B", pdfFontBold)
+	return pdf
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"billing-system/config"
+	"billing-system/internal/models"
+	"billing-system/internal/repository"
+	"context"
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// reportService реализация ReportService
+type reportService struct {
+	invoiceRepo repository.InvoiceRepository
+	currencies  config.CurrencyConfig
+}
+
+// NewReportService создает новый ReportService
+func NewReportService(invoiceRepo repository.InvoiceRepository, currencies config.CurrencyConfig) ReportService {
+	return &reportService{
+		invoiceRepo: invoiceRepo,
+		currencies:  currencies,
+	}
+}
+
+// GetAgingReport строит отчет о возрасте дебиторской задолженности на дату asOf.
+// Оплаты и кредит-ноты учитываются на дату отчета, а срок оплаты и скидка за раннюю оплату - текущие:
+// история их изменений не хранится, поэтому отчет на прошлую дату может отличаться от построенного в тот день
+// для счетов, у которых позже изменили срок или которым предоставили скидку.
+func (s *reportService) GetAgingReport(ctx context.Context, asOf time.Time, brokerID primitive.ObjectID) (*models.AgingReport, error) {
+	invoices, err := s.invoiceRepo.GetOpenAsOf(ctx, asOf, brokerID, s.currencies.Base)
+	if err != nil {
+		return nil, err
+	}
+
+	type rowKey struct {
+		brokerID primitive.ObjectID
+		currency string
+	}
+
+	report := &models.AgingReport{
+		AsOf:         asOf,
+		BaseCurrency: s.currencies.Base,
+		MissingRates: []string{},
+	}
+	rows := make(map[rowKey]*models.AgingReportRow)
+	totals := make(map[string]*models.AgingReportTotal)
+	missingRates := make(map[string]bool)
+	asOfDay := truncateToDay(asOf)
+
+	for _, invoice := range invoices {
+		if invoice.RemainingAmount <= 0 {
+			continue
+		}
+
+		daysPastDue := int(asOfDay.Sub(truncateToDay(invoice.DueDate)).Hours() / 24)
+
+		key := rowKey{brokerID: invoice.BrokerID, currency: invoice.Currency}
+		row, ok := rows[key]
+		if !ok {
+			row = &models.AgingReportRow{
+				BrokerID:    invoice.BrokerID,
+				CompanyName: invoice.BrokerName,
+				Currency:    invoice.Currency,
+			}
+			rows[key] = row
+		}
+		row.InvoicesCount++
+		row.Add(daysPastDue, invoice.RemainingAmount)
+
+		total, ok := totals[invoice.Currency]
+		if !ok {
+			total = &models.AgingReportTotal{Currency: invoice.Currency}
+			totals[invoice.Currency] = total
+		}
+		total.InvoicesCount++
+		total.Add(daysPastDue, invoice.RemainingAmount)
+
+		// Итог в базовой валюте по курсу на дату счета
+		if invoice.ExchangeRate > 0 {
+			report.BaseTotal.Add(daysPastDue, invoice.RemainingAmount.Mul(invoice.ExchangeRate))
+		} else {
+			missingRates[invoice.Currency] = true
+		}
+	}
+
+	report.Rows = make([]models.AgingReportRow, 0, len(rows))
+	report.Totals = make([]models.AgingReportTotal, 0, len(totals))
+	for currency := range missingRates {
+		report.MissingRates = append(report.MissingRates, currency)
+	}
+	sort.Strings(report.MissingRates)
+	for _, row := range rows {
+		report.Rows = append(report.Rows, *row)
+	}
+	for _, total := range totals {
+		report.Totals = append(report.Totals, *total)
+	}
+
+	sort.Slice(report.Rows, func(i, j int) bool {
+		if report.Rows[i].CompanyName != report.Rows[j].CompanyName {
+			return report.Rows[i].CompanyName < report.Rows[j].CompanyName
+		}
+		return report.Rows[i].Currency < report.Rows[j].Currency
+	})
+	sort.Slice(report.Totals, func(i, j int) bool {
+		return report.Totals[i].Currency < report.Totals[j].Currency
+	})
+
+	return report, nil
+}
+
+// WriteAgingReportCSV записывает отчет о возрасте задолженности в CSV
+func (s *reportService) WriteAgingReportCSV(w io.Writer, report *models.AgingReport) error {
+	writer := csv.NewWriter(w)
+
+	header := []string{
+		"Broker", "Currency", "Invoices",
+		"Current", "1-30", "31-60", "61-90", "90+", "Total",
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, row := range report.Rows {
+		record := append([]string{row.CompanyName, row.Currency, strconv.Itoa(row.InvoicesCount)}, agingBucketsRecord(row.AgingBuckets)...)
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	for _, total := range report.Totals {
+		record := append([]string{"TOTAL", total.Currency, strconv.Itoa(total.InvoicesCount)}, agingBucketsRecord(total.AgingBuckets)...)
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	baseTotal := append([]string{"TOTAL (base)", report.BaseCurrency, ""}, agingBucketsRecord(report.BaseTotal)...)
+	if err := writer.Write(baseTotal); err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// agingBucketsRecord форматирует корзины задолженности для CSV
+func agingBucketsRecord(buckets models.AgingBuckets) []string {
+	return []string{
+		formatAmount(buckets.Current),
+		formatAmount(buckets.Days1To30),
+		formatAmount(buckets.Days31To60),
+		formatAmount(buckets.Days61To90),
+		formatAmount(buckets.Days90Plus),
+		formatAmount(buckets.Total),
+	}
+}
+
+// formatAmount форматирует сумму с двумя знаками после запятой
+func formatAmount(amount models.Amount) string {
+	return amount.String()
+}
+
+// truncateToDay отбрасывает время, оставляя календарную дату в UTC
+func truncateToDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
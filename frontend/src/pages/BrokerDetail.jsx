@@ -24,6 +24,7 @@ const BrokerDetail = () => {
       active: { color: 'green', text: 'Active' },
       inactive: { color: 'orange', text: 'Inactive' },
       suspended: { color: 'red', text: 'Suspended' },
+      credit_hold: { color: 'volcano', text: 'Credit Hold' },
     }
     
     const config = statusConfig[status] || { color: 'default', text: status }
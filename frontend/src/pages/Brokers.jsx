@@ -72,8 +72,22 @@ const Brokers = () => {
     },
   })
 
+  // Статус меняется отдельно от данных брокера; кредитный стоп снимается с причиной
   const updateMutation = useMutation(
-    ({ id, data }) => brokersApi.update(id, data),
+    async ({ id, data, previousStatus, releaseReason }) => {
+      const { status, ...fields } = data
+      await brokersApi.update(id, fields)
+      if (!status || status === previousStatus) {
+        return
+      }
+      if (previousStatus === 'credit_hold') {
+        await brokersApi.releaseHold(id, { reason: releaseReason })
+        if (status === 'active') {
+          return
+        }
+      }
+      await brokersApi.updateStatus(id, status)
+    },
     {
       onSuccess: () => {
         message.success('Broker updated successfully')
@@ -155,11 +169,17 @@ const Brokers = () => {
       delete brokerData.state
       delete brokerData.country
       delete brokerData.zip_code
+      delete brokerData.release_reason
 
       if (modalMode === 'create') {
         createMutation.mutate(brokerData)
       } else if (modalMode === 'edit') {
-        updateMutation.mutate({ id: selectedBroker.id, data: brokerData })
+        updateMutation.mutate({
+          id: selectedBroker.id,
+          data: brokerData,
+          previousStatus: selectedBroker.status,
+          releaseReason: values.release_reason,
+        })
       }
     })
   }
@@ -175,6 +195,7 @@ const Brokers = () => {
       active: { color: 'green', text: 'Active' },
       inactive: { color: 'orange', text: 'Inactive' },
       suspended: { color: 'red', text: 'Suspended' },
+      credit_hold: { color: 'volcano', text: 'Credit Hold' },
     }
     
     const config = statusConfig[status] || { color: 'default', text: status }
@@ -432,11 +453,28 @@ const Brokers = () => {
                   <Option value="active">Active</Option>
                   <Option value="inactive">Inactive</Option>
                   <Option value="suspended">Suspended</Option>
+                  <Option value="credit_hold">Credit Hold</Option>
                 </Select>
               </Form.Item>
             </Col>
           </Row>
 
+          {modalMode === 'edit' && selectedBroker?.status === 'credit_hold' && (
+            <Form.Item
+              label="Credit hold release reason"
+              name="release_reason"
+              dependencies={['status']}
+              rules={[
+                ({ getFieldValue }) => ({
+                  required: getFieldValue('status') !== 'credit_hold',
+                  message: 'Please enter the reason for releasing the credit hold',
+                }),
+              ]}
+            >
+              <Input placeholder="Overdue balance paid" />
+            </Form.Item>
+          )}
+
           <Divider>Address</Divider>
 
           <Row gutter={16}>
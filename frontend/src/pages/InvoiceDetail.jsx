@@ -22,11 +22,12 @@ const InvoiceDetail = () => {
 
   const renderStatus = (status) => {
     const statusConfig = {
-      pending: { color: 'orange', text: 'Pending' },
+      draft: { color: 'default', text: 'Draft' },
+      issued: { color: 'orange', text: 'Issued' },
       paid: { color: 'green', text: 'Paid' },
       partial: { color: 'blue', text: 'Partial' },
       overdue: { color: 'red', text: 'Overdue' },
-      cancelled: { color: 'default', text: 'Cancelled' },
+      void: { color: 'default', text: 'Void' },
     }
     
     const config = statusConfig[status] || { color: 'default', text: status }
@@ -95,11 +95,17 @@ const Invoices = () => {
   )
 
   // Mutations
-  const createMutation = useMutation(invoicesApi.create, {
+  // Счет по выбранным грузам считается на сервере, чтобы сумма совпадала со стоимостью грузов
+  const createInvoice = (data) => (
+    data.load_ids?.length > 0 ? invoicesApi.createFromLoads(data) : invoicesApi.create(data)
+  )
+
+  const createMutation = useMutation(createInvoice, {
     onSuccess: (data) => {
       console.log('Invoice created successfully:', data)
       message.success('Invoice created successfully')
       queryClient.invalidateQueries('invoices')
+      queryClient.invalidateQueries('unbilled-loads')
       setIsModalVisible(false)
       form.resetFields()
     },
@@ -147,8 +153,7 @@ const Invoices = () => {
     // Set default values
     form.setFieldsValue({
       currency: 'USD',
-      status: 'pending',
-      due_date: dayjs().add(30, 'day'),
+      status: 'issued',
     })
   }
 
@@ -246,11 +251,12 @@ const Invoices = () => {
   // Render status
   const renderStatus = (status) => {
     const statusConfig = {
-      pending: { color: 'orange', text: 'Pending' },
+      draft: { color: 'default', text: 'Draft' },
+      issued: { color: 'orange', text: 'Issued' },
       paid: { color: 'green', text: 'Paid' },
       partial: { color: 'blue', text: 'Partial' },
       overdue: { color: 'red', text: 'Overdue' },
-      cancelled: { color: 'default', text: 'Cancelled' },
+      void: { color: 'default', text: 'Void' },
     }
     
     const config = statusConfig[status] || { color: 'default', text: status }
@@ -415,11 +421,12 @@ const Invoices = () => {
                 style={{ width: '100%' }}
                 onChange={handleStatusFilter}
               >
-                <Option value="pending">Pending</Option>
+                <Option value="draft">Draft</Option>
+                <Option value="issued">Issued</Option>
                 <Option value="paid">Paid</Option>
                 <Option value="partial">Partial</Option>
                 <Option value="overdue">Overdue</Option>
-                <Option value="cancelled">Cancelled</Option>
+                <Option value="void">Void</Option>
               </Select>
             </Col>
           </Row>
@@ -546,12 +553,9 @@ const Invoices = () => {
                 name="status"
                 rules={[{ required: true, message: 'Please select status' }]}
               >
-                <Select>
-                  <Option value="pending">Pending</Option>
-                  <Option value="paid">Paid</Option>
-                  <Option value="partial">Partial</Option>
-                  <Option value="overdue">Overdue</Option>
-                  <Option value="cancelled">Cancelled</Option>
+                <Select disabled={modalMode !== 'create'}>
+                  <Option value="draft">Draft</Option>
+                  <Option value="issued">Issued</Option>
                 </Select>
               </Form.Item>
             </Col>
@@ -562,7 +566,7 @@ const Invoices = () => {
               <Form.Item
                 label="Due Date"
                 name="due_date"
-                rules={[{ required: true, message: 'Please select due date' }]}
+                extra="Leave empty to calculate from payment terms"
               >
                 <DatePicker 
                   style={{ width: '100%' }} 
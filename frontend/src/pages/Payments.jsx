@@ -66,7 +66,7 @@ const Payments = () => {
   const { data: invoicesData, isLoading: invoicesLoading } = useQuery(
     ['invoices-select', selectedBrokerId], 
     () => {
-      const params = { limit: 1000, status: 'pending,partial' }
+      const params = { limit: 1000, status: 'issued,partial,overdue' }
       if (selectedBrokerId) {
         params.broker_id = selectedBrokerId
       }
@@ -59,10 +59,27 @@ export const brokersApi = {
   create: (data) => api.post('/brokers', data),
   update: (id, data) => api.put(`/brokers/${id}`, data),
   delete: (id) => api.delete(`/brokers/${id}`),
+  updateStatus: (id, status) => api.put(`/brokers/${id}/status`, { status }),
+  releaseHold: (id, data) => api.post(`/brokers/${id}/release-hold`, data),
   search: (query, params) => api.get(`/brokers/search?query=${query}`, { params }),
   getStats: (id) => api.get(`/brokers/${id}/stats`),
   getInvoices: (id, params) => api.get(`/brokers/${id}/invoices`, { params }),
   getPayments: (id, params) => api.get(`/brokers/${id}/payments`, { params }),
+  getCredit: (id) => api.get(`/brokers/${id}/credit`),
+  applyCredit: (id, data) => api.post(`/brokers/${id}/apply-credit`, data),
+  getExposure: (id) => api.get(`/brokers/${id}/exposure`),
+}
+
+// API для расписаний регулярных счетов
+export const billingSchedulesApi = {
+  getAll: (params) => api.get('/billing-schedules', { params }),
+  getById: (id) => api.get(`/billing-schedules/${id}`),
+  create: (data) => api.post('/billing-schedules', data),
+  update: (id, data) => api.put(`/billing-schedules/${id}`, data),
+  delete: (id) => api.delete(`/billing-schedules/${id}`),
+  pause: (id) => api.post(`/billing-schedules/${id}/pause`),
+  resume: (id) => api.post(`/billing-schedules/${id}/resume`),
+  preview: (id, count) => api.get(`/billing-schedules/${id}/preview`, { params: { count } }),
 }
 
 // API для счетов
@@ -70,11 +87,18 @@ export const invoicesApi = {
   getAll: (params) => api.get('/invoices', { params }),
   getById: (id) => api.get(`/invoices/${id}`),
   create: (data) => api.post('/invoices', data),
+  createFromLoads: (data) => api.post('/invoices/from-loads', data),
   update: (id, data) => api.put(`/invoices/${id}`, data),
   delete: (id) => api.delete(`/invoices/${id}`),
+  issue: (id, data) => api.post(`/invoices/${id}/issue`, data),
+  void: (id, reason) => api.post(`/invoices/${id}/void`, { reason }),
   getByStatus: (status, params) => api.get(`/invoices/status/${status}`, { params }),
   getOverdue: (params) => api.get('/invoices/overdue', { params }),
   getPayments: (id) => api.get(`/invoices/${id}/payments`),
+  getCreditNotes: (id) => api.get(`/invoices/${id}/credit-notes`),
+  createCreditNote: (id, data) => api.post(`/invoices/${id}/credit-notes`, data),
+  getLateFees: (id) => api.get(`/invoices/${id}/late-fees`),
+  waiveLateFee: (id, reason) => api.post(`/invoices/${id}/waive-late-fee`, { reason }),
 }
 
 // API для платежей
@@ -84,6 +108,25 @@ export const paymentsApi = {
   create: (data) => api.post('/payments', data),
   update: (id, data) => api.put(`/payments/${id}`, data),
   delete: (id) => api.delete(`/payments/${id}`),
+  getAdjustments: (id) => api.get(`/payments/${id}/adjustments`),
+  reverse: (id, data) => api.post(`/payments/${id}/reverse`, data),
+  refund: (id, data) => api.post(`/payments/${id}/refund`, data),
+}
+
+// API для сверки банковских выписок
+export const bankTransactionsApi = {
+  getAll: (params) => api.get('/bank-transactions', { params }),
+  getById: (id) => api.get(`/bank-transactions/${id}`),
+  importStatement: (file, options = {}) => {
+    const formData = new FormData()
+    formData.append('file', file)
+    if (options.format) formData.append('format', options.format)
+    if (options.currency) formData.append('currency', options.currency)
+    return api.post('/bank-transactions/import', formData)
+  },
+  confirm: (id, data = {}) => api.post(`/bank-transactions/${id}/confirm`, data),
+  split: (id, parts) => api.post(`/bank-transactions/${id}/split`, { parts }),
+  reject: (id, reason) => api.post(`/bank-transactions/${id}/reject`, { reason }),
 }
 
 // API для грузов
@@ -105,6 +148,17 @@ export const exportApi = {
 }
 
 // Административные API
+export const currenciesApi = {
+  getSettings: () => api.get('/currencies'),
+  getRates: (params) => api.get('/exchange-rates', { params }),
+  saveRates: (rates) => api.post('/exchange-rates', { rates }),
+  importRates: (file) => {
+    const formData = new FormData()
+    formData.append('file', file)
+    return api.post('/exchange-rates/import', formData)
+  },
+}
+
 export const adminApi = {
   sendOverdueNotifications: () => api.post('/admin/send-overdue-notifications'),
 }
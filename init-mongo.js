@@ -10,6 +10,7 @@ db.createCollection('brokers');
 db.createCollection('invoices');
 db.createCollection('payments');
 db.createCollection('loads');
+db.createCollection('counters');
 
 // Создаем индексы для пользователей
 db.users.createIndex({ "username": 1 }, { unique: true });
@@ -18,13 +19,28 @@ db.users.createIndex({ "email": 1 }, { unique: true });
 // Создаем индексы для остальных коллекций
 db.brokers.createIndex({ "company_name": 1 });
 db.brokers.createIndex({ "email": 1 });
+db.invoices.createIndex({ "invoice_number": 1 }, { unique: true });
 db.invoices.createIndex({ "broker_id": 1 });
 db.invoices.createIndex({ "status": 1 });
 db.invoices.createIndex({ "due_date": 1 });
-db.payments.createIndex({ "invoice_id": 1 });
+db.invoices.createIndex({ "late_fee_for": 1, "late_fee_period": 1 }, { unique: true, partialFilterExpression: { "late_fee_for": { $exists: true } } });
+db.invoices.createIndex({ "billing_schedule_id": 1, "billing_period": 1 }, { unique: true, partialFilterExpression: { "billing_schedule_id": { $exists: true } } });
+db.billing_schedules.createIndex({ "status": 1, "next_run_date": 1 });
+db.billing_schedules.createIndex({ "broker_id": 1 });
+db.payments.createIndex({ "allocations.invoice_id": 1 });
 db.payments.createIndex({ "broker_id": 1 });
+db.payments.createIndex({ "original_payment_id": 1 }, { sparse: true });
+db.payments.createIndex({ "bank_transaction_id": 1 }, { unique: true, partialFilterExpression: { "bank_transaction_id": { $exists: true } } });
+db.bank_transactions.createIndex({ "fingerprint": 1 }, { unique: true });
+db.bank_transactions.createIndex({ "status": 1, "booking_date": -1 });
+db.bank_transactions.createIndex({ "import_id": 1 });
+db.loads.createIndex({ "load_number": 1 }, { unique: true });
 db.loads.createIndex({ "broker_id": 1 });
 db.loads.createIndex({ "status": 1 });
+db.dunning_history.createIndex({ "invoice_id": 1, "stage": 1 }, { unique: true });
+db.credit_notes.createIndex({ "credit_note_number": 1 }, { unique: true });
+db.credit_notes.createIndex({ "invoice_id": 1 });
+db.exchange_rates.createIndex({ "currency": 1, "base_currency": 1, "date": -1 }, { unique: true });
 
 print('MongoDB инициализирован успешно!');
 print('Создана база данных billing_system с коллекциями и индексами');